@@ -0,0 +1,342 @@
+package gogn
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Aggregate accumulates the rows of one group for a user-defined aggregate
+// function. A new Aggregate is made for every group.
+type Aggregate interface {
+	// Step is called with the arguments of each row in the group.
+	Step(args []Cell) error
+	// Result is called once after the last row of the group. A nil Cell is
+	// NULL.
+	Result() (Cell, error)
+}
+
+type aggregateState interface {
+	step(args []MemoryCell) error
+	result() (MemoryCell, error)
+}
+
+type countState struct {
+	count int32
+}
+
+func (s *countState) step(args []MemoryCell) error {
+	s.count++
+	return nil
+}
+
+func (s *countState) result() (MemoryCell, error) {
+	return intToCell(s.count), nil
+}
+
+type intSumState struct {
+	sum   int64
+	empty bool
+}
+
+func (s *intSumState) step(args []MemoryCell) error {
+	s.sum += int64(args[0].AsInt())
+	s.empty = false
+	if s.sum < math.MinInt32 || s.sum > math.MaxInt32 {
+		return ErrIntegerOutOfRange
+	}
+	return nil
+}
+
+func (s *intSumState) result() (MemoryCell, error) {
+	if s.empty {
+		return nil, nil
+	}
+	return intToCell(int32(s.sum)), nil
+}
+
+type floatSumState struct {
+	sum   float64
+	count int
+	// avg divides the sum by the row count
+	avg bool
+}
+
+func (s *floatSumState) step(args []MemoryCell) error {
+	s.sum += args[0].AsFloat()
+	s.count++
+	return nil
+}
+
+func (s *floatSumState) result() (MemoryCell, error) {
+	if s.count == 0 {
+		return nil, nil
+	}
+	if s.avg {
+		return floatToCell(s.sum / float64(s.count)), nil
+	}
+	return floatToCell(s.sum), nil
+}
+
+// extremeState keeps the smallest value seen, or the largest for max.
+type extremeState struct {
+	typ     ColumnType
+	max     bool
+	extreme MemoryCell
+}
+
+func (s *extremeState) step(args []MemoryCell) error {
+	if s.extreme == nil {
+		s.extreme = args[0]
+		return nil
+	}
+
+	c := compareCells(args[0], s.typ, s.extreme, s.typ)
+	if (s.max && c > 0) || (!s.max && c < 0) {
+		s.extreme = args[0]
+	}
+	return nil
+}
+
+func (s *extremeState) result() (MemoryCell, error) {
+	return s.extreme, nil
+}
+
+// userAggregateState adapts an Aggregate registered with RegisterAggregate.
+type userAggregateState struct {
+	aggregate  Aggregate
+	returnType ColumnType
+}
+
+func (s *userAggregateState) step(args []MemoryCell) error {
+	return s.aggregate.Step(memoryCellsToCells(args))
+}
+
+func (s *userAggregateState) result() (MemoryCell, error) {
+	c, err := s.aggregate.Result()
+	if err != nil {
+		return nil, err
+	}
+	return cellToMemoryCell(c, s.returnType), nil
+}
+
+func init() {
+	types := []ColumnType{IntType, FloatType, TextType, BoolType}
+
+	builtinFunctions["count"] = []*function{{
+		argTypes:     []ColumnType{},
+		returnType:   IntType,
+		newAggregate: func() aggregateState { return &countState{} },
+	}}
+
+	for _, typ := range types {
+		typ := typ
+		builtinFunctions["count"] = append(builtinFunctions["count"], &function{
+			argTypes:     []ColumnType{typ},
+			returnType:   IntType,
+			newAggregate: func() aggregateState { return &countState{} },
+		})
+		builtinFunctions["min"] = append(builtinFunctions["min"], &function{
+			argTypes:     []ColumnType{typ},
+			returnType:   typ,
+			newAggregate: func() aggregateState { return &extremeState{typ: typ} },
+		})
+		builtinFunctions["max"] = append(builtinFunctions["max"], &function{
+			argTypes:     []ColumnType{typ},
+			returnType:   typ,
+			newAggregate: func() aggregateState { return &extremeState{typ: typ, max: true} },
+		})
+	}
+
+	builtinFunctions["sum"] = []*function{
+		{
+			argTypes:     []ColumnType{IntType},
+			returnType:   IntType,
+			newAggregate: func() aggregateState { return &intSumState{empty: true} },
+		},
+		{
+			argTypes:     []ColumnType{FloatType},
+			returnType:   FloatType,
+			newAggregate: func() aggregateState { return &floatSumState{} },
+		},
+	}
+
+	builtinFunctions["avg"] = []*function{{
+		argTypes:     []ColumnType{FloatType},
+		returnType:   FloatType,
+		newAggregate: func() aggregateState { return &floatSumState{avg: true} },
+	}}
+}
+
+// RegisterAggregate makes an aggregate function callable from SQL as name,
+// for example in SELECT name(x) FROM t GROUP BY y. Calls are type checked
+// like RegisterFunction, and newAggregate is called once per group.
+func (mb *MemoryBackend) RegisterAggregate(name string, argTypes []ColumnType, returnType ColumnType, newAggregate func() Aggregate) error {
+	name = strings.ToLower(name)
+	if err := mb.validateSignature(name, argTypes, returnType); err != nil {
+		return err
+	}
+
+	mb.functions[name] = append(mb.functions[name], &function{
+		argTypes:   append([]ColumnType{}, argTypes...),
+		returnType: returnType,
+		callOnNull: true,
+		newAggregate: func() aggregateState {
+			return &userAggregateState{aggregate: newAggregate(), returnType: returnType}
+		},
+	})
+	return nil
+}
+
+type groupedAggregate struct {
+	call     *callExpression
+	fn       *function
+	argTypes []ColumnType
+}
+
+// rewriteGrouped replaces the GROUP BY expressions and aggregate calls in exp
+// with references to the columns of grouped, adding a column for each
+// aggregate. Any other column reference is an error, since it has no single
+// value per group.
+func (mb *MemoryBackend) rewriteGrouped(t *table, exp *expression, groupBy []*expression, grouped *table, aggregates *[]*groupedAggregate) (*expression, error) {
+	column := func(name string) *expression {
+		return &expression{kind: literalKind, literal: &token{kind: identifierKind, value: name}}
+	}
+
+	s := exp.String()
+	for i, g := range groupBy {
+		if g.String() == s {
+			return column(grouped.columns[i]), nil
+		}
+	}
+
+	if mb.isAggregateCall(exp) {
+		fn, argTypes, err := mb.resolveCall(t, exp.call)
+		if err != nil {
+			return nil, err
+		}
+
+		name := fmt.Sprintf("$agg%d", len(*aggregates))
+		grouped.columns = append(grouped.columns, name)
+		grouped.columnTypes = append(grouped.columnTypes, fn.returnType)
+		*aggregates = append(*aggregates, &groupedAggregate{call: exp.call, fn: fn, argTypes: argTypes})
+		return column(name), nil
+	}
+
+	if exp.kind == literalKind && exp.literal.kind == identifierKind {
+		return nil, fmt.Errorf("%w: %s", ErrNotGrouped, exp.literal.value)
+	}
+
+	return exp.mapChildren(func(child *expression) (*expression, error) {
+		return mb.rewriteGrouped(t, child, groupBy, grouped, aggregates)
+	})
+}
+
+// planGrouping works out the grouping of items over t by groupBy: the
+// grouped table, whose columns are the GROUP BY values followed by the
+// aggregate results, the aggregates to compute per group and items
+// rewritten to read from the grouped table.
+func (mb *MemoryBackend) planGrouping(t *table, items []*expression, groupBy []*expression) (*table, []*groupedAggregate, []*expression, error) {
+	grouped := &table{}
+	for i, exp := range groupBy {
+		typ, err := mb.expressionType(t, exp)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		grouped.columns = append(grouped.columns, fmt.Sprintf("$group%d", i))
+		grouped.columnTypes = append(grouped.columnTypes, typ)
+	}
+
+	aggregates := []*groupedAggregate{}
+	rewritten := []*expression{}
+	for _, item := range items {
+		exp, err := mb.rewriteGrouped(t, item, groupBy, grouped, &aggregates)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rewritten = append(rewritten, exp)
+	}
+
+	return grouped, aggregates, rewritten, nil
+}
+
+// groupRows collapses rows of t into one row per distinct value of groupBy,
+// or a single row when there is no GROUP BY, and computes the aggregates per
+// group, giving the rows of the grouped table planGrouping returned.
+func (mb *MemoryBackend) groupRows(t *table, rows [][]MemoryCell, groupBy []*expression, grouped *table, aggregates []*groupedAggregate) ([][]MemoryCell, error) {
+	type group struct {
+		values []MemoryCell
+		states []aggregateState
+	}
+	newGroup := func(values []MemoryCell) *group {
+		g := &group{values: values}
+		for _, agg := range aggregates {
+			g.states = append(g.states, agg.fn.newAggregate())
+		}
+		return g
+	}
+
+	groups := []*group{}
+	groupIndex := map[string]*group{}
+
+	// Without GROUP BY there is exactly one group, even over no rows.
+	if len(groupBy) == 0 {
+		groups = append(groups, newGroup(nil))
+		groupIndex[""] = groups[0]
+	}
+
+	for _, row := range rows {
+		values := []MemoryCell{}
+		for i, exp := range groupBy {
+			cell, typ, err := mb.evaluateCell(t, row, exp)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, coerceCell(cell, typ, grouped.columnTypes[i]))
+		}
+
+		key := encodeKey(values)
+		g, ok := groupIndex[key]
+		if !ok {
+			g = newGroup(values)
+			groupIndex[key] = g
+			groups = append(groups, g)
+		}
+
+	aggregates:
+		for i, agg := range aggregates {
+			args := []MemoryCell{}
+			for j, arg := range agg.call.args {
+				cell, _, err := mb.evaluateCell(t, row, arg)
+				if err != nil {
+					return nil, err
+				}
+
+				if cell == nil && !agg.fn.callOnNull {
+					continue aggregates
+				}
+				args = append(args, coerceCell(cell, agg.argTypes[j], agg.fn.paramType(j)))
+			}
+
+			if err := g.states[i].step(args); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	groupedRows := [][]MemoryCell{}
+	for _, g := range groups {
+		row := append([]MemoryCell{}, g.values...)
+		for _, state := range g.states {
+			cell, err := state.result()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, cell)
+		}
+		groupedRows = append(groupedRows, row)
+	}
+
+	return groupedRows, nil
+}
@@ -0,0 +1,520 @@
+package gogn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// clone returns a copy of t whose columns and constraints can be changed
+// without affecting t. The rows are shared.
+func (t *table) clone() *table {
+	c := *t
+	c.columns = append([]string{}, t.columns...)
+	c.columnTypes = append([]ColumnType{}, t.columnTypes...)
+	c.notNull = append([]bool{}, t.notNull...)
+	c.defaults = append([]*expression{}, t.defaults...)
+
+	c.uniques = nil
+	for _, u := range t.uniques {
+		u := *u
+		c.uniques = append(c.uniques, &u)
+	}
+	c.foreignKeys = nil
+	for _, fk := range t.foreignKeys {
+		fk := *fk
+		c.foreignKeys = append(c.foreignKeys, &fk)
+	}
+	c.checks = nil
+	for _, check := range t.checks {
+		check := *check
+		c.checks = append(c.checks, &check)
+	}
+	c.indexes = nil
+	for _, ix := range t.indexes {
+		ix := *ix
+		c.indexes = append(c.indexes, &ix)
+	}
+
+	// The statistics describe the columns as they were.
+	c.stats = nil
+	return &c
+}
+
+// AlterTable changes a table based on the information in
+// AlterTableStatement. Existing rows are rewritten to fit the new
+// definition, which only replaces the old one once every row passes its
+// constraints.
+func (mb *MemoryBackend) AlterTable(alt *AlterTableStatement) error {
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.alterTable(alt)
+	})
+}
+
+func (mb *MemoryBackend) alterTable(alt *AlterTableStatement) error {
+	t, ok := mb.tables[alt.table.value]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+	t = mb.alter(t)
+
+	switch alt.action {
+	case addColumnAction:
+		return mb.addColumn(t, alt.column)
+	case renameTableAction:
+		return mb.renameTable(t, alt.newName.value)
+	}
+
+	i, ok := t.columnIndex(alt.name.value)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, alt.name.value)
+	}
+
+	switch alt.action {
+	case dropColumnAction:
+		return mb.dropColumn(t, i)
+	case renameColumnAction:
+		return mb.renameColumn(t, i, alt.newName.value)
+	case alterColumnTypeAction:
+		return mb.alterColumnType(t, i, alt.datatype.value)
+	}
+	return nil
+}
+
+// replaceTable checks rows against the constraints of nt, the new
+// definition of t, and only then makes nt with rows and rebuilt indexes
+// the contents of t.
+func (mb *MemoryBackend) replaceTable(t, nt *table, rows [][]MemoryCell) error {
+	for _, row := range rows {
+		if err := mb.checkRow(nt, row); err != nil {
+			return err
+		}
+	}
+
+	versions := make([]rowVersion, 0, len(rows))
+	t.eachRow(func(v rowVersion, row []MemoryCell) error {
+		versions = append(versions, mb.stamp(v.id))
+		return nil
+	})
+
+	// Building the indexes checks the unique keys.
+	for _, ix := range nt.indexes {
+		ix.tree = newBtree(nt.columnTypesOf(ix.columns))
+		for i, row := range rows {
+			if !ix.add(row, versions[i].id) {
+				return nt.keyViolation(ix)
+			}
+		}
+	}
+
+	for _, fk := range nt.foreignKeys {
+		parent := nt
+		if fk.parent != nt.name {
+			parent = mb.tables[fk.parent]
+		}
+		ui, _ := parent.uniqueOn(fk.parentColumns)
+		ix := parent.uniqueIndex(parent.uniques[ui])
+
+		for _, row := range rows {
+			if key, ok := keyCells(row, fk.columns); ok {
+				if _, found := ix.find(key); !found {
+					return nt.violation(ForeignKeyConstraint, fk.name, fk.columns)
+				}
+			}
+		}
+	}
+
+	nt.setRows(rows, versions)
+	*t = *nt
+	return nil
+}
+
+// dependentForeignKey returns a foreign key that involves column i of t,
+// either as one of its own columns or as a referenced one, or nil.
+func (mb *MemoryBackend) dependentForeignKey(t *table, i int, own bool) *foreignKey {
+	contains := func(columns []int) bool {
+		for _, col := range columns {
+			if col == i {
+				return true
+			}
+		}
+		return false
+	}
+
+	if own {
+		for _, fk := range t.foreignKeys {
+			if contains(fk.columns) {
+				return fk
+			}
+		}
+	}
+
+	for _, other := range mb.tables {
+		for _, fk := range other.foreignKeys {
+			if fk.parent == t.name && contains(fk.parentColumns) {
+				return fk
+			}
+		}
+	}
+	return nil
+}
+
+// referencingTables returns the tables with foreign keys referencing the
+// table called parent, ready to change.
+func (mb *MemoryBackend) referencingTables(parent string) []*table {
+	tables := []*table{}
+	for _, other := range mb.tables {
+		for _, fk := range other.foreignKeys {
+			if fk.parent == parent {
+				tables = append(tables, mb.alter(other))
+				break
+			}
+		}
+	}
+	return tables
+}
+
+// addColumn adds col to t. Existing rows get the column's default, which is
+// evaluated once per row, or NULL without one.
+func (mb *MemoryBackend) addColumn(t *table, col *columnDefinition) error {
+	if _, ok := t.columnIndex(col.name.value); ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateColumn, col.name.value)
+	}
+
+	nt := t.clone()
+	s, err := mb.defineColumn(nt, col)
+	if err != nil {
+		return err
+	}
+
+	i := len(nt.columns) - 1
+	if err := mb.addColumnConstraints(nt, i, col); err != nil {
+		return err
+	}
+	if col.references != nil {
+		if err := mb.addForeignKey(nt, col.references); err != nil {
+			return err
+		}
+	}
+
+	// The sequence of a SERIAL column numbers the existing rows, and goes
+	// away again if they can't be added.
+	if s != nil {
+		mb.sequences[s.name] = s
+	}
+	fail := func(err error) error {
+		if s != nil {
+			delete(mb.sequences, s.name)
+		}
+		return err
+	}
+
+	rows := [][]MemoryCell{}
+	for _, row := range t.allRows() {
+		var cell MemoryCell
+		if exp := nt.defaults[i]; exp != nil {
+			value, typ, err := mb.evaluateCell(&table{}, nil, exp)
+			if err != nil {
+				return fail(err)
+			}
+			cell = coerceCell(value, typ, nt.columnTypes[i])
+		}
+
+		rows = append(rows, append(append([]MemoryCell{}, row...), cell))
+	}
+
+	if err := mb.replaceTable(t, nt, rows); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// dropColumn removes column i from t along with the constraints and
+// indexes that involve it. Columns referenced by a foreign key can't be
+// dropped.
+func (mb *MemoryBackend) dropColumn(t *table, i int) error {
+	if fk := mb.dependentForeignKey(t, i, false); fk != nil {
+		return fmt.Errorf("%w: foreign key %s references column %s", ErrDependentObjects, fk.name, t.columns[i])
+	}
+
+	// shift renumbers columns for the removal of column i, failing if i
+	// is one of them.
+	shift := func(columns []int) ([]int, bool) {
+		shifted := []int{}
+		for _, col := range columns {
+			if col == i {
+				return nil, false
+			}
+			if col > i {
+				col--
+			}
+			shifted = append(shifted, col)
+		}
+		return shifted, true
+	}
+
+	nt := t.clone()
+	nt.columns = append(nt.columns[:i], nt.columns[i+1:]...)
+	nt.columnTypes = append(nt.columnTypes[:i], nt.columnTypes[i+1:]...)
+	nt.notNull = append(nt.notNull[:i], nt.notNull[i+1:]...)
+	nt.defaults = append(nt.defaults[:i], nt.defaults[i+1:]...)
+
+	uniques := []*uniqueConstraint{}
+	for _, u := range nt.uniques {
+		var ok bool
+		if u.columns, ok = shift(u.columns); ok {
+			uniques = append(uniques, u)
+		}
+	}
+	nt.uniques = uniques
+
+	checks := []*checkConstraint{}
+	for _, c := range nt.checks {
+		var ok bool
+		if c.columns, ok = shift(c.columns); ok {
+			checks = append(checks, c)
+		}
+	}
+	nt.checks = checks
+
+	foreignKeys := []*foreignKey{}
+	for _, fk := range nt.foreignKeys {
+		var ok bool
+		if fk.columns, ok = shift(fk.columns); !ok {
+			continue
+		}
+		if fk.parent == t.name {
+			fk.parentColumns, _ = shift(fk.parentColumns)
+		}
+		foreignKeys = append(foreignKeys, fk)
+	}
+	nt.foreignKeys = foreignKeys
+
+	indexes := []*index{}
+	for _, ix := range nt.indexes {
+		var ok bool
+		if ix.columns, ok = shift(ix.columns); ok {
+			indexes = append(indexes, ix)
+		}
+	}
+	nt.indexes = indexes
+
+	rows := [][]MemoryCell{}
+	for _, row := range t.allRows() {
+		newRow := append([]MemoryCell{}, row[:i]...)
+		rows = append(rows, append(newRow, row[i+1:]...))
+	}
+
+	if err := mb.replaceTable(t, nt, rows); err != nil {
+		return err
+	}
+
+	// Foreign keys of other tables keep referencing the same columns.
+	for _, other := range mb.referencingTables(t.name) {
+		if other == t {
+			continue
+		}
+		for _, fk := range other.foreignKeys {
+			if fk.parent == t.name {
+				fk.parentColumns, _ = shift(fk.parentColumns)
+			}
+		}
+	}
+	return nil
+}
+
+// mapIdentifiers returns a copy of exp with every identifier replaced by
+// the result of f.
+func mapIdentifiers(exp *expression, f func(string) string) *expression {
+	if exp.kind == literalKind && exp.literal.kind == identifierKind {
+		literal := *exp.literal
+		literal.value = f(literal.value)
+		return &expression{kind: literalKind, literal: &literal}
+	}
+
+	mapped, _ := exp.mapChildren(func(child *expression) (*expression, error) {
+		return mapIdentifiers(child, f), nil
+	})
+	return mapped
+}
+
+// renameColumn renames column i of t to name, along with the references to
+// it in CHECK constraints.
+func (mb *MemoryBackend) renameColumn(t *table, i int, name string) error {
+	if _, ok := t.columnIndex(name); ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateColumn, name)
+	}
+
+	old := t.columns[i]
+	rename := func(id string) string {
+		switch id {
+		case old:
+			return name
+		case t.name + "." + old:
+			return t.name + "." + name
+		}
+		return id
+	}
+
+	nt := t.clone()
+	nt.columns[i] = name
+	for _, c := range nt.checks {
+		c.condition = mapIdentifiers(c.condition, rename)
+	}
+
+	*t = *nt
+	return nil
+}
+
+// renameTable renames t to name. Foreign keys referencing t and CHECK
+// constraints qualifying its columns follow it.
+func (mb *MemoryBackend) renameTable(t *table, name string) error {
+	if _, ok := mb.tables[name]; ok {
+		return fmt.Errorf("%w: %s", ErrTableExists, name)
+	}
+
+	old := t.name
+	rename := func(id string) string {
+		if strings.HasPrefix(id, old+".") {
+			return name + id[len(old):]
+		}
+		return id
+	}
+
+	for _, other := range mb.referencingTables(old) {
+		for _, fk := range other.foreignKeys {
+			if fk.parent == old {
+				fk.parent = name
+			}
+		}
+	}
+
+	for j, c := range t.checks {
+		renamed := *c
+		renamed.condition = mapIdentifiers(c.condition, rename)
+		t.checks[j] = &renamed
+	}
+
+	delete(mb.tables, old)
+	t.name = name
+	mb.tables[name] = t
+	return nil
+}
+
+// alterColumnType converts column i of t and its values to the type named
+// datatype. Columns involved in foreign keys keep their type.
+func (mb *MemoryBackend) alterColumnType(t *table, i int, datatype string) error {
+	typ, err := columnType(datatype)
+	if err != nil {
+		return err
+	}
+
+	from := t.columnTypes[i]
+	if typ == from {
+		return nil
+	}
+
+	if fk := mb.dependentForeignKey(t, i, true); fk != nil {
+		return fmt.Errorf("%w: column %s is used by foreign key %s", ErrDependentObjects, t.columns[i], fk.name)
+	}
+
+	nt := t.clone()
+	nt.columnTypes[i] = typ
+
+	if exp := nt.defaults[i]; exp != nil {
+		defaultType, err := mb.expressionType(&table{}, exp)
+		if err != nil {
+			return err
+		}
+		if !assignable(defaultType, typ) {
+			return fmt.Errorf("%w: expected %s for default of column %s, got %s", ErrMismatchedType, typ, nt.columns[i], defaultType)
+		}
+	}
+
+	for _, c := range nt.checks {
+		if err := mb.checkCondition(nt, c.condition); err != nil {
+			return err
+		}
+	}
+
+	rows := [][]MemoryCell{}
+	for _, row := range t.allRows() {
+		cell, err := castCell(row[i], from, typ)
+		if err != nil {
+			return err
+		}
+
+		newRow := append([]MemoryCell{}, row...)
+		newRow[i] = cell
+		rows = append(rows, newRow)
+	}
+
+	return mb.replaceTable(t, nt, rows)
+}
+
+// castCell converts a cell of type from to type to. Anything can become
+// text, text is parsed, floats are rounded to ints and ints and booleans
+// convert like 1 and true.
+func castCell(cell MemoryCell, from, to ColumnType) (MemoryCell, error) {
+	if cell == nil || from == to {
+		return cell, nil
+	}
+
+	switch {
+	case to == TextType:
+		return MemoryCell(cellToText(cell, from)), nil
+	case from == IntType && to == FloatType:
+		return coerceCell(cell, from, to), nil
+	case from == FloatType && to == IntType:
+		f := math.Round(cell.AsFloat())
+		if f > math.MaxInt32 || f < math.MinInt32 {
+			return nil, ErrIntegerOutOfRange
+		}
+		return intToCell(int32(f)), nil
+	case from == IntType && to == BoolType:
+		return boolToCell(cell.AsInt() != 0), nil
+	case from == BoolType && to == IntType:
+		if cell.AsBool() {
+			return intToCell(1), nil
+		}
+		return intToCell(0), nil
+	case from == TextType:
+		return parseCell(cell.AsText(), to)
+	}
+
+	return nil, fmt.Errorf("%w: can't convert %s to %s", ErrMismatchedType, from, to)
+}
+
+// parseCell parses text as a value of type to.
+func parseCell(text string, to ColumnType) (MemoryCell, error) {
+	s := strings.TrimSpace(text)
+	invalid := fmt.Errorf("%w: invalid input for %s: '%s'", ErrMismatchedType, to, text)
+
+	switch to {
+	case IntType:
+		i, err := strconv.ParseInt(s, 10, 32)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, ErrIntegerOutOfRange
+		}
+		if err != nil {
+			return nil, invalid
+		}
+		return intToCell(int32(i)), nil
+	case FloatType:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return floatToCell(f), nil
+	case BoolType:
+		switch strings.ToLower(s) {
+		case "t", "true", "y", "yes", "on", "1":
+			return trueMemoryCell, nil
+		case "f", "false", "n", "no", "off", "0":
+			return falseMemoryCell, nil
+		}
+	}
+	return nil, invalid
+}
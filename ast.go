@@ -1,5 +1,9 @@
 package gogn
 
+import (
+	"strings"
+)
+
 // Ast is an Abstract Syntax Tree.
 type Ast struct {
 	Statements []*Statement
@@ -11,42 +15,509 @@ const (
 	SelectKind AstKind = iota
 	CreateTableKind
 	InsertKind
+	UpdateKind
+	DeleteKind
+	CreateSequenceKind
+	AlterTableKind
+	CreateIndexKind
+	DropIndexKind
+	ExplainKind
+	AnalyzeKind
+	BeginKind
+	CommitKind
+	RollbackKind
+	SavepointKind
+	ReleaseSavepointKind
+	SetTransactionKind
 )
 
 type Statement struct {
-	SelectStatement      *SelectStatement
-	CreateTableStatement *CreateTableStatement
-	InsertStatement      *InsertStatement
-	Kind                 AstKind
+	SelectStatement           *SelectStatement
+	CreateTableStatement      *CreateTableStatement
+	InsertStatement           *InsertStatement
+	UpdateStatement           *UpdateStatement
+	DeleteStatement           *DeleteStatement
+	CreateSequenceStatement   *CreateSequenceStatement
+	AlterTableStatement       *AlterTableStatement
+	CreateIndexStatement      *CreateIndexStatement
+	DropIndexStatement        *DropIndexStatement
+	ExplainStatement          *ExplainStatement
+	AnalyzeStatement          *AnalyzeStatement
+	BeginStatement            *BeginStatement
+	CommitStatement           *CommitStatement
+	RollbackStatement         *RollbackStatement
+	SavepointStatement        *SavepointStatement
+	ReleaseSavepointStatement *ReleaseSavepointStatement
+	SetTransactionStatement   *SetTransactionStatement
+	Kind                      AstKind
 }
 
 type InsertStatement struct {
-	Table  token
-	Values *[]*expression
+	Table token
+	// Columns are the columns given values, in order, or nil for all of
+	// them.
+	Columns []token
+	// Values holds the row to insert, or Select the query producing the
+	// rows.
+	Values     *[]*expression
+	Select     *SelectStatement
+	OnConflict *onConflictClause
+	// Returning holds the RETURNING items, or nil without the clause.
+	Returning []*expression
+}
+
+// onConflictClause is ON CONFLICT [(columns)] DO NOTHING or
+// ON CONFLICT (columns) DO UPDATE SET ... [WHERE ...].
+type onConflictClause struct {
+	// columns name the unique constraint whose conflicts are handled.
+	// Without them, DO NOTHING handles conflicts on any unique constraint.
+	columns  []token
+	doUpdate bool
+	set      []*assignment
+	where    *expression
 }
 
 type expressionKind uint
 
 const (
 	literalKind expressionKind = iota
+	binaryKind
+	unaryKind
+	caseKind
+	coalesceKind
+	nullifKind
+	inKind
+	betweenKind
+	callKind
 )
 
+// binaryExpression is an infix operator such as =, AND, LIKE or IS.
+type binaryExpression struct {
+	a  *expression
+	b  *expression
+	op token
+}
+
+// unaryExpression is a prefix operator such as NOT or unary minus.
+type unaryExpression struct {
+	operand *expression
+	op      token
+}
+
+type whenClause struct {
+	condition *expression
+	result    *expression
+}
+
+// caseExpression is a searched CASE when operand is nil and a simple CASE
+// comparing operand against each WHEN value otherwise.
+type caseExpression struct {
+	operand    *expression
+	whens      []*whenClause
+	elseResult *expression
+}
+
+type coalesceExpression struct {
+	args []*expression
+}
+
+type nullifExpression struct {
+	a *expression
+	b *expression
+}
+
+type inExpression struct {
+	operand *expression
+	list    []*expression
+}
+
+type betweenExpression struct {
+	operand *expression
+	low     *expression
+	high    *expression
+}
+
+// callExpression is a call to a scalar or aggregate function by name. star
+// marks the argument list of count(*).
+type callExpression struct {
+	name token
+	args []*expression
+	star bool
+}
+
 type expression struct {
-	literal *token
-	kind    expressionKind
+	literal  *token
+	binary   *binaryExpression
+	unary    *unaryExpression
+	caseExp  *caseExpression
+	coalesce *coalesceExpression
+	nullif   *nullifExpression
+	in       *inExpression
+	between  *betweenExpression
+	call     *callExpression
+	kind     expressionKind
 }
 
 type columnDefinition struct {
+	name       token
+	datatype   token
+	notNull    bool
+	primaryKey bool
+	unique     bool
+	// references is the REFERENCES constraint of the column, if any.
+	references *tableConstraint
+	// defaultValue is evaluated for every row inserted without the column.
+	defaultValue *expression
+	checks       []*tableConstraint
+}
+
+// referentialAction is what happens to referencing rows when the row they
+// reference is deleted or its key updated.
+type referentialAction uint
+
+const (
+	// restrictAction is also the default when no action is given.
+	restrictAction referentialAction = iota
+	cascadeAction
+	setNullAction
+)
+
+// tableConstraint is a constraint over one or more columns declared apart
+// from the column definitions. name is the zero token when it was not given.
+// Foreign keys also set the parent table and columns, where no parent
+// columns means the parent's primary key.
+type tableConstraint struct {
+	name          token
+	kind          ConstraintKind
+	columns       []token
+	parent        token
+	parentColumns []token
+	onDelete      referentialAction
+	onUpdate      referentialAction
+	// check is the condition of a CHECK constraint.
+	check *expression
+}
+
+type CreateTableStatement struct {
+	name        token
+	cols        *[]*columnDefinition
+	constraints []*tableConstraint
+	// query is the SELECT of CREATE TABLE ... AS, which replaces the column
+	// definitions.
+	query *SelectStatement
+	// using names the layout of the table given by USING, heap or
+	// columnar, and is empty without it.
+	using token
+}
+
+type assignment struct {
+	column token
+	value  *expression
+}
+
+type UpdateStatement struct {
+	table     token
+	set       []*assignment
+	where     *expression
+	returning []*expression
+}
+
+type DeleteStatement struct {
+	table     token
+	where     *expression
+	returning []*expression
+}
+
+// CreateSequenceStatement is CREATE SEQUENCE. increment and start are nil
+// when left out.
+type CreateSequenceStatement struct {
+	name      token
+	increment *expression
+	start     *expression
+}
+
+type alterTableAction uint
+
+const (
+	addColumnAction alterTableAction = iota
+	dropColumnAction
+	renameColumnAction
+	renameTableAction
+	alterColumnTypeAction
+)
+
+// AlterTableStatement is ALTER TABLE with a single action. column is the
+// definition of ADD COLUMN, name the column the other column actions apply
+// to, newName the new name of a RENAME and datatype the new type of ALTER
+// COLUMN ... TYPE.
+type AlterTableStatement struct {
+	table    token
+	action   alterTableAction
+	column   *columnDefinition
 	name     token
+	newName  token
 	datatype token
 }
 
-type CreateTableStatement struct {
+// CreateIndexStatement is CREATE [UNIQUE] INDEX name ON table (columns).
+type CreateIndexStatement struct {
+	name    token
+	unique  bool
+	table   token
+	columns []token
+}
+
+type DropIndexStatement struct {
+	name token
+}
+
+// AnalyzeStatement is ANALYZE [table], which collects the statistics of
+// one table or, without a name, of all of them.
+type AnalyzeStatement struct {
+	table token
+}
+
+// BeginStatement is BEGIN [TRANSACTION | WORK] [ISOLATION LEVEL level],
+// which starts a transaction. The statements after it only take effect
+// together, once COMMIT ends it, and ROLLBACK undoes them all.
+type BeginStatement struct {
+	isolation isolationLevel
+}
+
+// isolationLevel is the level of ISOLATION LEVEL, or defaultIsolation
+// without one.
+type isolationLevel uint
+
+const (
+	defaultIsolation isolationLevel = iota
+	readUncommitted
+	readCommitted
+	repeatableRead
+	serializable
+)
+
+// SetTransactionStatement is SET TRANSACTION ISOLATION LEVEL level, which
+// sets the isolation level of the transaction in progress before its first
+// query.
+type SetTransactionStatement struct {
+	isolation isolationLevel
+}
+
+// CommitStatement is COMMIT [TRANSACTION | WORK].
+type CommitStatement struct{}
+
+// RollbackStatement is ROLLBACK [TRANSACTION | WORK], or with TO
+// [SAVEPOINT] name, which only undoes the statements since the savepoint
+// and keeps the transaction going.
+type RollbackStatement struct {
+	savepoint token
+}
+
+// SavepointStatement is SAVEPOINT name, which marks a point in a
+// transaction to roll back to.
+type SavepointStatement struct {
+	name token
+}
+
+// ReleaseSavepointStatement is RELEASE [SAVEPOINT] name, which forgets a
+// savepoint and the ones after it while keeping their changes.
+type ReleaseSavepointStatement struct {
 	name token
-	cols *[]*columnDefinition
 }
 
 type SelectStatement struct {
 	item []*expression
-	from token
+	// aliases holds the AS name of each item, with an empty value for
+	// items without one, or is nil when no item has one.
+	aliases []token
+	from    token
+	// alias is the name the FROM table goes by in the query, or empty to
+	// use its own.
+	alias   token
+	joins   []*joinClause
+	where   *expression
+	groupBy []*expression
+	orderBy []*orderItem
+	// limit and offset are nil without LIMIT and OFFSET.
+	limit  *expression
+	offset *expression
+}
+
+type joinKind uint
+
+const (
+	innerJoin joinKind = iota
+	leftJoin
+)
+
+// joinClause is [INNER] JOIN table [alias] ON condition or
+// LEFT [OUTER] JOIN table [alias] ON condition.
+type joinClause struct {
+	kind  joinKind
+	table token
+	alias token
+	on    *expression
+}
+
+type orderItem struct {
+	exp  *expression
+	desc bool
+}
+
+// ExplainStatement is EXPLAIN [ANALYZE] followed by the query whose plan to
+// show. With ANALYZE the query is run, to show what each operator did.
+type ExplainStatement struct {
+	statement *SelectStatement
+	analyze   bool
+}
+
+// children returns the direct subexpressions of e.
+func (e *expression) children() []*expression {
+	switch e.kind {
+	case binaryKind:
+		return []*expression{e.binary.a, e.binary.b}
+	case unaryKind:
+		return []*expression{e.unary.operand}
+	case caseKind:
+		children := []*expression{}
+		if e.caseExp.operand != nil {
+			children = append(children, e.caseExp.operand)
+		}
+		for _, when := range e.caseExp.whens {
+			children = append(children, when.condition, when.result)
+		}
+		if e.caseExp.elseResult != nil {
+			children = append(children, e.caseExp.elseResult)
+		}
+		return children
+	case coalesceKind:
+		return e.coalesce.args
+	case nullifKind:
+		return []*expression{e.nullif.a, e.nullif.b}
+	case inKind:
+		return append([]*expression{e.in.operand}, e.in.list...)
+	case betweenKind:
+		return []*expression{e.between.operand, e.between.low, e.between.high}
+	case callKind:
+		return e.call.args
+	}
+	return nil
+}
+
+// mapChildren returns a copy of e with each direct subexpression replaced by
+// the result of f.
+func (e *expression) mapChildren(f func(*expression) (*expression, error)) (*expression, error) {
+	var err error
+	mapAll := func(exps []*expression) []*expression {
+		mapped := []*expression{}
+		for _, exp := range exps {
+			var m *expression
+			if err == nil {
+				m, err = f(exp)
+			}
+			mapped = append(mapped, m)
+		}
+		return mapped
+	}
+
+	c := *e
+	switch e.kind {
+	case binaryKind:
+		m := mapAll([]*expression{e.binary.a, e.binary.b})
+		c.binary = &binaryExpression{a: m[0], b: m[1], op: e.binary.op}
+	case unaryKind:
+		m := mapAll([]*expression{e.unary.operand})
+		c.unary = &unaryExpression{operand: m[0], op: e.unary.op}
+	case caseKind:
+		caseExp := caseExpression{}
+		if e.caseExp.operand != nil {
+			caseExp.operand = mapAll([]*expression{e.caseExp.operand})[0]
+		}
+		for _, when := range e.caseExp.whens {
+			m := mapAll([]*expression{when.condition, when.result})
+			caseExp.whens = append(caseExp.whens, &whenClause{condition: m[0], result: m[1]})
+		}
+		if e.caseExp.elseResult != nil {
+			caseExp.elseResult = mapAll([]*expression{e.caseExp.elseResult})[0]
+		}
+		c.caseExp = &caseExp
+	case coalesceKind:
+		c.coalesce = &coalesceExpression{args: mapAll(e.coalesce.args)}
+	case nullifKind:
+		m := mapAll([]*expression{e.nullif.a, e.nullif.b})
+		c.nullif = &nullifExpression{a: m[0], b: m[1]}
+	case inKind:
+		m := mapAll(append([]*expression{e.in.operand}, e.in.list...))
+		c.in = &inExpression{operand: m[0], list: m[1:]}
+	case betweenKind:
+		m := mapAll([]*expression{e.between.operand, e.between.low, e.between.high})
+		c.between = &betweenExpression{operand: m[0], low: m[1], high: m[2]}
+	case callKind:
+		c.call = &callExpression{name: e.call.name, args: mapAll(e.call.args), star: e.call.star}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func joinExpressions(exps []*expression) string {
+	strs := []string{}
+	for _, exp := range exps {
+		strs = append(strs, exp.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+func tokenString(t token) string {
+	switch t.kind {
+	case keywordKind:
+		return strings.ToUpper(t.value)
+	case stringKind:
+		return "'" + strings.ReplaceAll(t.value, "'", "''") + "'"
+	}
+	return t.value
+}
+
+// String formats e back into SQL, parenthesizing every binary operator so
+// that two expressions print the same exactly when they parse the same.
+func (e *expression) String() string {
+	switch e.kind {
+	case literalKind:
+		return tokenString(*e.literal)
+	case binaryKind:
+		return "(" + e.binary.a.String() + " " + tokenString(e.binary.op) + " " + e.binary.b.String() + ")"
+	case unaryKind:
+		if e.unary.op.kind == keywordKind {
+			return tokenString(e.unary.op) + " " + e.unary.operand.String()
+		}
+		return e.unary.op.value + e.unary.operand.String()
+	case caseKind:
+		s := "CASE"
+		if e.caseExp.operand != nil {
+			s += " " + e.caseExp.operand.String()
+		}
+		for _, when := range e.caseExp.whens {
+			s += " WHEN " + when.condition.String() + " THEN " + when.result.String()
+		}
+		if e.caseExp.elseResult != nil {
+			s += " ELSE " + e.caseExp.elseResult.String()
+		}
+		return s + " END"
+	case coalesceKind:
+		return "COALESCE(" + joinExpressions(e.coalesce.args) + ")"
+	case nullifKind:
+		return "NULLIF(" + e.nullif.a.String() + ", " + e.nullif.b.String() + ")"
+	case inKind:
+		return e.in.operand.String() + " IN (" + joinExpressions(e.in.list) + ")"
+	case betweenKind:
+		return e.between.operand.String() + " BETWEEN " + e.between.low.String() + " AND " + e.between.high.String()
+	case callKind:
+		if e.call.star {
+			return e.call.name.value + "(*)"
+		}
+		return e.call.name.value + "(" + joinExpressions(e.call.args) + ")"
+	}
+	return "?"
 }
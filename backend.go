@@ -2,6 +2,8 @@ package gogn
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 type ColumnType uint
@@ -9,11 +11,35 @@ type ColumnType uint
 const (
 	TextType ColumnType = iota
 	IntType
+	BoolType
+	FloatType
+	// NullType is the type of a bare NULL literal, which is compatible with
+	// every other type.
+	NullType
 )
 
+func (c ColumnType) String() string {
+	switch c {
+	case TextType:
+		return "text"
+	case IntType:
+		return "int"
+	case BoolType:
+		return "boolean"
+	case FloatType:
+		return "float"
+	case NullType:
+		return "null"
+	}
+	return "unknown"
+}
+
 type Cell interface {
 	AsText() string
 	AsInt() int32
+	AsFloat() float64
+	AsBool() bool
+	IsNull() bool
 }
 
 type Results struct {
@@ -24,16 +50,169 @@ type Results struct {
 	Rows [][]Cell
 }
 
+// Rows is a cursor over the results of a query. The backend produces each
+// row as it's read rather than all of them up front, so the first rows of a
+// large result come back without waiting for the rest.
+type Rows struct {
+	Columns []struct {
+		Type ColumnType
+		Name string
+	}
+	// next produces the next row, or false once there are none left.
+	next func() ([]Cell, bool, error)
+	// close, when set, is called once the cursor is closed.
+	close func() error
+	row   []Cell
+	err   error
+}
+
+// Next advances to the next row, returning false when there are no more or
+// producing it failed, which Err then reports.
+func (r *Rows) Next() bool {
+	if r.next == nil {
+		return false
+	}
+
+	row, ok, err := r.next()
+	if err != nil || !ok {
+		r.err = err
+		if err := r.Close(); r.err == nil {
+			r.err = err
+		}
+		return false
+	}
+	r.row = row
+	return true
+}
+
+// Row returns the row Next advanced to.
+func (r *Rows) Row() []Cell {
+	return r.row
+}
+
+// Err returns the error that stopped Next, if any.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close stops reading rows. Closing a cursor more than once is fine.
+func (r *Rows) Close() error {
+	r.next = nil
+	r.row = nil
+	if close := r.close; close != nil {
+		r.close = nil
+		return close()
+	}
+	return nil
+}
+
 var (
-	ErrTableDoesNotExist  = errors.New("Table does not exist")
-	ErrColumnDoesNotExist = errors.New("Column does not exist")
-	ErrInvalidSelectItem  = errors.New("Select item is not valid")
-	ErrInvalidDatatype    = errors.New("Invalid datatype")
-	ErrMissingValues      = errors.New("Missing values")
+	ErrTableDoesNotExist     = errors.New("Table does not exist")
+	ErrColumnDoesNotExist    = errors.New("Column does not exist")
+	ErrInvalidSelectItem     = errors.New("Select item is not valid")
+	ErrInvalidDatatype       = errors.New("Invalid datatype")
+	ErrMissingValues         = errors.New("Missing values")
+	ErrInvalidOperands       = errors.New("Invalid operands")
+	ErrInvalidCondition      = errors.New("Condition must be boolean")
+	ErrMismatchedType        = errors.New("Value does not match column type")
+	ErrFunctionDoesNotExist  = errors.New("Function does not exist")
+	ErrInvalidArguments      = errors.New("Invalid function arguments")
+	ErrDivisionByZero        = errors.New("Division by zero")
+	ErrIntegerOutOfRange     = errors.New("Integer out of range")
+	ErrFloatOutOfRange       = errors.New("Float out of range")
+	ErrFunctionExists        = errors.New("Function already exists")
+	ErrInvalidAggregate      = errors.New("Aggregate functions are not allowed here")
+	ErrNotGrouped            = errors.New("Column must appear in GROUP BY or be used in an aggregate function")
+	ErrInvalidConstraint     = errors.New("Invalid constraint")
+	ErrConstraintViolation   = errors.New("Constraint violation")
+	ErrDuplicateColumn       = errors.New("Column specified more than once")
+	ErrSequenceDoesNotExist  = errors.New("Sequence does not exist")
+	ErrSequenceExists        = errors.New("Sequence already exists")
+	ErrSequenceNotStarted    = errors.New("Currval of sequence is not yet defined in this session")
+	ErrInvalidSequence       = errors.New("Invalid sequence")
+	ErrCardinalityViolation  = errors.New("ON CONFLICT DO UPDATE cannot affect a row a second time")
+	ErrTableExists           = errors.New("Table already exists")
+	ErrDependentObjects      = errors.New("Other objects depend on it")
+	ErrIndexExists           = errors.New("Index already exists")
+	ErrIndexDoesNotExist     = errors.New("Index does not exist")
+	ErrInvalidLimit          = errors.New("LIMIT and OFFSET must be non-negative integer constants")
+	ErrInvalidLayout         = errors.New("Table layout must be heap or columnar")
+	ErrTransactionInProgress = errors.New("There is already a transaction in progress")
+	ErrNoTransaction         = errors.New("There is no transaction in progress")
+	ErrSavepointDoesNotExist = errors.New("Savepoint does not exist")
+	ErrTransactionStarted    = errors.New("SET TRANSACTION ISOLATION LEVEL must be called before any query")
 )
 
+type ConstraintKind uint
+
+const (
+	NotNullConstraint ConstraintKind = iota
+	UniqueConstraint
+	PrimaryKeyConstraint
+	ForeignKeyConstraint
+	CheckConstraint
+)
+
+func (c ConstraintKind) String() string {
+	switch c {
+	case NotNullConstraint:
+		return "not null"
+	case UniqueConstraint:
+		return "unique"
+	case PrimaryKeyConstraint:
+		return "primary key"
+	case ForeignKeyConstraint:
+		return "foreign key"
+	case CheckConstraint:
+		return "check"
+	}
+	return "unknown"
+}
+
+// ConstraintViolationError reports the constraint that a write would have
+// broken. It matches ErrConstraintViolation with errors.Is.
+type ConstraintViolationError struct {
+	Kind       ConstraintKind
+	Table      string
+	Constraint string
+	Columns    []string
+}
+
+func (e *ConstraintViolationError) Error() string {
+	return fmt.Sprintf("%s: %s constraint %s on %s(%s)", ErrConstraintViolation, e.Kind, e.Constraint, e.Table, strings.Join(e.Columns, ", "))
+}
+
+func (e *ConstraintViolationError) Is(target error) bool {
+	return target == ErrConstraintViolation
+}
+
 type Backend interface {
 	CreateTable(*CreateTableStatement) error
-	Insert(*InsertStatement)
+	// Insert, Update and Delete return the rows of their RETURNING clause,
+	// or nil Results without one.
+	Insert(*InsertStatement) (*Results, error)
+	Update(*UpdateStatement) (*Results, error)
+	Delete(*DeleteStatement) (*Results, error)
+	CreateSequence(*CreateSequenceStatement) error
+	AlterTable(*AlterTableStatement) error
+	CreateIndex(*CreateIndexStatement) error
+	DropIndex(*DropIndexStatement) error
+	// Analyze collects the column statistics the planner estimates with.
+	Analyze(*AnalyzeStatement) error
 	Select(*SelectStatement) (*Results, error)
+	// Query runs a SELECT, returning a cursor over its rows.
+	Query(*SelectStatement) (*Rows, error)
+	// Explain returns the plan of a SELECT as rows of text.
+	Explain(*ExplainStatement) (*Results, error)
+	// Begin starts a transaction, which Commit applies and Rollback
+	// undoes. Outside of one, every statement takes effect on its own.
+	Begin(*BeginStatement) error
+	Commit(*CommitStatement) error
+	// Rollback undoes the whole transaction, or only back to a savepoint.
+	Rollback(*RollbackStatement) error
+	Savepoint(*SavepointStatement) error
+	ReleaseSavepoint(*ReleaseSavepointStatement) error
+	// SetTransaction sets the isolation level of the transaction in
+	// progress, before it runs any other statement.
+	SetTransaction(*SetTransactionStatement) error
 }
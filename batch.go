@@ -0,0 +1,807 @@
+package gogn
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// batchSize is the most rows a batch operator works on at a time.
+const batchSize = 1024
+
+// columnBatch is a batch of rows held as a vector per column. selection
+// lists the positions of the rows still in play, in order.
+type columnBatch struct {
+	vectors   []*columnVector
+	selection []int
+}
+
+// row builds the row at position i of b.
+func (b *columnBatch) row(i int) []MemoryCell {
+	row := make([]MemoryCell, 0, len(b.vectors))
+	for _, v := range b.vectors {
+		row = append(row, v.cell(i))
+	}
+	return row
+}
+
+// batchIterator returns the next batch of an operator each time it's
+// called. ok is false once the batches run out.
+type batchIterator func() (batch *columnBatch, ok bool, err error)
+
+// batchNode is an operator that can produce its rows a batch at a time as
+// well as one at a time.
+type batchNode interface {
+	planNode
+	openBatches(mb *MemoryBackend) (batchIterator, error)
+}
+
+// runBatches opens node a batch at a time, recording what it did like run
+// when it's being analyzed.
+func runBatches(mb *MemoryBackend, node batchNode) (batchIterator, error) {
+	act := node.actual()
+	if act == nil {
+		return node.openBatches(mb)
+	}
+
+	start := time.Now()
+	it, err := node.openBatches(mb)
+	act.time += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	act.loops++
+
+	return func() (*columnBatch, bool, error) {
+		start := time.Now()
+		batch, ok, err := it()
+		act.time += time.Since(start)
+		if ok {
+			act.rows += len(batch.selection)
+		}
+		return batch, ok, err
+	}, nil
+}
+
+// batchRows returns the rows of the batches of it one at a time.
+func batchRows(it batchIterator) rowIterator {
+	var batch *columnBatch
+	i := 0
+	return func() ([]MemoryCell, bool, error) {
+		for batch == nil || i == len(batch.selection) {
+			b, ok, err := it()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			batch, i = b, 0
+		}
+
+		i++
+		return batch.row(batch.selection[i-1]), true, nil
+	}
+}
+
+// vectorPredicate is a condition on one column that a columnar scan checks
+// against a whole vector at once.
+type vectorPredicate struct {
+	column int
+	// op compares the column with value. Without one the predicate is IS
+	// NULL, or IS NOT NULL when notNull is set.
+	op      symbol
+	value   MemoryCell
+	typ     ColumnType
+	notNull bool
+}
+
+// vectorPredicates returns the predicates exp is made of, or false when
+// it's something else, which has to be evaluated a row at a time.
+func (mb *MemoryBackend) vectorPredicates(t *table, exp *expression) ([]vectorPredicate, bool) {
+	column := func(exp *expression) (int, bool) {
+		if exp.kind != literalKind || exp.literal.kind != identifierKind {
+			return -1, false
+		}
+		return t.columnIndex(exp.literal.value)
+	}
+
+	// compare is the comparison of column a with constant b.
+	compare := func(a, b *expression, op symbol) (vectorPredicate, bool) {
+		i, ok := column(a)
+		if !ok || !isConstant(b) {
+			return vectorPredicate{}, false
+		}
+
+		value, typ, err := mb.evaluateCell(&table{}, nil, b)
+		if err != nil {
+			return vectorPredicate{}, false
+		}
+		if _, ok := commonType(t.columnTypes[i], typ); !ok {
+			return vectorPredicate{}, false
+		}
+		return vectorPredicate{column: i, op: op, value: value, typ: typ}, true
+	}
+
+	switch exp.kind {
+	case binaryKind:
+		op, a, b := exp.binary.op, exp.binary.a, exp.binary.b
+
+		// IS NULL
+		if op.matchesKeyword(isKeyword) && b.literal.matchesKeyword(nullKeyword) {
+			if i, ok := column(a); ok {
+				return []vectorPredicate{{column: i}}, true
+			}
+		}
+		if op.kind != symbolKind {
+			break
+		}
+
+		switch symbol(op.value) {
+		case notEqualsSymbol, bangEqualsSymbol:
+			if p, ok := compare(a, b, notEqualsSymbol); ok {
+				return []vectorPredicate{p}, true
+			}
+			if p, ok := compare(b, a, notEqualsSymbol); ok {
+				return []vectorPredicate{p}, true
+			}
+		default:
+			reversed, comparison := flippedComparisons[symbol(op.value)]
+			if !comparison {
+				break
+			}
+			if p, ok := compare(a, b, symbol(op.value)); ok {
+				return []vectorPredicate{p}, true
+			}
+			if p, ok := compare(b, a, reversed); ok {
+				return []vectorPredicate{p}, true
+			}
+		}
+	case unaryKind:
+		// IS NOT NULL
+		operand := exp.unary.operand
+		if exp.unary.op.matchesKeyword(notKeyword) && operand.kind == binaryKind && operand.binary.op.matchesKeyword(isKeyword) && operand.binary.b.literal.matchesKeyword(nullKeyword) {
+			if i, ok := column(operand.binary.a); ok {
+				return []vectorPredicate{{column: i, notNull: true}}, true
+			}
+		}
+	case betweenKind:
+		low, lowOk := compare(exp.between.operand, exp.between.low, greaterThanOrEqualSymbol)
+		high, highOk := compare(exp.between.operand, exp.between.high, lessThanOrEqualSymbol)
+		if lowOk && highOk {
+			return []vectorPredicate{low, high}, true
+		}
+	}
+
+	return nil, false
+}
+
+// filter returns the positions of selection whose value in v satisfies p,
+// reusing selection to hold them.
+func (p vectorPredicate) filter(v *columnVector, selection []int) []int {
+	kept := selection[:0]
+	if p.op == "" {
+		for _, i := range selection {
+			if v.nulls[i] != p.notNull {
+				kept = append(kept, i)
+			}
+		}
+		return kept
+	}
+
+	// Comparing with NULL is never true.
+	if p.value == nil {
+		return kept
+	}
+
+	// accept tells whether to keep a row by how its value compares with
+	// p.value: less, equal or greater.
+	var accept [3]bool
+	switch p.op {
+	case equalsSymbol:
+		accept = [3]bool{false, true, false}
+	case notEqualsSymbol:
+		accept = [3]bool{true, false, true}
+	case lessThanSymbol:
+		accept = [3]bool{true, false, false}
+	case lessThanOrEqualSymbol:
+		accept = [3]bool{true, true, false}
+	case greaterThanSymbol:
+		accept = [3]bool{false, false, true}
+	case greaterThanOrEqualSymbol:
+		accept = [3]bool{false, true, true}
+	}
+
+	typ, _ := commonType(v.typ, p.typ)
+	switch {
+	case typ == IntType:
+		x := p.value.AsInt()
+		for _, i := range selection {
+			if v.nulls[i] {
+				continue
+			}
+			y := v.ints[i]
+			if (y < x && accept[0]) || (y == x && accept[1]) || (y > x && accept[2]) {
+				kept = append(kept, i)
+			}
+		}
+	case typ == FloatType:
+		x := coerceCell(p.value, p.typ, FloatType).AsFloat()
+		for _, i := range selection {
+			if v.nulls[i] {
+				continue
+			}
+
+			var y float64
+			if v.typ == IntType {
+				y = float64(v.ints[i])
+			} else {
+				y = v.floats[i]
+			}
+			if (y < x && accept[0]) || (y == x && accept[1]) || (y > x && accept[2]) {
+				kept = append(kept, i)
+			}
+		}
+	default:
+		for _, i := range selection {
+			if v.nulls[i] {
+				continue
+			}
+			if accept[compareCells(v.cell(i), v.typ, p.value, p.typ)+1] {
+				kept = append(kept, i)
+			}
+		}
+	}
+	return kept
+}
+
+// columnarScan reads a columnar table a batch at a time. It checks the
+// conjuncts of its filter that are vectorPredicates a vector at a time and
+// the rest a row at a time.
+type columnarScan struct {
+	planBase
+	table      *table
+	predicates []vectorPredicate
+	vectorized *expression
+	rest       *expression
+}
+
+// newColumnarScan plans reading t, whose columns schema names, filtered by
+// condition.
+func (mb *MemoryBackend) newColumnarScan(schema *table, t *table, condition *expression, e planEstimate) *columnarScan {
+	s := &columnarScan{planBase: planBase{out: schema, est: e}, table: t}
+	vectorized, rest := []*expression{}, []*expression{}
+	for _, c := range conjuncts(condition) {
+		if predicates, ok := mb.vectorPredicates(schema, c); ok {
+			s.predicates = append(s.predicates, predicates...)
+			vectorized = append(vectorized, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	s.vectorized, s.rest = conjoin(vectorized), conjoin(rest)
+	return s
+}
+
+func (s *columnarScan) children() []planNode {
+	return nil
+}
+
+func (s *columnarScan) describe() (string, []string) {
+	details := []string{}
+	if s.vectorized != nil {
+		details = append(details, "Batch Filter: "+s.vectorized.String())
+	}
+	if s.rest != nil {
+		details = append(details, "Filter: "+s.rest.String())
+	}
+	return scanName("Columnar Scan", s.table, s.out), details
+}
+
+func (s *columnarScan) openBatches(mb *MemoryBackend) (batchIterator, error) {
+	store := s.table.columnar
+	n := store.len()
+	start := 0
+	selection := make([]int, batchSize)
+	return func() (*columnBatch, bool, error) {
+		for start < n {
+			end := start + batchSize
+			if end > n {
+				end = n
+			}
+
+			batch := &columnBatch{selection: selection[:end-start]}
+			for _, v := range store.vectors {
+				batch.vectors = append(batch.vectors, v.slice(start, end))
+			}
+			start = end
+
+			for i := range batch.selection {
+				batch.selection[i] = i
+			}
+			for _, p := range s.predicates {
+				batch.selection = p.filter(batch.vectors[p.column], batch.selection)
+			}
+
+			if s.rest != nil {
+				kept := batch.selection[:0]
+				for _, i := range batch.selection {
+					matched, err := mb.matches(s.out, batch.row(i), s.rest)
+					if err != nil {
+						return nil, false, err
+					}
+					if matched {
+						kept = append(kept, i)
+					}
+				}
+				batch.selection = kept
+			}
+
+			if len(batch.selection) > 0 {
+				return batch, true, nil
+			}
+		}
+		return nil, false, nil
+	}, nil
+}
+
+func (s *columnarScan) open(mb *MemoryBackend) (rowIterator, error) {
+	batches, err := s.openBatches(mb)
+	if err != nil {
+		return nil, err
+	}
+	return batchRows(batches), nil
+}
+
+// batchProject evaluates the select items over the batches of its input.
+// Items that are columns of the input pass its vectors on as they are, and
+// the others are evaluated a row at a time into new vectors.
+type batchProject struct {
+	planBase
+	input batchNode
+	items []*expression
+}
+
+func (p *batchProject) children() []planNode {
+	return []planNode{p.input}
+}
+
+func (p *batchProject) describe() (string, []string) {
+	return "Batch Project", nil
+}
+
+func (p *batchProject) openBatches(mb *MemoryBackend) (batchIterator, error) {
+	input, err := runBatches(mb, p.input)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := p.input.schema()
+	return func() (*columnBatch, bool, error) {
+		in, ok, err := input()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+
+		out := &columnBatch{selection: in.selection}
+		for j, exp := range p.items {
+			if exp.kind == literalKind && exp.literal.kind == identifierKind {
+				if i, ok := schema.columnIndex(exp.literal.value); ok {
+					out.vectors = append(out.vectors, in.vectors[i])
+					continue
+				}
+			}
+
+			// Rows outside the selection are left NULL.
+			size := in.vectors[0].len()
+			v := newColumnVector(p.out.columnTypes[j], size)
+			next := 0
+			for _, i := range in.selection {
+				for ; next < i; next++ {
+					v.append(nil)
+				}
+				cell, typ, err := mb.evaluateCell(schema, in.row(i), exp)
+				if err != nil {
+					return nil, false, err
+				}
+				v.append(coerceCell(cell, typ, v.typ))
+				next++
+			}
+			for ; next < size; next++ {
+				v.append(nil)
+			}
+			out.vectors = append(out.vectors, v)
+		}
+		return out, true, nil
+	}, nil
+}
+
+func (p *batchProject) open(mb *MemoryBackend) (rowIterator, error) {
+	batches, err := p.openBatches(mb)
+	if err != nil {
+		return nil, err
+	}
+	return batchRows(batches), nil
+}
+
+// batchAccumulator computes an aggregate for every group of a batch
+// aggregate at once.
+type batchAccumulator interface {
+	// add folds the values of v at the positions of selection into the
+	// groups of those rows. v is nil for count(*).
+	add(v *columnVector, selection []int, groups []int) error
+	result(group int) MemoryCell
+}
+
+// countAccumulator is count(*), or count(x), which skips NULLs.
+type countAccumulator struct {
+	counts []int32
+}
+
+func (a *countAccumulator) add(v *columnVector, selection []int, groups []int) error {
+	for j, i := range selection {
+		g := groups[j]
+		for g >= len(a.counts) {
+			a.counts = append(a.counts, 0)
+		}
+		if v == nil || !v.nulls[i] {
+			a.counts[g]++
+		}
+	}
+	return nil
+}
+
+func (a *countAccumulator) result(group int) MemoryCell {
+	if group >= len(a.counts) {
+		return intToCell(0)
+	}
+	return intToCell(a.counts[group])
+}
+
+// sumAccumulator is sum of an integer column, which fails once the running
+// sum leaves the range of an integer.
+type sumAccumulator struct {
+	sums  []int64
+	empty []bool
+}
+
+func (a *sumAccumulator) add(v *columnVector, selection []int, groups []int) error {
+	for j, i := range selection {
+		g := groups[j]
+		for g >= len(a.sums) {
+			a.sums = append(a.sums, 0)
+			a.empty = append(a.empty, true)
+		}
+		if v.nulls[i] {
+			continue
+		}
+
+		a.sums[g] += int64(v.ints[i])
+		a.empty[g] = false
+		if a.sums[g] < math.MinInt32 || a.sums[g] > math.MaxInt32 {
+			return ErrIntegerOutOfRange
+		}
+	}
+	return nil
+}
+
+func (a *sumAccumulator) result(group int) MemoryCell {
+	if group >= len(a.sums) || a.empty[group] {
+		return nil
+	}
+	return intToCell(int32(a.sums[group]))
+}
+
+// floatSumAccumulator is sum of a float column, or avg of a number column.
+type floatSumAccumulator struct {
+	sums   []float64
+	counts []int
+	avg    bool
+}
+
+func (a *floatSumAccumulator) add(v *columnVector, selection []int, groups []int) error {
+	for j, i := range selection {
+		g := groups[j]
+		for g >= len(a.sums) {
+			a.sums = append(a.sums, 0)
+			a.counts = append(a.counts, 0)
+		}
+		if v.nulls[i] {
+			continue
+		}
+
+		if v.typ == IntType {
+			a.sums[g] += float64(v.ints[i])
+		} else {
+			a.sums[g] += v.floats[i]
+		}
+		a.counts[g]++
+	}
+	return nil
+}
+
+func (a *floatSumAccumulator) result(group int) MemoryCell {
+	if group >= len(a.sums) || a.counts[group] == 0 {
+		return nil
+	}
+	if a.avg {
+		return floatToCell(a.sums[group] / float64(a.counts[group]))
+	}
+	return floatToCell(a.sums[group])
+}
+
+// extremeAccumulator is min, or max, keeping the extreme of each group in
+// a vector of the column's type.
+type extremeAccumulator struct {
+	extremes *columnVector
+	max      bool
+}
+
+func (a *extremeAccumulator) add(v *columnVector, selection []int, groups []int) error {
+	if a.extremes == nil {
+		a.extremes = newColumnVector(v.typ, 0)
+	}
+
+	e := a.extremes
+	for j, i := range selection {
+		g := groups[j]
+		for g >= e.len() {
+			e.append(nil)
+		}
+		if v.nulls[i] {
+			continue
+		}
+
+		var c int
+		switch {
+		case e.nulls[g]:
+			c = -1
+			if a.max {
+				c = 1
+			}
+		case v.typ == IntType:
+			c = compareInts(v.ints[i], e.ints[g])
+		case v.typ == FloatType:
+			c = compareFloats(v.floats[i], e.floats[g])
+		case v.typ == TextType:
+			c = strings.Compare(v.texts[i], e.texts[g])
+		default:
+			c = compareCells(v.cell(i), v.typ, e.cell(g), e.typ)
+		}
+
+		if (a.max && c > 0) || (!a.max && c < 0) {
+			e.nulls[g] = false
+			switch v.typ {
+			case IntType:
+				e.ints[g] = v.ints[i]
+			case FloatType:
+				e.floats[g] = v.floats[i]
+			case BoolType:
+				e.bools[g] = v.bools[i]
+			default:
+				e.texts[g] = v.texts[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (a *extremeAccumulator) result(group int) MemoryCell {
+	if a.extremes == nil || group >= a.extremes.len() {
+		return nil
+	}
+	return a.extremes.cell(group)
+}
+
+func compareInts(x, y int32) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// batchAggregate groups the batches of its input by columns and computes
+// count, sum, avg, min and max of columns a batch at a time.
+type batchAggregate struct {
+	planBase
+	input batchNode
+	// groupBy and arguments are positions of columns of the input, and an
+	// argument is -1 for count(*).
+	groupBy         []int
+	groupExps       []*expression
+	arguments       []int
+	newAccumulators func() []batchAccumulator
+}
+
+// newBatchAggregate plans the aggregate plan over input a batch at a time,
+// returning false when it groups by or aggregates something other than
+// columns, or uses an aggregate function other than the built in ones.
+func (mb *MemoryBackend) newBatchAggregate(plan *logicalPlan, input batchNode, e planEstimate) (*batchAggregate, bool) {
+	schema := input.schema()
+	column := func(exp *expression) (int, bool) {
+		if exp.kind != literalKind || exp.literal.kind != identifierKind {
+			return -1, false
+		}
+		return schema.columnIndex(exp.literal.value)
+	}
+
+	a := &batchAggregate{planBase: planBase{out: plan.schema, est: e}, input: input, groupExps: plan.groupBy}
+	for _, exp := range plan.groupBy {
+		i, ok := column(exp)
+		if !ok {
+			return nil, false
+		}
+		a.groupBy = append(a.groupBy, i)
+	}
+
+	kinds := []func() batchAccumulator{}
+	for _, agg := range plan.aggregates {
+		name := strings.ToLower(agg.call.name.value)
+		builtin := false
+		for _, fn := range builtinFunctions[name] {
+			builtin = builtin || fn == agg.fn
+		}
+		if !builtin {
+			return nil, false
+		}
+
+		argument := -1
+		switch len(agg.call.args) {
+		case 0:
+			if name != "count" {
+				return nil, false
+			}
+		case 1:
+			i, ok := column(agg.call.args[0])
+			if !ok {
+				return nil, false
+			}
+			argument = i
+		default:
+			return nil, false
+		}
+		a.arguments = append(a.arguments, argument)
+
+		typ := NullType
+		if argument >= 0 {
+			typ = schema.columnTypes[argument]
+		}
+		switch {
+		case name == "count":
+			kinds = append(kinds, func() batchAccumulator { return &countAccumulator{} })
+		case name == "sum" && typ == IntType:
+			kinds = append(kinds, func() batchAccumulator { return &sumAccumulator{} })
+		case name == "sum" || name == "avg":
+			avg := name == "avg"
+			kinds = append(kinds, func() batchAccumulator { return &floatSumAccumulator{avg: avg} })
+		case name == "min" || name == "max":
+			max := name == "max"
+			kinds = append(kinds, func() batchAccumulator { return &extremeAccumulator{max: max} })
+		default:
+			return nil, false
+		}
+	}
+
+	a.newAccumulators = func() []batchAccumulator {
+		accumulators := []batchAccumulator{}
+		for _, kind := range kinds {
+			accumulators = append(accumulators, kind())
+		}
+		return accumulators
+	}
+	return a, true
+}
+
+func (a *batchAggregate) children() []planNode {
+	return []planNode{a.input}
+}
+
+func (a *batchAggregate) describe() (string, []string) {
+	if len(a.groupBy) == 0 {
+		return "Batch Aggregate", nil
+	}
+	return "Batch HashAggregate", []string{"Group Key: " + joinExpressions(a.groupExps)}
+}
+
+func (a *batchAggregate) open(mb *MemoryBackend) (rowIterator, error) {
+	input, err := runBatches(mb, a.input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Groups are numbered in the order they're first seen, and without
+	// GROUP BY there is exactly one, even over no rows.
+	accumulators := a.newAccumulators()
+	keys := [][]MemoryCell{}
+	if len(a.groupBy) == 0 {
+		keys = append(keys, nil)
+	}
+	intGroups := map[int32]int{}
+	nullGroup := -1
+	groupIndex := map[string]int{}
+
+	groups := make([]int, 0, batchSize)
+	for {
+		batch, ok, err := input()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		groups = groups[:0]
+		for _, i := range batch.selection {
+			if len(a.groupBy) == 0 {
+				groups = append(groups, 0)
+				continue
+			}
+
+			// A single integer column is looked up by its value rather
+			// than an encoded key.
+			if v := batch.vectors[a.groupBy[0]]; len(a.groupBy) == 1 && v.typ == IntType {
+				var g int
+				var found bool
+				if v.nulls[i] {
+					g, found = nullGroup, nullGroup >= 0
+				} else {
+					g, found = intGroups[v.ints[i]]
+				}
+				if !found {
+					g = len(keys)
+					keys = append(keys, []MemoryCell{v.cell(i)})
+					if v.nulls[i] {
+						nullGroup = g
+					} else {
+						intGroups[v.ints[i]] = g
+					}
+				}
+				groups = append(groups, g)
+				continue
+			}
+
+			values := []MemoryCell{}
+			for _, column := range a.groupBy {
+				values = append(values, batch.vectors[column].cell(i))
+			}
+			key := encodeKey(values)
+			g, found := groupIndex[key]
+			if !found {
+				g = len(keys)
+				keys = append(keys, values)
+				groupIndex[key] = g
+			}
+			groups = append(groups, g)
+		}
+
+		for j, acc := range accumulators {
+			var v *columnVector
+			if a.arguments[j] >= 0 {
+				v = batch.vectors[a.arguments[j]]
+			}
+			if err := acc.add(v, batch.selection, groups); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows := [][]MemoryCell{}
+	for g, values := range keys {
+		row := append([]MemoryCell{}, values...)
+		for _, acc := range accumulators {
+			row = append(row, acc.result(g))
+		}
+		rows = append(rows, row)
+	}
+	return iterateRows(rows), nil
+}
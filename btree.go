@@ -0,0 +1,312 @@
+package gogn
+
+import (
+	"sort"
+)
+
+// btreeDegree is the minimum degree of a btree: nodes other than the root
+// hold between btreeDegree-1 and 2*btreeDegree-1 entries.
+const btreeDegree = 32
+
+// indexEntry is one row of an index: the row's values of the index columns
+// and the row's id.
+type indexEntry struct {
+	key []MemoryCell
+	row uint64
+}
+
+// owner marks the nodes of a tree that the tree can change in place. A copy
+// of a tree shares its nodes under an owner of its own, so it copies a node
+// before changing it and leaves the tree it was copied from alone.
+type owner struct{ _ byte }
+
+type btreeNode struct {
+	owner   *owner
+	entries []indexEntry
+	// children is nil for leaves and holds one more node than entries
+	// otherwise.
+	children []*btreeNode
+}
+
+func (n *btreeNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// btree is an in-memory B-tree of index entries ordered by key, with NULL
+// before every other value, and then by row. types are the types of the
+// key columns. Copies of a btree share its nodes until they change them.
+type btree struct {
+	types []ColumnType
+	root  *btreeNode
+	owner *owner
+}
+
+func newBtree(types []ColumnType) *btree {
+	o := &owner{}
+	return &btree{types: types, root: &btreeNode{owner: o}, owner: o}
+}
+
+// mutable returns n for b to change: n itself if b owns it, or else a copy
+// of it that b owns.
+func (b *btree) mutable(n *btreeNode) *btreeNode {
+	if n.owner == b.owner {
+		return n
+	}
+	c := &btreeNode{owner: b.owner, entries: append([]indexEntry{}, n.entries...)}
+	if !n.leaf() {
+		c.children = append([]*btreeNode{}, n.children...)
+	}
+	return c
+}
+
+// mutableChild makes child i of n, which b owns, one b can change, and
+// returns it.
+func (b *btree) mutableChild(n *btreeNode, i int) *btreeNode {
+	n.children[i] = b.mutable(n.children[i])
+	return n.children[i]
+}
+
+// compareKeys compares a and b column by column over the columns they both
+// have, so that a shorter key compares equal to every key it's a prefix of.
+func compareKeys(a []MemoryCell, aTypes []ColumnType, b []MemoryCell, bTypes []ColumnType) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return -1
+		case b[i] == nil:
+			return 1
+		}
+
+		if c := compareCells(a[i], aTypes[i], b[i], bTypes[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (b *btree) compare(x, y indexEntry) int {
+	if c := compareKeys(x.key, b.types, y.key, b.types); c != 0 {
+		return c
+	}
+
+	switch {
+	case x.row < y.row:
+		return -1
+	case x.row > y.row:
+		return 1
+	}
+	return 0
+}
+
+// search returns the position of the first entry of n not before e.
+func (b *btree) search(n *btreeNode, e indexEntry) int {
+	return sort.Search(len(n.entries), func(i int) bool {
+		return b.compare(n.entries[i], e) >= 0
+	})
+}
+
+func insertEntry(entries []indexEntry, i int, e indexEntry) []indexEntry {
+	entries = append(entries, indexEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	return entries
+}
+
+func removeEntry(entries []indexEntry, i int) []indexEntry {
+	copy(entries[i:], entries[i+1:])
+	return entries[:len(entries)-1]
+}
+
+func insertChild(children []*btreeNode, i int, child *btreeNode) []*btreeNode {
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	return children
+}
+
+func removeChild(children []*btreeNode, i int) []*btreeNode {
+	copy(children[i:], children[i+1:])
+	return children[:len(children)-1]
+}
+
+// splitChild splits the full child i of n in two around its median entry,
+// which moves up into n.
+func (b *btree) splitChild(n *btreeNode, i int) {
+	child := b.mutableChild(n, i)
+	median := child.entries[btreeDegree-1]
+
+	right := &btreeNode{owner: b.owner, entries: append([]indexEntry{}, child.entries[btreeDegree:]...)}
+	if !child.leaf() {
+		right.children = append([]*btreeNode{}, child.children[btreeDegree:]...)
+		child.children = child.children[:btreeDegree]
+	}
+	child.entries = child.entries[:btreeDegree-1]
+
+	n.entries = insertEntry(n.entries, i, median)
+	n.children = insertChild(n.children, i+1, right)
+}
+
+// insert adds e to b, splitting full nodes on the way down so that there is
+// always room for a median moving up.
+func (b *btree) insert(e indexEntry) {
+	b.root = b.mutable(b.root)
+	if len(b.root.entries) == 2*btreeDegree-1 {
+		b.root = &btreeNode{owner: b.owner, children: []*btreeNode{b.root}}
+		b.splitChild(b.root, 0)
+	}
+
+	n := b.root
+	for {
+		i := b.search(n, e)
+		if n.leaf() {
+			n.entries = insertEntry(n.entries, i, e)
+			return
+		}
+
+		if len(n.children[i].entries) == 2*btreeDegree-1 {
+			b.splitChild(n, i)
+			if b.compare(e, n.entries[i]) > 0 {
+				i++
+			}
+		}
+		n = b.mutableChild(n, i)
+	}
+}
+
+func (n *btreeNode) min() indexEntry {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.entries[0]
+}
+
+func (n *btreeNode) max() indexEntry {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.entries[len(n.entries)-1]
+}
+
+// merge joins child i+1 of n and the entry between them onto child i.
+func (b *btree) merge(n *btreeNode, i int) {
+	left, right := b.mutableChild(n, i), n.children[i+1]
+	left.entries = append(left.entries, n.entries[i])
+	left.entries = append(left.entries, right.entries...)
+	left.children = append(left.children, right.children...)
+
+	n.entries = removeEntry(n.entries, i)
+	n.children = removeChild(n.children, i+1)
+}
+
+// fill gives child i of n, which has the minimum number of entries, one
+// more by borrowing from a sibling or merging with one. It returns the
+// position of the child afterwards.
+func (b *btree) fill(n *btreeNode, i int) int {
+	switch {
+	case i > 0 && len(n.children[i-1].entries) >= btreeDegree:
+		child, left := b.mutableChild(n, i), b.mutableChild(n, i-1)
+		last := len(left.entries) - 1
+		child.entries = insertEntry(child.entries, 0, n.entries[i-1])
+		n.entries[i-1] = left.entries[last]
+		left.entries = left.entries[:last]
+		if !left.leaf() {
+			child.children = insertChild(child.children, 0, left.children[last+1])
+			left.children = left.children[:last+1]
+		}
+	case i < len(n.children)-1 && len(n.children[i+1].entries) >= btreeDegree:
+		child, right := b.mutableChild(n, i), b.mutableChild(n, i+1)
+		child.entries = append(child.entries, n.entries[i])
+		n.entries[i] = right.entries[0]
+		right.entries = removeEntry(right.entries, 0)
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = removeChild(right.children, 0)
+		}
+	case i < len(n.children)-1:
+		b.merge(n, i)
+	default:
+		b.merge(n, i-1)
+		i--
+	}
+	return i
+}
+
+// delete removes e from b if it's there. Nodes are topped up on the way
+// down so that removing an entry never leaves one with too few.
+func (b *btree) delete(e indexEntry) {
+	b.root = b.mutable(b.root)
+	n := b.root
+	for {
+		i := b.search(n, e)
+		found := i < len(n.entries) && b.compare(n.entries[i], e) == 0
+
+		if n.leaf() {
+			if found {
+				n.entries = removeEntry(n.entries, i)
+			}
+			break
+		}
+
+		if !found {
+			if len(n.children[i].entries) < btreeDegree {
+				i = b.fill(n, i)
+			}
+			n = b.mutableChild(n, i)
+			continue
+		}
+
+		// Replace e with its predecessor or successor and delete that
+		// from the child instead, or push e down into the merged
+		// children when both are minimal.
+		left, right := n.children[i], n.children[i+1]
+		switch {
+		case len(left.entries) >= btreeDegree:
+			e = left.max()
+			n.entries[i] = e
+			n = b.mutableChild(n, i)
+		case len(right.entries) >= btreeDegree:
+			e = right.min()
+			n.entries[i] = e
+			n = b.mutableChild(n, i+1)
+		default:
+			b.merge(n, i)
+			n = n.children[i]
+		}
+	}
+
+	if len(b.root.entries) == 0 && !b.root.leaf() {
+		b.root = b.root.children[0]
+	}
+}
+
+// ascend calls f on every entry whose key isn't before from, in order,
+// until f returns false. from may be a prefix of the key, or nil to start
+// at the first entry.
+func (b *btree) ascend(from []MemoryCell, fromTypes []ColumnType, f func(indexEntry) bool) {
+	b.ascendNode(b.root, from, fromTypes, f)
+}
+
+func (b *btree) ascendNode(n *btreeNode, from []MemoryCell, fromTypes []ColumnType, f func(indexEntry) bool) bool {
+	i := sort.Search(len(n.entries), func(i int) bool {
+		return compareKeys(n.entries[i].key, b.types, from, fromTypes) >= 0
+	})
+
+	for ; i <= len(n.entries); i++ {
+		if !n.leaf() && !b.ascendNode(n.children[i], from, fromTypes, f) {
+			return false
+		}
+		if i < len(n.entries) && !f(n.entries[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// clone returns a copy of b whose entries can change without affecting b.
+// The copy shares the nodes of b, so cloning takes no time whatever the
+// size of b.
+func (b *btree) clone() *btree {
+	return &btree{types: b.types, root: b.root, owner: &owner{}}
+}
@@ -0,0 +1,331 @@
+package gogn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Catalogs describe the tables and sequences of a database for storing it,
+// in varints, length-prefixed strings and lists prefixed with their length.
+// Expressions are stored as their SQL text. Indexes and the keys of unique
+// constraints are stored by definition only, and rebuilt from the rows.
+
+// maxColumns bounds the columns of a table read from a catalog.
+const maxColumns = 1 << 16
+
+type catalogEncoder struct {
+	data []byte
+}
+
+func (e *catalogEncoder) uint(v uint64) {
+	e.data = binary.AppendUvarint(e.data, v)
+}
+
+func (e *catalogEncoder) int(v int64) {
+	e.data = binary.AppendVarint(e.data, v)
+}
+
+func (e *catalogEncoder) bool(b bool) {
+	if b {
+		e.uint(1)
+	} else {
+		e.uint(0)
+	}
+}
+
+func (e *catalogEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.data = append(e.data, s...)
+}
+
+func (e *catalogEncoder) columns(columns []int) {
+	e.uint(uint64(len(columns)))
+	for _, col := range columns {
+		e.uint(uint64(col))
+	}
+}
+
+// tableNames returns the names of the tables of mb in order, so that
+// catalogs of the same tables encode the same.
+func (mb *MemoryBackend) tableNames() []string {
+	names := []string{}
+	for name := range mb.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// table encodes the definition of t, including its layout.
+func (e *catalogEncoder) table(t *table) {
+	e.string(t.name)
+	e.bool(t.columnar != nil)
+
+	e.uint(uint64(len(t.columns)))
+	for i, col := range t.columns {
+		e.string(col)
+		e.uint(uint64(t.columnTypes[i]))
+		e.bool(t.notNull[i])
+		e.bool(t.defaults[i] != nil)
+		if t.defaults[i] != nil {
+			e.string(t.defaults[i].String())
+		}
+	}
+
+	e.uint(uint64(len(t.uniques)))
+	for _, u := range t.uniques {
+		e.string(u.name)
+		e.bool(u.primaryKey)
+		e.columns(u.columns)
+	}
+
+	e.uint(uint64(len(t.foreignKeys)))
+	for _, fk := range t.foreignKeys {
+		e.string(fk.name)
+		e.columns(fk.columns)
+		e.string(fk.parent)
+		e.columns(fk.parentColumns)
+		e.uint(uint64(fk.onDelete))
+		e.uint(uint64(fk.onUpdate))
+	}
+
+	e.uint(uint64(len(t.checks)))
+	for _, c := range t.checks {
+		e.string(c.name)
+		e.string(c.condition.String())
+		e.columns(c.columns)
+	}
+
+	e.uint(uint64(len(t.indexes)))
+	for _, ix := range t.indexes {
+		e.string(ix.name)
+		e.columns(ix.columns)
+		e.bool(ix.unique)
+		e.bool(ix.constraint)
+	}
+}
+
+// sequences encodes every sequence of mb with its state.
+func (e *catalogEncoder) sequences(mb *MemoryBackend) {
+	names := []string{}
+	for name := range mb.sequences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e.uint(uint64(len(names)))
+	for _, name := range names {
+		s := mb.sequences[name]
+		s.mu.Lock()
+		e.string(s.name)
+		e.int(s.increment)
+		e.int(s.value)
+		e.bool(s.called)
+		s.mu.Unlock()
+	}
+}
+
+// catalogDecoder reads an encoded catalog, keeping the first error so that
+// a whole entry can be read before checking for one. Errors wrap corrupt.
+type catalogDecoder struct {
+	data    []byte
+	err     error
+	corrupt error
+}
+
+func (c *catalogDecoder) fail(what string) {
+	if c.err == nil {
+		c.err = fmt.Errorf("%w: bad catalog %s", c.corrupt, what)
+	}
+}
+
+func (c *catalogDecoder) uint() uint64 {
+	v, n := binary.Uvarint(c.data)
+	if n <= 0 {
+		c.fail("number")
+		return 0
+	}
+	c.data = c.data[n:]
+	return v
+}
+
+func (c *catalogDecoder) int() int64 {
+	v, n := binary.Varint(c.data)
+	if n <= 0 {
+		c.fail("number")
+		return 0
+	}
+	c.data = c.data[n:]
+	return v
+}
+
+func (c *catalogDecoder) bool() bool {
+	return c.uint() != 0
+}
+
+// count reads the length of a list, every item of which takes at least a
+// byte.
+func (c *catalogDecoder) count() int {
+	n := c.uint()
+	if n > uint64(len(c.data)) {
+		c.fail("length")
+		return 0
+	}
+	return int(n)
+}
+
+func (c *catalogDecoder) string() string {
+	n := c.count()
+	s := string(c.data[:n])
+	c.data = c.data[n:]
+	return s
+}
+
+// columns reads a list of columns of a table with n of them.
+func (c *catalogDecoder) columns(n int) []int {
+	columns := []int{}
+	for i := c.count(); i > 0; i-- {
+		col := c.uint()
+		if col >= uint64(n) {
+			c.fail("column")
+			return nil
+		}
+		columns = append(columns, int(col))
+	}
+	return columns
+}
+
+func (c *catalogDecoder) expression() *expression {
+	text := c.string()
+	if c.err != nil {
+		return nil
+	}
+
+	exp, err := parseExpressionText(text)
+	if err != nil {
+		c.fail("expression " + text)
+	}
+	return exp
+}
+
+// table decodes the definition of a table, returning whether it's
+// columnar. Its rows are then to be filled in and restored.
+func (c *catalogDecoder) table() (*table, bool) {
+	t := &table{name: c.string()}
+	columnar := c.bool()
+
+	for j := c.count(); j > 0; j-- {
+		t.columns = append(t.columns, c.string())
+		t.columnTypes = append(t.columnTypes, ColumnType(c.uint()))
+		t.notNull = append(t.notNull, c.bool())
+
+		var def *expression
+		if c.bool() {
+			def = c.expression()
+		}
+		t.defaults = append(t.defaults, def)
+	}
+	n := len(t.columns)
+
+	for j := c.count(); j > 0; j-- {
+		t.uniques = append(t.uniques, &uniqueConstraint{
+			name:       c.string(),
+			primaryKey: c.bool(),
+			columns:    c.columns(n),
+		})
+	}
+
+	for j := c.count(); j > 0; j-- {
+		t.foreignKeys = append(t.foreignKeys, &foreignKey{
+			name:    c.string(),
+			columns: c.columns(n),
+			parent:  c.string(),
+			// The parent may come later, so its columns are checked once
+			// every table is read.
+			parentColumns: c.columns(maxColumns),
+			onDelete:      referentialAction(c.uint()),
+			onUpdate:      referentialAction(c.uint()),
+		})
+	}
+
+	for j := c.count(); j > 0; j-- {
+		t.checks = append(t.checks, &checkConstraint{
+			name:      c.string(),
+			condition: c.expression(),
+			columns:   c.columns(n),
+		})
+	}
+
+	for j := c.count(); j > 0; j-- {
+		t.indexes = append(t.indexes, &index{
+			name:       c.string(),
+			columns:    c.columns(n),
+			unique:     c.bool(),
+			constraint: c.bool(),
+		})
+	}
+	return t, columnar
+}
+
+func (c *catalogDecoder) sequences(mb *MemoryBackend) {
+	for i := c.count(); i > 0 && c.err == nil; i-- {
+		s := &sequence{
+			name:      c.string(),
+			increment: c.int(),
+			value:     c.int(),
+			called:    c.bool(),
+		}
+		mb.sequences[s.name] = s
+	}
+}
+
+// validColumns reports whether columns are all columns of t.
+func (t *table) validColumns(columns []int) bool {
+	for _, col := range columns {
+		if col >= len(t.columns) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkForeignKeys checks that the foreign keys of the tables read refer
+// to tables and columns that exist.
+func (c *catalogDecoder) checkForeignKeys(mb *MemoryBackend) {
+	for _, t := range mb.tables {
+		for _, fk := range t.foreignKeys {
+			parent, ok := mb.tables[fk.parent]
+			if !ok || !parent.validColumns(fk.parentColumns) {
+				c.fail("foreign key " + fk.name)
+			}
+		}
+	}
+}
+
+// restore rebuilds what isn't stored of a table once its rows are in
+// place: the columns of a columnar table and its indexes.
+func (t *table) restore(columnar bool) error {
+	if columnar {
+		rows, versions := [][]MemoryCell{}, []rowVersion{}
+		t.eachRow(func(v rowVersion, row []MemoryCell) error {
+			rows = append(rows, row)
+			versions = append(versions, v)
+			return nil
+		})
+		t.columnar = newColumnStore(t.columnTypes, rows)
+		t.heap, t.versions = nil, versions
+	}
+	return t.rebuild()
+}
+
+// rebuild builds the indexes of t from its rows, failing on the first
+// duplicate key.
+func (t *table) rebuild() error {
+	for _, ix := range t.indexes {
+		if err := t.buildIndex(ix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
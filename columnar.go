@@ -0,0 +1,338 @@
+package gogn
+
+import (
+	"sort"
+)
+
+// Tables are stored in one of two layouts: heap tables keep a slice of cells
+// per row in a rowHeap, while columnar tables keep each column in one slice of its type,
+// which batch operators work through without decoding a cell at a time.
+// Tables of a DiskBackend also keep their rows in a rowTree, which heap
+// tables read them back from and columnar ones only write them through to.
+// Either way rows are kept in the order of their ids, which never change,
+// so that indexes can refer to rows by id.
+
+// columnVector holds values of one column in a slice of its type. nulls
+// marks the NULLs, whose slots in the typed slice hold the zero value.
+type columnVector struct {
+	typ    ColumnType
+	ints   []int32
+	floats []float64
+	bools  []bool
+	texts  []string
+	nulls  []bool
+}
+
+func newColumnVector(typ ColumnType, capacity int) *columnVector {
+	v := &columnVector{typ: typ, nulls: make([]bool, 0, capacity)}
+	switch typ {
+	case IntType:
+		v.ints = make([]int32, 0, capacity)
+	case FloatType:
+		v.floats = make([]float64, 0, capacity)
+	case BoolType:
+		v.bools = make([]bool, 0, capacity)
+	default:
+		v.texts = make([]string, 0, capacity)
+	}
+	return v
+}
+
+func (v *columnVector) len() int {
+	return len(v.nulls)
+}
+
+func (v *columnVector) append(cell MemoryCell) {
+	v.nulls = append(v.nulls, cell == nil)
+	switch v.typ {
+	case IntType:
+		var i int32
+		if cell != nil {
+			i = cell.AsInt()
+		}
+		v.ints = append(v.ints, i)
+	case FloatType:
+		var f float64
+		if cell != nil {
+			f = cell.AsFloat()
+		}
+		v.floats = append(v.floats, f)
+	case BoolType:
+		v.bools = append(v.bools, cell != nil && cell.AsBool())
+	default:
+		v.texts = append(v.texts, string(cell))
+	}
+}
+
+// cell returns value i as a cell.
+func (v *columnVector) cell(i int) MemoryCell {
+	if v.nulls[i] {
+		return nil
+	}
+
+	switch v.typ {
+	case IntType:
+		return intToCell(v.ints[i])
+	case FloatType:
+		return floatToCell(v.floats[i])
+	case BoolType:
+		return boolToCell(v.bools[i])
+	}
+	return MemoryCell(v.texts[i])
+}
+
+// slice returns the values from i up to j, sharing their storage until
+// values are appended to them.
+func (v *columnVector) slice(i, j int) *columnVector {
+	s := &columnVector{typ: v.typ, nulls: v.nulls[i:j:j]}
+	switch v.typ {
+	case IntType:
+		s.ints = v.ints[i:j:j]
+	case FloatType:
+		s.floats = v.floats[i:j:j]
+	case BoolType:
+		s.bools = v.bools[i:j:j]
+	default:
+		s.texts = v.texts[i:j:j]
+	}
+	return s
+}
+
+// columnStore holds the rows of a columnar table as a vector per column.
+type columnStore struct {
+	vectors []*columnVector
+}
+
+func newColumnStore(types []ColumnType, rows [][]MemoryCell) *columnStore {
+	s := &columnStore{}
+	for i, typ := range types {
+		v := newColumnVector(typ, len(rows))
+		for _, row := range rows {
+			v.append(row[i])
+		}
+		s.vectors = append(s.vectors, v)
+	}
+	return s
+}
+
+func (s *columnStore) len() int {
+	if len(s.vectors) == 0 {
+		return 0
+	}
+	return s.vectors[0].len()
+}
+
+func (s *columnStore) row(i int) []MemoryCell {
+	row := make([]MemoryCell, 0, len(s.vectors))
+	for _, v := range s.vectors {
+		row = append(row, v.cell(i))
+	}
+	return row
+}
+
+// rowCount is the number of rows of t.
+func (t *table) rowCount() int {
+	if t.columnar != nil {
+		return t.columnar.len()
+	}
+	if t.paged != nil {
+		return t.paged.count
+	}
+	return t.heap.len()
+}
+
+// position returns the position of the row with id in the versions of the
+// columnar table t, which are in the order of their ids.
+func (t *table) position(id uint64) int {
+	return sort.Search(len(t.versions), func(i int) bool {
+		return t.versions[i].id >= id
+	})
+}
+
+// lookup returns the row of t with id. The row of a columnar or paged table
+// is built from its storage, so changing it leaves the table alone.
+func (t *table) lookup(id uint64) []MemoryCell {
+	if t.columnar != nil {
+		return t.columnar.row(t.position(id))
+	}
+	if t.paged != nil {
+		data, err := t.paged.get(id)
+		must(err)
+		row, err := decodeRow(data)
+		must(err)
+		return row
+	}
+	r, _ := t.heap.get(id)
+	return r.cells
+}
+
+// allRows returns every row of t.
+func (t *table) allRows() [][]MemoryCell {
+	if t.columnar == nil {
+		rows, err := collect(t.scanRows())
+		must(err)
+		return rows
+	}
+
+	rows := make([][]MemoryCell, 0, t.columnar.len())
+	for i := 0; i < t.columnar.len(); i++ {
+		rows = append(rows, t.columnar.row(i))
+	}
+	return rows
+}
+
+// eachRow calls f on every row of t with its version, in the order of their
+// ids, until f fails. The rows of a paged table have their id as version.
+func (t *table) eachRow(f func(v rowVersion, row []MemoryCell) error) error {
+	if t.columnar == nil && t.paged == nil {
+		next := t.heap.scan()
+		for r, ok := next(); ok; r, ok = next() {
+			if err := f(r.version, r.cells); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if t.columnar == nil {
+		next := t.paged.scan()
+		for {
+			key, data, ok, err := next()
+			must(err)
+			if !ok {
+				return nil
+			}
+			row, err := decodeRow(data)
+			must(err)
+			if err := f(rowVersion{id: key}, row); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, v := range t.versions {
+		if err := f(v, t.columnar.row(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRows replaces the rows of t, each with its version, in the order of
+// their ids.
+func (t *table) setRows(rows [][]MemoryCell, versions []rowVersion) {
+	if t.paged != nil {
+		keys := make([]uint64, 0, len(rows))
+		encoded := make([][]byte, 0, len(rows))
+		for i, row := range rows {
+			keys = append(keys, versions[i].id)
+			encoded = append(encoded, encodeRow(row))
+		}
+		must(t.paged.replace(keys, encoded))
+	}
+
+	if t.columnar != nil {
+		t.columnar = newColumnStore(t.columnTypes, rows)
+		t.versions = versions
+		return
+	}
+	if t.paged == nil {
+		t.heap = newRowHeap()
+		for i, row := range rows {
+			t.heap.put(versions[i], row)
+		}
+	}
+}
+
+// appendRow adds row to the end of t with its version, whose id comes after
+// those of the rows of t.
+func (t *table) appendRow(row []MemoryCell, version rowVersion) {
+	if t.paged != nil {
+		must(t.paged.put(version.id, encodeRow(row)))
+	}
+
+	if t.columnar != nil {
+		for i, v := range t.columnar.vectors {
+			v.append(row[i])
+		}
+		t.versions = append(t.versions, version)
+		return
+	}
+	if t.paged == nil {
+		if t.heap == nil {
+			t.heap = newRowHeap()
+		}
+		t.heap.put(version, row)
+	}
+}
+
+// updateRows replaces the rows of t with the given ids, which are in
+// order, with their versions in updated, stamped by mb, deleting those that
+// are nil. The rows of heap and paged tables change where they are in their
+// trees, while the columns of a columnar table are rebuilt.
+func (t *table) updateRows(ids []uint64, updated map[uint64][]MemoryCell, mb *MemoryBackend) {
+	for _, id := range ids {
+		row := updated[id]
+		switch {
+		case t.paged != nil && row != nil:
+			must(t.paged.put(id, encodeRow(row)))
+		case t.paged != nil:
+			must(t.paged.delete(id))
+		case t.columnar == nil && row != nil:
+			t.heap.put(mb.stamp(id), row)
+		case t.columnar == nil:
+			t.heap.delete(id)
+		}
+	}
+	if t.columnar == nil {
+		return
+	}
+
+	rows := make([][]MemoryCell, 0, len(t.versions))
+	versions := make([]rowVersion, 0, len(t.versions))
+	t.eachRow(func(v rowVersion, row []MemoryCell) error {
+		if newRow, ok := updated[v.id]; ok {
+			row, v = newRow, mb.stamp(v.id)
+		}
+		if row != nil {
+			rows = append(rows, row)
+			versions = append(versions, v)
+		}
+		return nil
+	})
+
+	t.columnar = newColumnStore(t.columnTypes, rows)
+	t.versions = versions
+}
+
+// scanRows returns the rows of t one at a time.
+func (t *table) scanRows() rowIterator {
+	if t.columnar == nil && t.paged == nil {
+		next := t.heap.scan()
+		return func() ([]MemoryCell, bool, error) {
+			r, ok := next()
+			return r.cells, ok, nil
+		}
+	}
+
+	if t.columnar == nil {
+		next := t.paged.scan()
+		return func() ([]MemoryCell, bool, error) {
+			_, data, ok, err := next()
+			if !ok {
+				return nil, false, err
+			}
+			row, err := decodeRow(data)
+			return row, err == nil, err
+		}
+	}
+
+	store, i := t.columnar, 0
+	return func() ([]MemoryCell, bool, error) {
+		if i == store.len() {
+			return nil, false, nil
+		}
+		i++
+		return store.row(i - 1), true, nil
+	}
+}
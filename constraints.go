@@ -0,0 +1,635 @@
+package gogn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// encodeKey encodes a list of cells so that two lists encode the same
+// exactly when their cells are equal, with NULL equal to NULL.
+func encodeKey(cells []MemoryCell) string {
+	var b strings.Builder
+	for _, cell := range cells {
+		if cell == nil {
+			b.WriteByte(0)
+			continue
+		}
+
+		var length [5]byte
+		length[0] = 1
+		binary.BigEndian.PutUint32(length[1:], uint32(len(cell)))
+		b.Write(length[:])
+		b.Write(cell)
+	}
+	return b.String()
+}
+
+// uniqueConstraint is a PRIMARY KEY or UNIQUE constraint. Its keys are
+// looked up in the index of the same name. Only keys whose columns are all
+// non-NULL can conflict, since NULLs never equal each other.
+type uniqueConstraint struct {
+	name       string
+	primaryKey bool
+	columns    []int
+}
+
+func (u *uniqueConstraint) kind() ConstraintKind {
+	if u.primaryKey {
+		return PrimaryKeyConstraint
+	}
+	return UniqueConstraint
+}
+
+// keyCells returns the cells of row in columns, or false when one of them
+// is NULL.
+func keyCells(row []MemoryCell, columns []int) ([]MemoryCell, bool) {
+	cells := []MemoryCell{}
+	for _, i := range columns {
+		if row[i] == nil {
+			return nil, false
+		}
+		cells = append(cells, row[i])
+	}
+	return cells, true
+}
+
+// key returns the encoded key of row, or false when a key column is NULL.
+func (u *uniqueConstraint) key(row []MemoryCell) (string, bool) {
+	cells, ok := keyCells(row, u.columns)
+	if !ok {
+		return "", false
+	}
+	return encodeKey(cells), true
+}
+
+func (t *table) columnNames(columns []int) []string {
+	names := []string{}
+	for _, i := range columns {
+		names = append(names, t.columns[i])
+	}
+	return names
+}
+
+func (t *table) violation(kind ConstraintKind, name string, columns []int) error {
+	return &ConstraintViolationError{
+		Kind:       kind,
+		Table:      t.name,
+		Constraint: name,
+		Columns:    t.columnNames(columns),
+	}
+}
+
+// addUnique adds a PRIMARY KEY or UNIQUE constraint over columns, naming it
+// like Postgres when name is empty.
+func (t *table) addUnique(name string, kind ConstraintKind, columns []int) error {
+	u := &uniqueConstraint{
+		name:       name,
+		primaryKey: kind == PrimaryKeyConstraint,
+		columns:    columns,
+	}
+
+	if u.primaryKey {
+		for _, other := range t.uniques {
+			if other.primaryKey {
+				return fmt.Errorf("%w: multiple primary keys for table %s", ErrInvalidConstraint, t.name)
+			}
+		}
+
+		for _, i := range columns {
+			t.notNull[i] = true
+		}
+	}
+
+	if u.name == "" && u.primaryKey {
+		u.name = t.name + "_pkey"
+	} else if u.name == "" {
+		u.name = t.name + "_" + strings.Join(t.columnNames(columns), "_") + "_key"
+	}
+
+	t.uniques = append(t.uniques, u)
+
+	// The index starts out empty, for the rows to be added to.
+	t.indexes = append(t.indexes, &index{
+		name:       u.name,
+		columns:    columns,
+		unique:     true,
+		constraint: true,
+		tree:       newBtree(t.columnTypesOf(columns)),
+	})
+	return nil
+}
+
+// checkNotNull enforces the NOT NULL constraints of t on row.
+func (t *table) checkNotNull(row []MemoryCell) error {
+	for i, cell := range row {
+		if cell == nil && t.notNull[i] {
+			return t.violation(NotNullConstraint, t.columns[i]+"_not_null", []int{i})
+		}
+	}
+	return nil
+}
+
+// foreignKey is a FOREIGN KEY constraint of its table on parent. columns and
+// parentColumns are paired up in the column order of the parent's unique
+// constraint on parentColumns, so that a row's key over columns can be
+// looked up directly in that constraint's index.
+type foreignKey struct {
+	name          string
+	columns       []int
+	parent        string
+	parentColumns []int
+	onDelete      referentialAction
+	onUpdate      referentialAction
+}
+
+// key returns the encoded key that row references, or false when a key
+// column is NULL, in which case the row references nothing.
+func (fk *foreignKey) key(row []MemoryCell) (string, bool) {
+	cells, ok := keyCells(row, fk.columns)
+	if !ok {
+		return "", false
+	}
+	return encodeKey(cells), true
+}
+
+// uniqueOn returns the index of the unique constraint of t over exactly the
+// given columns, in any order.
+func (t *table) uniqueOn(columns []int) (int, bool) {
+outer:
+	for i, u := range t.uniques {
+		if len(u.columns) != len(columns) {
+			continue
+		}
+
+		for _, col := range columns {
+			found := false
+			for _, other := range u.columns {
+				found = found || col == other
+			}
+			if !found {
+				continue outer
+			}
+		}
+		return i, true
+	}
+	return -1, false
+}
+
+// addForeignKey adds the FOREIGN KEY constraint c to t. The parent is looked
+// up in mb unless t references itself.
+func (mb *MemoryBackend) addForeignKey(t *table, c *tableConstraint) error {
+	columns := []int{}
+	for _, col := range c.columns {
+		i, ok := t.columnIndex(col.value)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+		}
+		columns = append(columns, i)
+	}
+
+	parent := t
+	if c.parent.value != t.name {
+		var ok bool
+		parent, ok = mb.tables[c.parent.value]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTableDoesNotExist, c.parent.value)
+		}
+	}
+
+	parentColumns := []int{}
+	for _, col := range c.parentColumns {
+		i, ok := parent.columnIndex(col.value)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+		}
+		parentColumns = append(parentColumns, i)
+	}
+
+	// Without columns the parent's primary key is referenced.
+	if len(parentColumns) == 0 {
+		for _, u := range parent.uniques {
+			if u.primaryKey {
+				parentColumns = u.columns
+			}
+		}
+		if len(parentColumns) == 0 {
+			return fmt.Errorf("%w: table %s has no primary key", ErrInvalidConstraint, parent.name)
+		}
+	}
+
+	if len(columns) != len(parentColumns) {
+		return fmt.Errorf("%w: foreign key has %d columns but references %d", ErrInvalidConstraint, len(columns), len(parentColumns))
+	}
+
+	for i := range columns {
+		if t.columnTypes[columns[i]] != parent.columnTypes[parentColumns[i]] {
+			return fmt.Errorf("%w: %s and %s.%s have different types", ErrInvalidConstraint,
+				t.columns[columns[i]], parent.name, parent.columns[parentColumns[i]])
+		}
+	}
+
+	ui, ok := parent.uniqueOn(parentColumns)
+	if !ok {
+		return fmt.Errorf("%w: no unique constraint on the referenced columns of %s", ErrInvalidConstraint, parent.name)
+	}
+
+	fk := &foreignKey{
+		name:     c.name.value,
+		parent:   parent.name,
+		onDelete: c.onDelete,
+		onUpdate: c.onUpdate,
+	}
+	for _, parentCol := range parent.uniques[ui].columns {
+		for i := range parentColumns {
+			if parentColumns[i] == parentCol {
+				fk.columns = append(fk.columns, columns[i])
+				fk.parentColumns = append(fk.parentColumns, parentCol)
+			}
+		}
+	}
+
+	if fk.name == "" {
+		fk.name = t.name + "_" + strings.Join(t.columnNames(columns), "_") + "_fkey"
+	}
+
+	t.foreignKeys = append(t.foreignKeys, fk)
+	return nil
+}
+
+// rowChanges is the pending new state of a table during a write and the
+// cascades it sets off. updated maps the id of each changed row of the
+// table to its new version, nil for a deleted row. inserted holds the
+// versions of the new rows in the order they were inserted, and
+// insertedRows each new row by id, nil once deleted again.
+type rowChanges struct {
+	t            *table
+	updated      map[uint64][]MemoryCell
+	inserted     []rowVersion
+	insertedRows map[uint64][]MemoryCell
+	// keys holds the keys of the changed rows for each unique constraint
+	// once commit has checked them. The other rows keep theirs.
+	keys []map[string]bool
+}
+
+// row returns the pending version of the row with id, or nil if it was
+// deleted.
+func (c *rowChanges) row(id uint64) []MemoryCell {
+	if row, ok := c.insertedRows[id]; ok {
+		return row
+	}
+	if row, ok := c.updated[id]; ok {
+		return row
+	}
+	return c.t.lookup(id)
+}
+
+// updatedIDs returns the ids of the updated and deleted rows in table
+// order.
+func (c *rowChanges) updatedIDs() []uint64 {
+	ids := make([]uint64, 0, len(c.updated))
+	for id := range c.updated {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// changedRows returns the updated and inserted rows that weren't deleted.
+func (c *rowChanges) changedRows() [][]MemoryCell {
+	rows := [][]MemoryCell{}
+	for _, id := range c.updatedIDs() {
+		if row := c.updated[id]; row != nil {
+			rows = append(rows, row)
+		}
+	}
+	for _, v := range c.inserted {
+		if row := c.insertedRows[v.id]; row != nil {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// checkKeys fills in keys, failing on the first duplicate key. Only the
+// keys of the changed rows are checked, each against those of the other
+// changed rows and of the rows the write leaves alone.
+func (c *rowChanges) checkKeys() error {
+	c.keys = nil
+	rows := c.changedRows()
+	for _, u := range c.t.uniques {
+		keys := map[string]bool{}
+		for _, row := range rows {
+			cells, ok := keyCells(row, u.columns)
+			if !ok {
+				continue
+			}
+			key := encodeKey(cells)
+			if keys[key] || c.keptKey(u, cells) {
+				return c.t.violation(u.kind(), u.name, u.columns)
+			}
+			keys[key] = true
+		}
+		c.keys = append(c.keys, keys)
+	}
+	return nil
+}
+
+// keptKey reports whether a row of the table that the write neither
+// updates nor deletes has key as its key of the unique constraint u.
+func (c *rowChanges) keptKey(u *uniqueConstraint, key []MemoryCell) bool {
+	id, ok := c.t.uniqueIndex(u).find(key)
+	if !ok {
+		return false
+	}
+	_, changed := c.updated[id]
+	return !changed
+}
+
+// apply writes the changed rows to the table along with their index
+// entries, stamping them with the transaction of mb.
+func (c *rowChanges) apply(mb *MemoryBackend) {
+	c.updateIndexes()
+
+	if len(c.updated) > 0 {
+		c.t.updateRows(c.updatedIDs(), c.updated, mb)
+	}
+	for _, v := range c.inserted {
+		if row := c.insertedRows[v.id]; row != nil {
+			c.t.appendRow(row, v)
+		}
+	}
+}
+
+type pendingChange struct {
+	t   *table
+	id  uint64
+	old []MemoryCell
+}
+
+// writeSet collects every row changed by one statement, including the
+// changes cascaded through foreign keys, so that the whole statement can be
+// checked before any of it is applied.
+type writeSet struct {
+	changes []*rowChanges
+	// pending holds the changes not yet cascaded to referencing tables.
+	pending []pendingChange
+}
+
+// find returns the changes to t, or nil if it has none.
+func (ws *writeSet) find(t *table) *rowChanges {
+	for _, c := range ws.changes {
+		if c.t == t {
+			return c
+		}
+	}
+	return nil
+}
+
+func (ws *writeSet) changesFor(t *table) *rowChanges {
+	if c := ws.find(t); c != nil {
+		return c
+	}
+
+	c := &rowChanges{
+		t:            t,
+		updated:      map[uint64][]MemoryCell{},
+		insertedRows: map[uint64][]MemoryCell{},
+	}
+	ws.changes = append(ws.changes, c)
+	return c
+}
+
+// set replaces the row of t with id with row, or deletes it when row is
+// nil.
+func (ws *writeSet) set(t *table, id uint64, row []MemoryCell) {
+	c := ws.changesFor(t)
+	ws.pending = append(ws.pending, pendingChange{t: t, id: id, old: c.row(id)})
+	if _, ok := c.insertedRows[id]; ok {
+		c.insertedRows[id] = row
+	} else {
+		c.updated[id] = row
+	}
+}
+
+// insert adds row to t as a new row with version v. New rows are
+// referenced by nothing yet, so they cascade nowhere.
+func (ws *writeSet) insert(t *table, v rowVersion, row []MemoryCell) {
+	c := ws.changesFor(t)
+	c.inserted = append(c.inserted, v)
+	c.insertedRows[v.id] = row
+}
+
+// keyExists reports whether key is a key of the unique constraint ui of t
+// once the checked changes are applied.
+func (ws *writeSet) keyExists(t *table, ui int, key []MemoryCell) bool {
+	u := t.uniques[ui]
+	c := ws.find(t)
+	if c == nil {
+		_, ok := t.uniqueIndex(u).find(key)
+		return ok
+	}
+	return c.keys[ui][encodeKey(key)] || c.keptKey(u, key)
+}
+
+// cascade applies the ON DELETE and ON UPDATE actions of every foreign key
+// referencing a key that a pending change removed. Child rows are matched on
+// their keys from before the statement, so that a row an action already
+// rewrote isn't taken for one referencing its new key.
+func (mb *MemoryBackend) cascade(ws *writeSet) error {
+	rewritten := map[*foreignKey]map[uint64]bool{}
+	for len(ws.pending) > 0 {
+		change := ws.pending[0]
+		ws.pending = ws.pending[1:]
+		newRow := ws.changesFor(change.t).row(change.id)
+
+		for _, child := range mb.tables {
+			for _, fk := range child.foreignKeys {
+				if fk.parent != change.t.name {
+					continue
+				}
+
+				ui, _ := change.t.uniqueOn(fk.parentColumns)
+				u := change.t.uniques[ui]
+				oldKey, ok := u.key(change.old)
+				if !ok {
+					continue
+				}
+				if newRow != nil {
+					if newKey, ok := u.key(newRow); ok && newKey == oldKey {
+						continue
+					}
+				}
+
+				action := fk.onUpdate
+				if newRow == nil {
+					action = fk.onDelete
+				}
+
+				if rewritten[fk] == nil {
+					rewritten[fk] = map[uint64]bool{}
+				}
+				changes := ws.changesFor(child)
+				err := child.eachRow(func(v rowVersion, original []MemoryCell) error {
+					if key, ok := fk.key(original); !ok || key != oldKey || rewritten[fk][v.id] {
+						return nil
+					}
+					id, row := v.id, changes.row(v.id)
+					if row == nil {
+						return nil
+					}
+					rewritten[fk][id] = true
+
+					switch {
+					case action == restrictAction:
+						return child.violation(ForeignKeyConstraint, fk.name, fk.columns)
+					case action == cascadeAction && newRow == nil:
+						ws.set(child, id, nil)
+					case action == cascadeAction:
+						updated := append([]MemoryCell{}, row...)
+						for p, col := range fk.columns {
+							updated[col] = newRow[u.columns[p]]
+						}
+						ws.set(child, id, updated)
+					case action == setNullAction:
+						updated := append([]MemoryCell{}, row...)
+						for _, col := range fk.columns {
+							updated[col] = nil
+						}
+						ws.set(child, id, updated)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// commit cascades the changes in ws, checks every constraint of the changed
+// tables against their new rows and only then applies the changes.
+func (mb *MemoryBackend) commit(ws *writeSet) error {
+	if err := mb.cascade(ws); err != nil {
+		return err
+	}
+
+	for _, c := range ws.changes {
+		for _, row := range c.changedRows() {
+			if err := mb.checkRow(c.t, row); err != nil {
+				return err
+			}
+		}
+
+		if err := c.checkKeys(); err != nil {
+			return err
+		}
+	}
+
+	// Changed rows must still reference existing keys.
+	for _, c := range ws.changes {
+		for _, fk := range c.t.foreignKeys {
+			parent := mb.tables[fk.parent]
+			ui, _ := parent.uniqueOn(fk.parentColumns)
+
+			for _, row := range c.changedRows() {
+				if key, ok := keyCells(row, fk.columns); ok && !ws.keyExists(parent, ui, key) {
+					return c.t.violation(ForeignKeyConstraint, fk.name, fk.columns)
+				}
+			}
+		}
+	}
+
+	for _, c := range ws.changes {
+		c.t = mb.writable(c.t)
+		c.apply(mb)
+	}
+	return nil
+}
+
+// checkConstraint is a CHECK constraint. A row passes unless its condition
+// is false; NULL passes like true.
+type checkConstraint struct {
+	name      string
+	condition *expression
+	// columns are the columns the condition refers to.
+	columns []int
+}
+
+// referencedColumns returns the columns of t that exp refers to, in the
+// order they first appear.
+func (t *table) referencedColumns(exp *expression) []int {
+	columns := []int{}
+	seen := map[int]bool{}
+
+	var walk func(exp *expression)
+	walk = func(exp *expression) {
+		if exp.kind == literalKind && exp.literal.kind == identifierKind {
+			if i, ok := t.columnIndex(exp.literal.value); ok && !seen[i] {
+				seen[i] = true
+				columns = append(columns, i)
+			}
+		}
+		for _, child := range exp.children() {
+			walk(child)
+		}
+	}
+	walk(exp)
+
+	return columns
+}
+
+// addCheck adds the CHECK constraint c to t, naming it like Postgres when c
+// has no name.
+func (mb *MemoryBackend) addCheck(t *table, c *tableConstraint) error {
+	if err := mb.checkCondition(t, c.check); err != nil {
+		return err
+	}
+
+	check := &checkConstraint{
+		name:      c.name.value,
+		condition: c.check,
+		columns:   t.referencedColumns(c.check),
+	}
+
+	if check.name == "" {
+		// Column constraints are named after their column even when the
+		// condition doesn't mention it.
+		columns := check.columns
+		if len(c.columns) > 0 {
+			i, _ := t.columnIndex(c.columns[0].value)
+			columns = []int{i}
+		}
+
+		check.name = t.name + "_check"
+		if len(columns) > 0 {
+			check.name = t.name + "_" + strings.Join(t.columnNames(columns), "_") + "_check"
+		}
+	}
+
+	t.checks = append(t.checks, check)
+	return nil
+}
+
+// checkRow checks the NOT NULL and CHECK constraints of t against row.
+func (mb *MemoryBackend) checkRow(t *table, row []MemoryCell) error {
+	if err := t.checkNotNull(row); err != nil {
+		return err
+	}
+
+	for _, c := range t.checks {
+		cell, _, err := mb.evaluateCell(t, row, c.condition)
+		if err != nil {
+			return err
+		}
+		if !cell.IsNull() && !cell.AsBool() {
+			return t.violation(CheckConstraint, c.name, c.columns)
+		}
+	}
+	return nil
+}
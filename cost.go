@@ -0,0 +1,379 @@
+package gogn
+
+import (
+	"bytes"
+	"math"
+)
+
+const (
+	// defaultDistinct is the number of distinct values assumed for a
+	// column nothing more is known about.
+	defaultDistinct = 200
+	// rangeSelectivity is the fraction of rows assumed to pass a range
+	// comparison like a < 5.
+	rangeSelectivity = 1.0 / 3
+	// defaultSelectivity is the fraction of rows assumed to pass any other
+	// condition.
+	defaultSelectivity = 0.5
+)
+
+// planEstimate is what the planner expects of a plan node: the cost of
+// producing its rows, in rows processed, the number of rows and the number
+// of distinct values in each of their columns, along with the statistics
+// ANALYZE collected for the columns that come straight from a table.
+type planEstimate struct {
+	cost     float64
+	rows     float64
+	distinct []float64
+	stats    []*columnStats
+}
+
+// tableEstimate estimates reading every row of t, going by its statistics
+// when ANALYZE has collected them. The columns of single column unique
+// constraints have a distinct value per row.
+func tableEstimate(t *table) planEstimate {
+	n := float64(t.rowCount())
+	e := planEstimate{cost: n, rows: n}
+	for i := range t.columns {
+		distinct := math.Min(n, defaultDistinct)
+		var stats *columnStats
+		if t.stats != nil {
+			stats = t.stats.columns[i]
+			distinct = math.Min(float64(stats.distinct), n)
+		}
+		if _, ok := t.uniqueOn([]int{i}); ok {
+			distinct = n
+		}
+		e.distinct = append(e.distinct, distinct)
+		e.stats = append(e.stats, stats)
+	}
+	return e
+}
+
+// limitDistinct caps the distinct counts of e by its number of rows.
+func (e planEstimate) limitDistinct() planEstimate {
+	distinct := []float64{}
+	for _, d := range e.distinct {
+		distinct = append(distinct, math.Min(d, e.rows))
+	}
+	e.distinct = distinct
+	return e
+}
+
+// selectivity estimates the fraction of the rows of t that satisfy exp,
+// given what e knows about their columns.
+func (mb *MemoryBackend) selectivity(t *table, e planEstimate, exp *expression) float64 {
+	if exp == nil {
+		return 1
+	}
+
+	column := func(exp *expression) (int, bool) {
+		if exp.kind != literalKind || exp.literal.kind != identifierKind {
+			return -1, false
+		}
+		return t.columnIndex(exp.literal.value)
+	}
+
+	// comparison estimates a comparison of a column with a constant.
+	comparison := func(a, b *expression, op symbol) (float64, bool) {
+		i, ok := column(a)
+		if !ok || !isConstant(b) {
+			return 0, false
+		}
+
+		value, typ, err := mb.evaluateCell(&table{}, nil, b)
+		if err != nil {
+			return 0, false
+		}
+		return predicateSelectivity(e.stats[i], e.distinct[i], op, value, typ), true
+	}
+
+	// An equality of two columns matches one of the distinct values of
+	// the column with more of them.
+	equality := func(a, b *expression) float64 {
+		if s, ok := comparison(a, b, equalsSymbol); ok {
+			return s
+		}
+		if s, ok := comparison(b, a, equalsSymbol); ok {
+			return s
+		}
+
+		i, aColumn := column(a)
+		j, bColumn := column(b)
+		if aColumn && bColumn {
+			return 1 / math.Max(math.Max(e.distinct[i], e.distinct[j]), 1)
+		}
+		return 1.0 / defaultDistinct
+	}
+
+	switch exp.kind {
+	case literalKind:
+		switch {
+		case exp.literal.matchesKeyword(trueKeyword):
+			return 1
+		case exp.literal.kind == identifierKind:
+			return defaultSelectivity
+		}
+		return 0
+	case unaryKind:
+		if exp.unary.op.matchesKeyword(notKeyword) {
+			return 1 - mb.selectivity(t, e, exp.unary.operand)
+		}
+	case binaryKind:
+		op := exp.binary.op
+		a, b := exp.binary.a, exp.binary.b
+		switch {
+		case op.matchesKeyword(andKeyword):
+			return mb.selectivity(t, e, a) * mb.selectivity(t, e, b)
+		case op.matchesKeyword(orKeyword):
+			x, y := mb.selectivity(t, e, a), mb.selectivity(t, e, b)
+			return x + y - x*y
+		case op.matchesKeyword(isKeyword):
+			// IS NULL of a column ANALYZE has seen. IS NOT NULL negates it.
+			if i, ok := column(a); ok && e.stats[i] != nil && b.literal.matchesKeyword(nullKeyword) {
+				return e.stats[i].nullFraction
+			}
+		case op.kind == symbolKind:
+			switch symbol(op.value) {
+			case equalsSymbol:
+				return equality(a, b)
+			case notEqualsSymbol, bangEqualsSymbol:
+				return 1 - equality(a, b)
+			case lessThanSymbol, lessThanOrEqualSymbol, greaterThanSymbol, greaterThanOrEqualSymbol:
+				if s, ok := comparison(a, b, symbol(op.value)); ok {
+					return s
+				}
+				if s, ok := comparison(b, a, flippedComparisons[symbol(op.value)]); ok {
+					return s
+				}
+				return rangeSelectivity
+			}
+		}
+	case betweenKind:
+		between := exp.between
+		low, lowOk := comparison(between.operand, between.low, greaterThanOrEqualSymbol)
+		high, highOk := comparison(between.operand, between.high, lessThanOrEqualSymbol)
+		if lowOk && highOk {
+			// Both bounds leave out what the other keeps.
+			notNull := 1.0
+			if i, _ := column(between.operand); e.stats[i] != nil {
+				notNull -= e.stats[i].nullFraction
+			}
+			return math.Max(low+high-notNull, 0)
+		}
+		return rangeSelectivity * rangeSelectivity
+	case inKind:
+		s := 0.0
+		for _, item := range exp.in.list {
+			s += equality(exp.in.operand, item)
+		}
+		return math.Min(s, 1)
+	}
+	return defaultSelectivity
+}
+
+// physicalPlan picks how to carry out each operator of plan, going by the
+// estimated cost where there is a choice.
+func (mb *MemoryBackend) physicalPlan(plan *logicalPlan) planNode {
+	switch plan.kind {
+	case scanPlan:
+		return mb.accessPath(plan)
+	case joinPlan:
+		return mb.joinMethod(plan, mb.physicalPlan(plan.input), mb.physicalPlan(plan.right))
+	}
+
+	input := mb.physicalPlan(plan.input)
+	in := input.estimate()
+	switch plan.kind {
+	case filterPlan:
+		s := mb.selectivity(plan.schema, in, plan.condition)
+		e := planEstimate{cost: in.cost + in.rows, rows: in.rows * s, distinct: in.distinct, stats: in.stats}
+		return &filterNode{planBase{out: plan.schema, est: e.limitDistinct()}, input, plan.condition}
+	case aggregatePlan:
+		groups := 1.0
+		if len(plan.groupBy) > 0 {
+			for _, exp := range plan.groupBy {
+				distinct := float64(defaultDistinct)
+				if exp.kind == literalKind && exp.literal.kind == identifierKind {
+					if i, ok := input.schema().columnIndex(exp.literal.value); ok {
+						distinct = in.distinct[i]
+					}
+				}
+				groups *= math.Max(distinct, 1)
+			}
+			groups = math.Min(groups, in.rows)
+		}
+
+		e := planEstimate{cost: in.cost + in.rows, rows: groups}
+		for range plan.schema.columns {
+			e.distinct = append(e.distinct, groups)
+			e.stats = append(e.stats, nil)
+		}
+		if batches, ok := input.(batchNode); ok {
+			if a, ok := mb.newBatchAggregate(plan, batches, e); ok {
+				return a
+			}
+		}
+		return &aggregateNode{planBase{out: plan.schema, est: e}, input, plan.groupBy, plan.aggregates}
+	case sortPlan:
+		e := in
+		e.cost += in.rows * math.Log2(in.rows+1)
+		return &sortNode{planBase{out: plan.schema, est: e}, input, plan.orderBy}
+	case projectPlan:
+		e := planEstimate{cost: in.cost + in.rows, rows: in.rows}
+		for _, exp := range plan.items {
+			distinct := math.Min(in.rows, defaultDistinct)
+			var stats *columnStats
+			if exp.kind == literalKind && exp.literal.kind == identifierKind {
+				if i, ok := input.schema().columnIndex(exp.literal.value); ok {
+					distinct, stats = in.distinct[i], in.stats[i]
+				}
+			}
+			e.distinct = append(e.distinct, distinct)
+			e.stats = append(e.stats, stats)
+		}
+		if batches, ok := input.(batchNode); ok {
+			return &batchProject{planBase{out: plan.schema, est: e}, batches, plan.items}
+		}
+		return &projectNode{planBase{out: plan.schema, est: e}, input, plan.items}
+	}
+
+	e := in
+	e.rows = math.Max(in.rows-float64(plan.offset), 0)
+	if plan.limit >= 0 {
+		e.rows = math.Min(e.rows, float64(plan.limit))
+	}
+	return &limitNode{planBase{out: plan.schema, est: e.limitDistinct()}, input, plan.limit, plan.offset}
+}
+
+// accessPath picks between reading the whole table of a scan and looking
+// its rows up through an index. Columnar tables are read a batch at a time.
+func (mb *MemoryBackend) accessPath(plan *logicalPlan) planNode {
+	schema := plan.schema
+	mb.read(plan.table, schema, plan.condition)
+	e := tableEstimate(schema)
+	if len(schema.columns) == 0 {
+		// The single empty row of a SELECT without FROM.
+		return &seqScan{planBase{out: schema, est: e}, plan.table, plan.condition}
+	}
+
+	filtered := e
+	filtered.rows *= mb.selectivity(schema, e, plan.condition)
+	filtered = filtered.limitDistinct()
+	var seq planNode = &seqScan{planBase{out: schema, est: filtered}, plan.table, plan.condition}
+	if plan.table.columnar != nil {
+		seq = mb.newColumnarScan(schema, plan.table, plan.condition, filtered)
+	}
+
+	access, ok := mb.chooseIndex(schema, plan.condition)
+	if !ok {
+		return seq
+	}
+
+	// The index finds the rows matching its predicates, which the rest of
+	// the condition then filters.
+	matched := e.rows
+	for _, p := range access.predicates() {
+		matched *= predicateSelectivity(e.stats[p.column], e.distinct[p.column], p.op, p.value, p.typ)
+	}
+
+	indexed := filtered
+	indexed.cost = math.Log2(e.rows+1) + matched
+	if indexed.cost >= seq.estimate().cost {
+		return seq
+	}
+	return &indexScan{planBase{out: schema, est: indexed}, plan.table, access, mb.residual(schema, plan.condition, access)}
+}
+
+// residual returns the part of condition that the rows found by access
+// still have to be filtered by: the conditions whose comparisons aren't all
+// answered by the index.
+func (mb *MemoryBackend) residual(t *table, condition *expression, access *indexAccess) *expression {
+	answered := func(p columnPredicate) bool {
+		for _, q := range access.predicates() {
+			if p.column == q.column && p.op == q.op && p.typ == q.typ && bytes.Equal(p.value, q.value) && (p.value == nil) == (q.value == nil) {
+				return true
+			}
+		}
+		return false
+	}
+
+	rest := []*expression{}
+	for _, c := range conjuncts(condition) {
+		predicates := mb.indexPredicates(t, c)
+		covered := len(predicates) > 0
+		for _, p := range predicates {
+			covered = covered && answered(p)
+		}
+		if !covered {
+			rest = append(rest, c)
+		}
+	}
+	return conjoin(rest)
+}
+
+// hashKeys splits the ON condition of the join plan into the equalities
+// between an expression of each side, which a hash join looks rows up by,
+// and the rest.
+func (mb *MemoryBackend) hashKeys(plan *logicalPlan) (left, right []*expression, types []ColumnType, rest []*expression) {
+	for _, c := range conjuncts(plan.condition) {
+		if c.kind == binaryKind && c.binary.op.kind == symbolKind && symbol(c.binary.op.value) == equalsSymbol {
+			a, b := c.binary.a, c.binary.b
+			aSide, bSide := plan.side(a), plan.side(b)
+			if aSide == rightSide && bSide == leftSide && len(plan.schema.referencedColumns(b)) > 0 {
+				a, b = b, a
+				aSide, bSide = bSide, aSide
+			}
+
+			if aSide == leftSide && bSide == rightSide && len(plan.schema.referencedColumns(a)) > 0 {
+				aType, aErr := mb.expressionType(plan.input.schema, a)
+				bType, bErr := mb.expressionType(plan.right.schema, b)
+				typ, ok := commonType(aType, bType)
+				if aErr == nil && bErr == nil && ok {
+					left = append(left, a)
+					right = append(right, b)
+					types = append(types, typ)
+					continue
+				}
+			}
+		}
+		rest = append(rest, c)
+	}
+	return left, right, types, rest
+}
+
+// joinMethod picks between a nested loop join and a hash join of left and
+// right, and which side the hash join builds its table from.
+func (mb *MemoryBackend) joinMethod(plan *logicalPlan, left, right planNode) planNode {
+	l, r := left.estimate(), right.estimate()
+	e := planEstimate{rows: l.rows * r.rows}
+	e.distinct = append(append(e.distinct, l.distinct...), r.distinct...)
+	e.stats = append(append(e.stats, l.stats...), r.stats...)
+	e.rows *= mb.selectivity(plan.schema, e, plan.condition)
+	if plan.join == leftJoin {
+		e.rows = math.Max(e.rows, l.rows)
+	}
+	e = e.limitDistinct()
+
+	nested := e
+	nested.cost = l.cost + r.cost + l.rows*r.rows
+	var best planNode = &nestedLoopJoin{planBase{out: plan.schema, est: nested}, left, right, plan.join, plan.condition}
+
+	leftKeys, rightKeys, types, rest := mb.hashKeys(plan)
+	if len(leftKeys) > 0 {
+		// Building the hash table costs more per row than probing it, so
+		// an inner join builds it from the smaller side.
+		buildLeft := plan.join == innerJoin && l.rows < r.rows
+		build, probe := r.rows, l.rows
+		if buildLeft {
+			build, probe = l.rows, r.rows
+		}
+
+		hashed := e
+		hashed.cost = l.cost + r.cost + 2*build + probe
+		if hashed.cost < nested.cost {
+			best = &hashJoin{planBase{out: plan.schema, est: hashed}, left, right, plan.join, leftKeys, rightKeys, types, conjoin(rest), buildLeft}
+		}
+	}
+	return best
+}
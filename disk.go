@@ -0,0 +1,502 @@
+package gogn
+
+import (
+	"fmt"
+)
+
+// DiskBackend is a Backend keeping its database in a single file. Tables
+// are planned and checked like those of a MemoryBackend, but their rows live
+// in a B+tree per table in the pages of the file, read and written through a
+// buffer pool, so a table needn't fit in memory. Indexes and unique keys are
+// rebuilt from the rows when the file is opened. Each statement's changes
+// are in the write-ahead log next to the file before it returns, and are
+// recovered from it if the process crashes before a checkpoint. Unlike a
+// MemoryBackend, a DiskBackend has a single session and mustn't be used
+// from several goroutines at once.
+type DiskBackend struct {
+	mb   *MemoryBackend
+	pool *bufferPool
+	// trees holds the row tree of every table, so that the trees of tables
+	// no longer in the catalog can be freed.
+	trees map[*rowTree]bool
+	// catalog is the encoded catalog last written to the file.
+	catalog []byte
+	// savepoints holds the pages and row trees as of BEGIN and then of
+	// each savepoint during a transaction.
+	savepoints []*diskSavepoint
+}
+
+type diskSavepoint struct {
+	pool  *poolSavepoint
+	trees map[*rowTree]rowTree
+}
+
+func (d *DiskBackend) savepoint() *diskSavepoint {
+	s := &diskSavepoint{pool: d.pool.savepoint(), trees: map[*rowTree]rowTree{}}
+	for tree := range d.trees {
+		s.trees[tree] = *tree
+	}
+	return s
+}
+
+func (d *DiskBackend) rollback(s *diskSavepoint) {
+	d.pool.rollback(s.pool)
+	for tree, saved := range s.trees {
+		*tree = saved
+	}
+}
+
+// OpenDiskBackend opens the database file at path, creating an empty
+// database when the file doesn't exist.
+func OpenDiskBackend(path string) (*DiskBackend, error) {
+	return openDiskBackend(path, defaultPoolCapacity)
+}
+
+func openDiskBackend(path string, capacity int) (*DiskBackend, error) {
+	pool, err := openBufferPool(path, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DiskBackend{mb: newMemoryBackend(), pool: pool, trees: map[*rowTree]bool{}}
+	if err := d.load(); err != nil {
+		pool.close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close writes any remaining changes to the file, leaving the write-ahead
+// log empty, and closes it. A transaction still in progress is rolled back.
+// Closing a DiskBackend more than once is fine.
+func (d *DiskBackend) Close() error {
+	if d.pool == nil {
+		return nil
+	}
+
+	if d.mb.tx != nil {
+		d.Rollback(&RollbackStatement{})
+	}
+	err := d.Checkpoint()
+	if closeErr := d.pool.close(); err == nil {
+		err = closeErr
+	}
+	d.pool = nil
+	return err
+}
+
+// storageError carries a failure to read or write the database file out of
+// the table accessors, which have no error to return, up to the DiskBackend
+// method running the statement.
+type storageError struct {
+	err error
+}
+
+func must(err error) {
+	if err != nil {
+		panic(storageError{err})
+	}
+}
+
+// storageFailure sets err to the storage error r recovered, if it is one,
+// and panics again with anything else.
+func storageFailure(r interface{}, err *error) {
+	if r == nil {
+		return
+	}
+	s, ok := r.(storageError)
+	if !ok {
+		panic(r)
+	}
+	*err = s.err
+}
+
+func recoverStorage(err *error) {
+	storageFailure(recover(), err)
+}
+
+// Checkpoint writes every change logged in the write-ahead log to the
+// database file and empties the log. Checkpoints also happen on their own
+// as the log grows. There mustn't be a transaction in progress.
+func (d *DiskBackend) Checkpoint() error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if d.mb.tx != nil {
+		return ErrTransactionInProgress
+	}
+	if err := d.commit(); err != nil {
+		return err
+	}
+	return d.pool.checkpoint()
+}
+
+// finish ends a statement, turning a storage failure into its error and
+// committing its changes unless a transaction is in progress.
+func (d *DiskBackend) finish(err *error) {
+	storageFailure(recover(), err)
+	if d.mb.tx != nil {
+		return
+	}
+	if commitErr := d.commit(); *err == nil {
+		*err = commitErr
+	}
+}
+
+// commit moves the rows of new tables into trees of their own, frees the
+// trees of dropped ones and logs the catalog and every changed page to the
+// write-ahead log.
+func (d *DiskBackend) commit() error {
+	live := map[*rowTree]bool{}
+	for _, t := range d.mb.tables {
+		if t.paged == nil {
+			tree, err := newRowTree(d.pool)
+			if err != nil {
+				return err
+			}
+			err = t.eachRow(func(v rowVersion, row []MemoryCell) error {
+				return tree.put(v.id, encodeRow(row))
+			})
+			if err != nil {
+				return err
+			}
+
+			// Columnar tables keep their versions to find rows by id.
+			t.paged, t.heap = tree, nil
+			d.trees[tree] = true
+		}
+		live[t.paged] = true
+	}
+
+	for tree := range d.trees {
+		if live[tree] {
+			continue
+		}
+		if err := tree.destroy(); err != nil {
+			return err
+		}
+		delete(d.trees, tree)
+	}
+
+	catalog := encodeCatalog(d.mb)
+	if string(catalog) != string(d.catalog) {
+		if d.pool.header.catalog != 0 {
+			if err := d.pool.freeOverflow(d.pool.header.catalog); err != nil {
+				return err
+			}
+		}
+
+		first, err := d.pool.writeOverflow(catalog)
+		if err != nil {
+			return err
+		}
+		d.pool.header.catalog = first
+		d.pool.header.catalogLength = uint64(len(catalog))
+		d.catalog = catalog
+	}
+	return d.pool.commit()
+}
+
+// load reads the catalog of the file and rebuilds the tables it describes.
+func (d *DiskBackend) load() (err error) {
+	defer recoverStorage(&err)
+
+	header := d.pool.header
+	if header.catalog == 0 {
+		return nil
+	}
+
+	data, err := d.pool.readOverflow(header.catalog, int(header.catalogLength))
+	if err != nil {
+		return err
+	}
+	if err := d.decodeCatalog(data); err != nil {
+		return err
+	}
+	d.catalog = data
+	return nil
+}
+
+// The catalog of a database file holds catalogVersion, then each table's
+// definition followed by its tree's root and row count, and then the
+// sequences.
+const catalogVersion = 1
+
+func encodeCatalog(mb *MemoryBackend) []byte {
+	e := &catalogEncoder{}
+	e.uint(catalogVersion)
+
+	names := mb.tableNames()
+	e.uint(uint64(len(names)))
+	for _, name := range names {
+		t := mb.tables[name]
+		e.table(t)
+		e.uint(uint64(t.paged.root))
+		e.uint(uint64(t.paged.count))
+	}
+
+	e.sequences(mb)
+	return e.data
+}
+
+func (d *DiskBackend) decodeCatalog(data []byte) error {
+	c := &catalogDecoder{data: data, corrupt: ErrCorruptDatabase}
+	if version := c.uint(); c.err == nil && version != catalogVersion {
+		return fmt.Errorf("%w: unknown catalog version %d", ErrCorruptDatabase, version)
+	}
+
+	for i := c.count(); i > 0 && c.err == nil; i-- {
+		t, columnar := c.table()
+		t.paged = &rowTree{pool: d.pool, root: pageID(c.uint()), count: int(c.uint())}
+		if c.err != nil {
+			break
+		}
+
+		if err := t.restore(columnar); err != nil {
+			return fmt.Errorf("%w: %s", ErrCorruptDatabase, err)
+		}
+		d.mb.tables[t.name] = t
+		d.trees[t.paged] = true
+
+		// New rows are numbered after those of every table.
+		last, err := t.paged.last()
+		if err != nil {
+			return err
+		}
+		if last > d.mb.rowIDs {
+			d.mb.rowIDs = last
+		}
+	}
+
+	c.sequences(d.mb)
+	c.checkForeignKeys(d.mb)
+	return c.err
+}
+
+// begin fails statements once the file is closed.
+func (d *DiskBackend) begin() error {
+	if d.pool == nil {
+		return ErrDatabaseClosed
+	}
+	return nil
+}
+
+func (d *DiskBackend) CreateTable(crt *CreateTableStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.CreateTable(crt)
+}
+
+func (d *DiskBackend) Insert(inst *InsertStatement) (results *Results, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.finish(&err)
+	return d.mb.Insert(inst)
+}
+
+func (d *DiskBackend) Update(upd *UpdateStatement) (results *Results, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.finish(&err)
+	return d.mb.Update(upd)
+}
+
+func (d *DiskBackend) Delete(del *DeleteStatement) (results *Results, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.finish(&err)
+	return d.mb.Delete(del)
+}
+
+func (d *DiskBackend) CreateSequence(crt *CreateSequenceStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.CreateSequence(crt)
+}
+
+func (d *DiskBackend) AlterTable(alt *AlterTableStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.AlterTable(alt)
+}
+
+func (d *DiskBackend) CreateIndex(crt *CreateIndexStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.CreateIndex(crt)
+}
+
+func (d *DiskBackend) DropIndex(drp *DropIndexStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.DropIndex(drp)
+}
+
+func (d *DiskBackend) Analyze(anl *AnalyzeStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	defer d.finish(&err)
+	return d.mb.Analyze(anl)
+}
+
+// Select runs a SELECT, which can still change the file by advancing
+// sequences.
+func (d *DiskBackend) Select(slct *SelectStatement) (results *Results, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.finish(&err)
+	return d.mb.Select(slct)
+}
+
+// Query runs a SELECT, writing its changes to the file once the cursor is
+// closed.
+func (d *DiskBackend) Query(slct *SelectStatement) (rows *Rows, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+
+	defer recoverStorage(&err)
+	if rows, err = d.mb.Query(slct); err != nil {
+		return nil, err
+	}
+
+	next := rows.next
+	rows.next = func() (row []Cell, ok bool, err error) {
+		defer recoverStorage(&err)
+		return next()
+	}
+	rows.close = func() error {
+		if d.pool == nil {
+			return ErrDatabaseClosed
+		}
+		if d.mb.tx != nil {
+			return nil
+		}
+		return d.commit()
+	}
+	return rows, nil
+}
+
+func (d *DiskBackend) Explain(expl *ExplainStatement) (results *Results, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.finish(&err)
+	return d.mb.Explain(expl)
+}
+
+// Begin starts a transaction, whose changes stay in the buffer pool until
+// COMMIT logs them all at once. It checkpoints first, so that ROLLBACK can
+// read the pages the transaction changed back from the file.
+func (d *DiskBackend) Begin(begin *BeginStatement) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if d.mb.tx != nil {
+		return ErrTransactionInProgress
+	}
+	if err := d.Checkpoint(); err != nil {
+		return err
+	}
+
+	if err := d.mb.Begin(begin); err != nil {
+		return err
+	}
+	d.savepoints = []*diskSavepoint{d.savepoint()}
+	return nil
+}
+
+func (d *DiskBackend) Commit(commit *CommitStatement) (err error) {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if err := d.mb.Commit(commit); err != nil {
+		return err
+	}
+
+	d.savepoints = nil
+	defer recoverStorage(&err)
+	return d.commit()
+}
+
+func (d *DiskBackend) Rollback(rollback *RollbackStatement) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if err := d.mb.Rollback(rollback); err != nil {
+		return err
+	}
+
+	if d.mb.tx == nil {
+		d.rollback(d.savepoints[0])
+		d.savepoints = nil
+		return nil
+	}
+
+	// The first savepoint is BEGIN, which the MemoryBackend doesn't count.
+	d.savepoints = d.savepoints[:len(d.mb.tx.savepoints)+1]
+	d.rollback(d.savepoints[len(d.savepoints)-1])
+	return nil
+}
+
+func (d *DiskBackend) Savepoint(svpt *SavepointStatement) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if err := d.mb.Savepoint(svpt); err != nil {
+		return err
+	}
+
+	d.savepoints = append(d.savepoints, d.savepoint())
+	return nil
+}
+
+func (d *DiskBackend) ReleaseSavepoint(rel *ReleaseSavepointStatement) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	if err := d.mb.ReleaseSavepoint(rel); err != nil {
+		return err
+	}
+
+	d.savepoints = d.savepoints[:len(d.mb.tx.savepoints)+1]
+	return nil
+}
+
+// SetTransaction accepts every isolation level. A DiskBackend runs one
+// transaction at a time, which makes each of them serializable.
+func (d *DiskBackend) SetTransaction(set *SetTransactionStatement) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+	return d.mb.SetTransaction(set)
+}
+
+// RegisterFunction makes fn callable from SQL like
+// MemoryBackend.RegisterFunction. Functions aren't stored in the file, so
+// they need registering each time it's opened.
+func (d *DiskBackend) RegisterFunction(name string, argTypes []ColumnType, returnType ColumnType, fn func(args []Cell) (Cell, error)) error {
+	return d.mb.RegisterFunction(name, argTypes, returnType, fn)
+}
+
+// RegisterAggregate makes an aggregate function callable from SQL like
+// MemoryBackend.RegisterAggregate, and needs calling each time the file is
+// opened too.
+func (d *DiskBackend) RegisterAggregate(name string, argTypes []ColumnType, returnType ColumnType, newAggregate func() Aggregate) error {
+	return d.mb.RegisterAggregate(name, argTypes, returnType, newAggregate)
+}
@@ -0,0 +1,582 @@
+package gogn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRowTree(t *testing.T) {
+	pool, err := openBufferPool(filepath.Join(t.TempDir(), "tree.db"), 4)
+	assert.Nil(t, err)
+	defer pool.close()
+
+	tree, err := newRowTree(pool)
+	assert.Nil(t, err)
+
+	// Rows of varied sizes, some too large for a leaf, inserted out of
+	// order to split pages in the middle as well as at the end.
+	value := func(i int) []byte {
+		return []byte(strings.Repeat(fmt.Sprint(i%10), 1+(i*37)%2000))
+	}
+	for i := 0; i < 600; i += 2 {
+		assert.Nil(t, tree.put(uint64(i), value(i)))
+	}
+	for i := 1; i < 600; i += 2 {
+		assert.Nil(t, tree.put(uint64(i), value(i)))
+	}
+	assert.Equal(t, 600, tree.count)
+
+	for _, i := range []int{0, 1, 299, 300, 599} {
+		data, err := tree.get(uint64(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), data)
+	}
+
+	next, i := tree.scan(), 0
+	for {
+		key, data, ok, err := next()
+		assert.Nil(t, err)
+		if !ok {
+			break
+		}
+		assert.Equal(t, uint64(i), key)
+		assert.Equal(t, value(i), data)
+		i++
+	}
+	assert.Equal(t, 600, i)
+
+	// Replacing the rows frees the old pages for the new ones.
+	assert.Nil(t, pool.commit())
+	assert.Nil(t, pool.checkpoint())
+	pages := pool.header.pageCount
+	keys, rows := []uint64{}, [][]byte{}
+	for i := 0; i < 600; i++ {
+		keys = append(keys, uint64(i))
+		rows = append(rows, value(599-i))
+	}
+	assert.Nil(t, tree.replace(keys, rows))
+	assert.Equal(t, pages, pool.header.pageCount)
+
+	data, err := tree.get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, value(599), data)
+
+	// Putting a row under a key in the tree replaces it, and deleting rows
+	// frees the leaves they empty.
+	assert.Nil(t, tree.put(10, []byte("short")))
+	assert.Nil(t, tree.put(11, value(1999)))
+	assert.Equal(t, 600, tree.count)
+	for i := 100; i < 500; i++ {
+		assert.Nil(t, tree.delete(uint64(i)))
+	}
+	assert.True(t, errors.Is(tree.delete(100), ErrCorruptDatabase))
+	assert.Equal(t, 200, tree.count)
+
+	next, i = tree.scan(), 0
+	for {
+		key, data, ok, err := next()
+		assert.Nil(t, err)
+		if !ok {
+			break
+		}
+		if i == 100 {
+			i = 500
+		}
+		assert.Equal(t, uint64(i), key)
+		switch i {
+		case 10:
+			assert.Equal(t, []byte("short"), data)
+		case 11:
+			assert.Equal(t, value(1999), data)
+		default:
+			assert.Equal(t, value(599-i), data)
+		}
+		i++
+	}
+	assert.Equal(t, 600, i)
+
+	for i := 0; i < 600; i++ {
+		if i < 100 || i >= 500 {
+			assert.Nil(t, tree.delete(uint64(i)))
+		}
+	}
+	assert.Equal(t, 0, tree.count)
+	root, err := tree.read(tree.root)
+	assert.Nil(t, err)
+	assert.True(t, root.leaf)
+
+	// The freed pages take the rows put in again.
+	for i := 0; i < 600; i++ {
+		assert.Nil(t, tree.put(uint64(1000+i), value(i)))
+	}
+	assert.Equal(t, pages, pool.header.pageCount)
+	data, err = tree.get(1599)
+	assert.Nil(t, err)
+	assert.Equal(t, value(599), data)
+}
+
+func TestDiskBackendReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := OpenDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(d, `CREATE TABLE customers (id SERIAL PRIMARY KEY, name TEXT NOT NULL UNIQUE, tier INT DEFAULT 1 CHECK (tier > 0));
+	CREATE TABLE orders (id INT PRIMARY KEY, customer INT REFERENCES customers ON DELETE CASCADE, total FLOAT);
+	CREATE TABLE events (id INT, kind TEXT) USING columnar;
+	CREATE TABLE quoted (id INT, note TEXT DEFAULT 'it''s' CHECK (note <> 'don''t'));
+	CREATE SEQUENCE ticket INCREMENT BY 5;
+	INSERT INTO customers (name) VALUES ('Alice');
+	INSERT INTO customers (name, tier) VALUES ('Bob', 3);
+	INSERT INTO customers (name) VALUES ('Carol');
+	INSERT INTO orders VALUES (10, 1, 9.5);
+	INSERT INTO orders VALUES (11, 2, 20.0);
+	INSERT INTO orders VALUES (12, 3, 1.25);
+	INSERT INTO events VALUES (1, 'login');
+	INSERT INTO events VALUES (2, NULL);
+	CREATE INDEX orders_total ON orders (total);
+	UPDATE customers SET tier = 2 WHERE name = 'Carol';
+	DELETE FROM customers WHERE name = 'Bob';
+	ALTER TABLE events ADD COLUMN note TEXT DEFAULT '';
+	CREATE TABLE big AS SELECT id, name FROM customers;
+	SELECT nextval('ticket')`)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	_, err = execute(d, "SELECT id FROM customers")
+	assert.True(t, errors.Is(err, ErrDatabaseClosed))
+
+	d, err = OpenDiskBackend(path)
+	assert.Nil(t, err)
+	defer d.Close()
+
+	tests := []struct {
+		source string
+		values [][]interface{}
+	}{
+		{
+			source: "SELECT id, name, tier FROM customers ORDER BY id",
+			values: [][]interface{}{{int32(1), "Alice", int32(1)}, {int32(3), "Carol", int32(2)}},
+		},
+		{
+			source: "SELECT id, customer, total FROM orders ORDER BY id",
+			values: [][]interface{}{{int32(10), int32(1), 9.5}, {int32(12), int32(3), 1.25}},
+		},
+		{
+			source: "SELECT id, kind, note FROM events ORDER BY id",
+			values: [][]interface{}{{int32(1), "login", ""}, {int32(2), nil, ""}},
+		},
+		{
+			source: "SELECT id, name FROM big ORDER BY id",
+			values: [][]interface{}{{int32(1), "Alice"}, {int32(3), "Carol"}},
+		},
+		{
+			source: "SELECT id FROM orders WHERE total = 1.25",
+			values: [][]interface{}{{int32(12)}},
+		},
+		{
+			source: "SELECT nextval('ticket'), nextval('customers_id_seq')",
+			values: [][]interface{}{{int32(6), int32(4)}},
+		},
+		{
+			source: "INSERT INTO quoted (id) VALUES (1) RETURNING note",
+			values: [][]interface{}{{"it's"}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(d, test.source)
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.values, cellValues(results), test.source)
+	}
+
+	// The constraints hold after reopening.
+	for _, source := range []string{
+		"INSERT INTO customers (id, name) VALUES (1, 'Dan')",
+		"INSERT INTO customers (name) VALUES ('Alice')",
+		"INSERT INTO customers (name, tier) VALUES ('Dan', 0)",
+		"INSERT INTO orders VALUES (13, 2, 1.0)",
+		"INSERT INTO quoted VALUES (2, 'don''t')",
+	} {
+		_, err := execute(d, source)
+		assert.True(t, errors.Is(err, ErrConstraintViolation), source)
+	}
+
+	results, err := execute(d, "DELETE FROM customers WHERE id = 1; SELECT id FROM orders")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(12)}}, cellValues(results))
+	assert.NotNil(t, d.mb.tables["events"].columnar)
+
+	_, err = execute(d, "CREATE INDEX orders_total ON orders (id)")
+	assert.True(t, errors.Is(err, ErrIndexExists))
+}
+
+func TestDiskBackendLargeTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large.db")
+
+	// A pool much smaller than the table reads most pages back from the
+	// file.
+	d, err := openDiskBackend(path, 16)
+	assert.Nil(t, err)
+
+	_, err = execute(d, "CREATE TABLE items (id INT PRIMARY KEY, grp INT, payload TEXT)")
+	assert.Nil(t, err)
+
+	var b strings.Builder
+	for i := 0; i < 3000; i++ {
+		payload := "p"
+		if i%500 == 0 {
+			// Large enough for overflow pages.
+			payload = strings.Repeat("x", 3*pageSize)
+		}
+		fmt.Fprintf(&b, "INSERT INTO items VALUES (%d, %d, '%s');", i, i%7, payload)
+	}
+	_, err = execute(d, b.String())
+	assert.Nil(t, err)
+
+	_, err = execute(d, "UPDATE items SET grp = grp + 100 WHERE id % 2 = 0; DELETE FROM items WHERE id >= 2500")
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	d, err = openDiskBackend(path, 16)
+	assert.Nil(t, err)
+	defer d.Close()
+
+	results, err := execute(d, "SELECT count(*), sum(grp), max(length(payload)) FROM items")
+	assert.Nil(t, err)
+	sum := int32(0)
+	for i := 0; i < 2500; i++ {
+		sum += int32(i % 7)
+		if i%2 == 0 {
+			sum += 100
+		}
+	}
+	assert.Equal(t, [][]interface{}{{int32(2500), int32(sum), int32(3 * pageSize)}}, cellValues(results))
+
+	ast, err := Parse("SELECT id FROM items WHERE id > 2495")
+	assert.Nil(t, err)
+	rows, err := d.Query(ast.Statements[0].SelectStatement)
+	assert.Nil(t, err)
+	ids := []int32{}
+	for rows.Next() {
+		ids = append(ids, rows.Row()[0].AsInt())
+	}
+	assert.Nil(t, rows.Err())
+	assert.Equal(t, []int32{2496, 2497, 2498, 2499}, ids)
+}
+
+func TestDiskBackendLogsChangedPages(t *testing.T) {
+	d, err := OpenDiskBackend(filepath.Join(t.TempDir(), "pages.db"))
+	assert.Nil(t, err)
+	defer d.Close()
+
+	var b strings.Builder
+	b.WriteString("CREATE TABLE items (id INT PRIMARY KEY, payload TEXT);")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "INSERT INTO items VALUES (%d, '%s');", i, strings.Repeat("p", 50))
+	}
+	_, err = execute(d, b.String())
+	assert.Nil(t, err)
+
+	// Changing a row logs the leaf holding it and a commit frame, and
+	// deleting or inserting one the catalog too, which holds the row count,
+	// whatever the size of the table. A row written back unchanged logs
+	// nothing.
+	tests := []struct {
+		source string
+		frames int
+	}{
+		{"UPDATE items SET payload = 'q' WHERE id = 1000", 2},
+		{"DELETE FROM items WHERE id = 1500", 3},
+		{"INSERT INTO items VALUES (2000, 'r')", 3},
+		{"UPDATE items SET payload = payload WHERE id = 10", 0},
+	}
+	for _, test := range tests {
+		assert.Nil(t, d.Checkpoint())
+		_, err := execute(d, test.source)
+		assert.Nil(t, err)
+		assert.Equal(t, test.frames, d.pool.wal.frames, test.source)
+	}
+}
+
+func TestDiskBackendCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Repeat("not a database", 500)), 0644))
+
+	_, err := OpenDiskBackend(path)
+	assert.True(t, errors.Is(err, ErrCorruptDatabase))
+}
+
+// crashSnapshot returns the contents of the tables of the crash tests, or
+// nil for a table that doesn't exist yet.
+func crashSnapshot(t *testing.T, b Backend) [][][]interface{} {
+	snapshot := [][][]interface{}{}
+	for _, source := range []string{
+		"SELECT id, name FROM a ORDER BY id",
+		"SELECT id, length(note) FROM b ORDER BY id",
+	} {
+		results, err := execute(b, source)
+		if errors.Is(err, ErrTableDoesNotExist) {
+			snapshot = append(snapshot, nil)
+			continue
+		}
+		assert.Nil(t, err, source)
+		snapshot = append(snapshot, cellValues(results))
+	}
+	return snapshot
+}
+
+// TestDiskBackendCrashRecovery runs statements without checkpointing, then
+// simulates a crash at every point of writing the log by truncating a copy
+// of it at byte offsets through and around each statement's frames. Opening
+// the copy must recover exactly the statements whose log is complete.
+func TestDiskBackendCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "crash.db")
+	d, err := OpenDiskBackend(path)
+	assert.Nil(t, err)
+	d.pool.checkpointFrames = math.MaxInt32
+
+	statements := []string{
+		"CREATE TABLE a (id INT PRIMARY KEY, name TEXT)",
+		"INSERT INTO a VALUES (1, 'one')",
+		"INSERT INTO a VALUES (2, 'two')",
+		"CREATE TABLE b (id INT, note TEXT)",
+		fmt.Sprintf("INSERT INTO b VALUES (1, '%s')", strings.Repeat("n", 2*pageSize)),
+		"INSERT INTO b VALUES (2, 'short')",
+		"UPDATE a SET name = 'TWO' WHERE id = 2",
+		"DELETE FROM a WHERE id = 1",
+		"INSERT INTO a VALUES (3, 'three')",
+		"CREATE INDEX b_id ON b (id)",
+		"ALTER TABLE a ADD COLUMN n INT DEFAULT 7",
+		"UPDATE b SET note = 'tiny' WHERE id = 1",
+	}
+
+	// ends holds the length of the log after each statement, and states
+	// the tables then.
+	ends := []int64{d.pool.wal.size}
+	states := [][][][]interface{}{crashSnapshot(t, d)}
+	for _, source := range statements {
+		_, err := execute(d, source)
+		assert.Nil(t, err, source)
+		ends = append(ends, d.pool.wal.size)
+		states = append(states, crashSnapshot(t, d))
+	}
+
+	db, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	wal, err := os.ReadFile(path + "-wal")
+	assert.Nil(t, err)
+	assert.Equal(t, ends[len(ends)-1], int64(len(wal)))
+	// Crash without a checkpoint.
+	assert.Nil(t, d.pool.close())
+
+	offsets := []int64{}
+	for offset := int64(0); offset < int64(len(wal)); offset += 997 {
+		offsets = append(offsets, offset)
+	}
+	for _, end := range ends {
+		offsets = append(offsets, end-1, end, end+1)
+	}
+
+	crashed := filepath.Join(dir, "crashed.db")
+	for _, offset := range offsets {
+		if offset < 0 || offset > int64(len(wal)) {
+			continue
+		}
+		assert.Nil(t, os.WriteFile(crashed, db, 0644))
+		assert.Nil(t, os.WriteFile(crashed+"-wal", wal[:offset], 0644))
+
+		recovered := 0
+		for i, end := range ends {
+			if end <= offset {
+				recovered = i
+			}
+		}
+
+		r, err := OpenDiskBackend(crashed)
+		if !assert.Nil(t, err, offset) {
+			continue
+		}
+		assert.Equal(t, states[recovered], crashSnapshot(t, r), offset)
+
+		// The recovered database takes new writes.
+		if recovered >= 1 {
+			_, err = execute(r, "INSERT INTO a (id, name) VALUES (10, 'ten')")
+			assert.Nil(t, err, offset)
+		}
+		assert.Nil(t, r.Close())
+	}
+}
+
+func TestDiskBackendCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.db")
+	d, err := OpenDiskBackend(path)
+	assert.Nil(t, err)
+	d.pool.checkpointFrames = 10
+
+	_, err = execute(d, "CREATE TABLE a (id INT PRIMARY KEY, name TEXT)")
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = execute(d, fmt.Sprintf("INSERT INTO a VALUES (%d, 'x')", i))
+		assert.Nil(t, err)
+		// The log is truncated whenever it reaches 10 frames.
+		assert.True(t, d.pool.wal.frames < 10)
+	}
+
+	// A crash after a checkpoint wrote the file but before it truncated
+	// the log replays the log again, to the same effect.
+	_, err = execute(d, "DELETE FROM a WHERE id < 15")
+	assert.Nil(t, err)
+	wal, err := os.ReadFile(path + "-wal")
+	assert.Nil(t, err)
+	assert.True(t, len(wal) > walHeaderSize)
+
+	assert.Nil(t, d.Checkpoint())
+	info, err := os.Stat(path + "-wal")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(walHeaderSize), info.Size())
+	assert.Nil(t, d.pool.close())
+	assert.Nil(t, os.WriteFile(path+"-wal", wal, 0644))
+
+	d, err = OpenDiskBackend(path)
+	assert.Nil(t, err)
+	defer d.Close()
+	results, err := execute(d, "SELECT count(*), min(id) FROM a")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(5), int32(15)}}, cellValues(results))
+}
+
+func TestDiskBackendTransactions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tx.db")
+	d, err := openDiskBackend(path, 16)
+	assert.Nil(t, err)
+
+	_, err = execute(d, "CREATE TABLE a (id INT PRIMARY KEY, name TEXT)")
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		_, err = execute(d, fmt.Sprintf("INSERT INTO a VALUES (%d, 'row %d')", i, i))
+		assert.Nil(t, err)
+	}
+
+	state := func(b Backend) [][]interface{} {
+		results, err := execute(b, "SELECT count(*), sum(id), max(length(name)) FROM a")
+		assert.Nil(t, err)
+		return cellValues(results)
+	}
+	before := state(d)
+	pages := d.pool.header.pageCount
+
+	var b strings.Builder
+	b.WriteString("BEGIN;")
+	for i := 200; i < 500; i++ {
+		name := fmt.Sprintf("row %d", i)
+		if i%100 == 0 {
+			name = strings.Repeat("x", 2*pageSize)
+		}
+		fmt.Fprintf(&b, "INSERT INTO a VALUES (%d, '%s');", i, name)
+	}
+	b.WriteString(`UPDATE a SET name = 'changed' WHERE id % 3 = 0;
+	DELETE FROM a WHERE id < 50;
+	CREATE TABLE b (id INT);
+	INSERT INTO b VALUES (1);
+	ALTER TABLE a ADD COLUMN n INT DEFAULT 0`)
+	_, err = execute(d, b.String())
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(450), int32(124750 - 1225), int32(2 * pageSize)}}, state(d))
+	assert.True(t, errors.Is(d.Checkpoint(), ErrTransactionInProgress))
+
+	// Nothing of the transaction reaches the log before COMMIT, so a crash
+	// loses all of it.
+	db, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	wal, err := os.ReadFile(path + "-wal")
+	assert.Nil(t, err)
+	crashed := filepath.Join(dir, "crashed.db")
+	assert.Nil(t, os.WriteFile(crashed, db, 0644))
+	assert.Nil(t, os.WriteFile(crashed+"-wal", wal, 0644))
+	r, err := OpenDiskBackend(crashed)
+	assert.Nil(t, err)
+	assert.Equal(t, before, state(r))
+	assert.Nil(t, r.Close())
+
+	assert.Nil(t, d.Rollback(&RollbackStatement{}))
+	assert.Equal(t, before, state(d))
+	assert.Equal(t, pages, d.pool.header.pageCount)
+	_, err = execute(d, "SELECT id FROM b")
+	assert.True(t, errors.Is(err, ErrTableDoesNotExist))
+	_, err = execute(d, "INSERT INTO a VALUES (0, 'dup')")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	_, err = execute(d, `BEGIN;
+	INSERT INTO a VALUES (200, 'new');
+	DELETE FROM a WHERE id < 100;
+	CREATE TABLE b (id INT);
+	INSERT INTO b VALUES (1);
+	COMMIT`)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	d, err = openDiskBackend(path, 16)
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Equal(t, [][]interface{}{{int32(101), int32(14950 + 200), int32(7)}}, state(d))
+	results, err := execute(d, "SELECT id FROM b")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1)}}, cellValues(results))
+}
+
+func TestDiskBackendSavepoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savepoints.db")
+	d, err := openDiskBackend(path, 16)
+	assert.Nil(t, err)
+
+	state := func() [][]interface{} {
+		results, err := execute(d, "SELECT count(*), sum(id) FROM a")
+		assert.Nil(t, err)
+		return cellValues(results)
+	}
+	insert := func(from, to int) string {
+		var b strings.Builder
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&b, "INSERT INTO a VALUES (%d, '%s');", i, strings.Repeat("v", 100))
+		}
+		return b.String()
+	}
+
+	_, err = execute(d, "CREATE TABLE a (id INT PRIMARY KEY, v TEXT);"+insert(0, 100))
+	assert.Nil(t, err)
+
+	_, err = execute(d, "BEGIN;"+insert(100, 200)+"SAVEPOINT one")
+	assert.Nil(t, err)
+	first := [][]interface{}{{int32(200), int32(19900)}}
+	pages := d.pool.header.pageCount
+	_, err = execute(d, insert(200, 400)+"DELETE FROM a WHERE id < 50")
+	assert.Nil(t, err)
+
+	_, err = execute(d, "ROLLBACK TO SAVEPOINT one")
+	assert.Nil(t, err)
+	assert.Equal(t, first, state())
+	assert.Equal(t, pages, d.pool.header.pageCount)
+
+	_, err = execute(d, "UPDATE a SET v = 'short' WHERE id % 2 = 0; SAVEPOINT two;"+insert(400, 500)+"ROLLBACK TO one")
+	assert.Nil(t, err)
+	assert.Equal(t, first, state())
+	_, err = execute(d, "ROLLBACK TO two")
+	assert.True(t, errors.Is(err, ErrSavepointDoesNotExist))
+
+	_, err = execute(d, "SAVEPOINT three;"+insert(500, 510)+"RELEASE three; COMMIT")
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	d, err = openDiskBackend(path, 16)
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Equal(t, [][]interface{}{{int32(210), int32(19900 + 5045)}}, state())
+}
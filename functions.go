@@ -0,0 +1,476 @@
+package gogn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// function is one signature of a function callable from SQL. A name may
+// have several signatures, like abs(int) and abs(float).
+type function struct {
+	argTypes []ColumnType
+	// variadic functions accept any number of trailing arguments of the
+	// last type in argTypes.
+	variadic bool
+	// textArgs functions accept arguments of any type, converted to text
+	// before the call.
+	textArgs   bool
+	returnType ColumnType
+	// Functions are strict unless callOnNull is set: any NULL argument makes
+	// the result NULL without calling call. Strict aggregates skip the row.
+	callOnNull bool
+	// Scalar functions set call and aggregate functions set newAggregate.
+	// Scalar functions that use the backend's state, like nextval, set
+	// callBackend instead of call.
+	call         func(args []MemoryCell) (MemoryCell, error)
+	callBackend  func(mb *MemoryBackend, args []MemoryCell) (MemoryCell, error)
+	newAggregate func() aggregateState
+}
+
+func (fn *function) isAggregate() bool {
+	return fn.newAggregate != nil
+}
+
+// accepts reports whether fn can be called with arguments of argTypes, and
+// whether that needs no int to float widening.
+func (fn *function) accepts(argTypes []ColumnType) (ok bool, exact bool) {
+	if len(argTypes) < len(fn.argTypes) || (!fn.variadic && len(argTypes) != len(fn.argTypes)) {
+		return false, false
+	}
+
+	exact = true
+	for i, typ := range argTypes {
+		param := fn.paramType(i)
+		if fn.textArgs {
+			continue
+		}
+		if !assignable(typ, param) {
+			return false, false
+		}
+		if typ != param && typ != NullType {
+			exact = false
+		}
+	}
+	return true, exact
+}
+
+func (fn *function) paramType(i int) ColumnType {
+	if i >= len(fn.argTypes) {
+		return fn.argTypes[len(fn.argTypes)-1]
+	}
+	return fn.argTypes[i]
+}
+
+func textFunction(f func(s string) string) []*function {
+	return []*function{{
+		argTypes:   []ColumnType{TextType},
+		returnType: TextType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return MemoryCell(f(args[0].AsText())), nil
+		},
+	}}
+}
+
+func floatFunction(f func(x float64) float64) []*function {
+	return []*function{{
+		argTypes:   []ColumnType{FloatType},
+		returnType: FloatType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return floatToCell(f(args[0].AsFloat())), nil
+		},
+	}}
+}
+
+// substr follows Postgres in counting characters from 1 and letting a start
+// before the string eat into count.
+func substr(args []MemoryCell) (MemoryCell, error) {
+	s := []rune(args[0].AsText())
+	begin := int64(args[1].AsInt()) - 1
+	end := int64(len(s))
+	if len(args) > 2 {
+		count := int64(args[2].AsInt())
+		if count < 0 {
+			return nil, fmt.Errorf("%w: negative substring length", ErrInvalidArguments)
+		}
+		end = begin + count
+	}
+
+	if begin < 0 {
+		begin = 0
+	}
+	if end > int64(len(s)) {
+		end = int64(len(s))
+	}
+	if begin >= end {
+		return MemoryCell(""), nil
+	}
+	return MemoryCell(string(s[begin:end])), nil
+}
+
+var builtinFunctions = map[string][]*function{
+	"lower": textFunction(strings.ToLower),
+	"upper": textFunction(strings.ToUpper),
+	"length": {{
+		argTypes:   []ColumnType{TextType},
+		returnType: IntType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return intToCell(int32(utf8.RuneCountInString(args[0].AsText()))), nil
+		},
+	}},
+	"substr": {
+		{argTypes: []ColumnType{TextType, IntType}, returnType: TextType, call: substr},
+		{argTypes: []ColumnType{TextType, IntType, IntType}, returnType: TextType, call: substr},
+	},
+	"trim": {
+		{
+			argTypes:   []ColumnType{TextType},
+			returnType: TextType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				return MemoryCell(strings.Trim(args[0].AsText(), " ")), nil
+			},
+		},
+		{
+			argTypes:   []ColumnType{TextType, TextType},
+			returnType: TextType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				return MemoryCell(strings.Trim(args[0].AsText(), args[1].AsText())), nil
+			},
+		},
+	},
+	"replace": {{
+		argTypes:   []ColumnType{TextType, TextType, TextType},
+		returnType: TextType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			s, from := args[0].AsText(), args[1].AsText()
+			if from == "" {
+				return MemoryCell(s), nil
+			}
+			return MemoryCell(strings.ReplaceAll(s, from, args[2].AsText())), nil
+		},
+	}},
+	// concat skips NULL arguments instead of returning NULL.
+	"concat": {{
+		argTypes:   []ColumnType{TextType},
+		variadic:   true,
+		textArgs:   true,
+		returnType: TextType,
+		callOnNull: true,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			var b strings.Builder
+			for _, arg := range args {
+				b.WriteString(arg.AsText())
+			}
+			return MemoryCell(b.String()), nil
+		},
+	}},
+	"position": {{
+		argTypes:   []ColumnType{TextType, TextType},
+		returnType: IntType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			s := args[1].AsText()
+			i := strings.Index(s, args[0].AsText())
+			if i < 0 {
+				return intToCell(0), nil
+			}
+			return intToCell(int32(utf8.RuneCountInString(s[:i]) + 1)), nil
+		},
+	}},
+	"abs": {
+		{
+			argTypes:   []ColumnType{IntType},
+			returnType: IntType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				i := args[0].AsInt()
+				if i == math.MinInt32 {
+					return nil, ErrIntegerOutOfRange
+				}
+				if i < 0 {
+					i = -i
+				}
+				return intToCell(i), nil
+			},
+		},
+		floatFunction(math.Abs)[0],
+	},
+	"round": {
+		floatFunction(math.Round)[0],
+		{
+			argTypes:   []ColumnType{FloatType, IntType},
+			returnType: FloatType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				scale := math.Pow(10, float64(args[1].AsInt()))
+				return floatToCell(math.Round(args[0].AsFloat()*scale) / scale), nil
+			},
+		},
+	},
+	"floor": floatFunction(math.Floor),
+	"ceil":  floatFunction(math.Ceil),
+	"mod": {
+		{
+			argTypes:   []ColumnType{IntType, IntType},
+			returnType: IntType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				if args[1].AsInt() == 0 {
+					return nil, ErrDivisionByZero
+				}
+				return intToCell(args[0].AsInt() % args[1].AsInt()), nil
+			},
+		},
+		{
+			argTypes:   []ColumnType{FloatType, FloatType},
+			returnType: FloatType,
+			call: func(args []MemoryCell) (MemoryCell, error) {
+				if args[1].AsFloat() == 0 {
+					return nil, ErrDivisionByZero
+				}
+				return floatToCell(math.Mod(args[0].AsFloat(), args[1].AsFloat())), nil
+			},
+		},
+	},
+	"power": {{
+		argTypes:   []ColumnType{FloatType, FloatType},
+		returnType: FloatType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return floatToCell(math.Pow(args[0].AsFloat(), args[1].AsFloat())), nil
+		},
+	}},
+	"sqrt": {{
+		argTypes:   []ColumnType{FloatType},
+		returnType: FloatType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			x := args[0].AsFloat()
+			if x < 0 {
+				return nil, fmt.Errorf("%w: cannot take square root of a negative number", ErrInvalidArguments)
+			}
+			return floatToCell(math.Sqrt(x)), nil
+		},
+	}},
+	"random": {{
+		argTypes:   []ColumnType{},
+		returnType: FloatType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return floatToCell(rand.Float64()), nil
+		},
+	}},
+	// There is no timestamp type, so now() returns the time as text in the
+	// format Postgres prints timestamps with time zone.
+	"now": {{
+		argTypes:   []ColumnType{},
+		returnType: TextType,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			return MemoryCell(time.Now().Format("2006-01-02 15:04:05.999999-07")), nil
+		},
+	}},
+}
+
+// signatures returns every signature registered under name, user-defined
+// ones first.
+func (mb *MemoryBackend) signatures(name string) []*function {
+	signatures := append([]*function{}, mb.functions[name]...)
+	signatures = append(signatures, builtinFunctions[name]...)
+	return signatures
+}
+
+func (mb *MemoryBackend) isAggregateCall(exp *expression) bool {
+	if exp.kind != callKind {
+		return false
+	}
+
+	for _, fn := range mb.signatures(exp.call.name.value) {
+		if fn.isAggregate() {
+			return true
+		}
+	}
+	return false
+}
+
+func (mb *MemoryBackend) containsAggregate(exp *expression) bool {
+	if mb.isAggregateCall(exp) {
+		return true
+	}
+
+	for _, child := range exp.children() {
+		if mb.containsAggregate(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCall type checks the arguments of call and picks the signature to
+// run, preferring one that needs no widening of int arguments.
+func (mb *MemoryBackend) resolveCall(t *table, call *callExpression) (*function, []ColumnType, error) {
+	argTypes := []ColumnType{}
+	for _, arg := range call.args {
+		typ, err := mb.expressionType(t, arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		argTypes = append(argTypes, typ)
+	}
+
+	signatures := mb.signatures(call.name.value)
+	if len(signatures) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrFunctionDoesNotExist, call.name.value)
+	}
+
+	var widened *function
+	for _, fn := range signatures {
+		if call.star && !fn.isAggregate() {
+			continue
+		}
+
+		ok, exact := fn.accepts(argTypes)
+		if ok && exact {
+			return fn, argTypes, nil
+		}
+		if ok && widened == nil {
+			widened = fn
+		}
+	}
+
+	if widened == nil {
+		names := []string{}
+		for _, typ := range argTypes {
+			names = append(names, typ.String())
+		}
+		if call.star {
+			names = []string{"*"}
+		}
+		return nil, nil, fmt.Errorf("%w: %s(%s)", ErrInvalidArguments, call.name.value, strings.Join(names, ", "))
+	}
+	return widened, argTypes, nil
+}
+
+func (mb *MemoryBackend) evaluateCall(t *table, row []MemoryCell, call *callExpression) (MemoryCell, ColumnType, error) {
+	fn, argTypes, err := mb.resolveCall(t, call)
+	if err != nil {
+		return nil, NullType, err
+	}
+
+	args := []MemoryCell{}
+	for i, arg := range call.args {
+		cell, _, err := mb.evaluateCell(t, row, arg)
+		if err != nil {
+			return nil, NullType, err
+		}
+
+		if cell == nil && !fn.callOnNull {
+			return nil, fn.returnType, nil
+		}
+
+		if fn.textArgs && cell != nil {
+			cell = MemoryCell(cellToText(cell, argTypes[i]))
+		}
+		args = append(args, coerceCell(cell, argTypes[i], fn.paramType(i)))
+	}
+
+	var cell MemoryCell
+	if fn.callBackend != nil {
+		cell, err = fn.callBackend(mb, args)
+	} else {
+		cell, err = fn.call(args)
+	}
+	if err != nil {
+		return nil, NullType, err
+	}
+	return cell, fn.returnType, nil
+}
+
+// NewIntCell, NewFloatCell, NewTextCell and NewBoolCell build the cells that
+// user-defined functions return. A nil Cell is NULL.
+func NewIntCell(i int32) Cell {
+	return intToCell(i)
+}
+
+func NewFloatCell(f float64) Cell {
+	return floatToCell(f)
+}
+
+func NewTextCell(s string) Cell {
+	return MemoryCell(s)
+}
+
+func NewBoolCell(b bool) Cell {
+	return boolToCell(b)
+}
+
+// cellToMemoryCell re-encodes a Cell returned by user code as typ.
+func cellToMemoryCell(c Cell, typ ColumnType) MemoryCell {
+	if c == nil || c.IsNull() {
+		return nil
+	}
+
+	switch typ {
+	case IntType:
+		return intToCell(c.AsInt())
+	case FloatType:
+		return floatToCell(c.AsFloat())
+	case BoolType:
+		return boolToCell(c.AsBool())
+	}
+	return MemoryCell(c.AsText())
+}
+
+func memoryCellsToCells(cells []MemoryCell) []Cell {
+	args := make([]Cell, 0, len(cells))
+	for _, cell := range cells {
+		args = append(args, cell)
+	}
+	return args
+}
+
+// validateSignature checks a user-defined function signature before it is
+// registered under name.
+func (mb *MemoryBackend) validateSignature(name string, argTypes []ColumnType, returnType ColumnType) error {
+	if name == "" {
+		return fmt.Errorf("%w: missing function name", ErrInvalidArguments)
+	}
+
+	types := append([]ColumnType{returnType}, argTypes...)
+	for _, typ := range types {
+		switch typ {
+		case TextType, IntType, FloatType, BoolType:
+		default:
+			return ErrInvalidDatatype
+		}
+	}
+
+	for _, fn := range mb.signatures(name) {
+		if ok, exact := fn.accepts(argTypes); ok && exact && len(fn.argTypes) == len(argTypes) {
+			return fmt.Errorf("%w: %s", ErrFunctionExists, name)
+		}
+	}
+	return nil
+}
+
+// RegisterFunction makes fn callable from any SQL expression as name. Calls
+// are type checked against argTypes, widening int arguments to float where
+// needed, and fn's result is stored as returnType. fn is called for NULL
+// arguments too, which it can detect with IsNull. Every session of the
+// database shares the function, so register it before other sessions run
+// statements.
+func (mb *MemoryBackend) RegisterFunction(name string, argTypes []ColumnType, returnType ColumnType, fn func(args []Cell) (Cell, error)) error {
+	name = strings.ToLower(name)
+	if err := mb.validateSignature(name, argTypes, returnType); err != nil {
+		return err
+	}
+
+	mb.functions[name] = append(mb.functions[name], &function{
+		argTypes:   append([]ColumnType{}, argTypes...),
+		returnType: returnType,
+		callOnNull: true,
+		call: func(args []MemoryCell) (MemoryCell, error) {
+			c, err := fn(memoryCellsToCells(args))
+			if err != nil {
+				return nil, err
+			}
+			return cellToMemoryCell(c, returnType), nil
+		},
+	})
+	return nil
+}
@@ -0,0 +1,247 @@
+package gogn
+
+import (
+	"sort"
+)
+
+// heapDegree is the most rows a leaf of a rowHeap holds, and the most
+// children an internal node of one has.
+const heapDegree = 64
+
+// heapRow is a row of a heap table with its version.
+type heapRow struct {
+	version rowVersion
+	cells   []MemoryCell
+}
+
+// heapNode is a node of a rowHeap. Leaves hold rows in the order of their
+// ids. Internal nodes hold children, with keys holding the first id under
+// each child but the first.
+type heapNode struct {
+	owner    *owner
+	rows     []heapRow
+	keys     []uint64
+	children []*heapNode
+}
+
+func (n *heapNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// childIndex returns the child of n whose ids include id.
+func (n *heapNode) childIndex(id uint64) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > id
+	})
+}
+
+// rowIndex returns the position of the first row of the leaf n not before
+// id.
+func (n *heapNode) rowIndex(id uint64) int {
+	return sort.Search(len(n.rows), func(i int) bool {
+		return n.rows[i].version.id >= id
+	})
+}
+
+// rowHeap holds the rows of a heap table in a B+tree keyed by their ids.
+// Copies of a rowHeap share its nodes, so copying one takes no time whatever
+// the size of the table: a rowHeap copies a node it doesn't own before
+// changing it, along with the nodes above it. Leaves emptied by deletes are
+// dropped, but partly empty ones aren't merged. A nil rowHeap is empty.
+type rowHeap struct {
+	root  *heapNode
+	count int
+	owner *owner
+}
+
+func newRowHeap() *rowHeap {
+	o := &owner{}
+	return &rowHeap{root: &heapNode{owner: o}, owner: o}
+}
+
+// clone returns a copy of h whose rows can change without affecting h.
+func (h *rowHeap) clone() *rowHeap {
+	return &rowHeap{root: h.root, count: h.count, owner: &owner{}}
+}
+
+// len returns the number of rows of h.
+func (h *rowHeap) len() int {
+	if h == nil {
+		return 0
+	}
+	return h.count
+}
+
+// mutable returns n for h to change: n itself if h owns it, or else a copy
+// of it that h owns.
+func (h *rowHeap) mutable(n *heapNode) *heapNode {
+	if n.owner == h.owner {
+		return n
+	}
+	c := &heapNode{owner: h.owner}
+	if n.leaf() {
+		c.rows = append([]heapRow{}, n.rows...)
+	} else {
+		c.keys = append([]uint64{}, n.keys...)
+		c.children = append([]*heapNode{}, n.children...)
+	}
+	return c
+}
+
+// get returns the row of h with id.
+func (h *rowHeap) get(id uint64) (heapRow, bool) {
+	if h == nil {
+		return heapRow{}, false
+	}
+	n := h.root
+	for !n.leaf() {
+		n = n.children[n.childIndex(id)]
+	}
+	i := n.rowIndex(id)
+	if i == len(n.rows) || n.rows[i].version.id != id {
+		return heapRow{}, false
+	}
+	return n.rows[i], true
+}
+
+// put stores row with version v in h, in place of the row with the same id
+// if there is one.
+func (h *rowHeap) put(v rowVersion, row []MemoryCell) {
+	h.root = h.mutable(h.root)
+	if split, right := h.putInto(h.root, heapRow{v, row}); right != nil {
+		h.root = &heapNode{owner: h.owner, keys: []uint64{split}, children: []*heapNode{h.root, right}}
+	}
+}
+
+// putInto stores r under n, which h owns. If n splits, it returns the node
+// split off to its right and the first id under it.
+func (h *rowHeap) putInto(n *heapNode, r heapRow) (uint64, *heapNode) {
+	id := r.version.id
+	if n.leaf() {
+		i := n.rowIndex(id)
+		if i < len(n.rows) && n.rows[i].version.id == id {
+			n.rows[i] = r
+			return 0, nil
+		}
+		n.rows = append(n.rows, heapRow{})
+		copy(n.rows[i+1:], n.rows[i:])
+		n.rows[i] = r
+		h.count++
+		return h.split(n, i == len(n.rows)-1)
+	}
+
+	i := n.childIndex(id)
+	n.children[i] = h.mutable(n.children[i])
+	split, right := h.putInto(n.children[i], r)
+	if right == nil {
+		return 0, nil
+	}
+	n.keys = append(n.keys, 0)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = split
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+	return h.split(n, i == len(n.keys)-1)
+}
+
+// split splits n in two if it has grown past heapDegree, returning the
+// right half and the first id under it. When the rows are being appended,
+// which is how tables grow, the left half keeps all it can so that nodes
+// end up full.
+func (h *rowHeap) split(n *heapNode, appended bool) (uint64, *heapNode) {
+	right := &heapNode{owner: h.owner}
+	if n.leaf() {
+		if len(n.rows) <= heapDegree {
+			return 0, nil
+		}
+		at := len(n.rows) / 2
+		if appended {
+			at = len(n.rows) - 1
+		}
+		right.rows = append(right.rows, n.rows[at:]...)
+		n.rows = n.rows[:at]
+		return right.rows[0].version.id, right
+	}
+
+	if len(n.children) <= heapDegree {
+		return 0, nil
+	}
+	at := len(n.keys) / 2
+	if appended {
+		at = len(n.keys) - 1
+	}
+	split := n.keys[at]
+	right.keys = append(right.keys, n.keys[at+1:]...)
+	right.children = append(right.children, n.children[at+1:]...)
+	n.keys, n.children = n.keys[:at], n.children[:at+1]
+	return split, right
+}
+
+// delete removes the row with id from h if it's there.
+func (h *rowHeap) delete(id uint64) {
+	if _, ok := h.get(id); !ok {
+		return
+	}
+	h.root = h.mutable(h.root)
+	h.deleteFrom(h.root, id)
+	h.count--
+	for !h.root.leaf() && len(h.root.children) == 1 {
+		h.root = h.root.children[0]
+	}
+}
+
+// deleteFrom removes the row with id from under n, which h owns, and
+// reports whether that left n empty.
+func (h *rowHeap) deleteFrom(n *heapNode, id uint64) bool {
+	if n.leaf() {
+		i := n.rowIndex(id)
+		n.rows = append(n.rows[:i], n.rows[i+1:]...)
+		return len(n.rows) == 0
+	}
+
+	i := n.childIndex(id)
+	n.children[i] = h.mutable(n.children[i])
+	if !h.deleteFrom(n.children[i], id) {
+		return false
+	}
+	n.children = append(n.children[:i], n.children[i+1:]...)
+	if len(n.children) == 0 {
+		return true
+	}
+	if i > 0 {
+		i--
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	return false
+}
+
+// scan returns the rows of h in the order of their ids, one each time it's
+// called.
+func (h *rowHeap) scan() func() (heapRow, bool) {
+	type step struct {
+		node *heapNode
+		next int
+	}
+	var path []step
+	if h != nil {
+		path = append(path, step{node: h.root})
+	}
+
+	return func() (heapRow, bool) {
+		for len(path) > 0 {
+			top := &path[len(path)-1]
+			switch {
+			case top.node.leaf() && top.next < len(top.node.rows):
+				top.next++
+				return top.node.rows[top.next-1], true
+			case !top.node.leaf() && top.next < len(top.node.children):
+				top.next++
+				path = append(path, step{node: top.node.children[top.next-1]})
+			default:
+				path = path[:len(path)-1]
+			}
+		}
+		return heapRow{}, false
+	}
+}
@@ -0,0 +1,479 @@
+package gogn
+
+import (
+	"fmt"
+	"sort"
+)
+
+// index is an ordered index over some columns of its table, mapping their
+// values to row ids. PRIMARY KEY and UNIQUE constraints each get an
+// index named after them, which belongs to the constraint.
+type index struct {
+	name    string
+	columns []int
+	// unique is set when a unique constraint of the same name enforces
+	// that no two rows share a key.
+	unique     bool
+	constraint bool
+	tree       *btree
+}
+
+func (ix *index) entry(row []MemoryCell, id uint64) indexEntry {
+	key := []MemoryCell{}
+	for _, col := range ix.columns {
+		key = append(key, row[col])
+	}
+	return indexEntry{key: key, row: id}
+}
+
+// find returns the id of a row whose key over the index columns is key.
+func (ix *index) find(key []MemoryCell) (uint64, bool) {
+	types := ix.tree.types
+	var id uint64
+	found := false
+	ix.tree.ascend(key, types, func(e indexEntry) bool {
+		id, found = e.row, compareKeys(e.key, types, key, types) == 0
+		return false
+	})
+	return id, found
+}
+
+// uniqueIndex returns the index of the unique constraint u of t, which has
+// the constraint's name.
+func (t *table) uniqueIndex(u *uniqueConstraint) *index {
+	for _, ix := range t.indexes {
+		if ix.name == u.name {
+			return ix
+		}
+	}
+	return nil
+}
+
+func (t *table) columnTypesOf(columns []int) []ColumnType {
+	types := []ColumnType{}
+	for _, col := range columns {
+		types = append(types, t.columnTypes[col])
+	}
+	return types
+}
+
+// add adds the entry of row, the row with id, to ix. It fails when ix is
+// unique and another row has the same key.
+func (ix *index) add(row []MemoryCell, id uint64) bool {
+	if key, ok := keyCells(row, ix.columns); ok && ix.unique {
+		if _, found := ix.find(key); found {
+			return false
+		}
+	}
+	ix.tree.insert(ix.entry(row, id))
+	return true
+}
+
+// buildIndex fills ix with the rows of t, failing on the first key two rows
+// share when ix is unique.
+func (t *table) buildIndex(ix *index) error {
+	ix.tree = newBtree(t.columnTypesOf(ix.columns))
+	return t.eachRow(func(v rowVersion, row []MemoryCell) error {
+		if !ix.add(row, v.id) {
+			return t.keyViolation(ix)
+		}
+		return nil
+	})
+}
+
+// keyViolation is the error for two rows sharing a key of the unique index
+// ix.
+func (t *table) keyViolation(ix *index) error {
+	for _, u := range t.uniques {
+		if u.name == ix.name {
+			return t.violation(u.kind(), u.name, u.columns)
+		}
+	}
+	return t.violation(UniqueConstraint, ix.name, ix.columns)
+}
+
+// findIndex returns the table holding the index called name and the
+// index's position in it.
+func (mb *MemoryBackend) findIndex(name string) (*table, int, bool) {
+	for _, t := range mb.tables {
+		for i, ix := range t.indexes {
+			if ix.name == name {
+				return t, i, true
+			}
+		}
+	}
+	return nil, -1, false
+}
+
+// CreateIndex adds an index to a table based on the information in
+// CreateIndexStatement. A UNIQUE index also adds a unique constraint, so it
+// fails when existing rows share a key.
+func (mb *MemoryBackend) CreateIndex(crt *CreateIndexStatement) error {
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.createIndex(crt)
+	})
+}
+
+func (mb *MemoryBackend) createIndex(crt *CreateIndexStatement) error {
+	t, ok := mb.tables[crt.table.value]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	if _, _, ok := mb.findIndex(crt.name.value); ok {
+		return fmt.Errorf("%w: %s", ErrIndexExists, crt.name.value)
+	}
+
+	columns := []int{}
+	for _, col := range crt.columns {
+		i, ok := t.columnIndex(col.value)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+		}
+		columns = append(columns, i)
+	}
+
+	t = mb.alter(t)
+	ix := &index{name: crt.name.value, columns: columns, unique: crt.unique}
+	if err := t.buildIndex(ix); err != nil {
+		return err
+	}
+	if crt.unique {
+		t.uniques = append(t.uniques, &uniqueConstraint{name: ix.name, columns: columns})
+	}
+	t.indexes = append(t.indexes, ix)
+	return nil
+}
+
+// DropIndex removes an index based on the information in
+// DropIndexStatement. The indexes of constraints and unique indexes that
+// foreign keys rely on can't be dropped.
+func (mb *MemoryBackend) DropIndex(drp *DropIndexStatement) error {
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.dropIndex(drp)
+	})
+}
+
+func (mb *MemoryBackend) dropIndex(drp *DropIndexStatement) error {
+	t, i, ok := mb.findIndex(drp.name.value)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexDoesNotExist, drp.name.value)
+	}
+
+	ix := t.indexes[i]
+	if ix.constraint {
+		return fmt.Errorf("%w: index %s belongs to a constraint of %s", ErrDependentObjects, ix.name, t.name)
+	}
+	t = mb.alter(t)
+
+	if ix.unique {
+		uniques := []*uniqueConstraint{}
+		for _, u := range t.uniques {
+			if u.name != ix.name {
+				uniques = append(uniques, u)
+			}
+		}
+
+		// Foreign keys need another unique constraint on the columns
+		// they reference.
+		remaining := &table{uniques: uniques}
+		for _, other := range mb.tables {
+			for _, fk := range other.foreignKeys {
+				if _, ok := remaining.uniqueOn(fk.parentColumns); fk.parent == t.name && !ok {
+					return fmt.Errorf("%w: foreign key %s references index %s", ErrDependentObjects, fk.name, ix.name)
+				}
+			}
+		}
+		t.uniques = uniques
+	}
+
+	t.indexes = append(t.indexes[:i], t.indexes[i+1:]...)
+	return nil
+}
+
+// updateIndexes brings the indexes of the table up to date with the
+// changes, before they are applied to its rows.
+func (c *rowChanges) updateIndexes() {
+	t := c.t
+	if len(t.indexes) == 0 {
+		return
+	}
+
+	for id, row := range c.updated {
+		old := t.lookup(id)
+		for _, ix := range t.indexes {
+			ix.tree.delete(ix.entry(old, id))
+			if row != nil {
+				ix.tree.insert(ix.entry(row, id))
+			}
+		}
+	}
+
+	for _, v := range c.inserted {
+		row := c.insertedRows[v.id]
+		if row == nil {
+			continue
+		}
+		for _, ix := range t.indexes {
+			ix.tree.insert(ix.entry(row, v.id))
+		}
+	}
+}
+
+// columnPredicate is a comparison of a column with a constant, like
+// id = 5 or price < 10.0.
+type columnPredicate struct {
+	column int
+	op     symbol
+	value  MemoryCell
+	typ    ColumnType
+}
+
+// isConstant reports whether exp evaluates to the same value for every row,
+// which rules out columns and functions such as random().
+func isConstant(exp *expression) bool {
+	if exp.kind == callKind || (exp.kind == literalKind && exp.literal.kind == identifierKind) {
+		return false
+	}
+
+	for _, child := range exp.children() {
+		if !isConstant(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// flippedComparisons maps each comparison an index can answer to the one
+// for its operands swapped around.
+var flippedComparisons = map[symbol]symbol{
+	equalsSymbol:             equalsSymbol,
+	lessThanSymbol:           greaterThanSymbol,
+	lessThanOrEqualSymbol:    greaterThanOrEqualSymbol,
+	greaterThanSymbol:        lessThanSymbol,
+	greaterThanOrEqualSymbol: lessThanOrEqualSymbol,
+}
+
+// indexPredicates returns the comparisons of a column of t with a constant
+// that where ANDs together, which are the ones an index can answer.
+func (mb *MemoryBackend) indexPredicates(t *table, where *expression) []columnPredicate {
+	predicates := []columnPredicate{}
+
+	add := func(column, value *expression, op symbol) {
+		if column.kind != literalKind || column.literal.kind != identifierKind || !isConstant(value) {
+			return
+		}
+
+		i, ok := t.columnIndex(column.literal.value)
+		if !ok {
+			return
+		}
+
+		cell, typ, err := mb.evaluateCell(&table{}, nil, value)
+		if err != nil {
+			return
+		}
+		predicates = append(predicates, columnPredicate{column: i, op: op, value: cell, typ: typ})
+	}
+
+	var walk func(exp *expression)
+	walk = func(exp *expression) {
+		switch exp.kind {
+		case binaryKind:
+			op := exp.binary.op
+			if op.matchesKeyword(andKeyword) {
+				walk(exp.binary.a)
+				walk(exp.binary.b)
+				return
+			}
+
+			if reversed, ok := flippedComparisons[symbol(op.value)]; ok && op.kind == symbolKind {
+				add(exp.binary.a, exp.binary.b, symbol(op.value))
+				add(exp.binary.b, exp.binary.a, reversed)
+			}
+		case betweenKind:
+			add(exp.between.operand, exp.between.low, greaterThanOrEqualSymbol)
+			add(exp.between.operand, exp.between.high, lessThanOrEqualSymbol)
+		}
+	}
+	walk(where)
+
+	return predicates
+}
+
+// indexAccess is a lookup of the rows of a table through one of its
+// indexes: equality on a leading run of the index columns, optionally
+// followed by a range on the next one.
+type indexAccess struct {
+	index        *index
+	equal        []columnPredicate
+	lower, upper *columnPredicate
+}
+
+// predicates returns the comparisons the access answers.
+func (a *indexAccess) predicates() []columnPredicate {
+	predicates := append([]columnPredicate{}, a.equal...)
+	for _, p := range []*columnPredicate{a.lower, a.upper} {
+		if p != nil {
+			predicates = append(predicates, *p)
+		}
+	}
+	return predicates
+}
+
+// chooseIndex returns the access through the index of t that answers the
+// most of the predicates of where. It returns false when no index applies.
+func (mb *MemoryBackend) chooseIndex(t *table, where *expression) (*indexAccess, bool) {
+	if where == nil || len(t.indexes) == 0 {
+		return nil, false
+	}
+
+	predicates := mb.indexPredicates(t, where)
+
+	var best *indexAccess
+	bestScore := 0
+	for _, ix := range t.indexes {
+		equal := []columnPredicate{}
+	columns:
+		for _, col := range ix.columns {
+			for _, p := range predicates {
+				if p.column == col && p.op == equalsSymbol {
+					equal = append(equal, p)
+					continue columns
+				}
+			}
+			break
+		}
+
+		var lower, upper *columnPredicate
+		if len(equal) < len(ix.columns) {
+			for i, p := range predicates {
+				if p.column != ix.columns[len(equal)] {
+					continue
+				}
+
+				switch p.op {
+				case greaterThanSymbol, greaterThanOrEqualSymbol:
+					if lower == nil {
+						lower = &predicates[i]
+					}
+				case lessThanSymbol, lessThanOrEqualSymbol:
+					if upper == nil {
+						upper = &predicates[i]
+					}
+				}
+			}
+		}
+
+		score := 2 * len(equal)
+		if lower != nil || upper != nil {
+			score++
+		}
+		if score > bestScore {
+			best = &indexAccess{index: ix, equal: equal, lower: lower, upper: upper}
+			bestScore = score
+		}
+	}
+
+	return best, best != nil
+}
+
+// ids returns the ids of the rows found by the access, in table order.
+func (a *indexAccess) ids() []uint64 {
+	// Comparisons with NULL are never true.
+	from := []MemoryCell{}
+	fromTypes := []ColumnType{}
+	for _, p := range a.equal {
+		if p.value == nil {
+			return []uint64{}
+		}
+		from = append(from, p.value)
+		fromTypes = append(fromTypes, p.typ)
+	}
+	equalKey, equalTypes := from, fromTypes
+	if a.lower != nil {
+		if a.lower.value == nil {
+			return []uint64{}
+		}
+		from = append(append([]MemoryCell{}, from...), a.lower.value)
+		fromTypes = append(append([]ColumnType{}, fromTypes...), a.lower.typ)
+	}
+	if a.upper != nil && a.upper.value == nil {
+		return []uint64{}
+	}
+
+	k := len(a.equal)
+	lower, upper := a.lower, a.upper
+	types := a.index.tree.types
+	ids := []uint64{}
+	a.index.tree.ascend(from, fromTypes, func(e indexEntry) bool {
+		if compareKeys(e.key, types, equalKey, equalTypes) != 0 {
+			return false
+		}
+
+		if lower != nil || upper != nil {
+			cell := e.key[k]
+			if cell == nil {
+				return true
+			}
+			if lower != nil && lower.op == greaterThanSymbol && compareCells(cell, types[k], lower.value, lower.typ) == 0 {
+				return true
+			}
+			if upper != nil {
+				c := compareCells(cell, types[k], upper.value, upper.typ)
+				if c > 0 || (c == 0 && upper.op == lessThanSymbol) {
+					return false
+				}
+			}
+		}
+
+		ids = append(ids, e.row)
+		return true
+	})
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// indexScan returns the ids of the rows of t that may match where, in table
+// order, using the index chooseIndex picks. It returns false when no index
+// applies.
+func (mb *MemoryBackend) indexScan(t *table, where *expression) ([]uint64, bool) {
+	access, ok := mb.chooseIndex(t, where)
+	if !ok {
+		return nil, false
+	}
+	return access.ids(), true
+}
+
+// scan calls f on each row of t matching where, in table order, along with
+// its id. Only the rows found by an index are looked at when one applies to
+// where.
+func (mb *MemoryBackend) scan(t *table, where *expression, f func(id uint64, row []MemoryCell) error) error {
+	mb.read(t, t, where)
+	visit := func(id uint64, row []MemoryCell) error {
+		matched, err := mb.matches(t, row, where)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return nil
+		}
+		return f(id, row)
+	}
+
+	if ids, ok := mb.indexScan(t, where); ok {
+		for _, id := range ids {
+			if err := visit(id, t.lookup(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return t.eachRow(func(v rowVersion, row []MemoryCell) error {
+		return visit(v.id, row)
+	})
+}
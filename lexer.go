@@ -13,17 +13,97 @@ type location struct {
 type keyword string
 
 const (
-	selectKeyword keyword = "select"
-	fromKeyword   keyword = "from"
-	whereKeyword  keyword = "where"
-	asKeyword     keyword = "as"
-	tableKeyword  keyword = "table"
-	createKeyword keyword = "create"
-	insertKeyword keyword = "insert"
-	intoKeyword   keyword = "into"
-	valuesKeyword keyword = "values"
-	intKeyword    keyword = "int"
-	textKeyword   keyword = "text"
+	selectKeyword       keyword = "select"
+	fromKeyword         keyword = "from"
+	whereKeyword        keyword = "where"
+	asKeyword           keyword = "as"
+	tableKeyword        keyword = "table"
+	createKeyword       keyword = "create"
+	insertKeyword       keyword = "insert"
+	intoKeyword         keyword = "into"
+	valuesKeyword       keyword = "values"
+	intKeyword          keyword = "int"
+	textKeyword         keyword = "text"
+	floatKeyword        keyword = "float"
+	boolKeyword         keyword = "boolean"
+	andKeyword          keyword = "and"
+	orKeyword           keyword = "or"
+	notKeyword          keyword = "not"
+	isKeyword           keyword = "is"
+	nullKeyword         keyword = "null"
+	trueKeyword         keyword = "true"
+	falseKeyword        keyword = "false"
+	caseKeyword         keyword = "case"
+	whenKeyword         keyword = "when"
+	thenKeyword         keyword = "then"
+	elseKeyword         keyword = "else"
+	endKeyword          keyword = "end"
+	inKeyword           keyword = "in"
+	betweenKeyword      keyword = "between"
+	likeKeyword         keyword = "like"
+	ilikeKeyword        keyword = "ilike"
+	coalesceKeyword     keyword = "coalesce"
+	nullifKeyword       keyword = "nullif"
+	groupKeyword        keyword = "group"
+	byKeyword           keyword = "by"
+	primaryKeyword      keyword = "primary"
+	keyKeyword          keyword = "key"
+	uniqueKeyword       keyword = "unique"
+	constraintKeyword   keyword = "constraint"
+	updateKeyword       keyword = "update"
+	setKeyword          keyword = "set"
+	deleteKeyword       keyword = "delete"
+	foreignKeyword      keyword = "foreign"
+	referencesKeyword   keyword = "references"
+	onKeyword           keyword = "on"
+	cascadeKeyword      keyword = "cascade"
+	restrictKeyword     keyword = "restrict"
+	defaultKeyword      keyword = "default"
+	checkKeyword        keyword = "check"
+	sequenceKeyword     keyword = "sequence"
+	incrementKeyword    keyword = "increment"
+	startKeyword        keyword = "start"
+	withKeyword         keyword = "with"
+	serialKeyword       keyword = "serial"
+	bigserialKeyword    keyword = "bigserial"
+	returningKeyword    keyword = "returning"
+	conflictKeyword     keyword = "conflict"
+	doKeyword           keyword = "do"
+	nothingKeyword      keyword = "nothing"
+	alterKeyword        keyword = "alter"
+	addKeyword          keyword = "add"
+	columnKeyword       keyword = "column"
+	dropKeyword         keyword = "drop"
+	renameKeyword       keyword = "rename"
+	toKeyword           keyword = "to"
+	typeKeyword         keyword = "type"
+	indexKeyword        keyword = "index"
+	joinKeyword         keyword = "join"
+	innerKeyword        keyword = "inner"
+	leftKeyword         keyword = "left"
+	outerKeyword        keyword = "outer"
+	orderKeyword        keyword = "order"
+	ascKeyword          keyword = "asc"
+	descKeyword         keyword = "desc"
+	limitKeyword        keyword = "limit"
+	offsetKeyword       keyword = "offset"
+	explainKeyword      keyword = "explain"
+	analyzeKeyword      keyword = "analyze"
+	usingKeyword        keyword = "using"
+	beginKeyword        keyword = "begin"
+	commitKeyword       keyword = "commit"
+	rollbackKeyword     keyword = "rollback"
+	transactionKeyword  keyword = "transaction"
+	workKeyword         keyword = "work"
+	savepointKeyword    keyword = "savepoint"
+	releaseKeyword      keyword = "release"
+	isolationKeyword    keyword = "isolation"
+	levelKeyword        keyword = "level"
+	readKeyword         keyword = "read"
+	uncommittedKeyword  keyword = "uncommitted"
+	committedKeyword    keyword = "committed"
+	repeatableKeyword   keyword = "repeatable"
+	serializableKeyword keyword = "serializable"
 )
 
 func validKeywords() []string {
@@ -39,6 +119,52 @@ func validKeywords() []string {
 		valuesKeyword,
 		intKeyword,
 		textKeyword,
+		floatKeyword,
+		boolKeyword,
+		andKeyword,
+		orKeyword,
+		notKeyword,
+		isKeyword,
+		nullKeyword,
+		trueKeyword,
+		falseKeyword,
+		caseKeyword,
+		whenKeyword,
+		thenKeyword,
+		elseKeyword,
+		endKeyword,
+		inKeyword,
+		betweenKeyword,
+		likeKeyword,
+		ilikeKeyword,
+		coalesceKeyword,
+		nullifKeyword,
+		groupKeyword,
+		byKeyword,
+		primaryKeyword,
+		uniqueKeyword,
+		constraintKeyword,
+		updateKeyword,
+		setKeyword,
+		deleteKeyword,
+		foreignKeyword,
+		referencesKeyword,
+		onKeyword,
+		defaultKeyword,
+		checkKeyword,
+		withKeyword,
+		returningKeyword,
+		doKeyword,
+		alterKeyword,
+		joinKeyword,
+		innerKeyword,
+		leftKeyword,
+		outerKeyword,
+		orderKeyword,
+		ascKeyword,
+		descKeyword,
+		explainKeyword,
+		usingKeyword,
 	}
 
 	var options []string
@@ -48,16 +174,67 @@ func validKeywords() []string {
 	return options
 }
 
+// unreservedKeywords are only keywords where the parser expects them, like
+// LEVEL after ISOLATION, so they lex as identifiers and can name tables and
+// columns everywhere else.
+var unreservedKeywords = map[keyword]bool{
+	isolationKeyword:    true,
+	levelKeyword:        true,
+	readKeyword:         true,
+	uncommittedKeyword:  true,
+	committedKeyword:    true,
+	repeatableKeyword:   true,
+	serializableKeyword: true,
+	workKeyword:         true,
+	transactionKeyword:  true,
+	typeKeyword:         true,
+	columnKeyword:       true,
+	toKeyword:           true,
+	renameKeyword:       true,
+	addKeyword:          true,
+	dropKeyword:         true,
+	startKeyword:        true,
+	incrementKeyword:    true,
+	sequenceKeyword:     true,
+	keyKeyword:          true,
+	cascadeKeyword:      true,
+	restrictKeyword:     true,
+	indexKeyword:        true,
+	conflictKeyword:     true,
+	nothingKeyword:      true,
+	limitKeyword:        true,
+	offsetKeyword:       true,
+	analyzeKeyword:      true,
+	beginKeyword:        true,
+	commitKeyword:       true,
+	rollbackKeyword:     true,
+	savepointKeyword:    true,
+	releaseKeyword:      true,
+	serialKeyword:       true,
+	bigserialKeyword:    true,
+}
+
 type symbol string
 
 const (
-	semicolonSymbol  symbol = ";"
-	asteriskSymbol   symbol = "*"
-	commaSymbol      symbol = ","
-	leftParenSymbol  symbol = "("
-	rightParenSymbol symbol = ")"
-	concatSymbol     symbol = "||"
-	equalsSymbol     symbol = "="
+	semicolonSymbol          symbol = ";"
+	asteriskSymbol           symbol = "*"
+	commaSymbol              symbol = ","
+	leftParenSymbol          symbol = "("
+	rightParenSymbol         symbol = ")"
+	concatSymbol             symbol = "||"
+	equalsSymbol             symbol = "="
+	notEqualsSymbol          symbol = "<>"
+	bangEqualsSymbol         symbol = "!="
+	lessThanSymbol           symbol = "<"
+	lessThanOrEqualSymbol    symbol = "<="
+	greaterThanSymbol        symbol = ">"
+	greaterThanOrEqualSymbol symbol = ">="
+	plusSymbol               symbol = "+"
+	minusSymbol              symbol = "-"
+	slashSymbol              symbol = "/"
+	percentSymbol            symbol = "%"
+	dotSymbol                symbol = "."
 )
 
 func validSymbols() []string {
@@ -69,6 +246,17 @@ func validSymbols() []string {
 		rightParenSymbol,
 		concatSymbol,
 		equalsSymbol,
+		notEqualsSymbol,
+		bangEqualsSymbol,
+		lessThanSymbol,
+		lessThanOrEqualSymbol,
+		greaterThanSymbol,
+		greaterThanOrEqualSymbol,
+		plusSymbol,
+		minusSymbol,
+		slashSymbol,
+		percentSymbol,
+		dotSymbol,
 	}
 
 	var options []string
@@ -104,6 +292,11 @@ func (t *token) equals(other *token) bool {
 	return t.value == other.value && t.kind == other.kind
 }
 
+func (t *token) matchesKeyword(k keyword) bool {
+	kw := tokenFromKeyword(k)
+	return t.equals(&kw)
+}
+
 type lexer func(string, cursor) (*token, cursor, bool)
 
 func lex(source string) ([]*token, error) {
@@ -250,7 +443,8 @@ func lexCharacterDelimited(source string, ic cursor, delimiter byte) (*token, cu
 	for ; cur.pointer < uint(len(source)); cur.pointer++ {
 		c := source[cur.pointer]
 		if c == delimiter {
-			// SQL escapes are via double characters, not backslash
+			// SQL escapes are via double characters, not backslash, and
+			// the value keeps one of the two.
 			if cur.pointer+1 >= uint(len(source)) || source[cur.pointer+1] != delimiter {
 				cur.pointer++
 				cur.loc.col++
@@ -262,7 +456,6 @@ func lexCharacterDelimited(source string, ic cursor, delimiter byte) (*token, cu
 					cur,
 					true
 			} else {
-				value = append(value, delimiter)
 				cur.pointer++
 				cur.loc.col++
 			}
@@ -304,6 +497,11 @@ func lexSymbol(source string, ic cursor) (*token, cursor, bool) {
 		return nil, ic, false
 	}
 
+	// A period followed by a digit starts a number like .5
+	if match == string(dotSymbol) && ic.pointer+1 < uint(len(source)) && isNumeric(source[ic.pointer+1]) {
+		return nil, ic, false
+	}
+
 	cur.pointer = ic.pointer + uint(len(match))
 	cur.loc.col = ic.loc.col + uint(len(match))
 
@@ -323,6 +521,15 @@ func lexKeyword(source string, ic cursor) (*token, cursor, bool) {
 		return nil, ic, false
 	}
 
+	// A keyword followed by more identifier characters is the prefix of an
+	// identifier, like the "in" of "index" or the "not" of "notes".
+	if end := ic.pointer + uint(len(match)); end < uint(len(source)) {
+		c := source[end]
+		if isAlphabetical(c) || isNumeric(c) || c == '$' || c == '_' {
+			return nil, ic, false
+		}
+	}
+
 	cur.pointer = ic.pointer + uint(len(match))
 	cur.loc.col = ic.loc.col + uint(len(match))
 
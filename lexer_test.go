@@ -138,6 +138,30 @@ func TestTokenLexKeyword(t *testing.T) {
 			isValidKeyword: false,
 			value:          "flubbrety",
 		},
+		{
+			isValidKeyword: false,
+			value:          "index",
+		},
+		{
+			isValidKeyword: true,
+			value:          "not ",
+		},
+		{
+			isValidKeyword: false,
+			value:          "level",
+		},
+		{
+			isValidKeyword: false,
+			value:          "release",
+		},
+		{
+			isValidKeyword: false,
+			value:          "BEGIN",
+		},
+		{
+			isValidKeyword: false,
+			value:          "serial",
+		},
 	}
 
 	for _, test := range tests {
@@ -154,14 +178,17 @@ func TestTokenLexString(t *testing.T) {
 	tests := []struct {
 		isValidString bool
 		value         string
+		expected      string
 	}{
-		{isValidString: true, value: "'abc'"},
-		{isValidString: true, value: "'ab c'"},
-		{isValidString: true, value: "'a b'"},
-		{isValidString: true, value: "'b'"},
-		{isValidString: true, value: "'a '' b'"},
+		{isValidString: true, value: "'abc'", expected: "abc"},
+		{isValidString: true, value: "'ab c'", expected: "ab c"},
+		{isValidString: true, value: "'a b'", expected: "a b"},
+		{isValidString: true, value: "'b'", expected: "b"},
+		{isValidString: true, value: "'a '' b'", expected: "a ' b"},
+		{isValidString: true, value: "''''''", expected: "''"},
 		{isValidString: false, value: "a"},
 		{isValidString: false, value: "'"},
+		{isValidString: false, value: "'a''"},
 		{isValidString: false, value: ""},
 		{isValidString: false, value: " 'foo'"},
 	}
@@ -170,8 +197,7 @@ func TestTokenLexString(t *testing.T) {
 		tok, _, ok := lexString(test.value, cursor{})
 		assert.Equal(t, test.isValidString, ok, test.value)
 		if ok {
-			test.value = strings.TrimSpace(test.value)
-			assert.Equal(t, test.value[1:len(test.value)-1], tok.value, test.value)
+			assert.Equal(t, test.expected, tok.value, test.value)
 		}
 	}
 }
@@ -189,6 +215,22 @@ func TestTokenLexSymbol(t *testing.T) {
 			isValidSymbol: true,
 			value:         "||",
 		},
+		{
+			isValidSymbol: true,
+			value:         "<=",
+		},
+		{
+			isValidSymbol: true,
+			value:         "<>",
+		},
+		{
+			isValidSymbol: true,
+			value:         ".",
+		},
+		{
+			isValidSymbol: false,
+			value:         ".5",
+		},
 	}
 
 	for _, test := range tests {
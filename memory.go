@@ -4,171 +4,1732 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
 )
 
+// MemoryCell is a value in its fixed-width big endian encoding: 4 bytes for
+// an int, 8 for the bits of a float and 1 for a bool. Text is stored as is.
 type MemoryCell []byte
 
 func (mc MemoryCell) AsInt() int32 {
-	var i int32
-	err := binary.Read(bytes.NewBuffer(mc), binary.BigEndian, &i)
-	if err != nil {
-		panic(err)
-	}
+	return int32(binary.BigEndian.Uint32(mc))
+}
 
-	return i
+func (mc MemoryCell) AsFloat() float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(mc))
 }
 
 func (mc MemoryCell) AsText() string {
 	return string(mc)
 }
 
+func (mc MemoryCell) AsBool() bool {
+	return len(mc) > 0 && mc[0] != 0
+}
+
+// IsNull reports whether the cell is SQL NULL, which is stored as a nil
+// MemoryCell.
+func (mc MemoryCell) IsNull() bool {
+	return mc == nil
+}
+
+var (
+	trueMemoryCell  = MemoryCell{1}
+	falseMemoryCell = MemoryCell{0}
+)
+
+func intToCell(i int32) MemoryCell {
+	cell := make(MemoryCell, 4)
+	binary.BigEndian.PutUint32(cell, uint32(i))
+	return cell
+}
+
+func floatToCell(f float64) MemoryCell {
+	cell := make(MemoryCell, 8)
+	binary.BigEndian.PutUint64(cell, math.Float64bits(f))
+	return cell
+}
+
+func boolToCell(b bool) MemoryCell {
+	if b {
+		return trueMemoryCell
+	}
+	return falseMemoryCell
+}
+
 type table struct {
+	name        string
 	columns     []string
 	columnTypes []ColumnType
-	rows        [][]MemoryCell
+	// notNull marks the columns that reject NULL, including primary key
+	// columns.
+	notNull     []bool
+	uniques     []*uniqueConstraint
+	foreignKeys []*foreignKey
+	checks      []*checkConstraint
+	indexes     []*index
+	// defaults holds the DEFAULT expression of each column, or nil.
+	defaults []*expression
+	// heap holds the rows of a heap table with their versions, and columnar
+	// the columns of a columnar one. paged holds the rows of the table in
+	// the file of a DiskBackend, in place of heap.
+	heap     *rowHeap
+	columnar *columnStore
+	paged    *rowTree
+	// versions holds the version of each row of a columnar table.
+	versions []rowVersion
+	// stats holds the statistics ANALYZE last collected, or nil.
+	stats *tableStats
 }
 
+func (t *table) columnIndex(name string) (int, bool) {
+	for i, col := range t.columns {
+		if col == name {
+			return i, true
+		}
+	}
+
+	// The column may be qualified with the table's name.
+	dot := strings.Index(name, ".")
+	if dot >= 0 && name[:dot] == t.name {
+		return t.columnIndex(name[dot+1:])
+	}
+
+	// The columns of a join are qualified with the names of their tables,
+	// and an unqualified name picks the only one it matches.
+	if dot < 0 {
+		found := -1
+		for i, col := range t.columns {
+			if strings.HasSuffix(col, "."+name) {
+				if found >= 0 {
+					return -1, false
+				}
+				found = i
+			}
+		}
+		if found >= 0 {
+			return found, true
+		}
+	}
+	return -1, false
+}
+
+// MemoryBackend is a session of an in-memory database. Sessions run their
+// statements one at a time, each in a transaction of its own unless BEGIN
+// started one, and the sessions of a database run theirs concurrently.
 type MemoryBackend struct {
+	// mu makes the statements of the session run one at a time.
+	mu     sync.Mutex
 	tables map[string]*table
+	// functions holds the user-defined scalar and aggregate functions by name.
+	functions map[string][]*function
+	sequences map[string]*sequence
+	// db is the database the session belongs to, or nil for the backend
+	// of a DiskBackend, which changes tables and sequences in place.
+	db *database
+	// tx is the transaction of a workspace, or of a DiskBackend's backend,
+	// in progress, and work the workspace of the transaction BEGIN started.
+	tx   *transaction
+	work *MemoryBackend
+	// currvals holds the value currval returns for each sequence nextval,
+	// or setval with is_called true, was called on in the session, by
+	// name. Workspaces share the map of their session.
+	currvals map[string]int64
+	// rowIDs numbers the rows written without a database, for rowVersion.
+	rowIDs uint64
 }
 
 // Creates a MemoryBackend that stores the table definitions for the database.
 func NewMemoryBackend() *MemoryBackend {
-	return &MemoryBackend{tables: map[string]*table{}}
+	mb := newMemoryBackend()
+	mb.db = &database{versions: []*version{{tables: mb.tables, sequences: mb.sequences}}}
+	return mb
+}
+
+func newMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		tables:    map[string]*table{},
+		functions: map[string][]*function{},
+		sequences: map[string]*sequence{},
+		currvals:  map[string]int64{},
+	}
 }
 
 // CreateTable adds the table to MemoryBackend based on the information in CreateTableStatement
 func (mb *MemoryBackend) CreateTable(crt *CreateTableStatement) error {
-	t := table{}
-	mb.tables[crt.name.value] = &t
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.createTable(crt)
+	})
+}
 
-	if crt.cols == nil {
-		return nil
+func (mb *MemoryBackend) createTable(crt *CreateTableStatement) error {
+	switch crt.using.value {
+	case "", "heap", "columnar":
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidLayout, crt.using.value)
 	}
 
-	for _, col := range *crt.cols {
-		t.columns = append(t.columns, col.name.value)
+	if crt.query != nil {
+		return mb.createTableAs(crt)
+	}
 
-		var dt ColumnType
-		switch col.datatype.value {
-		case "int":
-			dt = IntType
-		case "text":
-			dt = TextType
-		default:
-			return ErrInvalidDatatype
+	t := table{name: crt.name.value}
+	sequences := []*sequence{}
+
+	if crt.cols != nil {
+		for _, col := range *crt.cols {
+			s, err := mb.defineColumn(&t, col)
+			if err != nil {
+				return err
+			}
+			if s != nil {
+				sequences = append(sequences, s)
+			}
+		}
+
+		for i, col := range *crt.cols {
+			if err := mb.addColumnConstraints(&t, i, col); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, c := range crt.constraints {
+		if c.kind == ForeignKeyConstraint {
+			continue
+		}
+
+		if c.kind == CheckConstraint {
+			if err := mb.addCheck(&t, c); err != nil {
+				return err
+			}
+			continue
+		}
+
+		columns := []int{}
+		for _, col := range c.columns {
+			i, ok := t.columnIndex(col.value)
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+			}
+			columns = append(columns, i)
+		}
+
+		if err := t.addUnique(c.name.value, c.kind, columns); err != nil {
+			return err
+		}
+	}
+
+	// Foreign keys come last, since they can reference the table's own
+	// unique constraints.
+	foreignKeys := []*tableConstraint{}
+	if crt.cols != nil {
+		for _, col := range *crt.cols {
+			if col.references != nil {
+				foreignKeys = append(foreignKeys, col.references)
+			}
+		}
+	}
+	for _, c := range crt.constraints {
+		if c.kind == ForeignKeyConstraint {
+			foreignKeys = append(foreignKeys, c)
+		}
+	}
+	for _, c := range foreignKeys {
+		if err := mb.addForeignKey(&t, c); err != nil {
+			return err
 		}
-		t.columnTypes = append(t.columnTypes, dt)
 	}
+
+	if crt.using.value == "columnar" {
+		t.columnar = newColumnStore(t.columnTypes, nil)
+	}
+
+	for _, s := range sequences {
+		mb.sequences[s.name] = s
+	}
+	mb.tables[crt.name.value] = &t
 	return nil
 }
 
-// Insert values into the in-memory table.
-func (mb *MemoryBackend) Insert(inst *InsertStatement) error {
-	table, ok := mb.tables[inst.Table.value]
-	if !ok {
-		return ErrTableDoesNotExist
+// columnType returns the type named by a column definition.
+func columnType(datatype string) (ColumnType, error) {
+	switch datatype {
+	case "int":
+		return IntType, nil
+	case "text":
+		return TextType, nil
+	case "float":
+		return FloatType, nil
+	case "boolean":
+		return BoolType, nil
 	}
+	return NullType, ErrInvalidDatatype
+}
 
-	if inst.Values == nil {
-		return nil
-	}
+// defineColumn appends the column col to t. A SERIAL column also gets a
+// new sequence, which is returned for the caller to register.
+func (mb *MemoryBackend) defineColumn(t *table, col *columnDefinition) (*sequence, error) {
+	t.columns = append(t.columns, col.name.value)
+	t.notNull = append(t.notNull, col.notNull)
+	t.defaults = append(t.defaults, col.defaultValue)
 
-	row := []MemoryCell{}
+	var s *sequence
+	var dt ColumnType
+	switch col.datatype.value {
+	case "serial", "bigserial":
+		// A SERIAL column is a NOT NULL int taking its default from a
+		// sequence of its own. Ints are 32-bit, so BIGSERIAL is the same
+		// as SERIAL here.
+		if col.defaultValue != nil {
+			return nil, fmt.Errorf("%w: serial column %s can't have a default", ErrInvalidConstraint, col.name.value)
+		}
+
+		name := t.name + "_" + col.name.value + "_seq"
+		if _, ok := mb.sequences[name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrSequenceExists, name)
+		}
+		s, _ = newSequence(name, 1, nil)
 
-	if len(*inst.Values) != len(table.columns) {
-		return ErrMissingValues
+		dt = IntType
+		t.notNull[len(t.notNull)-1] = true
+		t.defaults[len(t.defaults)-1] = nextvalCall(name)
+	default:
+		var err error
+		if dt, err = columnType(col.datatype.value); err != nil {
+			return nil, err
+		}
 	}
+	t.columnTypes = append(t.columnTypes, dt)
+	return s, nil
+}
 
-	for _, value := range *inst.Values {
-		if value.kind != literalKind {
-			fmt.Println("Skipping non-literal.")
-			continue
+// addColumnConstraints checks the default of col, the i-th column of t,
+// and adds its CHECK, PRIMARY KEY and UNIQUE constraints to t.
+func (mb *MemoryBackend) addColumnConstraints(t *table, i int, col *columnDefinition) error {
+	if col.defaultValue != nil {
+		// Defaults can't refer to columns.
+		typ, err := mb.expressionType(&table{}, col.defaultValue)
+		if err != nil {
+			return err
+		}
+		if !assignable(typ, t.columnTypes[i]) {
+			return fmt.Errorf("%w: expected %s for default of column %s, got %s", ErrMismatchedType, t.columnTypes[i], t.columns[i], typ)
+		}
+	}
+
+	for _, c := range col.checks {
+		if err := mb.addCheck(t, c); err != nil {
+			return err
 		}
-		row = append(row, mb.tokenToCell(value.literal))
 	}
 
-	table.rows = append(table.rows, row)
+	if col.primaryKey {
+		if err := t.addUnique("", PrimaryKeyConstraint, []int{i}); err != nil {
+			return err
+		}
+	}
+	if col.unique {
+		if err := t.addUnique("", UniqueConstraint, []int{i}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (mb *MemoryBackend) tokenToCell(t *token) MemoryCell {
-	if t.kind == numericKind {
-		buf := new(bytes.Buffer)
-		i, err := strconv.Atoi(t.value)
-		if err != nil {
-			panic(err)
+// createTableAs creates a table holding the results of the query of crt,
+// with a column named and typed after each result column.
+func (mb *MemoryBackend) createTableAs(crt *CreateTableStatement) error {
+	results, err := mb.selectRows(crt.query)
+	if err != nil {
+		return err
+	}
+
+	t := table{name: crt.name.value}
+	for _, col := range results.Columns {
+		if _, ok := t.columnIndex(col.Name); ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateColumn, col.Name)
 		}
 
-		err = binary.Write(buf, binary.BigEndian, int32(i))
-		if err != nil {
-			panic(err)
+		// Columns of bare NULLs become text, like unknown-typed columns
+		// in Postgres.
+		typ := col.Type
+		if typ == NullType {
+			typ = TextType
 		}
 
-		return MemoryCell(buf.Bytes())
+		t.columns = append(t.columns, col.Name)
+		t.columnTypes = append(t.columnTypes, typ)
+		t.notNull = append(t.notNull, false)
+		t.defaults = append(t.defaults, nil)
+	}
+	if crt.using.value == "columnar" {
+		t.columnar = newColumnStore(t.columnTypes, nil)
 	}
 
-	if t.kind == stringKind {
-		return MemoryCell(t.value)
+	for _, result := range results.Rows {
+		row := []MemoryCell{}
+		for _, cell := range result {
+			row = append(row, cell.(MemoryCell))
+		}
+		t.appendRow(row, mb.stamp(0))
 	}
 
+	mb.tables[t.name] = &t
 	return nil
 }
 
-// Execute a SELECT against the tables in the MemoryBackend.
-func (mb *MemoryBackend) Select(slct *SelectStatement) (*Results, error) {
-	table, ok := mb.tables[slct.from.value]
+// Insert values into the in-memory table. The rows of an INSERT ... SELECT
+// are all checked before any of them is added.
+func (mb *MemoryBackend) Insert(inst *InsertStatement) (*Results, error) {
+	var result *Results
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.insert(inst)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) insert(inst *InsertStatement) (*Results, error) {
+	t, ok := mb.tables[inst.Table.value]
 	if !ok {
 		return nil, ErrTableDoesNotExist
 	}
 
-	results := [][]Cell{}
-	columns := []struct {
-		Type ColumnType
-		Name string
-	}{}
+	if err := mb.checkItems(t, inst.Returning); err != nil {
+		return nil, err
+	}
 
-	for i, row := range table.rows {
-		result := []Cell{}
-		isFirstRow := i == 0
+	arbiter := -1
+	if c := inst.OnConflict; c != nil {
+		var err error
+		arbiter, err = mb.checkOnConflict(t, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if inst.Values == nil && inst.Select == nil {
+		return nil, nil
+	}
+
+	// Work out which column each value goes to.
+	columns := []int{}
+	given := map[int]bool{}
+	if inst.Columns == nil {
+		for i := range t.columns {
+			columns = append(columns, i)
+			given[i] = true
+		}
+	} else {
+		for _, col := range inst.Columns {
+			i, ok := t.columnIndex(col.value)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+			}
+			if given[i] {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateColumn, col.value)
+			}
+			given[i] = true
+			columns = append(columns, i)
+		}
+	}
+
+	values, types, err := mb.insertValues(inst)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(types) != len(columns) {
+		return nil, ErrMissingValues
+	}
+
+	for j, typ := range types {
+		i := columns[j]
+		if !assignable(typ, t.columnTypes[i]) {
+			return nil, fmt.Errorf("%w: expected %s for column %s, got %s", ErrMismatchedType, t.columnTypes[i], t.columns[i], typ)
+		}
+	}
+
+	ws := &writeSet{}
+	// inserted holds the keys of the rows inserted so far, for ON CONFLICT
+	// to see them.
+	inserted := make([]map[string]bool, len(t.uniques))
+	for i := range inserted {
+		inserted[i] = map[string]bool{}
+	}
 
-		for _, exp := range slct.item {
-			if exp.kind != literalKind {
-				// Unsupported, doesn't exist, ignore.
-				fmt.Println("Skipping non-literal expression.")
+	returned := [][]MemoryCell{}
+	for _, value := range values {
+		// Omitted columns get their default, or NULL without one.
+		row := make([]MemoryCell, len(t.columns))
+		for i, exp := range t.defaults {
+			if exp == nil || given[i] {
 				continue
 			}
 
-			lit := exp.literal
-			if lit.kind == identifierKind {
-				found := false
-				for i, tableCol := range table.columns {
-					if tableCol == lit.value {
-						if isFirstRow {
-							columns = append(columns, struct {
-								Type ColumnType
-								Name string
-							}{
-								Type: table.columnTypes[i],
-								Name: lit.value,
-							})
-						}
-
-						result = append(result, row[i])
-						found = true
-						break
-					}
-				}
+			cell, typ, err := mb.evaluateCell(&table{}, nil, exp)
+			if err != nil {
+				return nil, err
+			}
+			row[i] = coerceCell(cell, typ, t.columnTypes[i])
+		}
 
-				if !found {
-					return nil, ErrColumnDoesNotExist
-				}
+		for j, cell := range value {
+			i := columns[j]
+			row[i] = coerceCell(cell, types[j], t.columnTypes[i])
+		}
+
+		if err := mb.checkRow(t, row); err != nil {
+			return nil, err
+		}
+
+		if inst.OnConflict != nil {
+			handled, updated, err := mb.onConflict(ws, t, inst.OnConflict, arbiter, row, inserted)
+			if err != nil {
+				return nil, err
+			}
+			if updated != nil {
+				returned = append(returned, updated)
+			}
+			if handled {
 				continue
 			}
-			return nil, ErrColumnDoesNotExist
 		}
-		results = append(results, result)
+
+		for i, u := range t.uniques {
+			if key, ok := u.key(row); ok {
+				inserted[i][key] = true
+			}
+		}
+		ws.insert(t, mb.stamp(0), row)
+		returned = append(returned, row)
 	}
-	return &Results{Columns: columns, Rows: results}, nil
+
+	results, err := mb.returning(t, returned, inst.Returning)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mb.commit(ws); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// insertValues evaluates the VALUES or SELECT of inst into the rows to
+// insert and the type of each of their cells.
+func (mb *MemoryBackend) insertValues(inst *InsertStatement) ([][]MemoryCell, []ColumnType, error) {
+	if inst.Select != nil {
+		results, err := mb.selectRows(inst.Select)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		types := []ColumnType{}
+		for _, col := range results.Columns {
+			types = append(types, col.Type)
+		}
+
+		rows := [][]MemoryCell{}
+		for _, result := range results.Rows {
+			row := []MemoryCell{}
+			for _, cell := range result {
+				row = append(row, cell.(MemoryCell))
+			}
+			rows = append(rows, row)
+		}
+		return rows, types, nil
+	}
+
+	row := []MemoryCell{}
+	types := []ColumnType{}
+	for _, value := range *inst.Values {
+		cell, typ, err := mb.evaluateCell(&table{}, nil, value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		row = append(row, cell)
+		types = append(types, typ)
+	}
+	return [][]MemoryCell{row}, types, nil
+}
+
+// upsertTable is the table that the SET and WHERE of ON CONFLICT DO UPDATE
+// see: the columns of t, followed by those of the row proposed for
+// insertion as excluded.column.
+func upsertTable(t *table) *table {
+	upsert := &table{name: t.name}
+	upsert.columns = append(upsert.columns, t.columns...)
+	upsert.columnTypes = append(upsert.columnTypes, t.columnTypes...)
+	for i, col := range t.columns {
+		upsert.columns = append(upsert.columns, "excluded."+col)
+		upsert.columnTypes = append(upsert.columnTypes, t.columnTypes[i])
+	}
+	return upsert
+}
+
+// checkOnConflict type checks an ON CONFLICT clause and returns the index of
+// the unique constraint it handles, or -1 for any.
+func (mb *MemoryBackend) checkOnConflict(t *table, c *onConflictClause) (int, error) {
+	arbiter := -1
+	if c.columns != nil {
+		columns := []int{}
+		for _, col := range c.columns {
+			i, ok := t.columnIndex(col.value)
+			if !ok {
+				return -1, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.value)
+			}
+			columns = append(columns, i)
+		}
+
+		var ok bool
+		arbiter, ok = t.uniqueOn(columns)
+		if !ok {
+			return -1, fmt.Errorf("%w: no unique constraint matches the ON CONFLICT columns", ErrInvalidConstraint)
+		}
+	}
+
+	if c.doUpdate {
+		upsert := upsertTable(t)
+		if _, _, err := mb.checkAssignments(t, upsert, c.set); err != nil {
+			return -1, err
+		}
+		if err := mb.checkCondition(upsert, c.where); err != nil {
+			return -1, err
+		}
+	}
+
+	return arbiter, nil
+}
+
+// onConflict carries out the ON CONFLICT clause c for row. It reports
+// whether row conflicted, in which case it must not be inserted, and returns
+// the existing row's new version when DO UPDATE changed it. inserted holds
+// the keys of the rows the statement inserted before row.
+func (mb *MemoryBackend) onConflict(ws *writeSet, t *table, c *onConflictClause, arbiter int, row []MemoryCell, inserted []map[string]bool) (bool, []MemoryCell, error) {
+	for i, u := range t.uniques {
+		if arbiter >= 0 && i != arbiter {
+			continue
+		}
+
+		cells, ok := keyCells(row, u.columns)
+		if !ok {
+			continue
+		}
+		key := encodeKey(cells)
+		existing, found := t.uniqueIndex(u).find(cells)
+		if !found && !inserted[i][key] {
+			continue
+		}
+
+		if !c.doUpdate {
+			return true, nil, nil
+		}
+		if inserted[i][key] {
+			return false, nil, ErrCardinalityViolation
+		}
+
+		changes := ws.changesFor(t)
+		if _, ok := changes.updated[existing]; ok {
+			return false, nil, ErrCardinalityViolation
+		}
+
+		existingRow := t.lookup(existing)
+		upsert := upsertTable(t)
+		upsertRow := append(append([]MemoryCell{}, existingRow...), row...)
+
+		matched, err := mb.matches(upsert, upsertRow, c.where)
+		if err != nil {
+			return false, nil, err
+		}
+		if !matched {
+			return true, nil, nil
+		}
+
+		columns, types, err := mb.checkAssignments(t, upsert, c.set)
+		if err != nil {
+			return false, nil, err
+		}
+
+		updated, err := mb.assign(t, existingRow, upsert, upsertRow, c.set, columns, types)
+		if err != nil {
+			return false, nil, err
+		}
+
+		ws.set(t, existing, updated)
+		return true, updated, nil
+	}
+
+	return false, nil, nil
+}
+
+// checkAssignments type checks a SET list whose values are evaluated against
+// source, returning the column of t each one assigns and the type of its
+// value.
+func (mb *MemoryBackend) checkAssignments(t *table, source *table, set []*assignment) ([]int, []ColumnType, error) {
+	columns := []int{}
+	types := []ColumnType{}
+	for _, a := range set {
+		i, ok := t.columnIndex(a.column.value)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, a.column.value)
+		}
+
+		typ, err := mb.expressionType(source, a.value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !assignable(typ, t.columnTypes[i]) {
+			return nil, nil, fmt.Errorf("%w: expected %s for column %s, got %s", ErrMismatchedType, t.columnTypes[i], t.columns[i], typ)
+		}
+
+		columns = append(columns, i)
+		types = append(types, typ)
+	}
+	return columns, types, nil
+}
+
+// assign returns a copy of row with a SET list checked by checkAssignments
+// applied. Every value sees sourceRow, which holds the row as it was before
+// the write.
+func (mb *MemoryBackend) assign(t *table, row []MemoryCell, source *table, sourceRow []MemoryCell, set []*assignment, columns []int, types []ColumnType) ([]MemoryCell, error) {
+	updated := append([]MemoryCell{}, row...)
+	for j, a := range set {
+		cell, _, err := mb.evaluateCell(source, sourceRow, a.value)
+		if err != nil {
+			return nil, err
+		}
+		updated[columns[j]] = coerceCell(cell, types[j], t.columnTypes[columns[j]])
+	}
+	return updated, nil
+}
+
+// Update rewrites the rows matching the WHERE clause. The new rows and any
+// changes cascaded through foreign keys are checked against every constraint
+// before they replace the old rows, so a failed UPDATE changes nothing.
+func (mb *MemoryBackend) Update(upd *UpdateStatement) (*Results, error) {
+	var result *Results
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.update(upd)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) update(upd *UpdateStatement) (*Results, error) {
+	t, ok := mb.tables[upd.table.value]
+	if !ok {
+		return nil, ErrTableDoesNotExist
+	}
+
+	columns, types, err := mb.checkAssignments(t, t, upd.set)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mb.checkCondition(t, upd.where); err != nil {
+		return nil, err
+	}
+
+	if err := mb.checkItems(t, upd.returning); err != nil {
+		return nil, err
+	}
+
+	ws := &writeSet{}
+	updatedRows := [][]MemoryCell{}
+	err = mb.scan(t, upd.where, func(id uint64, row []MemoryCell) error {
+		updated, err := mb.assign(t, row, t, row, upd.set, columns, types)
+		if err != nil {
+			return err
+		}
+
+		ws.set(t, id, updated)
+		updatedRows = append(updatedRows, updated)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := mb.returning(t, updatedRows, upd.returning)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mb.commit(ws); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes the rows matching the WHERE clause, along with the changes
+// that foreign keys referencing them cascade to.
+func (mb *MemoryBackend) Delete(del *DeleteStatement) (*Results, error) {
+	var result *Results
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.delete(del)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) delete(del *DeleteStatement) (*Results, error) {
+	t, ok := mb.tables[del.table.value]
+	if !ok {
+		return nil, ErrTableDoesNotExist
+	}
+
+	if err := mb.checkCondition(t, del.where); err != nil {
+		return nil, err
+	}
+
+	if err := mb.checkItems(t, del.returning); err != nil {
+		return nil, err
+	}
+
+	ws := &writeSet{}
+	deletedRows := [][]MemoryCell{}
+	err := mb.scan(t, del.where, func(id uint64, row []MemoryCell) error {
+		ws.set(t, id, nil)
+		deletedRows = append(deletedRows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := mb.returning(t, deletedRows, del.returning)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mb.commit(ws); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// tokenToCell returns the value of a literal token. Numeric literals that
+// don't fit their type were rejected when type checking, so come out NULL.
+func (mb *MemoryBackend) tokenToCell(t *token) MemoryCell {
+	if t.kind == numericKind {
+		cell, _ := numericLiteral(t.value)
+		return cell
+	}
+
+	if t.kind == stringKind {
+		return MemoryCell(t.value)
+	}
+
+	if t.matchesKeyword(trueKeyword) {
+		return trueMemoryCell
+	}
+
+	if t.matchesKeyword(falseKeyword) {
+		return falseMemoryCell
+	}
+
+	return nil
+}
+
+// Execute a SELECT against the tables in the MemoryBackend.
+func (mb *MemoryBackend) Select(slct *SelectStatement) (*Results, error) {
+	var result *Results
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.selectRows(slct)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) selectRows(slct *SelectStatement) (*Results, error) {
+	rows, err := mb.query(slct)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &Results{Columns: rows.Columns, Rows: [][]Cell{}}
+	for rows.Next() {
+		results.Rows = append(results.Rows, rows.Row())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Query plans a SELECT and returns a cursor that runs it as its rows are
+// read. Outside of a transaction, the cursor reads the database as of the
+// query. Inside one, writes made while the cursor is open may or may not
+// show up in the rows it hasn't read yet.
+func (mb *MemoryBackend) Query(slct *SelectStatement) (*Rows, error) {
+	var result *Rows
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.query(slct)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) query(slct *SelectStatement) (*Rows, error) {
+	plan, err := mb.planSelect(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := run(mb, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := plan.schema()
+	rows := &Rows{next: func() ([]Cell, bool, error) {
+		row, ok, err := it()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		return memoryCellsToCells(row), true, nil
+	}}
+	for i, name := range schema.columns {
+		rows.Columns = append(rows.Columns, struct {
+			Type ColumnType
+			Name string
+		}{
+			Type: schema.columnTypes[i],
+			Name: name,
+		})
+	}
+	return rows, nil
+}
+
+// columnName is the name of the result column of item.
+func columnName(item *expression) string {
+	switch {
+	case item.kind == literalKind && item.literal.kind == identifierKind:
+		// Qualified names are named after just the column.
+		name := item.literal.value
+		return name[strings.LastIndex(name, ".")+1:]
+	case item.kind == callKind:
+		return item.call.name.value
+	}
+	return "?column?"
+}
+
+// project evaluates items over rows into result columns with the given
+// names.
+func (mb *MemoryBackend) project(t *table, rows [][]MemoryCell, items []*expression, names []string) (*Results, error) {
+	results := [][]Cell{}
+	columns := []struct {
+		Type ColumnType
+		Name string
+	}{}
+
+	for i, exp := range items {
+		typ, err := mb.expressionType(t, exp)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, struct {
+			Type ColumnType
+			Name string
+		}{
+			Type: typ,
+			Name: names[i],
+		})
+	}
+
+	for _, row := range rows {
+		result := []Cell{}
+		for _, exp := range items {
+			cell, _, err := mb.evaluateCell(t, row, exp)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, cell)
+		}
+		results = append(results, result)
+	}
+	return &Results{Columns: columns, Rows: results}, nil
+}
+
+// checkItems type checks the items of a RETURNING clause.
+func (mb *MemoryBackend) checkItems(t *table, items []*expression) error {
+	for _, exp := range items {
+		if _, err := mb.expressionType(t, exp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returning evaluates the RETURNING clause of a write over the rows it
+// inserted, updated or deleted. Without the clause it returns nil Results.
+func (mb *MemoryBackend) returning(t *table, rows [][]MemoryCell, items []*expression) (*Results, error) {
+	if items == nil {
+		return nil, nil
+	}
+	names := []string{}
+	for _, item := range items {
+		names = append(names, columnName(item))
+	}
+	return mb.project(t, rows, items, names)
+}
+
+// checkCondition type checks a WHERE clause, which may be nil.
+func (mb *MemoryBackend) checkCondition(t *table, where *expression) error {
+	if where == nil {
+		return nil
+	}
+
+	typ, err := mb.expressionType(t, where)
+	if err != nil {
+		return err
+	}
+	if typ != BoolType && typ != NullType {
+		return ErrInvalidCondition
+	}
+	return nil
+}
+
+// matches reports whether row satisfies a WHERE clause, which may be nil.
+// NULL filters the row out just like false.
+func (mb *MemoryBackend) matches(t *table, row []MemoryCell, where *expression) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+
+	cell, _, err := mb.evaluateCell(t, row, where)
+	if err != nil {
+		return false, err
+	}
+	return cell.AsBool(), nil
+}
+
+// numericLiteralType is FloatType for numbers written with a decimal point
+// or exponent and IntType otherwise.
+func numericLiteralType(value string) ColumnType {
+	if strings.ContainsAny(value, ".e") {
+		return FloatType
+	}
+	return IntType
+}
+
+// numericLiteral parses a numeric literal, failing when its value is out of
+// the range of its type.
+func numericLiteral(value string) (MemoryCell, error) {
+	if numericLiteralType(value) == FloatType {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrFloatOutOfRange, value)
+		}
+		return floatToCell(f), nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIntegerOutOfRange, value)
+	}
+	return intToCell(int32(i)), nil
+}
+
+// commonType is the type that values of every one of types can be compared
+// with or stored as. NULL literals fit anywhere and ints widen to floats.
+func commonType(types ...ColumnType) (ColumnType, bool) {
+	common := NullType
+	for _, typ := range types {
+		if typ == NullType || typ == common {
+			continue
+		}
+
+		switch {
+		case common == NullType:
+			common = typ
+		case common == IntType && typ == FloatType:
+			common = FloatType
+		case common == FloatType && typ == IntType:
+		default:
+			return common, false
+		}
+	}
+	return common, true
+}
+
+// assignable reports whether a value of type from can be stored in a column
+// of type to.
+func assignable(from, to ColumnType) bool {
+	common, ok := commonType(from, to)
+	return ok && (common == to || from == NullType)
+}
+
+// coerceCell converts a cell of type from to the encoding of type to. Only
+// the int to float widening changes the encoding.
+func coerceCell(cell MemoryCell, from, to ColumnType) MemoryCell {
+	if cell != nil && from == IntType && to == FloatType {
+		return floatToCell(float64(cell.AsInt()))
+	}
+	return cell
+}
+
+// cellToText renders a non-NULL cell of type typ as text.
+func cellToText(cell MemoryCell, typ ColumnType) string {
+	switch typ {
+	case IntType:
+		return strconv.Itoa(int(cell.AsInt()))
+	case FloatType:
+		return strconv.FormatFloat(cell.AsFloat(), 'g', -1, 64)
+	case BoolType:
+		return strconv.FormatBool(cell.AsBool())
+	}
+	return cell.AsText()
+}
+
+func isArithmeticOperator(op token) bool {
+	if op.kind != symbolKind {
+		return false
+	}
+
+	switch symbol(op.value) {
+	case plusSymbol, minusSymbol, asteriskSymbol, slashSymbol, percentSymbol:
+		return true
+	}
+	return false
+}
+
+func isComparisonOperator(op token) bool {
+	if op.kind != symbolKind {
+		return false
+	}
+
+	switch symbol(op.value) {
+	case equalsSymbol, notEqualsSymbol, bangEqualsSymbol, lessThanSymbol,
+		lessThanOrEqualSymbol, greaterThanSymbol, greaterThanOrEqualSymbol:
+		return true
+	}
+	return false
+}
+
+// expressionType type checks exp against the columns of t and returns the
+// type of the values it evaluates to.
+func (mb *MemoryBackend) expressionType(t *table, exp *expression) (ColumnType, error) {
+	switch exp.kind {
+	case literalKind:
+		lit := exp.literal
+		switch lit.kind {
+		case identifierKind:
+			i, ok := t.columnIndex(lit.value)
+			if !ok {
+				return NullType, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, lit.value)
+			}
+			return t.columnTypes[i], nil
+		case numericKind:
+			if _, err := numericLiteral(lit.value); err != nil {
+				return NullType, err
+			}
+			return numericLiteralType(lit.value), nil
+		case stringKind:
+			return TextType, nil
+		case keywordKind:
+			switch keyword(lit.value) {
+			case trueKeyword, falseKeyword:
+				return BoolType, nil
+			case nullKeyword:
+				return NullType, nil
+			}
+		}
+		return NullType, ErrInvalidSelectItem
+	case unaryKind:
+		typ, err := mb.expressionType(t, exp.unary.operand)
+		if err != nil {
+			return NullType, err
+		}
+		if exp.unary.op.value == string(minusSymbol) {
+			if typ != IntType && typ != FloatType && typ != NullType {
+				return NullType, fmt.Errorf("%w: cannot negate %s", ErrInvalidOperands, typ)
+			}
+			return typ, nil
+		}
+		if _, ok := commonType(typ, BoolType); !ok {
+			return NullType, fmt.Errorf("%w: NOT expects boolean, got %s", ErrInvalidOperands, typ)
+		}
+		return BoolType, nil
+	case binaryKind:
+		a, err := mb.expressionType(t, exp.binary.a)
+		if err != nil {
+			return NullType, err
+		}
+		b, err := mb.expressionType(t, exp.binary.b)
+		if err != nil {
+			return NullType, err
+		}
+
+		op := exp.binary.op
+		switch {
+		case op.matchesKeyword(isKeyword):
+			if !exp.binary.b.literal.matchesKeyword(nullKeyword) {
+				if _, ok := commonType(a, BoolType); !ok {
+					return NullType, fmt.Errorf("%w: IS TRUE and IS FALSE expect boolean, got %s", ErrInvalidOperands, a)
+				}
+			}
+			return BoolType, nil
+		case op.matchesKeyword(andKeyword), op.matchesKeyword(orKeyword):
+			if _, ok := commonType(a, b, BoolType); !ok {
+				return NullType, fmt.Errorf("%w: %s expects boolean operands, got %s and %s", ErrInvalidOperands, strings.ToUpper(op.value), a, b)
+			}
+			return BoolType, nil
+		case op.matchesKeyword(likeKeyword), op.matchesKeyword(ilikeKeyword):
+			if _, ok := commonType(a, b, TextType); !ok {
+				return NullType, fmt.Errorf("%w: %s expects text operands, got %s and %s", ErrInvalidOperands, strings.ToUpper(op.value), a, b)
+			}
+			return BoolType, nil
+		case isComparisonOperator(op):
+			if _, ok := commonType(a, b); !ok {
+				return NullType, fmt.Errorf("%w: cannot compare %s with %s", ErrInvalidOperands, a, b)
+			}
+			return BoolType, nil
+		case op.value == string(concatSymbol):
+			// Non-text operands are converted to their text form, so only
+			// two non-text operands are ambiguous.
+			if a != TextType && b != TextType && a != NullType && b != NullType {
+				return NullType, fmt.Errorf("%w: || expects a text operand, got %s and %s", ErrInvalidOperands, a, b)
+			}
+			return TextType, nil
+		case isArithmeticOperator(op):
+			typ, ok := commonType(a, b)
+			if !ok || (typ != IntType && typ != FloatType && typ != NullType) {
+				return NullType, fmt.Errorf("%w: %s expects numeric operands, got %s and %s", ErrInvalidOperands, op.value, a, b)
+			}
+			return typ, nil
+		}
+		return NullType, fmt.Errorf("%w: unknown operator %s", ErrInvalidOperands, op.value)
+	case caseKind:
+		c := exp.caseExp
+
+		operandType := BoolType
+		if c.operand != nil {
+			typ, err := mb.expressionType(t, c.operand)
+			if err != nil {
+				return NullType, err
+			}
+			operandType = typ
+		}
+
+		resultTypes := []ColumnType{}
+		for _, when := range c.whens {
+			typ, err := mb.expressionType(t, when.condition)
+			if err != nil {
+				return NullType, err
+			}
+			if _, ok := commonType(operandType, typ); !ok {
+				if c.operand == nil {
+					return NullType, ErrInvalidCondition
+				}
+				return NullType, fmt.Errorf("%w: cannot compare %s with %s", ErrInvalidOperands, operandType, typ)
+			}
+
+			typ, err = mb.expressionType(t, when.result)
+			if err != nil {
+				return NullType, err
+			}
+			resultTypes = append(resultTypes, typ)
+		}
+
+		if c.elseResult != nil {
+			typ, err := mb.expressionType(t, c.elseResult)
+			if err != nil {
+				return NullType, err
+			}
+			resultTypes = append(resultTypes, typ)
+		}
+
+		typ, ok := commonType(resultTypes...)
+		if !ok {
+			return NullType, fmt.Errorf("%w: CASE results have different types", ErrInvalidOperands)
+		}
+		return typ, nil
+	case coalesceKind:
+		types := []ColumnType{}
+		for _, arg := range exp.coalesce.args {
+			typ, err := mb.expressionType(t, arg)
+			if err != nil {
+				return NullType, err
+			}
+			types = append(types, typ)
+		}
+
+		typ, ok := commonType(types...)
+		if !ok {
+			return NullType, fmt.Errorf("%w: COALESCE arguments have different types", ErrInvalidOperands)
+		}
+		return typ, nil
+	case nullifKind:
+		a, err := mb.expressionType(t, exp.nullif.a)
+		if err != nil {
+			return NullType, err
+		}
+		b, err := mb.expressionType(t, exp.nullif.b)
+		if err != nil {
+			return NullType, err
+		}
+		if _, ok := commonType(a, b); !ok {
+			return NullType, fmt.Errorf("%w: cannot compare %s with %s", ErrInvalidOperands, a, b)
+		}
+		return a, nil
+	case inKind:
+		types := []ColumnType{}
+		for _, e := range append([]*expression{exp.in.operand}, exp.in.list...) {
+			typ, err := mb.expressionType(t, e)
+			if err != nil {
+				return NullType, err
+			}
+			types = append(types, typ)
+		}
+		if _, ok := commonType(types...); !ok {
+			return NullType, fmt.Errorf("%w: IN list values must match the operand type", ErrInvalidOperands)
+		}
+		return BoolType, nil
+	case betweenKind:
+		types := []ColumnType{}
+		for _, e := range []*expression{exp.between.operand, exp.between.low, exp.between.high} {
+			typ, err := mb.expressionType(t, e)
+			if err != nil {
+				return NullType, err
+			}
+			types = append(types, typ)
+		}
+		if _, ok := commonType(types...); !ok {
+			return NullType, fmt.Errorf("%w: BETWEEN bounds must match the operand type", ErrInvalidOperands)
+		}
+		return BoolType, nil
+	case callKind:
+		fn, _, err := mb.resolveCall(t, exp.call)
+		if err != nil {
+			return NullType, err
+		}
+
+		// Aggregates are only valid in select items, which planGrouping
+		// rewrites before they are type checked here.
+		if fn.isAggregate() {
+			return NullType, fmt.Errorf("%w: %s", ErrInvalidAggregate, exp.call.name.value)
+		}
+		return fn.returnType, nil
+	}
+
+	return NullType, ErrInvalidSelectItem
+}
+
+// compareCells orders two non-NULL cells of the same type, or of the two
+// numeric types when typ is FloatType.
+func compareCells(a MemoryCell, aType ColumnType, b MemoryCell, bType ColumnType) int {
+	typ, _ := commonType(aType, bType)
+	switch typ {
+	case FloatType:
+		x := coerceCell(a, aType, FloatType).AsFloat()
+		y := coerceCell(b, bType, FloatType).AsFloat()
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case IntType:
+		x, y := a.AsInt(), b.AsInt()
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case BoolType:
+		x, y := a.AsBool(), b.AsBool()
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+// evaluateCell computes the value of exp for one row of t, along with the
+// value's type. NULL propagates through operators following SQL's three
+// valued logic.
+func (mb *MemoryBackend) evaluateCell(t *table, row []MemoryCell, exp *expression) (MemoryCell, ColumnType, error) {
+	switch exp.kind {
+	case literalKind:
+		lit := exp.literal
+		if lit.kind == identifierKind {
+			i, ok := t.columnIndex(lit.value)
+			if !ok {
+				return nil, NullType, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, lit.value)
+			}
+			return row[i], t.columnTypes[i], nil
+		}
+
+		typ, err := mb.expressionType(t, exp)
+		if err != nil {
+			return nil, NullType, err
+		}
+		return mb.tokenToCell(lit), typ, nil
+	case unaryKind:
+		cell, typ, err := mb.evaluateCell(t, row, exp.unary.operand)
+		if exp.unary.op.value == string(minusSymbol) {
+			if err != nil || cell == nil {
+				return nil, typ, err
+			}
+			return evaluateArithmetic(exp.unary.op, intToCell(0), IntType, cell, typ)
+		}
+
+		if err != nil || cell == nil {
+			return nil, BoolType, err
+		}
+		return boolToCell(!cell.AsBool()), BoolType, nil
+	case binaryKind:
+		return mb.evaluateBinaryCell(t, row, exp.binary)
+	case caseKind:
+		c := exp.caseExp
+
+		var operand MemoryCell
+		operandType := BoolType
+		if c.operand != nil {
+			var err error
+			operand, operandType, err = mb.evaluateCell(t, row, c.operand)
+			if err != nil {
+				return nil, NullType, err
+			}
+		}
+
+		typ, err := mb.expressionType(t, exp)
+		if err != nil {
+			return nil, NullType, err
+		}
+
+		for _, when := range c.whens {
+			cell, condType, err := mb.evaluateCell(t, row, when.condition)
+			if err != nil {
+				return nil, NullType, err
+			}
+
+			matched := cell.AsBool()
+			if c.operand != nil {
+				matched = operand != nil && cell != nil && compareCells(operand, operandType, cell, condType) == 0
+			}
+
+			if matched {
+				result, resultType, err := mb.evaluateCell(t, row, when.result)
+				return coerceCell(result, resultType, typ), typ, err
+			}
+		}
+
+		if c.elseResult != nil {
+			result, resultType, err := mb.evaluateCell(t, row, c.elseResult)
+			return coerceCell(result, resultType, typ), typ, err
+		}
+		return nil, typ, nil
+	case coalesceKind:
+		typ, err := mb.expressionType(t, exp)
+		if err != nil {
+			return nil, NullType, err
+		}
+
+		for _, arg := range exp.coalesce.args {
+			cell, cellType, err := mb.evaluateCell(t, row, arg)
+			if err != nil {
+				return nil, NullType, err
+			}
+			if cell != nil {
+				return coerceCell(cell, cellType, typ), typ, nil
+			}
+		}
+		return nil, typ, nil
+	case nullifKind:
+		a, aType, err := mb.evaluateCell(t, row, exp.nullif.a)
+		if err != nil {
+			return nil, NullType, err
+		}
+		b, bType, err := mb.evaluateCell(t, row, exp.nullif.b)
+		if err != nil {
+			return nil, NullType, err
+		}
+
+		if a != nil && b != nil && compareCells(a, aType, b, bType) == 0 {
+			return nil, aType, nil
+		}
+		return a, aType, nil
+	case inKind:
+		operand, operandType, err := mb.evaluateCell(t, row, exp.in.operand)
+		if err != nil || operand == nil {
+			return nil, BoolType, err
+		}
+
+		sawNull := false
+		for _, e := range exp.in.list {
+			cell, typ, err := mb.evaluateCell(t, row, e)
+			if err != nil {
+				return nil, BoolType, err
+			}
+			if cell == nil {
+				sawNull = true
+				continue
+			}
+
+			if compareCells(operand, operandType, cell, typ) == 0 {
+				return trueMemoryCell, BoolType, nil
+			}
+		}
+
+		// x IN (..., NULL) is unknown rather than false when nothing matched.
+		if sawNull {
+			return nil, BoolType, nil
+		}
+		return falseMemoryCell, BoolType, nil
+	case betweenKind:
+		operand, operandType, err := mb.evaluateCell(t, row, exp.between.operand)
+		if err != nil {
+			return nil, BoolType, err
+		}
+		low, lowType, err := mb.evaluateCell(t, row, exp.between.low)
+		if err != nil {
+			return nil, BoolType, err
+		}
+		high, highType, err := mb.evaluateCell(t, row, exp.between.high)
+		if err != nil {
+			return nil, BoolType, err
+		}
+
+		if operand == nil {
+			return nil, BoolType, nil
+		}
+
+		// With one NULL bound the result is still false when the other bound
+		// already rules the operand out.
+		aboveLow := low == nil || compareCells(operand, operandType, low, lowType) >= 0
+		belowHigh := high == nil || compareCells(operand, operandType, high, highType) <= 0
+		if !aboveLow || !belowHigh {
+			return falseMemoryCell, BoolType, nil
+		}
+		if low == nil || high == nil {
+			return nil, BoolType, nil
+		}
+		return trueMemoryCell, BoolType, nil
+	case callKind:
+		return mb.evaluateCall(t, row, exp.call)
+	}
+
+	return nil, NullType, ErrInvalidSelectItem
+}
+
+// evaluateArithmetic applies +, -, *, / or % to two numeric cells. Int
+// arithmetic stays in int and fails instead of overflowing.
+func evaluateArithmetic(op token, a MemoryCell, aType ColumnType, b MemoryCell, bType ColumnType) (MemoryCell, ColumnType, error) {
+	typ, _ := commonType(aType, bType)
+	if a == nil || b == nil {
+		return nil, typ, nil
+	}
+
+	if typ == FloatType {
+		x := coerceCell(a, aType, FloatType).AsFloat()
+		y := coerceCell(b, bType, FloatType).AsFloat()
+
+		var f float64
+		switch symbol(op.value) {
+		case plusSymbol:
+			f = x + y
+		case minusSymbol:
+			f = x - y
+		case asteriskSymbol:
+			f = x * y
+		case slashSymbol, percentSymbol:
+			if y == 0 {
+				return nil, NullType, ErrDivisionByZero
+			}
+			f = x / y
+			if op.value == string(percentSymbol) {
+				f = math.Mod(x, y)
+			}
+		}
+		return floatToCell(f), FloatType, nil
+	}
+
+	x, y := int64(a.AsInt()), int64(b.AsInt())
+
+	var i int64
+	switch symbol(op.value) {
+	case plusSymbol:
+		i = x + y
+	case minusSymbol:
+		i = x - y
+	case asteriskSymbol:
+		i = x * y
+	case slashSymbol, percentSymbol:
+		if y == 0 {
+			return nil, NullType, ErrDivisionByZero
+		}
+		i = x / y
+		if op.value == string(percentSymbol) {
+			i = x % y
+		}
+	}
+
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return nil, NullType, ErrIntegerOutOfRange
+	}
+	return intToCell(int32(i)), IntType, nil
+}
+
+func (mb *MemoryBackend) evaluateBinaryCell(t *table, row []MemoryCell, bexp *binaryExpression) (MemoryCell, ColumnType, error) {
+	op := bexp.op
+
+	a, aType, err := mb.evaluateCell(t, row, bexp.a)
+	if err != nil {
+		return nil, NullType, err
+	}
+
+	// AND and OR skip the right operand once the left decides the result.
+	if op.matchesKeyword(andKeyword) && a != nil && !a.AsBool() {
+		return falseMemoryCell, BoolType, nil
+	}
+	if op.matchesKeyword(orKeyword) && a != nil && a.AsBool() {
+		return trueMemoryCell, BoolType, nil
+	}
+
+	b, bType, err := mb.evaluateCell(t, row, bexp.b)
+	if err != nil {
+		return nil, NullType, err
+	}
+
+	switch {
+	case op.matchesKeyword(isKeyword):
+		switch {
+		case bexp.b.literal.matchesKeyword(nullKeyword):
+			return boolToCell(a == nil), BoolType, nil
+		case bexp.b.literal.matchesKeyword(trueKeyword):
+			return boolToCell(a != nil && a.AsBool()), BoolType, nil
+		}
+		return boolToCell(a != nil && !a.AsBool()), BoolType, nil
+	case op.matchesKeyword(andKeyword):
+		if b != nil && !b.AsBool() {
+			return falseMemoryCell, BoolType, nil
+		}
+		if a == nil || b == nil {
+			return nil, BoolType, nil
+		}
+		return trueMemoryCell, BoolType, nil
+	case op.matchesKeyword(orKeyword):
+		if b != nil && b.AsBool() {
+			return trueMemoryCell, BoolType, nil
+		}
+		if a == nil || b == nil {
+			return nil, BoolType, nil
+		}
+		return falseMemoryCell, BoolType, nil
+	}
+
+	if op.value == string(concatSymbol) {
+		if a == nil || b == nil {
+			return nil, TextType, nil
+		}
+		return MemoryCell(cellToText(a, aType) + cellToText(b, bType)), TextType, nil
+	}
+
+	if isArithmeticOperator(op) {
+		return evaluateArithmetic(op, a, aType, b, bType)
+	}
+
+	if a == nil || b == nil {
+		return nil, BoolType, nil
+	}
+
+	switch {
+	case op.matchesKeyword(likeKeyword):
+		return boolToCell(likeMatch(a.AsText(), b.AsText())), BoolType, nil
+	case op.matchesKeyword(ilikeKeyword):
+		return boolToCell(likeMatch(strings.ToLower(a.AsText()), strings.ToLower(b.AsText()))), BoolType, nil
+	}
+
+	if _, ok := commonType(aType, bType); !ok {
+		return nil, NullType, fmt.Errorf("%w: cannot compare %s with %s", ErrInvalidOperands, aType, bType)
+	}
+
+	c := compareCells(a, aType, b, bType)
+	switch symbol(op.value) {
+	case equalsSymbol:
+		return boolToCell(c == 0), BoolType, nil
+	case notEqualsSymbol, bangEqualsSymbol:
+		return boolToCell(c != 0), BoolType, nil
+	case lessThanSymbol:
+		return boolToCell(c < 0), BoolType, nil
+	case lessThanOrEqualSymbol:
+		return boolToCell(c <= 0), BoolType, nil
+	case greaterThanSymbol:
+		return boolToCell(c > 0), BoolType, nil
+	case greaterThanOrEqualSymbol:
+		return boolToCell(c >= 0), BoolType, nil
+	}
+
+	return nil, NullType, fmt.Errorf("%w: unknown operator %s", ErrInvalidOperands, op.value)
+}
+
+// likeMatch reports whether s matches a LIKE pattern, where % matches any
+// run of characters, _ matches exactly one and a backslash escapes the
+// character after it.
+func likeMatch(s, pattern string) bool {
+	sr, pr := []rune(s), []rune(pattern)
+
+	// On a mismatch, backtrack to the most recent % and let it swallow one
+	// more character.
+	si, pi := 0, 0
+	starPi, starSi := -1, 0
+	for si < len(sr) {
+		if pi < len(pr) {
+			switch c := pr[pi]; c {
+			case '%':
+				starPi, starSi = pi, si
+				pi++
+				continue
+			case '_':
+				si++
+				pi++
+				continue
+			case '\\':
+				if pi+1 < len(pr) {
+					if pr[pi+1] == sr[si] {
+						si++
+						pi += 2
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				if c == sr[si] {
+					si++
+					pi++
+					continue
+				}
+			}
+		}
+
+		if starPi < 0 {
+			return false
+		}
+		starSi++
+		si, pi = starSi, starPi+1
+	}
+
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+	return pi == len(pr)
 }
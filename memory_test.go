@@ -0,0 +1,2848 @@
+package gogn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// execute runs every statement in source against mb and returns the results
+// of the last SELECT.
+func execute(mb Backend, source string) (*Results, error) {
+	ast, err := Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var results *Results
+	for _, stmt := range ast.Statements {
+		switch stmt.Kind {
+		case CreateTableKind:
+			err = mb.CreateTable(stmt.CreateTableStatement)
+		case InsertKind:
+			results, err = mb.Insert(stmt.InsertStatement)
+		case UpdateKind:
+			results, err = mb.Update(stmt.UpdateStatement)
+		case DeleteKind:
+			results, err = mb.Delete(stmt.DeleteStatement)
+		case CreateSequenceKind:
+			err = mb.CreateSequence(stmt.CreateSequenceStatement)
+		case AlterTableKind:
+			err = mb.AlterTable(stmt.AlterTableStatement)
+		case CreateIndexKind:
+			err = mb.CreateIndex(stmt.CreateIndexStatement)
+		case DropIndexKind:
+			err = mb.DropIndex(stmt.DropIndexStatement)
+		case AnalyzeKind:
+			err = mb.Analyze(stmt.AnalyzeStatement)
+		case BeginKind:
+			err = mb.Begin(stmt.BeginStatement)
+		case CommitKind:
+			err = mb.Commit(stmt.CommitStatement)
+		case RollbackKind:
+			err = mb.Rollback(stmt.RollbackStatement)
+		case SavepointKind:
+			err = mb.Savepoint(stmt.SavepointStatement)
+		case ReleaseSavepointKind:
+			err = mb.ReleaseSavepoint(stmt.ReleaseSavepointStatement)
+		case SetTransactionKind:
+			err = mb.SetTransaction(stmt.SetTransactionStatement)
+		case SelectKind:
+			results, err = mb.Select(stmt.SelectStatement)
+		case ExplainKind:
+			results, err = mb.Explain(stmt.ExplainStatement)
+		default:
+			err = errors.New("Unsupported statement")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// cellValues flattens results into Go values so tests can compare them
+// without caring about the cell encoding.
+func cellValues(results *Results) [][]interface{} {
+	rows := [][]interface{}{}
+	for _, row := range results.Rows {
+		values := []interface{}{}
+		for i, cell := range row {
+			if cell.IsNull() {
+				values = append(values, nil)
+				continue
+			}
+
+			switch results.Columns[i].Type {
+			case IntType:
+				values = append(values, cell.AsInt())
+			case FloatType:
+				values = append(values, cell.AsFloat())
+			case BoolType:
+				values = append(values, cell.AsBool())
+			default:
+				values = append(values, cell.AsText())
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows
+}
+
+func newUsersBackend(t *testing.T) *MemoryBackend {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE users (id INT, name TEXT, age INT);
+		INSERT INTO users VALUES (1, 'Alice', 31);
+		INSERT INTO users VALUES (2, 'bob', NULL);
+		INSERT INTO users VALUES (3, 'Carol', 17);`)
+	assert.Nil(t, err)
+	return mb
+}
+
+func TestMemoryBackendConditionalExpressions(t *testing.T) {
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: "SELECT id, CASE WHEN age >= 18 THEN 'adult' WHEN age < 18 THEN 'minor' ELSE 'unknown' END FROM users",
+			rows:   [][]interface{}{{int32(1), "adult"}, {int32(2), "unknown"}, {int32(3), "minor"}},
+		},
+		{
+			source: "SELECT CASE id WHEN 1 THEN 'one' WHEN 2 THEN 'two' END FROM users",
+			rows:   [][]interface{}{{"one"}, {"two"}, {nil}},
+		},
+		{
+			source: "SELECT COALESCE(age, 0), NULLIF(id, 2) FROM users",
+			rows:   [][]interface{}{{int32(31), int32(1)}, {int32(0), nil}, {int32(17), int32(3)}},
+		},
+		{
+			source: "SELECT id FROM users WHERE id IN (1, 3)",
+			rows:   [][]interface{}{{int32(1)}, {int32(3)}},
+		},
+		{
+			source: "SELECT id FROM users WHERE id NOT IN (1, 3)",
+			rows:   [][]interface{}{{int32(2)}},
+		},
+		{
+			source: "SELECT id IN (5, NULL), id NOT IN (5, NULL) FROM users WHERE id = 1",
+			rows:   [][]interface{}{{nil, nil}},
+		},
+		{
+			source: "SELECT id FROM users WHERE age BETWEEN 18 AND 40 OR age IS NULL",
+			rows:   [][]interface{}{{int32(1)}, {int32(2)}},
+		},
+		{
+			source: "SELECT id FROM users WHERE age NOT BETWEEN 18 AND 40",
+			rows:   [][]interface{}{{int32(3)}},
+		},
+		{
+			source: "SELECT name FROM users WHERE name LIKE '%o%'",
+			rows:   [][]interface{}{{"bob"}, {"Carol"}},
+		},
+		{
+			source: "SELECT name FROM users WHERE name ILIKE 'a____'",
+			rows:   [][]interface{}{{"Alice"}},
+		},
+		{
+			source: "SELECT name FROM users WHERE name NOT LIKE 'B%' AND NOT age IS NULL",
+			rows:   [][]interface{}{{"Alice"}, {"Carol"}},
+		},
+		{
+			source: "SELECT NULL = 1, TRUE OR NULL, FALSE AND NULL, NOT NULL",
+			rows:   [][]interface{}{{nil, true, false, nil}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(newUsersBackend(t), test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+}
+
+func TestMemoryBackendExpressionTypeErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "SELECT id FROM users WHERE name", err: ErrInvalidCondition},
+		{source: "SELECT id = 'Alice' FROM users", err: ErrInvalidOperands},
+		{source: "SELECT id LIKE '1%' FROM users", err: ErrInvalidOperands},
+		{source: "SELECT CASE WHEN id = 1 THEN 'one' ELSE 2 END FROM users", err: ErrInvalidOperands},
+		{source: "SELECT COALESCE(name, age) FROM users", err: ErrInvalidOperands},
+		{source: "SELECT id FROM users WHERE id IN (1, 'two')", err: ErrInvalidOperands},
+		{source: "SELECT email FROM users", err: ErrColumnDoesNotExist},
+		{source: "INSERT INTO users VALUES ('4', 'Dave', 40)", err: ErrMismatchedType},
+	}
+
+	for _, test := range tests {
+		_, err := execute(newUsersBackend(t), test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func TestLikeMatch(t *testing.T) {
+	tests := []struct {
+		s       string
+		pattern string
+		matches bool
+	}{
+		{s: "abc", pattern: "abc", matches: true},
+		{s: "abc", pattern: "a%", matches: true},
+		{s: "abc", pattern: "%c", matches: true},
+		{s: "abc", pattern: "%b%", matches: true},
+		{s: "abc", pattern: "a_c", matches: true},
+		{s: "abc", pattern: "_", matches: false},
+		{s: "", pattern: "%", matches: true},
+		{s: "aXbXc", pattern: "a%b%c", matches: true},
+		{s: "abcabd", pattern: "%abd", matches: true},
+		{s: "100%", pattern: `100\%`, matches: true},
+		{s: "1000", pattern: `100\%`, matches: false},
+		{s: "a_c", pattern: `a\_c`, matches: true},
+		{s: "abc", pattern: `a\_c`, matches: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, likeMatch(test.s, test.pattern), test.s+" LIKE "+test.pattern)
+	}
+}
+
+func TestMemoryBackendScalarFunctions(t *testing.T) {
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: "SELECT lower(name), upper(name), length(name) FROM users WHERE id = 1",
+			rows:   [][]interface{}{{"alice", "ALICE", int32(5)}},
+		},
+		{
+			source: "SELECT substr('hello', 2), substr('hello', 0, 3), substr('hello', 4, 10)",
+			rows:   [][]interface{}{{"ello", "he", "lo"}},
+		},
+		{
+			source: "SELECT trim('  x  '), trim('xxaxx', 'x'), replace('a-b-c', '-', '+')",
+			rows:   [][]interface{}{{"x", "a", "a+b+c"}},
+		},
+		{
+			source: "SELECT concat(name, NULL, '!'), position('ro' IN name) FROM users WHERE id = 3",
+			rows:   [][]interface{}{{"Carol!", int32(3)}},
+		},
+		{
+			source: "SELECT concat('a', 1, NULL, 2.5, true), concat(age) FROM users WHERE id = 1",
+			rows:   [][]interface{}{{"a12.5true", "31"}},
+		},
+		{
+			source: "SELECT name || ' is ' || age, name || NULL FROM users WHERE id = 1",
+			rows:   [][]interface{}{{"Alice is 31", nil}},
+		},
+		{
+			source: "SELECT abs(-3), abs(-2.5), round(2.5), round(3.14159, 2), floor(-1.5), ceil(1.2)",
+			rows:   [][]interface{}{{int32(3), 2.5, 3.0, 3.14, -2.0, 2.0}},
+		},
+		{
+			source: "SELECT mod(7, 3), power(2, 10), sqrt(16), 7 / 2, 7.0 / 2, -7 % 3",
+			rows:   [][]interface{}{{int32(1), 1024.0, 4.0, int32(3), 3.5, int32(-1)}},
+		},
+		{
+			source: "SELECT 2147483647, 1.7976931348623157e308",
+			rows:   [][]interface{}{{int32(2147483647), 1.7976931348623157e308}},
+		},
+		{
+			source: "SELECT id FROM users WHERE age + 1 > 2 * 10",
+			rows:   [][]interface{}{{int32(1)}},
+		},
+		{
+			source: "SELECT upper(NULL), abs(age) FROM users WHERE id = 2",
+			rows:   [][]interface{}{{nil, nil}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(newUsersBackend(t), test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+
+	results, err := execute(newUsersBackend(t), "SELECT random()")
+	assert.Nil(t, err)
+	r := results.Rows[0][0].AsFloat()
+	assert.True(t, r >= 0 && r < 1)
+}
+
+func TestMemoryBackendScalarFunctionErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "SELECT nope(1)", err: ErrFunctionDoesNotExist},
+		{source: "SELECT lower(1)", err: ErrInvalidArguments},
+		{source: "SELECT substr('abc')", err: ErrInvalidArguments},
+		{source: "SELECT sqrt(-1)", err: ErrInvalidArguments},
+		{source: "SELECT 1 / 0", err: ErrDivisionByZero},
+		{source: "SELECT mod(1, 0)", err: ErrDivisionByZero},
+		{source: "SELECT 2147483647 + 1", err: ErrIntegerOutOfRange},
+		{source: "SELECT 3000000000", err: ErrIntegerOutOfRange},
+		{source: "SELECT -2147483649", err: ErrIntegerOutOfRange},
+		{source: "SELECT -(-2147483648)", err: ErrIntegerOutOfRange},
+		{source: "SELECT 99999999999999999999", err: ErrIntegerOutOfRange},
+		{source: "SELECT 1e400", err: ErrFloatOutOfRange},
+		{source: "SELECT 1.7976931348623157e309", err: ErrFloatOutOfRange},
+		{source: "SELECT name + 1 FROM users", err: ErrInvalidOperands},
+		{source: "SELECT 1 || 2", err: ErrInvalidOperands},
+	}
+
+	for _, test := range tests {
+		_, err := execute(newUsersBackend(t), test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+
+	// The range of a literal takes its minus into account, so the smallest
+	// INT can be written.
+	results, err := execute(newUsersBackend(t), "SELECT -2147483648, -2147483647 - 1, - -5, -1.5")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(math.MinInt32), int32(math.MinInt32), int32(5), -1.5}}, cellValues(results))
+}
+
+func TestMemoryBackendAggregates(t *testing.T) {
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: "SELECT count(*), count(age), sum(age), min(name), max(age), avg(age) FROM users",
+			rows:   [][]interface{}{{int32(3), int32(2), int32(48), "Alice", int32(31), 24.0}},
+		},
+		{
+			source: "SELECT count(*), sum(age), max(name) FROM users WHERE id > 10",
+			rows:   [][]interface{}{{int32(0), nil, nil}},
+		},
+		{
+			source: "SELECT age IS NULL, count(*), max(id) + 1 FROM users GROUP BY age IS NULL",
+			rows:   [][]interface{}{{false, int32(2), int32(4)}, {true, int32(1), int32(3)}},
+		},
+		{
+			source: "SELECT upper(name), count(id) FROM users WHERE id < 3 GROUP BY upper(name)",
+			rows:   [][]interface{}{{"ALICE", int32(1)}, {"BOB", int32(1)}},
+		},
+		{
+			source: "SELECT id FROM users WHERE id > 10 GROUP BY id",
+			rows:   [][]interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(newUsersBackend(t), test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+
+	errTests := []struct {
+		source string
+		err    error
+	}{
+		{source: "SELECT name, count(*) FROM users", err: ErrNotGrouped},
+		{source: "SELECT id FROM users WHERE count(*) > 1", err: ErrInvalidAggregate},
+		{source: "SELECT sum(count(*)) FROM users", err: ErrInvalidAggregate},
+		{source: "SELECT sum(name) FROM users", err: ErrInvalidArguments},
+		{source: "SELECT lower(*) FROM users", err: ErrInvalidArguments},
+	}
+
+	for _, test := range errTests {
+		_, err := execute(newUsersBackend(t), test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+type productAggregate struct {
+	product float64
+}
+
+func (p *productAggregate) Step(args []Cell) error {
+	if !args[0].IsNull() {
+		p.product *= args[0].AsFloat()
+	}
+	return nil
+}
+
+func (p *productAggregate) Result() (Cell, error) {
+	return NewFloatCell(p.product), nil
+}
+
+func TestMemoryBackendUserDefinedFunctions(t *testing.T) {
+	mb := newUsersBackend(t)
+
+	err := mb.RegisterFunction("greet", []ColumnType{TextType}, TextType, func(args []Cell) (Cell, error) {
+		if args[0].IsNull() {
+			return nil, nil
+		}
+		return NewTextCell("hello " + args[0].AsText()), nil
+	})
+	assert.Nil(t, err)
+
+	err = mb.RegisterFunction("is_adult", []ColumnType{IntType}, BoolType, func(args []Cell) (Cell, error) {
+		return NewBoolCell(!args[0].IsNull() && args[0].AsInt() >= 18), nil
+	})
+	assert.Nil(t, err)
+
+	failure := errors.New("always fails")
+	err = mb.RegisterFunction("fail", []ColumnType{}, IntType, func(args []Cell) (Cell, error) {
+		return nil, failure
+	})
+	assert.Nil(t, err)
+
+	err = mb.RegisterAggregate("product", []ColumnType{FloatType}, FloatType, func() Aggregate {
+		return &productAggregate{product: 1}
+	})
+	assert.Nil(t, err)
+
+	results, err := execute(mb, "SELECT greet(name), product(age) FROM users WHERE is_adult(age) OR is_adult(id) IS FALSE GROUP BY greet(name)")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"hello Alice", 31.0}, {"hello bob", 1.0}, {"hello Carol", 17.0}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT product(id) FROM users")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{6.0}}, cellValues(results))
+
+	_, err = execute(mb, "INSERT INTO users VALUES (4, greet('Dave'), 40); SELECT greet(NULL)")
+	assert.Nil(t, err)
+
+	_, err = execute(mb, "SELECT greet(id) FROM users")
+	assert.True(t, errors.Is(err, ErrInvalidArguments))
+
+	_, err = execute(mb, "SELECT fail()")
+	assert.Equal(t, failure, err)
+
+	err = mb.RegisterFunction("greet", []ColumnType{TextType}, TextType, nil)
+	assert.True(t, errors.Is(err, ErrFunctionExists))
+
+	err = mb.RegisterFunction("lower", []ColumnType{TextType}, TextType, nil)
+	assert.True(t, errors.Is(err, ErrFunctionExists))
+
+	err = mb.RegisterFunction("nothing", []ColumnType{NullType}, TextType, nil)
+	assert.True(t, errors.Is(err, ErrInvalidDatatype))
+}
+
+func TestMemoryBackendUpdate(t *testing.T) {
+	mb := newUsersBackend(t)
+
+	results, err := execute(mb, "UPDATE users SET age = age + 1, name = upper(name) WHERE age IS NOT NULL; SELECT id, name, age FROM users")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), "ALICE", int32(32)}, {int32(2), "bob", nil}, {int32(3), "CAROL", int32(18)}}, cellValues(results))
+
+	results, err = execute(mb, "UPDATE users SET age = 0; SELECT sum(age) FROM users")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(0)}}, cellValues(results))
+
+	_, err = execute(mb, "UPDATE users SET age = 'old'")
+	assert.True(t, errors.Is(err, ErrMismatchedType))
+
+	_, err = execute(mb, "UPDATE users SET email = 'x'")
+	assert.True(t, errors.Is(err, ErrColumnDoesNotExist))
+}
+
+func TestMemoryBackendKeyConstraints(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE,
+		org INT,
+		slug TEXT,
+		CONSTRAINT accounts_org_slug UNIQUE (org, slug)
+	);
+	INSERT INTO accounts VALUES (1, 'a@example.com', 1, 'a');
+	INSERT INTO accounts VALUES (2, 'b@example.com', 1, 'b');
+	INSERT INTO accounts VALUES (3, 'c@example.com', NULL, 'a');
+	INSERT INTO accounts VALUES (4, 'd@example.com', NULL, 'a');`)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		source     string
+		kind       ConstraintKind
+		constraint string
+		columns    []string
+	}{
+		{
+			source:     "INSERT INTO accounts VALUES (1, 'x@example.com', 2, 'x')",
+			kind:       PrimaryKeyConstraint,
+			constraint: "accounts_pkey",
+			columns:    []string{"id"},
+		},
+		{
+			source:     "INSERT INTO accounts VALUES (NULL, 'x@example.com', 2, 'x')",
+			kind:       NotNullConstraint,
+			constraint: "id_not_null",
+			columns:    []string{"id"},
+		},
+		{
+			source:     "INSERT INTO accounts VALUES (5, 'a@example.com', 2, 'x')",
+			kind:       UniqueConstraint,
+			constraint: "accounts_email_key",
+			columns:    []string{"email"},
+		},
+		{
+			source:     "INSERT INTO accounts VALUES (5, 'x@example.com', 1, 'b')",
+			kind:       UniqueConstraint,
+			constraint: "accounts_org_slug",
+			columns:    []string{"org", "slug"},
+		},
+		{
+			source:     "UPDATE accounts SET email = NULL WHERE id = 1",
+			kind:       NotNullConstraint,
+			constraint: "email_not_null",
+			columns:    []string{"email"},
+		},
+		{
+			source:     "UPDATE accounts SET slug = 'b' WHERE id = 1",
+			kind:       UniqueConstraint,
+			constraint: "accounts_org_slug",
+			columns:    []string{"org", "slug"},
+		},
+		{
+			source:     "UPDATE accounts SET id = 1",
+			kind:       PrimaryKeyConstraint,
+			constraint: "accounts_pkey",
+			columns:    []string{"id"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, ErrConstraintViolation), test.source)
+
+		var violation *ConstraintViolationError
+		if assert.True(t, errors.As(err, &violation), test.source) {
+			assert.Equal(t, test.kind, violation.Kind, test.source)
+			assert.Equal(t, "accounts", violation.Table, test.source)
+			assert.Equal(t, test.constraint, violation.Constraint, test.source)
+			assert.Equal(t, test.columns, violation.Columns, test.source)
+		}
+	}
+
+	// Failed writes leave the table untouched, and keys shifted as a whole
+	// don't conflict with themselves.
+	results, err := execute(mb, "UPDATE accounts SET id = id + 1; SELECT id, email FROM accounts WHERE id IN (1, 5)")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(5), "d@example.com"}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT count(*) FROM accounts")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(4)}}, cellValues(results))
+
+	_, err = execute(mb, "INSERT INTO accounts VALUES (1, 'e@example.com', 3, 'c')")
+	assert.Nil(t, err)
+}
+
+func TestMemoryBackendInvalidConstraints(t *testing.T) {
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "CREATE TABLE t (a INT PRIMARY KEY, b INT PRIMARY KEY)", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT PRIMARY KEY, b INT, PRIMARY KEY (b))", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT, UNIQUE (b))", err: ErrColumnDoesNotExist},
+		{source: "CREATE TABLE t (a INT REFERENCES missing)", err: ErrTableDoesNotExist},
+		{source: "CREATE TABLE t (a INT REFERENCES t)", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT UNIQUE, b INT REFERENCES t (b))", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT UNIQUE, b TEXT REFERENCES t (a))", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT, b INT, PRIMARY KEY (a, b), FOREIGN KEY (a) REFERENCES t)", err: ErrInvalidConstraint},
+		{source: "CREATE TABLE t (a INT DEFAULT 'x')", err: ErrMismatchedType},
+		{source: "CREATE TABLE t (a INT, b INT DEFAULT a)", err: ErrColumnDoesNotExist},
+		{source: "CREATE TABLE t (a INT CHECK (a + 1))", err: ErrInvalidCondition},
+		{source: "CREATE TABLE t (a INT, CHECK (b > 0))", err: ErrColumnDoesNotExist},
+	}
+
+	for _, test := range tests {
+		mb := NewMemoryBackend()
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+
+		_, err = execute(mb, "SELECT a FROM t")
+		assert.Equal(t, ErrTableDoesNotExist, err, test.source)
+	}
+}
+
+func newOrdersBackend(t *testing.T, actions string) *MemoryBackend {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE customers (id INT PRIMARY KEY, name TEXT);
+	CREATE TABLE orders (
+		id INT PRIMARY KEY,
+		customer INT REFERENCES customers `+actions+`
+	);
+	CREATE TABLE items (
+		id INT PRIMARY KEY,
+		order_id INT,
+		FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE ON UPDATE CASCADE
+	);
+	INSERT INTO customers VALUES (1, 'Alice');
+	INSERT INTO customers VALUES (2, 'Bob');
+	INSERT INTO orders VALUES (10, 1);
+	INSERT INTO orders VALUES (11, 1);
+	INSERT INTO orders VALUES (12, 2);
+	INSERT INTO orders VALUES (13, NULL);
+	INSERT INTO items VALUES (100, 10);
+	INSERT INTO items VALUES (101, 11);
+	INSERT INTO items VALUES (102, 12);`)
+	assert.Nil(t, err)
+	return mb
+}
+
+func TestMemoryBackendForeignKeys(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+
+	tests := []struct {
+		source     string
+		table      string
+		constraint string
+		columns    []string
+	}{
+		{
+			source:     "INSERT INTO orders VALUES (14, 3)",
+			table:      "orders",
+			constraint: "orders_customer_fkey",
+			columns:    []string{"customer"},
+		},
+		{
+			source:     "UPDATE orders SET customer = 3 WHERE id = 10",
+			table:      "orders",
+			constraint: "orders_customer_fkey",
+			columns:    []string{"customer"},
+		},
+		{
+			source:     "DELETE FROM customers WHERE id = 1",
+			table:      "orders",
+			constraint: "orders_customer_fkey",
+			columns:    []string{"customer"},
+		},
+		{
+			source:     "UPDATE customers SET id = 3 WHERE id = 2",
+			table:      "orders",
+			constraint: "orders_customer_fkey",
+			columns:    []string{"customer"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, ErrConstraintViolation), test.source)
+
+		var violation *ConstraintViolationError
+		if assert.True(t, errors.As(err, &violation), test.source) {
+			assert.Equal(t, ForeignKeyConstraint, violation.Kind, test.source)
+			assert.Equal(t, test.table, violation.Table, test.source)
+			assert.Equal(t, test.constraint, violation.Constraint, test.source)
+			assert.Equal(t, test.columns, violation.Columns, test.source)
+		}
+	}
+
+	// NULL references nothing, and unreferenced rows can go.
+	results, err := execute(mb, `INSERT INTO orders VALUES (14, NULL);
+	UPDATE customers SET name = 'Carol' WHERE id = 2;
+	DELETE FROM orders WHERE id = 12;
+	DELETE FROM customers WHERE id = 2;
+	SELECT id FROM customers`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1)}}, cellValues(results))
+
+	// Deleting orders cascades to their items.
+	results, err = execute(mb, "SELECT id FROM items")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(100)}, {int32(101)}}, cellValues(results))
+}
+
+func TestMemoryBackendReferentialActions(t *testing.T) {
+	tests := []struct {
+		actions string
+		source  string
+		orders  [][]interface{}
+		items   [][]interface{}
+	}{
+		{
+			actions: "ON DELETE CASCADE",
+			source:  "DELETE FROM customers WHERE id = 1",
+			orders:  [][]interface{}{{int32(12), int32(2)}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(102), int32(12)}},
+		},
+		{
+			actions: "ON DELETE SET NULL",
+			source:  "DELETE FROM customers WHERE id = 1",
+			orders:  [][]interface{}{{int32(10), nil}, {int32(11), nil}, {int32(12), int32(2)}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(100), int32(10)}, {int32(101), int32(11)}, {int32(102), int32(12)}},
+		},
+		{
+			actions: "ON UPDATE CASCADE",
+			source:  "UPDATE customers SET id = id + 5",
+			orders:  [][]interface{}{{int32(10), int32(6)}, {int32(11), int32(6)}, {int32(12), int32(7)}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(100), int32(10)}, {int32(101), int32(11)}, {int32(102), int32(12)}},
+		},
+		{
+			actions: "ON UPDATE CASCADE",
+			source:  "UPDATE orders SET id = id * 2 WHERE customer = 1",
+			orders:  [][]interface{}{{int32(20), int32(1)}, {int32(22), int32(1)}, {int32(12), int32(2)}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(100), int32(20)}, {int32(101), int32(22)}, {int32(102), int32(12)}},
+		},
+		{
+			// Consecutive keys shift onto each other, so each child row
+			// must move with the key it had before the statement.
+			actions: "ON UPDATE CASCADE",
+			source:  "UPDATE customers SET id = id + 1",
+			orders:  [][]interface{}{{int32(10), int32(2)}, {int32(11), int32(2)}, {int32(12), int32(3)}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(100), int32(10)}, {int32(101), int32(11)}, {int32(102), int32(12)}},
+		},
+		{
+			actions: "",
+			source:  "UPDATE orders SET id = id + 1",
+			orders:  [][]interface{}{{int32(11), int32(1)}, {int32(12), int32(1)}, {int32(13), int32(2)}, {int32(14), nil}},
+			items:   [][]interface{}{{int32(100), int32(11)}, {int32(101), int32(12)}, {int32(102), int32(13)}},
+		},
+		{
+			actions: "ON UPDATE SET NULL ON DELETE RESTRICT",
+			source:  "UPDATE customers SET id = 3 WHERE name = 'Bob'",
+			orders:  [][]interface{}{{int32(10), int32(1)}, {int32(11), int32(1)}, {int32(12), nil}, {int32(13), nil}},
+			items:   [][]interface{}{{int32(100), int32(10)}, {int32(101), int32(11)}, {int32(102), int32(12)}},
+		},
+	}
+
+	for _, test := range tests {
+		mb := newOrdersBackend(t, test.actions)
+		_, err := execute(mb, test.source)
+		assert.Nil(t, err, test.source)
+
+		results, err := execute(mb, "SELECT id, customer FROM orders")
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.orders, cellValues(results), test.source)
+
+		results, err = execute(mb, `SELECT id, order_id FROM items`)
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.items, cellValues(results), test.source)
+	}
+}
+
+func TestMemoryBackendSelfReferencingForeignKey(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE employees (
+		id INT PRIMARY KEY,
+		manager INT REFERENCES employees ON DELETE CASCADE
+	);
+	INSERT INTO employees VALUES (1, 1);
+	INSERT INTO employees VALUES (2, 1);
+	INSERT INTO employees VALUES (3, 2);
+	INSERT INTO employees VALUES (4, NULL);`)
+	assert.Nil(t, err)
+
+	_, err = execute(mb, "INSERT INTO employees VALUES (5, 6)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	results, err := execute(mb, "DELETE FROM employees WHERE id = 2; SELECT id FROM employees")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1)}, {int32(4)}}, cellValues(results))
+}
+
+func TestMemoryBackendDefaults(t *testing.T) {
+	mb := NewMemoryBackend()
+	results, err := execute(mb, `CREATE TABLE events (
+		id INT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT 'event' || '!',
+		priority FLOAT DEFAULT 1,
+		note TEXT,
+		created TEXT DEFAULT now()
+	);
+	INSERT INTO events (id) VALUES (1);
+	INSERT INTO events (note, id, priority) VALUES ('late', 2, 0.5);
+	INSERT INTO events (id, name, priority) VALUES (3, 'x', NULL);
+	INSERT INTO events VALUES (4, 'y', 2, NULL, NULL);
+	SELECT id, name, priority, note, created IS NOT NULL FROM events`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{
+		{int32(1), "event!", float64(1), nil, true},
+		{int32(2), "event!", 0.5, "late", true},
+		{int32(3), "x", nil, nil, true},
+		{int32(4), "y", float64(2), nil, false},
+	}, cellValues(results))
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "INSERT INTO events (id, missing) VALUES (5, 1)", err: ErrColumnDoesNotExist},
+		{source: "INSERT INTO events (id, id) VALUES (5, 5)", err: ErrDuplicateColumn},
+		{source: "INSERT INTO events (id, name) VALUES (5)", err: ErrMissingValues},
+		{source: "INSERT INTO events (id, name) VALUES (5, 1)", err: ErrMismatchedType},
+		{source: "INSERT INTO events (name) VALUES ('z')", err: ErrConstraintViolation},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func TestMemoryBackendCheckConstraints(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE products (
+		id INT PRIMARY KEY,
+		price FLOAT CHECK (price > 0),
+		discount FLOAT CHECK (discount >= 0) CHECK (1 = 1),
+		CONSTRAINT valid_discount CHECK (discount < price),
+		CHECK (id < 100)
+	);
+	INSERT INTO products VALUES (1, 10, 2);
+	INSERT INTO products VALUES (2, NULL, 5);`)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		source     string
+		constraint string
+		columns    []string
+	}{
+		{
+			source:     "INSERT INTO products VALUES (3, 0, 0)",
+			constraint: "products_price_check",
+			columns:    []string{"price"},
+		},
+		{
+			source:     "INSERT INTO products VALUES (3, 5, -1)",
+			constraint: "products_discount_check",
+			columns:    []string{"discount"},
+		},
+		{
+			source:     "INSERT INTO products VALUES (3, 5, 5)",
+			constraint: "valid_discount",
+			columns:    []string{"discount", "price"},
+		},
+		{
+			source:     "INSERT INTO products VALUES (100, 5, 1)",
+			constraint: "products_id_check",
+			columns:    []string{"id"},
+		},
+		{
+			source:     "UPDATE products SET price = 1 WHERE id = 1",
+			constraint: "valid_discount",
+			columns:    []string{"discount", "price"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, ErrConstraintViolation), test.source)
+
+		var violation *ConstraintViolationError
+		if assert.True(t, errors.As(err, &violation), test.source) {
+			assert.Equal(t, CheckConstraint, violation.Kind, test.source)
+			assert.Equal(t, "products", violation.Table, test.source)
+			assert.Equal(t, test.constraint, violation.Constraint, test.source)
+			assert.Equal(t, test.columns, violation.Columns, test.source)
+		}
+	}
+
+	results, err := execute(mb, "UPDATE products SET price = price * 2; SELECT id, price FROM products")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), float64(20)}, {int32(2), nil}}, cellValues(results))
+}
+
+func TestMemoryBackendSequences(t *testing.T) {
+	mb := NewMemoryBackend()
+	results, err := execute(mb, `CREATE SEQUENCE ids;
+	CREATE SEQUENCE countdown START WITH 10 INCREMENT BY -5;
+	SELECT nextval('ids'), nextval('ids'), currval('ids'), nextval('countdown'), nextval('countdown')`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), int32(2), int32(2), int32(10), int32(5)}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT setval('ids', 41), currval('ids'), nextval('ids'), setval('ids', 7, false), currval('ids'), nextval('ids')")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(41), int32(41), int32(42), int32(7), int32(42), int32(7)}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT setval('ids', 100, true), currval('ids'), setval('ids', 200, false), currval('ids')")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(100), int32(100), int32(200), int32(100)}}, cellValues(results))
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "CREATE SEQUENCE ids", err: ErrSequenceExists},
+		{source: "CREATE SEQUENCE s INCREMENT 0", err: ErrInvalidSequence},
+		{source: "CREATE SEQUENCE s START 'one'", err: ErrInvalidSequence},
+		{source: "SELECT nextval('missing')", err: ErrSequenceDoesNotExist},
+		{source: "CREATE SEQUENCE fresh; SELECT currval('fresh')", err: ErrSequenceNotStarted},
+		{source: "SELECT setval('fresh', 5, false), currval('fresh')", err: ErrSequenceNotStarted},
+		{source: "CREATE SEQUENCE big START 2147483647; SELECT nextval('big'), nextval('big')", err: ErrIntegerOutOfRange},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func TestMemoryBackendSerialColumns(t *testing.T) {
+	mb := NewMemoryBackend()
+	results, err := execute(mb, `CREATE TABLE notes (id SERIAL PRIMARY KEY, n BIGSERIAL, body TEXT);
+	INSERT INTO notes (body) VALUES ('a');
+	INSERT INTO notes (body) VALUES ('b');
+	INSERT INTO notes (id, body) VALUES (10, 'c');
+	INSERT INTO notes (body) VALUES ('d');
+	SELECT id, n, body FROM notes`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{
+		{int32(1), int32(1), "a"},
+		{int32(2), int32(2), "b"},
+		{int32(10), int32(3), "c"},
+		{int32(3), int32(4), "d"},
+	}, cellValues(results))
+
+	results, err = execute(mb, "SELECT currval('notes_id_seq'), currval('notes_n_seq')")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(3), int32(4)}}, cellValues(results))
+
+	_, err = execute(mb, "INSERT INTO notes (id, body) VALUES (NULL, 'e')")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	_, err = execute(mb, "CREATE TABLE notes2 (id SERIAL DEFAULT 1)")
+	assert.True(t, errors.Is(err, ErrInvalidConstraint))
+
+	// A failed CREATE TABLE leaves no sequence behind.
+	_, err = execute(mb, "CREATE TABLE broken (id SERIAL, PRIMARY KEY (missing))")
+	assert.True(t, errors.Is(err, ErrColumnDoesNotExist))
+
+	_, err = execute(mb, "SELECT nextval('broken_id_seq')")
+	assert.True(t, errors.Is(err, ErrSequenceDoesNotExist))
+}
+
+func TestMemoryBackendReturning(t *testing.T) {
+	mb := NewMemoryBackend()
+	results, err := execute(mb, `CREATE TABLE notes (id SERIAL PRIMARY KEY, body TEXT, stars INT DEFAULT 0);
+	INSERT INTO notes (body) VALUES ('a') RETURNING id, stars, upper(body)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "id", results.Columns[0].Name)
+	assert.Equal(t, IntType, results.Columns[0].Type)
+	assert.Equal(t, "stars", results.Columns[1].Name)
+	assert.Equal(t, "upper", results.Columns[2].Name)
+	assert.Equal(t, TextType, results.Columns[2].Type)
+	assert.Equal(t, [][]interface{}{{int32(1), int32(0), "A"}}, cellValues(results))
+
+	results, err = execute(mb, "INSERT INTO notes (body) VALUES ('b')")
+	assert.Nil(t, err)
+	assert.Nil(t, results)
+
+	results, err = execute(mb, "UPDATE notes SET stars = stars + id RETURNING id, stars * 10")
+	assert.Nil(t, err)
+	assert.Equal(t, "?column?", results.Columns[1].Name)
+	assert.Equal(t, [][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}}, cellValues(results))
+
+	results, err = execute(mb, "DELETE FROM notes WHERE id = 2 RETURNING body, stars")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"b", int32(2)}}, cellValues(results))
+
+	results, err = execute(mb, "DELETE FROM notes WHERE id = 2 RETURNING id")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results.Rows))
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "INSERT INTO notes (body) VALUES ('c') RETURNING missing", err: ErrColumnDoesNotExist},
+		{source: "UPDATE notes SET stars = 1 RETURNING count(*)", err: ErrInvalidAggregate},
+		{source: "DELETE FROM notes RETURNING 1 / (id - 1)", err: ErrDivisionByZero},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+
+	// Failed writes change nothing.
+	results, err = execute(mb, "SELECT id, stars FROM notes")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), int32(1)}}, cellValues(results))
+}
+
+func TestMemoryBackendUpsert(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE counters (name TEXT PRIMARY KEY, hits INT NOT NULL, label TEXT UNIQUE);
+	INSERT INTO counters VALUES ('a', 1, 'first');`)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		source   string
+		returned [][]interface{}
+		counters [][]interface{}
+	}{
+		{
+			source:   "INSERT INTO counters VALUES ('a', 5, 'other') ON CONFLICT DO NOTHING RETURNING name",
+			returned: [][]interface{}{},
+			counters: [][]interface{}{{"a", int32(1), "first"}},
+		},
+		{
+			source:   "INSERT INTO counters VALUES ('b', 5, 'first') ON CONFLICT DO NOTHING RETURNING name",
+			returned: [][]interface{}{},
+			counters: [][]interface{}{{"a", int32(1), "first"}},
+		},
+		{
+			source:   "INSERT INTO counters VALUES ('b', 2, NULL) ON CONFLICT (name) DO NOTHING RETURNING name",
+			returned: [][]interface{}{{"b"}},
+			counters: [][]interface{}{{"a", int32(1), "first"}, {"b", int32(2), nil}},
+		},
+		{
+			source:   "INSERT INTO counters VALUES ('a', 3, NULL) ON CONFLICT (name) DO UPDATE SET hits = counters.hits + excluded.hits RETURNING name, hits",
+			returned: [][]interface{}{{"a", int32(4)}},
+			counters: [][]interface{}{{"a", int32(4), "first"}, {"b", int32(2), nil}},
+		},
+		{
+			source:   "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (name) DO UPDATE SET hits = excluded.hits WHERE excluded.hits > hits RETURNING hits",
+			returned: [][]interface{}{},
+			counters: [][]interface{}{{"a", int32(4), "first"}, {"b", int32(2), nil}},
+		},
+		{
+			source:   "INSERT INTO counters (name, hits, label) VALUES ('b', 9, 'second') ON CONFLICT (name) DO UPDATE SET hits = excluded.hits, label = excluded.label RETURNING label",
+			returned: [][]interface{}{{"second"}},
+			counters: [][]interface{}{{"a", int32(4), "first"}, {"b", int32(9), "second"}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(mb, test.source)
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.returned, cellValues(results), test.source)
+
+		results, err = execute(mb, "SELECT name, hits, label FROM counters")
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.counters, cellValues(results), test.source)
+	}
+
+	errorTests := []struct {
+		source string
+		err    error
+	}{
+		// Conflicts on other constraints than the arbiter still fail.
+		{source: "INSERT INTO counters VALUES ('c', 1, 'first') ON CONFLICT (name) DO NOTHING", err: ErrConstraintViolation},
+		{source: "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (name) DO UPDATE SET label = 'second'", err: ErrConstraintViolation},
+		{source: "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (hits) DO NOTHING", err: ErrInvalidConstraint},
+		{source: "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (name) DO UPDATE SET hits = excluded.missing", err: ErrColumnDoesNotExist},
+		{source: "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (name) DO UPDATE SET hits = excluded.label", err: ErrMismatchedType},
+		{source: "INSERT INTO counters VALUES ('a', 1, NULL) ON CONFLICT (name) DO UPDATE SET hits = 1 WHERE excluded.hits", err: ErrInvalidCondition},
+	}
+
+	for _, test := range errorTests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func TestMemoryBackendInsertSelect(t *testing.T) {
+	mb := newUsersBackend(t)
+	results, err := execute(mb, `CREATE TABLE adults (id SERIAL PRIMARY KEY, name TEXT NOT NULL, decade INT);
+	INSERT INTO adults (name, decade) SELECT upper(name), age / 10 FROM users WHERE age >= 18 RETURNING id, name;`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), "ALICE"}}, cellValues(results))
+
+	// The SELECT doesn't see the rows the INSERT adds.
+	results, err = execute(mb, "INSERT INTO adults (name) SELECT name FROM adults; SELECT id, name, decade FROM adults")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), "ALICE", int32(3)}, {int32(2), "ALICE", nil}}, cellValues(results))
+
+	results, err = execute(mb, "INSERT INTO adults SELECT id, name, 0 FROM users ON CONFLICT (id) DO UPDATE SET decade = excluded.id RETURNING id, decade")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), int32(1)}, {int32(2), int32(2)}, {int32(3), int32(0)}}, cellValues(results))
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "INSERT INTO adults (name) SELECT id FROM users", err: ErrMismatchedType},
+		{source: "INSERT INTO adults (name) SELECT name, age FROM users", err: ErrMissingValues},
+		{source: "INSERT INTO adults (name, decade) SELECT name, 1 / (age - 17) FROM users", err: ErrDivisionByZero},
+		// A late failure leaves none of the rows behind.
+		{source: "INSERT INTO adults (id, name) SELECT 10, name FROM users", err: ErrConstraintViolation},
+		{source: "INSERT INTO adults (id, name) SELECT 10 + id, CASE WHEN id = 3 THEN NULL ELSE name END FROM users", err: ErrConstraintViolation},
+		{source: "INSERT INTO adults SELECT 1, 'x', 1 FROM users ON CONFLICT (id) DO UPDATE SET decade = 0", err: ErrCardinalityViolation},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+
+	results, err = execute(mb, "SELECT count(*) FROM adults")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(3)}}, cellValues(results))
+}
+
+func TestMemoryBackendCreateTableAs(t *testing.T) {
+	mb := newUsersBackend(t)
+	results, err := execute(mb, `CREATE TABLE summary AS SELECT name AS who, age * 1.5 AS score, age IS NULL AS unknown, NULL AS empty FROM users WHERE id < 3;
+	SELECT who, score, unknown, empty FROM summary`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"Alice", 46.5, false, nil}, {"bob", nil, true, nil}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT count(*) FROM summary")
+	assert.Nil(t, err)
+	assert.Equal(t, "count", results.Columns[0].Name)
+
+	results, err = execute(mb, "INSERT INTO summary VALUES ('x', 1, true, 'y') RETURNING score, empty")
+	assert.Nil(t, err)
+	assert.Equal(t, FloatType, results.Columns[0].Type)
+	assert.Equal(t, TextType, results.Columns[1].Type)
+
+	_, err = execute(mb, "CREATE TABLE broken AS SELECT id, age AS id FROM users")
+	assert.True(t, errors.Is(err, ErrDuplicateColumn))
+
+	_, err = execute(mb, "CREATE TABLE broken AS SELECT id FROM missing")
+	assert.Equal(t, ErrTableDoesNotExist, err)
+}
+
+func TestMemoryBackendAlterTable(t *testing.T) {
+	mb := newUsersBackend(t)
+	results, err := execute(mb, `ALTER TABLE users ADD COLUMN active BOOLEAN DEFAULT true NOT NULL;
+	ALTER TABLE users ADD COLUMN nickname TEXT;
+	ALTER TABLE users ADD COLUMN n SERIAL UNIQUE;
+	SELECT id, active, nickname, n FROM users`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{
+		{int32(1), true, nil, int32(1)},
+		{int32(2), true, nil, int32(2)},
+		{int32(3), true, nil, int32(3)},
+	}, cellValues(results))
+
+	results, err = execute(mb, `INSERT INTO users (id, name) VALUES (4, 'Dave');
+	ALTER TABLE users DROP COLUMN age;
+	ALTER TABLE users RENAME COLUMN name TO full_name;
+	SELECT full_name, active, n FROM users WHERE id = 4`)
+	assert.Nil(t, err)
+	assert.Equal(t, "full_name", results.Columns[0].Name)
+	assert.Equal(t, [][]interface{}{{"Dave", true, int32(4)}}, cellValues(results))
+
+	_, err = execute(mb, "SELECT age FROM users")
+	assert.True(t, errors.Is(err, ErrColumnDoesNotExist))
+
+	results, err = execute(mb, `ALTER TABLE users ALTER COLUMN id TYPE TEXT;
+	ALTER TABLE users RENAME TO people;
+	SELECT id || '!' FROM people WHERE full_name = 'bob'`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"2!"}}, cellValues(results))
+
+	_, err = execute(mb, "SELECT id FROM users")
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	// Failed changes leave the table as it was.
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "ALTER TABLE missing ADD c INT", err: ErrTableDoesNotExist},
+		{source: "ALTER TABLE people ADD active INT", err: ErrDuplicateColumn},
+		{source: "ALTER TABLE people ADD c INT NOT NULL", err: ErrConstraintViolation},
+		{source: "ALTER TABLE people ADD c INT DEFAULT 1 UNIQUE", err: ErrConstraintViolation},
+		{source: "ALTER TABLE people ADD c TEXT DEFAULT 1", err: ErrMismatchedType},
+		{source: "ALTER TABLE people DROP COLUMN missing", err: ErrColumnDoesNotExist},
+		{source: "ALTER TABLE people RENAME full_name TO id", err: ErrDuplicateColumn},
+		{source: "ALTER TABLE people ALTER COLUMN full_name TYPE INT", err: ErrMismatchedType},
+		{source: "ALTER TABLE people ALTER COLUMN n TYPE SERIAL", err: ErrInvalidDatatype},
+		{source: "CREATE TABLE other (a INT); ALTER TABLE people RENAME TO other", err: ErrTableExists},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+
+	results, err = execute(mb, "SELECT id, full_name, active, nickname, n FROM people")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"1", "Alice", true, nil, int32(1)},
+		{"2", "bob", true, nil, int32(2)},
+		{"3", "Carol", true, nil, int32(3)},
+		{"4", "Dave", true, nil, int32(4)},
+	}, cellValues(results))
+}
+
+func TestMemoryBackendAlterTableConstraints(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+	_, err := execute(mb, `CREATE TABLE goods (id INT PRIMARY KEY, qty INT CHECK (qty > 0), price FLOAT, CHECK (price < goods.qty * 10));
+	INSERT INTO goods VALUES (1, 2, 5.5)`)
+	assert.Nil(t, err)
+
+	// Checks follow renamed columns and tables.
+	_, err = execute(mb, `ALTER TABLE goods RENAME qty TO quantity;
+	ALTER TABLE goods RENAME TO stock`)
+	assert.Nil(t, err)
+	_, err = execute(mb, "INSERT INTO stock VALUES (2, 1, 20)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	// Dropping a column drops its constraints and shifts the others.
+	results, err := execute(mb, `ALTER TABLE stock DROP quantity;
+	INSERT INTO stock VALUES (2, 20);
+	SELECT id, price FROM stock`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1), 5.5}, {int32(2), float64(20)}}, cellValues(results))
+	_, err = execute(mb, "INSERT INTO stock VALUES (2, 1)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	results, err = execute(mb, `ALTER TABLE stock ALTER price TYPE INT; SELECT price FROM stock`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(6)}, {int32(20)}}, cellValues(results))
+
+	// Foreign keys keep working across changes to the parent.
+	_, err = execute(mb, `ALTER TABLE customers ADD COLUMN vip BOOLEAN;
+	ALTER TABLE customers RENAME TO clients;
+	ALTER TABLE orders ADD item INT REFERENCES stock`)
+	assert.Nil(t, err)
+	_, err = execute(mb, "INSERT INTO orders VALUES (100, 1, 3)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+	_, err = execute(mb, "INSERT INTO orders VALUES (100, 1, 2); DELETE FROM clients WHERE id = 1")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	tests := []string{
+		"ALTER TABLE clients DROP id",
+		"ALTER TABLE clients ALTER id TYPE TEXT",
+		"ALTER TABLE orders ALTER customer TYPE FLOAT",
+	}
+	for _, source := range tests {
+		_, err := execute(mb, source)
+		assert.True(t, errors.Is(err, ErrDependentObjects), source)
+	}
+
+	_, err = execute(mb, "ALTER TABLE orders ADD c INT REFERENCES clients")
+	assert.Nil(t, err)
+	_, err = execute(mb, "ALTER TABLE orders ADD d INT DEFAULT 7 REFERENCES clients")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+}
+
+func TestBtree(t *testing.T) {
+	tree := newBtree([]ColumnType{IntType})
+	entry := func(i int) indexEntry {
+		// Every third key is NULL and the rest repeat, so that entries
+		// are told apart by their rows.
+		var key MemoryCell
+		if i%3 != 0 {
+			key = intToCell(int32(i % 50))
+		}
+		return indexEntry{key: []MemoryCell{key}, row: uint64(i)}
+	}
+
+	// entries lists the entries of the tree in order and checks that
+	// every node but the root holds enough of them.
+	entries := func() []int {
+		rows := []int{}
+		var walk func(n *btreeNode)
+		walk = func(n *btreeNode) {
+			if n != tree.root {
+				assert.True(t, len(n.entries) >= btreeDegree-1)
+			}
+			assert.True(t, len(n.entries) <= 2*btreeDegree-1)
+			for i, e := range n.entries {
+				if !n.leaf() {
+					walk(n.children[i])
+				}
+				rows = append(rows, int(e.row))
+			}
+			if !n.leaf() {
+				walk(n.children[len(n.entries)])
+			}
+		}
+		walk(tree.root)
+		return rows
+	}
+
+	// sorted orders rows like the tree orders their entries.
+	sorted := func(rows map[int]bool) []int {
+		all := []indexEntry{}
+		for i := range rows {
+			all = append(all, entry(i))
+		}
+		sort.Slice(all, func(i, j int) bool { return tree.compare(all[i], all[j]) < 0 })
+
+		result := []int{}
+		for _, e := range all {
+			result = append(result, int(e.row))
+		}
+		return result
+	}
+
+	rows := map[int]bool{}
+	for i := 0; i < 5000; i++ {
+		j := (i * 7919) % 5000
+		tree.insert(entry(j))
+		rows[j] = true
+	}
+	assert.Equal(t, sorted(rows), entries())
+
+	for i := 0; i < 5000; i += 2 {
+		j := (i * 104729) % 5000
+		tree.delete(entry(j))
+		delete(rows, j)
+	}
+	tree.delete(entry(100000))
+	assert.Equal(t, sorted(rows), entries())
+
+	found := []int{}
+	tree.ascend([]MemoryCell{intToCell(48)}, []ColumnType{IntType}, func(e indexEntry) bool {
+		found = append(found, int(e.key[0].AsInt()))
+		return len(found) < 3
+	})
+	// Only odd rows are left, so no key is 48.
+	assert.Equal(t, []int{49, 49, 49}, found)
+
+	// A clone shares the nodes of the tree, and changing either leaves the
+	// other alone.
+	before := entries()
+	original := tree
+	tree = tree.clone()
+	assert.True(t, tree.root == original.root)
+	changed := map[int]bool{100001: true}
+	for i := range rows {
+		if i%3 == 1 {
+			tree.delete(entry(i))
+		} else {
+			changed[i] = true
+		}
+	}
+	tree.insert(entry(100001))
+	assert.Equal(t, sorted(changed), entries())
+	tree = original
+	assert.Equal(t, before, entries())
+
+	for i := range rows {
+		tree.delete(entry(i))
+	}
+	assert.Equal(t, []int{}, entries())
+	assert.True(t, tree.root.leaf())
+}
+
+func TestRowHeap(t *testing.T) {
+	heap := newRowHeap()
+	row := func(id, n int) []MemoryCell {
+		return []MemoryCell{intToCell(int32(id)), intToCell(int32(n))}
+	}
+
+	// contents lists the ids of the rows of h in order, checking each row
+	// against its id and n.
+	contents := func(h *rowHeap, n int) []int {
+		ids := []int{}
+		next := h.scan()
+		for r, ok := next(); ok; r, ok = next() {
+			ids = append(ids, int(r.version.id))
+			assert.Equal(t, row(int(r.version.id), n), r.cells)
+		}
+		assert.Equal(t, len(ids), h.len())
+		return ids
+	}
+
+	all := []int{}
+	for i := 1; i <= 5000; i++ {
+		heap.put(rowVersion{id: uint64(i)}, row(i, 0))
+		all = append(all, i)
+	}
+	assert.Equal(t, all, contents(heap, 0))
+	r, ok := heap.get(2500)
+	assert.True(t, ok)
+	assert.Equal(t, row(2500, 0), r.cells)
+	_, ok = heap.get(5001)
+	assert.False(t, ok)
+
+	// Changing a clone leaves the heap alone.
+	copied := heap.clone()
+	odd := []int{}
+	for _, i := range all {
+		if i%2 == 0 {
+			copied.delete(uint64(i))
+		} else {
+			copied.put(rowVersion{id: uint64(i), xmin: 1}, row(i, 1))
+			odd = append(odd, i)
+		}
+	}
+	copied.delete(10000)
+	assert.Equal(t, odd, contents(copied, 1))
+	assert.Equal(t, all, contents(heap, 0))
+
+	for _, i := range odd {
+		copied.delete(uint64(i))
+	}
+	assert.Equal(t, []int{}, contents(copied, 1))
+	assert.True(t, copied.root.leaf())
+	assert.Equal(t, all, contents(heap, 0))
+}
+
+func TestMemoryBackendIndexes(t *testing.T) {
+	// The same rows go into an indexed table and a plain one, which
+	// must always give the same results.
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE indexed (id INT PRIMARY KEY, a INT, b TEXT, c FLOAT);
+	CREATE TABLE plain (id INT, a INT, b TEXT, c FLOAT);
+	CREATE INDEX indexed_a_b ON indexed (a, b);
+	CREATE INDEX indexed_c ON indexed (c)`)
+	assert.Nil(t, err)
+
+	for i := 0; i < 300; i++ {
+		a, b, c := fmt.Sprint(i%10), fmt.Sprintf("'%c'", 'a'+i%7), fmt.Sprintf("%d.5", i%20)
+		switch {
+		case i%11 == 0:
+			a = "NULL"
+		case i%13 == 0:
+			b = "NULL"
+		case i%17 == 0:
+			c = "NULL"
+		}
+
+		for _, name := range []string{"indexed", "plain"} {
+			_, err := execute(mb, fmt.Sprintf("INSERT INTO %s VALUES (%d, %s, %s, %s)", name, i, a, b, c))
+			assert.Nil(t, err)
+		}
+	}
+
+	conditions := []string{
+		"id = 42",
+		"42 = id",
+		"id = 42.0",
+		"id = NULL",
+		"id > 290",
+		"id >= 10 AND id < 15",
+		"id BETWEEN 5 AND 8 AND a = 6",
+		"a = 3",
+		"a = 3 AND b = 'd'",
+		"a = 3 AND b > 'b' AND b <= 'e'",
+		"a = 3 AND b < 'c'",
+		"a >= 8",
+		"a < 2",
+		"c <= 3",
+		"c > 17.5",
+		"a = 3 OR id = 1",
+		"a = -1",
+		"b = 'a'",
+		"a = 1 + 2 AND id > 100",
+	}
+
+	check := func() {
+		for _, condition := range conditions {
+			expected, err := execute(mb, "SELECT id, a, b, c FROM plain WHERE "+condition)
+			assert.Nil(t, err, condition)
+			results, err := execute(mb, "SELECT id, a, b, c FROM indexed WHERE "+condition)
+			assert.Nil(t, err, condition)
+			assert.Equal(t, cellValues(expected), cellValues(results), condition)
+		}
+	}
+	check()
+
+	// The statements below keep both tables and the indexes in step.
+	for _, source := range []string{
+		"UPDATE %s SET a = a + 1 WHERE id < 100",
+		"DELETE FROM %s WHERE a = 5",
+		"UPDATE %s SET c = NULL WHERE c > 10",
+		"DELETE FROM %s WHERE id BETWEEN 150 AND 200",
+		"INSERT INTO %s VALUES (1000, 3, 'd', 1.5)",
+		"UPDATE %s SET id = id + 1000 WHERE b = 'c'",
+	} {
+		for _, name := range []string{"indexed", "plain"} {
+			_, err := execute(mb, fmt.Sprintf(source, name))
+			assert.Nil(t, err, source)
+		}
+		check()
+	}
+
+	indexed := mb.tables["indexed"]
+	for _, condition := range []string{"id = 1", "a = 3 AND b = 'd'", "c < 2", "b = 'a' AND id > 3"} {
+		where, _, _ := parseExpression(mustLex(t, condition), 0, 0)
+		_, ok := mb.indexScan(indexed, where)
+		assert.True(t, ok, condition)
+	}
+	for _, condition := range []string{"b = 'a'", "a = 1 OR id = 1", "id = random()", "a + 0 = 1"} {
+		where, _, _ := parseExpression(mustLex(t, condition), 0, 0)
+		_, ok := mb.indexScan(indexed, where)
+		assert.False(t, ok, condition)
+	}
+}
+
+func mustLex(t *testing.T, source string) []*token {
+	tokens, err := lex(source)
+	assert.Nil(t, err)
+	return tokens
+}
+
+func TestMemoryBackendCreateAndDropIndex(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+	_, err := execute(mb, `CREATE UNIQUE INDEX customers_name ON customers (name);
+	CREATE INDEX orders_customer ON orders (customer)`)
+	assert.Nil(t, err)
+
+	_, err = execute(mb, "INSERT INTO customers VALUES (3, 'Alice')")
+	var violation *ConstraintViolationError
+	if assert.True(t, errors.As(err, &violation)) {
+		assert.Equal(t, "customers_name", violation.Constraint)
+	}
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{source: "CREATE INDEX orders_customer ON items (id)", err: ErrIndexExists},
+		{source: "CREATE INDEX customers_pkey ON items (id)", err: ErrIndexExists},
+		{source: "CREATE INDEX x ON missing (id)", err: ErrTableDoesNotExist},
+		{source: "CREATE INDEX x ON items (missing)", err: ErrColumnDoesNotExist},
+		{source: "CREATE UNIQUE INDEX x ON orders (customer)", err: ErrConstraintViolation},
+		{source: "DROP INDEX missing", err: ErrIndexDoesNotExist},
+		{source: "DROP INDEX customers_pkey", err: ErrDependentObjects},
+		{source: "CREATE TABLE nicknames (name TEXT REFERENCES customers (name)); DROP INDEX customers_name", err: ErrDependentObjects},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+
+	_, err = execute(mb, `ALTER TABLE nicknames DROP name;
+	DROP INDEX customers_name;
+	DROP INDEX orders_customer;
+	INSERT INTO customers VALUES (3, 'Alice')`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mb.tables["customers"].indexes))
+
+	// Dropping a column drops its indexes.
+	_, err = execute(mb, `CREATE INDEX customers_name ON customers (name, id);
+	ALTER TABLE customers DROP name`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mb.tables["customers"].indexes))
+	_, err = execute(mb, "CREATE INDEX customers_name ON customers (id)")
+	assert.Nil(t, err)
+}
+
+func TestMemoryBackendJoins(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: "SELECT o.id, c.name FROM orders o JOIN customers c ON o.customer = c.id ORDER BY o.id",
+			rows:   [][]interface{}{{int32(10), "Alice"}, {int32(11), "Alice"}, {int32(12), "Bob"}},
+		},
+		{
+			source: "SELECT o.id, name FROM orders AS o LEFT JOIN customers c ON o.customer = c.id ORDER BY o.id DESC",
+			rows:   [][]interface{}{{int32(13), nil}, {int32(12), "Bob"}, {int32(11), "Alice"}, {int32(10), "Alice"}},
+		},
+		{
+			source: "SELECT o.id FROM orders o LEFT JOIN customers c ON o.customer = c.id WHERE c.id IS NULL",
+			rows:   [][]interface{}{{int32(13)}},
+		},
+		{
+			// Conditions on the left side of a LEFT JOIN's ON only decide
+			// which rows match.
+			source: "SELECT o.id, c.name FROM orders o LEFT OUTER JOIN customers c ON o.customer = c.id AND o.id > 10 ORDER BY o.id",
+			rows:   [][]interface{}{{int32(10), nil}, {int32(11), "Alice"}, {int32(12), "Bob"}, {int32(13), nil}},
+		},
+		{
+			source: "SELECT c.name, count(*) FROM customers c JOIN orders o ON o.customer = c.id INNER JOIN items i ON i.order_id = o.id GROUP BY c.name ORDER BY count(*) DESC",
+			rows:   [][]interface{}{{"Alice", int32(2)}, {"Bob", int32(1)}},
+		},
+		{
+			source: "SELECT c.name, o.id FROM customers c JOIN orders o ON o.customer < c.id ORDER BY c.name, o.id",
+			rows:   [][]interface{}{{"Bob", int32(10)}, {"Bob", int32(11)}},
+		},
+		{
+			source: "SELECT count(*) FROM orders JOIN customers ON customer = customers.id WHERE name = 'Alice'",
+			rows:   [][]interface{}{{int32(2)}},
+		},
+		{
+			source: "SELECT a.id, b.id FROM customers a JOIN customers b ON a.id + 1 = b.id",
+			rows:   [][]interface{}{{int32(1), int32(2)}},
+		},
+		{
+			source: "SELECT o.id, i.id FROM orders o LEFT JOIN items i ON i.order_id = o.id AND i.id <> 101 WHERE o.customer = 1 ORDER BY o.id",
+			rows:   [][]interface{}{{int32(10), int32(100)}, {int32(11), nil}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(mb, test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+
+	errs := []struct {
+		source string
+		err    error
+	}{
+		{source: "SELECT id FROM orders JOIN customers ON customer = customers.id", err: ErrColumnDoesNotExist},
+		{source: "SELECT name FROM orders JOIN missing ON customer = missing.id", err: ErrTableDoesNotExist},
+		{source: "SELECT name FROM orders JOIN customers ON 1", err: ErrInvalidCondition},
+		{source: "SELECT orders.id FROM orders o", err: ErrColumnDoesNotExist},
+	}
+	for _, test := range errs {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func TestMemoryBackendOrderByAndLimit(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: "SELECT customer AS c, id FROM orders ORDER BY c, id DESC",
+			rows:   [][]interface{}{{int32(1), int32(11)}, {int32(1), int32(10)}, {int32(2), int32(12)}, {nil, int32(13)}},
+		},
+		{
+			source: "SELECT id FROM orders ORDER BY customer DESC, 1",
+			rows:   [][]interface{}{{int32(13)}, {int32(12)}, {int32(10)}, {int32(11)}},
+		},
+		{
+			source: "SELECT id FROM orders ORDER BY id DESC LIMIT 2 OFFSET 1",
+			rows:   [][]interface{}{{int32(12)}, {int32(11)}},
+		},
+		{
+			source: "SELECT id FROM orders OFFSET 3",
+			rows:   [][]interface{}{{int32(13)}},
+		},
+		{
+			source: "SELECT id FROM orders LIMIT 0",
+			rows:   [][]interface{}{},
+		},
+		{
+			source: "SELECT id FROM orders OFFSET 10",
+			rows:   [][]interface{}{},
+		},
+		{
+			source: "SELECT count(*) FROM orders LIMIT NULL",
+			rows:   [][]interface{}{{int32(4)}},
+		},
+		{
+			source: "SELECT 1 + 2 LIMIT 1 + 1",
+			rows:   [][]interface{}{{int32(3)}},
+		},
+		{
+			source: "SELECT customer FROM orders GROUP BY customer ORDER BY count(*) DESC, customer",
+			rows:   [][]interface{}{{int32(1)}, {int32(2)}, {nil}},
+		},
+		{
+			source: "SELECT name FROM customers ORDER BY upper(name) DESC",
+			rows:   [][]interface{}{{"Bob"}, {"Alice"}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(mb, test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+
+	errs := []struct {
+		source string
+		err    error
+	}{
+		{source: "SELECT id FROM orders LIMIT -1", err: ErrInvalidLimit},
+		{source: "SELECT id FROM orders LIMIT 'a'", err: ErrInvalidLimit},
+		{source: "SELECT id FROM orders LIMIT id", err: ErrInvalidLimit},
+		{source: "SELECT id FROM orders OFFSET 1.5", err: ErrInvalidLimit},
+		{source: "SELECT id FROM orders ORDER BY 2", err: ErrColumnDoesNotExist},
+		{source: "SELECT id FROM orders ORDER BY missing", err: ErrColumnDoesNotExist},
+		{source: "SELECT count(*) FROM orders ORDER BY id", err: ErrNotGrouped},
+	}
+	for _, test := range errs {
+		_, err := execute(mb, test.source)
+		assert.True(t, errors.Is(err, test.err), test.source)
+	}
+}
+
+func explain(t *testing.T, mb Backend, source string) []string {
+	results, err := execute(mb, "EXPLAIN "+source)
+	assert.Nil(t, err, source)
+	if err != nil {
+		return nil
+	}
+
+	assert.Equal(t, "QUERY PLAN", results.Columns[0].Name)
+	lines := []string{}
+	for _, row := range results.Rows {
+		lines = append(lines, row[0].AsText())
+	}
+	return lines
+}
+
+func TestMemoryBackendExplain(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+	_, err := execute(mb, "CREATE TABLE big (id INT PRIMARY KEY, grp INT)")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		_, err := execute(mb, fmt.Sprintf("INSERT INTO big VALUES (%d, %d)", i, i%10))
+		assert.Nil(t, err)
+	}
+
+	// Constants are folded before the index is picked, and the condition
+	// it answers isn't checked again.
+	assert.Equal(t, []string{
+		"Project  (cost=11.97 rows=1)",
+		"  ->  Index Scan using big_pkey on big  (cost=10.97 rows=1)",
+		"        Index Cond: (id = 3)",
+	}, explain(t, mb, "SELECT grp FROM big WHERE id = 1 + 2"))
+
+	lines := explain(t, mb, "SELECT grp FROM big WHERE grp = 2 * 2 OR id = 1")
+	assert.Equal(t, "  ->  Seq Scan on big  (cost=1000.00 rows=6)", lines[1])
+	assert.Equal(t, "        Filter: ((grp = 4) OR (id = 1))", lines[2])
+
+	assert.Equal(t, []string{
+		"Limit  (cost=347.33 rows=1)",
+		"  Limit: 5",
+		"  Offset: 1",
+		"  ->  Project  (cost=347.33 rows=2)",
+		"        ->  Sort  (cost=345.66 rows=2)",
+		"              Sort Key: id DESC",
+		"              ->  Index Scan using big_pkey on big  (cost=343.30 rows=2)",
+		"                    Index Cond: (id < 500)",
+		"                    Filter: (grp = 3)",
+	}, explain(t, mb, "SELECT grp FROM big WHERE grp = 3 AND id < 500 ORDER BY id DESC LIMIT 5 OFFSET 1"))
+
+	// WHERE conditions move into the scans they filter, except onto the
+	// side of a LEFT JOIN that gets NULLs.
+	assert.Equal(t, []string{
+		"Project  (cost=710.63 rows=3)",
+		"  ->  HashAggregate  (cost=707.30 rows=3)",
+		"        Group Key: b.grp",
+		"        ->  Filter  (cost=703.97 rows=3)",
+		"              Filter: (c.name IS NULL)",
+		"              ->  Nested Loop Left Join  (cost=697.30 rows=7)",
+		"                    Join Filter: (c.id = o.customer)",
+		"                    ->  Hash Join  (cost=688.63 rows=7)",
+		"                          Hash Cond: (b.grp = o.id)",
+		"                          ->  Index Scan using big_pkey on big b  (cost=343.30 rows=333)",
+		"                                Index Cond: (id < 500)",
+		"                          ->  Seq Scan on orders o  (cost=4.00 rows=4)",
+		"                    ->  Seq Scan on customers c  (cost=2.00 rows=1)",
+		"                          Filter: (c.name <> 'x')",
+	}, explain(t, mb, "SELECT b.grp, count(*) FROM big b JOIN orders o ON o.id = b.grp LEFT JOIN customers c ON c.id = o.customer AND c.name <> 'x' WHERE b.id < 500 AND c.name IS NULL GROUP BY b.grp"))
+
+	// The hash table is built from the smaller side.
+	lines = explain(t, mb, "SELECT b.id FROM orders o JOIN big b ON b.grp = o.id")
+	assert.Equal(t, []string{
+		"  ->  Hash Join  (cost=2012.00 rows=20)",
+		"        Hash Cond: (o.id = b.grp)",
+		"        Build Side: left",
+	}, lines[1:4])
+
+	assert.Equal(t, []string{
+		"Project  (cost=2.00 rows=1)",
+		"  ->  Result  (cost=1.00 rows=1)",
+	}, explain(t, mb, "SELECT 1 + 2"))
+
+	_, err = execute(mb, "EXPLAIN SELECT missing FROM big")
+	assert.True(t, errors.Is(err, ErrColumnDoesNotExist))
+}
+
+func TestMemoryBackendExplainAnalyze(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+	lines := explain(t, mb, "ANALYZE SELECT o.id, c.name FROM orders o LEFT JOIN customers c ON o.customer = c.id WHERE o.id > 10 ORDER BY o.id LIMIT 2")
+	if !assert.Equal(t, 12, len(lines)) {
+		return
+	}
+
+	// Each operator shows its estimates and then what it actually did.
+	actual := regexp.MustCompile(`^ *(?:->  )?([A-Za-z ]+?)(?: on| using|  )\S*.*\(cost=[0-9.]+ rows=\d+\) \(actual time=\d+\.\d{3} ms rows=(\d+) loops=(\d+)\)$`)
+	rows := map[string]string{}
+	for _, line := range lines[:len(lines)-2] {
+		if strings.Contains(line, "(cost=") {
+			m := actual.FindStringSubmatch(line)
+			if assert.NotNil(t, m, line) {
+				assert.Equal(t, "1", m[3], line)
+				rows[m[1]] = m[2]
+			}
+		}
+	}
+	// The sort reads all of its input, but the limit stops reading it after
+	// two rows.
+	assert.Equal(t, map[string]string{
+		"Limit":                 "2",
+		"Project":               "2",
+		"Sort":                  "2",
+		"Nested Loop Left Join": "3",
+		"Index Scan":            "3",
+		"Seq Scan":              "2",
+	}, rows)
+
+	timing := regexp.MustCompile(`^(Planning|Execution) Time: \d+\.\d{3} ms$`)
+	assert.True(t, timing.MatchString(lines[len(lines)-2]), lines[len(lines)-2])
+	assert.True(t, timing.MatchString(lines[len(lines)-1]), lines[len(lines)-1])
+
+	// Errors while running the query are reported.
+	_, err := execute(mb, "EXPLAIN ANALYZE SELECT 1 / (id - 10) FROM orders")
+	assert.True(t, errors.Is(err, ErrDivisionByZero))
+
+	// Without ANALYZE the query isn't run.
+	_, err = execute(mb, "EXPLAIN SELECT 1 / (id - 10) FROM orders")
+	assert.Nil(t, err)
+}
+
+func TestMemoryBackendAnalyze(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE t (id INT PRIMARY KEY, grp INT, name TEXT);
+	CREATE TABLE u (a INT)`)
+	assert.Nil(t, err)
+	for i := 1; i <= 100; i++ {
+		name := "NULL"
+		if i%4 != 0 {
+			name = fmt.Sprintf("'n%d'", i%3)
+		}
+		_, err = execute(mb, fmt.Sprintf("INSERT INTO t VALUES (%d, %d, %s)", i, i%5, name))
+		assert.Nil(t, err)
+	}
+
+	// There are no statistics until ANALYZE runs.
+	results, err := execute(mb, "SELECT tablename, attname, row_count, null_frac, n_distinct, min_value, max_value, most_common_vals, most_common_freqs, histogram_bounds FROM pg_stats")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(results.Columns))
+	assert.Equal(t, 0, len(results.Rows))
+
+	_, err = execute(mb, "ANALYZE missing")
+	assert.Equal(t, ErrTableDoesNotExist, err)
+
+	_, err = execute(mb, "ANALYZE t")
+	assert.Nil(t, err)
+	results, err = execute(mb, "SELECT attname, row_count, null_frac, n_distinct, min_value, max_value, most_common_vals, most_common_freqs, histogram_bounds FROM pg_stats WHERE tablename = 't'")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"id", int32(100), 0.0, int32(100), "1", "100", nil, nil, "{1,10,20,30,40,50,60,70,80,90,100}"},
+		{"grp", int32(100), 0.0, int32(5), "0", "4", "{0,1,2,3,4}", "{0.2,0.2,0.2,0.2,0.2}", nil},
+		{"name", int32(100), 0.25, int32(3), "n0", "n2", "{n0,n1,n2}", "{0.25,0.25,0.25}", nil},
+	}, cellValues(results))
+
+	// ANALYZE without a table collects the statistics of all of them.
+	_, err = execute(mb, "ANALYZE")
+	assert.Nil(t, err)
+	results, err = execute(mb, "SELECT tablename, attname, row_count, min_value FROM pg_stats WHERE tablename = 'u'")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"u", "a", int32(0), nil}}, cellValues(results))
+
+	// The planner goes by the statistics: nearly every id is greater than
+	// 1, so reading them through the index costs more than the whole table.
+	assert.Equal(t, []string{
+		"Project  (cost=200.00 rows=100)",
+		"  ->  Seq Scan on t  (cost=100.00 rows=100)",
+		"        Filter: (id > 1)",
+	}, explain(t, mb, "SELECT id FROM t WHERE id > 1"))
+	assert.Equal(t, []string{
+		"Project  (cost=28.66 rows=11)",
+		"  ->  Index Scan using t_pkey on t  (cost=17.66 rows=11)",
+		"        Index Cond: (id < 11)",
+	}, explain(t, mb, "SELECT id FROM t WHERE id < 11"))
+	assert.Equal(t, []string{
+		"Project  (cost=125.00 rows=25)",
+		"  ->  Seq Scan on t  (cost=100.00 rows=25)",
+		"        Filter: (name IS NULL)",
+	}, explain(t, mb, "SELECT id FROM t WHERE name IS NULL"))
+	assert.Equal(t, []string{
+		"Project  (cost=120.00 rows=20)",
+		"  ->  Seq Scan on t  (cost=100.00 rows=20)",
+		"        Filter: (grp = 3)",
+	}, explain(t, mb, "SELECT id FROM t WHERE grp = 3"))
+}
+
+func TestMemoryBackendQuery(t *testing.T) {
+	mb := newOrdersBackend(t, "")
+
+	query := func(source string) *Rows {
+		ast, err := Parse(source)
+		assert.Nil(t, err)
+		rows, err := mb.Query(ast.Statements[0].SelectStatement)
+		assert.Nil(t, err)
+		return rows
+	}
+
+	rows := query("SELECT o.id, c.name FROM orders o LEFT JOIN customers c ON o.customer = c.id ORDER BY o.id")
+	assert.Equal(t, "id", rows.Columns[0].Name)
+	assert.Equal(t, TextType, rows.Columns[1].Type)
+	ids := []int32{}
+	for rows.Next() {
+		ids = append(ids, rows.Row()[0].AsInt())
+	}
+	assert.Nil(t, rows.Err())
+	assert.Equal(t, []int32{10, 11, 12, 13}, ids)
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Close())
+
+	// Rows are produced as they're read, so an error in a later row only
+	// comes up once it's reached.
+	rows = query("SELECT 10 / (id - 11) FROM orders ORDER BY id")
+	assert.True(t, rows.Next())
+	assert.Equal(t, int32(-10), rows.Row()[0].AsInt())
+	assert.False(t, rows.Next())
+	assert.True(t, errors.Is(rows.Err(), ErrDivisionByZero))
+
+	// A closed cursor produces no more rows.
+	rows = query("SELECT id FROM orders")
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Close())
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Err())
+}
+
+func TestMemoryBackendStreaming(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, "CREATE TABLE t (id INT)")
+	assert.Nil(t, err)
+	for i := 1; i <= 100; i++ {
+		_, err = execute(mb, fmt.Sprintf("INSERT INTO t VALUES (%d)", i))
+		assert.Nil(t, err)
+	}
+
+	// Operators only read as many rows from their input as the rows asked
+	// of them need.
+	for _, test := range []struct {
+		query string
+		read  string
+	}{
+		{"SELECT id FROM t LIMIT 3", "3"},
+		{"SELECT id FROM t WHERE id % 10 = 0 LIMIT 2 OFFSET 1", "3"},
+		{"SELECT a.id FROM t a JOIN t b ON a.id = b.id LIMIT 5", "5"},
+		{"SELECT a.id FROM t a JOIN t b ON a.id < b.id LIMIT 5", "1"},
+	} {
+		lines := explain(t, mb, "ANALYZE "+test.query)
+		scan := regexp.MustCompile(`Seq Scan on t(?: a)?  .*rows=(\d+) loops=1\)$`)
+		found := false
+		for _, line := range lines {
+			if m := scan.FindStringSubmatch(line); m != nil && !found {
+				assert.Equal(t, test.read, m[1], test.query)
+				found = true
+			}
+		}
+		assert.True(t, found, test.query)
+	}
+}
+
+func TestMemoryBackendColumnar(t *testing.T) {
+	// The same rows in a heap table and a columnar one give the same
+	// results.
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE h (id INT PRIMARY KEY, grp INT, price FLOAT, name TEXT, flag BOOLEAN);
+	CREATE TABLE c (id INT PRIMARY KEY, grp INT, price FLOAT, name TEXT, flag BOOLEAN) USING columnar`)
+	assert.Nil(t, err)
+	for i := 1; i <= 3000; i++ {
+		values := fmt.Sprintf("(%d, %d, %d.5, 'n%d', %t)", i, i%7, i%100, i%13, i%2 == 0)
+		if i%10 == 0 {
+			values = fmt.Sprintf("(%d, NULL, NULL, NULL, NULL)", i)
+		}
+		_, err = execute(mb, "INSERT INTO h VALUES "+values+"; INSERT INTO c VALUES "+values)
+		assert.Nil(t, err)
+	}
+
+	for _, query := range []string{
+		"SELECT id, grp, price, name, flag FROM %s",
+		"SELECT id FROM %s WHERE grp = 3",
+		"SELECT id, name FROM %s WHERE grp >= 5 AND price < 20 AND name <> 'n1'",
+		"SELECT id FROM %s WHERE price > 90 OR id < 5",
+		"SELECT id FROM %s WHERE 4 < grp AND flag = true",
+		"SELECT id FROM %s WHERE price BETWEEN 10 AND 11",
+		"SELECT id FROM %s WHERE grp IS NULL AND id > 2900",
+		"SELECT id FROM %s WHERE grp IS NOT NULL AND id < 12",
+		"SELECT id FROM %s WHERE name = NULL",
+		"SELECT id FROM %s WHERE id BETWEEN 100 AND 110",
+		"SELECT id * 2, price + 1, upper(name) FROM %s WHERE id <= 20",
+		"SELECT count(*), count(grp), sum(grp), avg(price), sum(price), min(name), max(id), min(flag) FROM %s",
+		"SELECT grp, count(*), sum(id), min(price), max(name), avg(grp) FROM %s GROUP BY grp",
+		"SELECT name, flag, count(*) FROM %s GROUP BY name, flag ORDER BY name, flag",
+		"SELECT count(*), sum(grp) FROM %s WHERE id < 0",
+		"SELECT grp, count(*) FROM %s WHERE id < 0 GROUP BY grp",
+		"SELECT grp, sum(id) * 2 FROM %s GROUP BY grp",
+		"SELECT id FROM %s ORDER BY price DESC, id LIMIT 5",
+		"SELECT x.id FROM %[1]s x JOIN %[1]s y ON x.id = y.grp",
+	} {
+		heap, err := execute(mb, fmt.Sprintf(query, "h"))
+		assert.Nil(t, err, query)
+		columnar, err := execute(mb, fmt.Sprintf(query, "c"))
+		assert.Nil(t, err, query)
+		if heap != nil && columnar != nil {
+			assert.Equal(t, cellValues(heap), cellValues(columnar), query)
+		}
+	}
+
+	// Errors come up just the same.
+	_, err = execute(mb, "SELECT sum(id * 1000000) FROM c")
+	assert.True(t, errors.Is(err, ErrIntegerOutOfRange))
+	_, err = execute(mb, "SELECT 1 / (id - 5) FROM c")
+	assert.True(t, errors.Is(err, ErrDivisionByZero))
+	_, err = execute(mb, "CREATE TABLE bad (a INT) USING rows")
+	assert.True(t, errors.Is(err, ErrInvalidLayout))
+
+	assert.Equal(t, []string{
+		"Project  (cost=3010.00 rows=5)",
+		"  ->  Batch HashAggregate  (cost=3005.00 rows=5)",
+		"        Group Key: grp",
+		"        ->  Columnar Scan on c  (cost=3000.00 rows=5)",
+		"              Batch Filter: (price < 50.0)",
+		"              Filter: (length(name) = 2)",
+	}, explain(t, mb, "SELECT grp, count(*) FROM c WHERE price < 50.0 AND length(name) = 2 GROUP BY grp"))
+	assert.Equal(t, []string{
+		"Batch Project  (cost=4500.00 rows=1500)",
+		"  ->  Columnar Scan on c  (cost=3000.00 rows=1500)",
+		"        Batch Filter: (name IS NULL)",
+	}, explain(t, mb, "SELECT id FROM c WHERE name IS NULL"))
+	assert.Equal(t, []string{
+		"Project  (cost=13.55 rows=1)",
+		"  ->  Index Scan using c_pkey on c  (cost=12.55 rows=1)",
+		"        Index Cond: (id = 7)",
+	}, explain(t, mb, "SELECT id FROM c WHERE id = 7"))
+}
+
+func TestMemoryBackendColumnarWrites(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE c (id INT PRIMARY KEY, name TEXT DEFAULT 'x') USING columnar;
+	INSERT INTO c VALUES (1, 'a');
+	INSERT INTO c VALUES (2, 'b');
+	INSERT INTO c (id) VALUES (3);
+	UPDATE c SET name = 'B' WHERE id = 2;
+	DELETE FROM c WHERE id = 1;
+	INSERT INTO c VALUES (3, 'c') ON CONFLICT (id) DO UPDATE SET name = excluded.name;
+	ALTER TABLE c ADD COLUMN n INT DEFAULT 5;
+	ALTER TABLE c ALTER COLUMN n TYPE FLOAT;
+	CREATE INDEX c_name ON c (name);
+	CREATE TABLE d USING columnar AS SELECT id, name FROM c WHERE n > 1`)
+	assert.Nil(t, err)
+
+	results, err := execute(mb, "SELECT id, name, n FROM c ORDER BY id")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(2), "B", 5.0}, {int32(3), "c", 5.0}}, cellValues(results))
+	assert.NotNil(t, mb.tables["c"].columnar)
+
+	_, err = execute(mb, "INSERT INTO c VALUES (2, 'z', 1.0)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	results, err = execute(mb, "SELECT id FROM c WHERE name = 'c'")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(3)}}, cellValues(results))
+
+	results, err = execute(mb, "SELECT id, name FROM d ORDER BY id")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(2), "B"}, {int32(3), "c"}}, cellValues(results))
+	assert.NotNil(t, mb.tables["d"].columnar)
+	assert.Nil(t, mb.tables["d"].heap)
+}
+
+func TestMemoryBackendSnapshot(t *testing.T) {
+	mb := newOrdersBackend(t, "ON DELETE CASCADE")
+	_, err := execute(mb, `CREATE TABLE notes (id SERIAL PRIMARY KEY, body TEXT DEFAULT 'empty', score FLOAT CHECK (score >= 0)) USING columnar;
+	CREATE TABLE quoted (id INT, note TEXT DEFAULT 'it''s' CHECK (note <> 'don''t'));
+	INSERT INTO notes (score) VALUES (1.5);
+	INSERT INTO notes (body, score) VALUES (NULL, 2.0);
+	CREATE SEQUENCE counter START WITH 10;
+	SELECT nextval('counter');
+	CREATE INDEX orders_customer ON orders (customer)`)
+	assert.Nil(t, err)
+	for i := 0; i < 2*snapshotChunk; i++ {
+		_, err := execute(mb, fmt.Sprintf("INSERT INTO notes (body, score) VALUES ('%d', %d.0)", i, i))
+		assert.Nil(t, err)
+	}
+
+	var snapshot bytes.Buffer
+	assert.Nil(t, mb.Save(&snapshot))
+
+	loaded, err := LoadMemoryBackend(bytes.NewReader(snapshot.Bytes()))
+	assert.Nil(t, err)
+
+	for _, source := range []string{
+		"SELECT id, customer FROM orders ORDER BY id",
+		"SELECT id, order_id FROM items ORDER BY id",
+		"SELECT id, name FROM customers ORDER BY id",
+		"SELECT id, body, score FROM notes WHERE id < 5 ORDER BY id",
+		"SELECT count(*), sum(score) FROM notes",
+		"SELECT nextval('counter'), nextval('notes_id_seq')",
+	} {
+		want, err := execute(mb, source)
+		assert.Nil(t, err, source)
+		got, err := execute(loaded, source)
+		assert.Nil(t, err, source)
+		assert.Equal(t, cellValues(want), cellValues(got), source)
+	}
+	assert.NotNil(t, loaded.tables["notes"].columnar)
+
+	// Constraints, defaults and indexes come back with the rows.
+	for _, source := range []string{
+		"INSERT INTO customers VALUES (1, 'Dup')",
+		"INSERT INTO orders VALUES (20, 99)",
+		"INSERT INTO notes (score) VALUES (-1.0)",
+	} {
+		_, err := execute(loaded, source)
+		assert.True(t, errors.Is(err, ErrConstraintViolation), source)
+	}
+	results, err := execute(loaded, "INSERT INTO notes (score) VALUES (3.0) RETURNING body; DELETE FROM customers WHERE id = 1; SELECT count(*) FROM orders")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(2)}}, cellValues(results))
+	_, err = execute(loaded, "CREATE INDEX orders_customer ON orders (id)")
+	assert.True(t, errors.Is(err, ErrIndexExists))
+
+	// Quotes in defaults and checks come back as they were, however often
+	// the snapshot is saved again.
+	for i := 0; i < 2; i++ {
+		var again bytes.Buffer
+		assert.Nil(t, loaded.Save(&again))
+		loaded, err = LoadMemoryBackend(&again)
+		assert.Nil(t, err)
+	}
+	results, err = execute(loaded, "INSERT INTO quoted (id) VALUES (1) RETURNING note")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"it's"}}, cellValues(results))
+	_, err = execute(loaded, "INSERT INTO quoted VALUES (2, 'don''t')")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+
+	// Damage anywhere is caught by the checksums, and a snapshot cut short
+	// by its missing end.
+	data := snapshot.Bytes()
+	for _, at := range []int{len(snapshotMagic) + 8, len(data) / 2, len(data) - 3} {
+		damaged := append([]byte{}, data...)
+		damaged[at] ^= 0x40
+		_, err := LoadMemoryBackend(bytes.NewReader(damaged))
+		assert.True(t, errors.Is(err, ErrCorruptSnapshot), at)
+	}
+	for _, length := range []int{0, 5, len(data) / 3, len(data) - blockHeaderSize} {
+		_, err := LoadMemoryBackend(bytes.NewReader(data[:length]))
+		assert.True(t, errors.Is(err, ErrCorruptSnapshot), length)
+	}
+
+	future := append([]byte{}, data...)
+	future[len(snapshotMagic)+3] = snapshotVersion + 1
+	_, err = LoadMemoryBackend(bytes.NewReader(future))
+	assert.True(t, errors.Is(err, ErrUnsupportedSnapshot))
+}
+
+func TestMemoryBackendTransactions(t *testing.T) {
+	mb := newOrdersBackend(t, "ON DELETE CASCADE")
+	_, err := execute(mb, `CREATE TABLE notes (id SERIAL PRIMARY KEY, body TEXT) USING columnar;
+	INSERT INTO notes (body) VALUES ('first');
+	CREATE INDEX orders_customer ON orders (customer)`)
+	assert.Nil(t, err)
+
+	queries := []string{
+		"SELECT id, name FROM customers ORDER BY id",
+		"SELECT id, customer FROM orders ORDER BY id",
+		"SELECT id, order_id FROM items ORDER BY id",
+		"SELECT id, body FROM notes ORDER BY id",
+		"SELECT id FROM orders WHERE customer = 1 ORDER BY id",
+	}
+	state := func() [][][]interface{} {
+		values := [][][]interface{}{}
+		for _, source := range queries {
+			results, err := execute(mb, source)
+			assert.Nil(t, err, source)
+			values = append(values, cellValues(results))
+		}
+		return values
+	}
+	before := state()
+
+	_, err = execute(mb, `BEGIN;
+	INSERT INTO customers VALUES (3, 'Carol');
+	UPDATE orders SET customer = 3 WHERE id = 12;
+	DELETE FROM customers WHERE id = 1;
+	INSERT INTO notes (body) VALUES ('second');
+	ALTER TABLE customers ADD COLUMN email TEXT;
+	ALTER TABLE items RENAME TO lines;
+	CREATE TABLE audit (id INT);
+	CREATE SEQUENCE audit_seq;
+	CREATE INDEX customers_name ON customers (name);
+	DROP INDEX orders_customer`)
+	assert.Nil(t, err)
+
+	// The transaction sees its own changes, and a failed statement leaves
+	// them be.
+	_, err = execute(mb, "INSERT INTO customers VALUES (3, 'Again', NULL)")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+	results, err := execute(mb, "SELECT count(*) FROM customers")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(2)}}, cellValues(results))
+	results, err = execute(mb, "SELECT count(*) FROM lines")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(1)}}, cellValues(results))
+	assert.Equal(t, ErrTransactionInProgress, mb.Begin(&BeginStatement{}))
+
+	assert.Nil(t, mb.Rollback(&RollbackStatement{}))
+	assert.Equal(t, before, state())
+	for _, name := range []string{"audit", "lines"} {
+		_, ok := mb.tables[name]
+		assert.False(t, ok, name)
+	}
+	_, ok := mb.sequences["audit_seq"]
+	assert.False(t, ok)
+
+	// The keys and indexes are as they were too.
+	_, err = execute(mb, "INSERT INTO customers VALUES (1, 'Dup')")
+	assert.True(t, errors.Is(err, ErrConstraintViolation))
+	_, err = execute(mb, "CREATE INDEX orders_customer ON orders (id)")
+	assert.True(t, errors.Is(err, ErrIndexExists))
+
+	_, err = execute(mb, `BEGIN TRANSACTION;
+	INSERT INTO customers VALUES (3, 'Carol');
+	INSERT INTO notes (body) VALUES ('second');
+	ALTER TABLE items RENAME TO lines;
+	COMMIT`)
+	assert.Nil(t, err)
+	results, err = execute(mb, "SELECT id, name FROM customers WHERE id = 3")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(3), "Carol"}}, cellValues(results))
+	results, err = execute(mb, "SELECT count(*) FROM notes")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(2)}}, cellValues(results))
+	_, err = execute(mb, "SELECT id FROM lines")
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrNoTransaction, mb.Commit(&CommitStatement{}))
+	assert.Equal(t, ErrNoTransaction, mb.Rollback(&RollbackStatement{}))
+}
+
+func TestMemoryBackendSavepoints(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, "CREATE TABLE t (id INT PRIMARY KEY); INSERT INTO t VALUES (1)")
+	assert.Nil(t, err)
+
+	ids := func() [][]interface{} {
+		results, err := execute(mb, "SELECT id FROM t ORDER BY id")
+		assert.Nil(t, err)
+		return cellValues(results)
+	}
+
+	tests := []struct {
+		source string
+		err    error
+		ids    [][]interface{}
+	}{
+		{"BEGIN; INSERT INTO t VALUES (2); SAVEPOINT a", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"INSERT INTO t VALUES (3); CREATE INDEX t_id ON t (id); SAVEPOINT b; INSERT INTO t VALUES (4)", nil, [][]interface{}{{int32(1)}, {int32(2)}, {int32(3)}, {int32(4)}}},
+		{"ROLLBACK TO SAVEPOINT a", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		// Rolling back to a savepoint forgets the ones after it but keeps
+		// it.
+		{"ROLLBACK TO b", ErrSavepointDoesNotExist, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"INSERT INTO t VALUES (5); ROLLBACK TO a", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"CREATE INDEX t_id ON t (id)", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		// A later savepoint of the same name hides the earlier one until
+		// it's released.
+		{"INSERT INTO t VALUES (6); SAVEPOINT a; INSERT INTO t VALUES (7); ROLLBACK TO a", nil, [][]interface{}{{int32(1)}, {int32(2)}, {int32(6)}}},
+		{"RELEASE SAVEPOINT a; ROLLBACK TO a", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"RELEASE a; ROLLBACK TO a", ErrSavepointDoesNotExist, [][]interface{}{{int32(1)}, {int32(2)}}},
+		// A failed optional step is undone without the rest.
+		{"SAVEPOINT step; INSERT INTO t VALUES (8); INSERT INTO t VALUES (1)", ErrConstraintViolation, [][]interface{}{{int32(1)}, {int32(2)}, {int32(8)}}},
+		{"ROLLBACK TO step; COMMIT", nil, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"SAVEPOINT a", ErrNoTransaction, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"RELEASE a", ErrNoTransaction, [][]interface{}{{int32(1)}, {int32(2)}}},
+		{"ROLLBACK TO a", ErrNoTransaction, [][]interface{}{{int32(1)}, {int32(2)}}},
+	}
+
+	for _, test := range tests {
+		_, err := execute(mb, test.source)
+		if test.err == nil {
+			assert.Nil(t, err, test.source)
+		} else {
+			assert.True(t, errors.Is(err, test.err), test.source)
+		}
+		assert.Equal(t, test.ids, ids(), test.source)
+	}
+}
+
+func TestMemoryBackendSessions(t *testing.T) {
+	type step struct {
+		session int
+		source  string
+		err     error
+		rows    [][]interface{}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		rows  [][]interface{}
+	}{
+		{
+			"snapshot",
+			[]step{
+				{0, "BEGIN", nil, nil},
+				{1, "INSERT INTO t VALUES (3, 30); UPDATE t SET n = 11 WHERE id = 1", nil, nil},
+				{0, "SELECT id, n FROM t ORDER BY id", nil, [][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}}},
+				{1, "SELECT id, n FROM t ORDER BY id", nil, [][]interface{}{{int32(1), int32(11)}, {int32(2), int32(20)}, {int32(3), int32(30)}}},
+				{0, "COMMIT; SELECT id, n FROM t ORDER BY id", nil, [][]interface{}{{int32(1), int32(11)}, {int32(2), int32(20)}, {int32(3), int32(30)}}},
+			},
+			[][]interface{}{{int32(1), int32(11)}, {int32(2), int32(20)}, {int32(3), int32(30)}},
+		},
+		{
+			"same row",
+			[]step{
+				{0, "BEGIN; UPDATE t SET n = 11 WHERE id = 1", nil, nil},
+				{1, "BEGIN; UPDATE t SET n = 12 WHERE id = 1", nil, nil},
+				{0, "COMMIT", nil, nil},
+				{1, "COMMIT", ErrSerializationFailure, nil},
+				{1, "COMMIT", ErrNoTransaction, nil},
+			},
+			[][]interface{}{{int32(1), int32(11)}, {int32(2), int32(20)}},
+		},
+		{
+			"deleted row",
+			[]step{
+				{0, "BEGIN; UPDATE t SET n = 11 WHERE id = 1", nil, nil},
+				{1, "DELETE FROM t WHERE id = 1", nil, nil},
+				{0, "COMMIT", ErrSerializationFailure, nil},
+			},
+			[][]interface{}{{int32(2), int32(20)}},
+		},
+		{
+			"different rows",
+			[]step{
+				{0, "BEGIN; UPDATE t SET n = 11 WHERE id = 1; INSERT INTO t VALUES (4, 40)", nil, nil},
+				{1, "BEGIN; UPDATE t SET n = 21 WHERE id = 2; INSERT INTO t VALUES (3, 30); COMMIT", nil, nil},
+				{0, "COMMIT", nil, nil},
+			},
+			[][]interface{}{{int32(1), int32(11)}, {int32(2), int32(21)}, {int32(3), int32(30)}, {int32(4), int32(40)}},
+		},
+		{
+			"duplicate key",
+			[]step{
+				{0, "BEGIN; INSERT INTO t VALUES (3, 30)", nil, nil},
+				{1, "INSERT INTO t VALUES (3, 31)", nil, nil},
+				{0, "COMMIT", ErrConstraintViolation, nil},
+			},
+			[][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}, {int32(3), int32(31)}},
+		},
+		{
+			"altered table",
+			[]step{
+				{0, "BEGIN; ALTER TABLE t ADD COLUMN note TEXT", nil, nil},
+				{1, "INSERT INTO t VALUES (3, 30)", nil, nil},
+				{0, "COMMIT", ErrSerializationFailure, nil},
+			},
+			[][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}, {int32(3), int32(30)}},
+		},
+		{
+			"created table",
+			[]step{
+				{0, "BEGIN; CREATE TABLE u (id INT)", nil, nil},
+				{1, "CREATE TABLE u (id INT)", nil, nil},
+				{0, "COMMIT", ErrSerializationFailure, nil},
+			},
+			[][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}},
+		},
+		{
+			"foreign key",
+			[]step{
+				{0, "BEGIN; DELETE FROM t WHERE id = 2", nil, nil},
+				{1, "INSERT INTO c VALUES (1, 2)", nil, nil},
+				{0, "COMMIT", ErrSerializationFailure, nil},
+			},
+			[][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}},
+		},
+		{
+			"currval",
+			[]step{
+				{0, "CREATE SEQUENCE s; SELECT nextval('s')", nil, [][]interface{}{{int32(1)}}},
+				{1, "SELECT currval('s')", ErrSequenceNotStarted, nil},
+				{1, "BEGIN; SELECT nextval('s'); ROLLBACK; SELECT currval('s')", nil, [][]interface{}{{int32(2)}}},
+				{0, "SELECT currval('s')", nil, [][]interface{}{{int32(1)}}},
+				{1, "SELECT setval('s', 50, false), currval('s')", nil, [][]interface{}{{int32(50), int32(2)}}},
+				{0, "BEGIN; SELECT setval('s', 60); COMMIT; SELECT currval('s')", nil, [][]interface{}{{int32(60)}}},
+				{1, "SELECT currval('s')", nil, [][]interface{}{{int32(2)}}},
+			},
+			[][]interface{}{{int32(1), int32(10)}, {int32(2), int32(20)}},
+		},
+	}
+
+	for _, test := range tests {
+		mb := NewMemoryBackend()
+		_, err := execute(mb, `CREATE TABLE t (id INT PRIMARY KEY, n INT);
+		CREATE TABLE c (id INT, t_id INT REFERENCES t (id));
+		INSERT INTO t VALUES (1, 10);
+		INSERT INTO t VALUES (2, 20)`)
+		assert.Nil(t, err)
+		sessions := []*MemoryBackend{mb, mb.Session()}
+
+		for _, step := range test.steps {
+			results, err := execute(sessions[step.session], step.source)
+			if step.err == nil {
+				assert.Nil(t, err, test.name, step.source)
+			} else {
+				assert.True(t, errors.Is(err, step.err), test.name, step.source)
+			}
+			if step.rows != nil {
+				assert.Equal(t, step.rows, cellValues(results), test.name, step.source)
+			}
+		}
+
+		results, err := execute(mb, "SELECT id, n FROM t ORDER BY id")
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.rows, cellValues(results), test.name)
+		assert.Equal(t, 1, len(mb.db.versions), test.name)
+	}
+}
+
+func TestMemoryBackendVersionCollection(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, "CREATE TABLE t (id INT)")
+	assert.Nil(t, err)
+
+	// A transaction keeps the version it reads, and every version
+	// committed since, until it ends.
+	reader := mb.Session()
+	assert.Nil(t, reader.Begin(&BeginStatement{}))
+	for i := 0; i < 3; i++ {
+		_, err := execute(mb, fmt.Sprintf("INSERT INTO t VALUES (%d)", i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 4, len(mb.db.versions))
+
+	results, err := execute(reader, "SELECT count(*) FROM t")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(0)}}, cellValues(results))
+
+	assert.Nil(t, reader.Commit(&CommitStatement{}))
+	assert.Equal(t, 1, len(mb.db.versions))
+	results, err = execute(reader, "SELECT count(*) FROM t")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(3)}}, cellValues(results))
+}
+
+func TestMemoryBackendConcurrentSessions(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE counter (id INT PRIMARY KEY, n INT);
+	CREATE TABLE log (id SERIAL PRIMARY KEY, worker INT);
+	INSERT INTO counter VALUES (1, 0)`)
+	assert.Nil(t, err)
+
+	const workers, increments = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(session *MemoryBackend, w int) {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					_, err := execute(session, fmt.Sprintf(`BEGIN;
+					UPDATE counter SET n = n + 1 WHERE id = 1;
+					INSERT INTO log (worker) VALUES (%d);
+					COMMIT`, w))
+					if !errors.Is(err, ErrSerializationFailure) {
+						assert.Nil(t, err)
+						break
+					}
+				}
+			}
+		}(mb.Session(), w)
+	}
+
+	// Readers see the counter and the log agree in every snapshot.
+	wg.Add(1)
+	go func(session *MemoryBackend) {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, session.Begin(&BeginStatement{}))
+			counter, err := execute(session, "SELECT n FROM counter")
+			assert.Nil(t, err)
+			log, err := execute(session, "SELECT count(*) FROM log")
+			assert.Nil(t, err)
+			assert.Equal(t, cellValues(counter), cellValues(log))
+			assert.Nil(t, session.Commit(&CommitStatement{}))
+		}
+	}(mb.Session())
+	wg.Wait()
+
+	results, err := execute(mb, "SELECT n FROM counter")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(workers * increments)}}, cellValues(results))
+	results, err = execute(mb, "SELECT count(*) FROM log")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(workers * increments)}}, cellValues(results))
+	assert.Equal(t, 1, len(mb.db.versions))
+}
+
+func TestMemoryBackendSerializable(t *testing.T) {
+	type step struct {
+		session int
+		source  string
+		err     error
+		rows    [][]interface{}
+	}
+
+	onCall := "SELECT count(*) FROM doctors WHERE on_call"
+	balance := "SELECT sum(amount) FROM ledger WHERE account = 1"
+	tests := []struct {
+		name  string
+		steps []step
+		check string
+		rows  [][]interface{}
+	}{
+		{
+			// Each doctor may go off call while another is on call, which
+			// both check before either commits.
+			"write skew",
+			[]step{
+				{0, "BEGIN ISOLATION LEVEL SERIALIZABLE; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{1, "BEGIN; SET TRANSACTION ISOLATION LEVEL SERIALIZABLE; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{0, "UPDATE doctors SET on_call = false WHERE name = 'Alice'", nil, nil},
+				{1, "UPDATE doctors SET on_call = false WHERE name = 'Bob'", nil, nil},
+				{0, "COMMIT", nil, nil},
+				{1, "COMMIT", ErrSerializationFailure, nil},
+			},
+			onCall,
+			[][]interface{}{{int32(1)}},
+		},
+		{
+			// Snapshot isolation lets the same schedule leave no doctor
+			// on call.
+			"write skew under repeatable read",
+			[]step{
+				{0, "BEGIN ISOLATION LEVEL REPEATABLE READ; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{1, "BEGIN; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{0, "UPDATE doctors SET on_call = false WHERE name = 'Alice'", nil, nil},
+				{1, "UPDATE doctors SET on_call = false WHERE name = 'Bob'", nil, nil},
+				{0, "COMMIT", nil, nil},
+				{1, "COMMIT", nil, nil},
+			},
+			onCall,
+			[][]interface{}{{int32(0)}},
+		},
+		{
+			// Each withdrawal checks the balance covers it, but the
+			// other's row is new to it.
+			"write skew through inserts",
+			[]step{
+				{0, "BEGIN ISOLATION LEVEL SERIALIZABLE; " + balance, nil, [][]interface{}{{int32(100)}}},
+				{1, "BEGIN ISOLATION LEVEL SERIALIZABLE; " + balance, nil, [][]interface{}{{int32(100)}}},
+				{0, "INSERT INTO ledger VALUES (1, -80)", nil, nil},
+				{1, "INSERT INTO ledger VALUES (1, -70)", nil, nil},
+				{1, "COMMIT", nil, nil},
+				{0, "COMMIT", ErrSerializationFailure, nil},
+			},
+			balance,
+			[][]interface{}{{int32(30)}},
+		},
+		{
+			// Reading and writing separate rows is fine.
+			"disjoint rows",
+			[]step{
+				{0, "BEGIN ISOLATION LEVEL SERIALIZABLE; SELECT on_call FROM doctors WHERE name = 'Alice'", nil, [][]interface{}{{true}}},
+				{1, "BEGIN ISOLATION LEVEL SERIALIZABLE; SELECT on_call FROM doctors WHERE name = 'Bob'", nil, [][]interface{}{{true}}},
+				{0, "UPDATE doctors SET on_call = false WHERE name = 'Alice'; INSERT INTO ledger VALUES (2, 5)", nil, nil},
+				{1, "UPDATE doctors SET on_call = false WHERE name = 'Bob'; " + balance, nil, [][]interface{}{{int32(100)}}},
+				{0, "COMMIT", nil, nil},
+				{1, "COMMIT", nil, nil},
+			},
+			onCall,
+			[][]interface{}{{int32(0)}},
+		},
+		{
+			// Only one of the two transactions reading what the other
+			// writes is a serial order.
+			"one dependency",
+			[]step{
+				{0, "BEGIN ISOLATION LEVEL SERIALIZABLE; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{1, "BEGIN ISOLATION LEVEL SERIALIZABLE; UPDATE doctors SET on_call = false WHERE name = 'Bob'; COMMIT", nil, nil},
+				{0, onCall + "; COMMIT", nil, [][]interface{}{{int32(2)}}},
+			},
+			onCall,
+			[][]interface{}{{int32(1)}},
+		},
+		{
+			"too late",
+			[]step{
+				{0, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", ErrNoTransaction, nil},
+				{0, "BEGIN; " + onCall, nil, [][]interface{}{{int32(2)}}},
+				{0, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", ErrTransactionStarted, nil},
+				{0, "ROLLBACK", nil, nil},
+			},
+			onCall,
+			[][]interface{}{{int32(2)}},
+		},
+	}
+
+	for _, test := range tests {
+		mb := NewMemoryBackend()
+		_, err := execute(mb, `CREATE TABLE doctors (name TEXT PRIMARY KEY, on_call BOOLEAN);
+		INSERT INTO doctors VALUES ('Alice', true);
+		INSERT INTO doctors VALUES ('Bob', true);
+		CREATE TABLE ledger (account INT, amount INT);
+		INSERT INTO ledger VALUES (1, 100)`)
+		assert.Nil(t, err)
+		sessions := []*MemoryBackend{mb, mb.Session()}
+
+		for _, step := range test.steps {
+			results, err := execute(sessions[step.session], step.source)
+			if step.err == nil {
+				assert.Nil(t, err, test.name, step.source)
+			} else {
+				assert.True(t, errors.Is(err, step.err), test.name, step.source)
+			}
+			if step.rows != nil {
+				assert.Equal(t, step.rows, cellValues(results), test.name, step.source)
+			}
+		}
+
+		results, err := execute(mb, test.check)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.rows, cellValues(results), test.name)
+		assert.Equal(t, 0, len(mb.db.serializables), test.name)
+	}
+}
+
+func TestMemoryBackendConcurrentSerializable(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, `CREATE TABLE accounts (id INT PRIMARY KEY, balance INT);
+	INSERT INTO accounts VALUES (1, 50);
+	INSERT INTO accounts VALUES (2, 50)`)
+	assert.Nil(t, err)
+
+	// Withdrawals may overdraw one account as long as the two together
+	// stay covered.
+	withdraw := func(session *MemoryBackend, id int) bool {
+		results, err := execute(session, "BEGIN ISOLATION LEVEL SERIALIZABLE; SELECT sum(balance) FROM accounts")
+		assert.Nil(t, err)
+		if cellValues(results)[0][0].(int32) < 10 {
+			assert.Nil(t, session.Rollback(&RollbackStatement{}))
+			return true
+		}
+		// Let the other withdrawals read the same balance.
+		runtime.Gosched()
+
+		_, err = execute(session, fmt.Sprintf("UPDATE accounts SET balance = balance - 10 WHERE id = %d; COMMIT", id))
+		if errors.Is(err, ErrSerializationFailure) {
+			return false
+		}
+		assert.Nil(t, err)
+		return true
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(session *MemoryBackend, id int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				for !withdraw(session, id) {
+				}
+			}
+		}(mb.Session(), w%2+1)
+	}
+	wg.Wait()
+
+	results, err := execute(mb, "SELECT sum(balance) FROM accounts")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{int32(0)}}, cellValues(results))
+	assert.Equal(t, 0, len(mb.db.serializables))
+}
+
+func TestMemoryBackendUnreservedKeywords(t *testing.T) {
+	tests := []struct {
+		source string
+		rows   [][]interface{}
+	}{
+		{
+			source: `CREATE TABLE logs (level INT, read BOOLEAN, committed TEXT);
+			INSERT INTO logs (level, read, committed) VALUES (1, true, 'yes');
+			BEGIN;
+			SET TRANSACTION ISOLATION LEVEL SERIALIZABLE;
+			SELECT level, read, committed FROM logs WHERE level = 1;
+			COMMIT`,
+			rows: [][]interface{}{{int32(1), true, "yes"}},
+		},
+		{
+			source: `CREATE TABLE work (transaction INT);
+			BEGIN TRANSACTION;
+			INSERT INTO work (transaction) VALUES (1);
+			COMMIT WORK;
+			SELECT transaction FROM work`,
+			rows: [][]interface{}{{int32(1)}},
+		},
+		{
+			source: `CREATE TABLE add (type TEXT, drop INT);
+			ALTER TABLE add RENAME drop TO rename;
+			INSERT INTO add (type, rename) VALUES ('a', 1);
+			SELECT type, rename FROM add`,
+			rows: [][]interface{}{{"a", int32(1)}},
+		},
+		{
+			source: `CREATE TABLE sequence (start INT, increment INT);
+			CREATE SEQUENCE start START 3;
+			INSERT INTO sequence (start, increment) VALUES (nextval('start'), 1);
+			SELECT start, increment FROM sequence`,
+			rows: [][]interface{}{{int32(3), int32(1)}},
+		},
+		{
+			source: `CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT);
+			INSERT INTO settings (key, value) VALUES ('a', 'b');
+			UPDATE settings SET value = 'c' WHERE key = 'a';
+			SELECT key, value FROM settings`,
+			rows: [][]interface{}{{"a", "c"}},
+		},
+		{
+			source: `CREATE TABLE rules (id INT PRIMARY KEY, cascade BOOLEAN);
+			CREATE TABLE uses (restrict INT REFERENCES rules (id) ON DELETE CASCADE);
+			INSERT INTO rules (id, cascade) VALUES (1, true);
+			INSERT INTO uses (restrict) VALUES (1);
+			DELETE FROM rules WHERE cascade;
+			SELECT count(*) FROM uses`,
+			rows: [][]interface{}{{int32(0)}},
+		},
+		{
+			source: `CREATE TABLE pages (index INT);
+			CREATE INDEX index ON pages (index);
+			INSERT INTO pages (index) VALUES (7);
+			SELECT index FROM pages WHERE index = 7`,
+			rows: [][]interface{}{{int32(7)}},
+		},
+		{
+			source: `CREATE TABLE conflict (id INT PRIMARY KEY, nothing TEXT);
+			INSERT INTO conflict (id, nothing) VALUES (1, 'a');
+			INSERT INTO conflict (id, nothing) VALUES (1, 'b') ON CONFLICT DO NOTHING;
+			SELECT nothing FROM conflict`,
+			rows: [][]interface{}{{"a"}},
+		},
+		{
+			source: `CREATE TABLE pages (limit INT, offset INT);
+			INSERT INTO pages (limit, offset) VALUES (1, 2);
+			INSERT INTO pages (limit, offset) VALUES (3, 4);
+			INSERT INTO pages (limit, offset) VALUES (3, 5);
+			SELECT limit, count(offset) FROM pages GROUP BY limit ORDER BY limit DESC LIMIT 1 OFFSET 0`,
+			rows: [][]interface{}{{int32(3), int32(2)}},
+		},
+		{
+			source: `CREATE TABLE pages (limit INT);
+			INSERT INTO pages (limit) VALUES (1);
+			INSERT INTO pages (limit) VALUES (2);
+			SELECT p.limit FROM pages p WHERE limit > 0 LIMIT 1 OFFSET 1`,
+			rows: [][]interface{}{{int32(2)}},
+		},
+		{
+			source: `CREATE TABLE analyze (analyze INT);
+			INSERT INTO analyze (analyze) VALUES (1);
+			ANALYZE analyze;
+			SELECT analyze FROM analyze`,
+			rows: [][]interface{}{{int32(1)}},
+		},
+		{
+			source: `CREATE TABLE savepoint (release INT, begin TEXT, commit TEXT, rollback BOOLEAN, serial SERIAL, bigserial BIGSERIAL);
+			BEGIN;
+			INSERT INTO savepoint (release, begin, commit, rollback) VALUES (1, 'a', 'b', true);
+			SAVEPOINT release;
+			INSERT INTO savepoint (release) VALUES (2);
+			ROLLBACK TO SAVEPOINT release;
+			RELEASE SAVEPOINT release;
+			COMMIT;
+			SELECT release, begin, commit, rollback, serial, bigserial FROM savepoint`,
+			rows: [][]interface{}{{int32(1), "a", "b", true, int32(1), int32(1)}},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(NewMemoryBackend(), test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.rows, cellValues(results), test.source)
+		}
+	}
+}
+
+func TestMemoryCellEncoding(t *testing.T) {
+	for _, i := range []int32{0, 1, -1, 42, math.MaxInt32, math.MinInt32} {
+		assert.Equal(t, i, intToCell(i).AsInt())
+	}
+	for _, f := range []float64{0, 1.5, -2.25, math.MaxFloat64, math.Inf(-1)} {
+		assert.Equal(t, f, floatToCell(f).AsFloat())
+	}
+	assert.True(t, math.IsNaN(floatToCell(math.NaN()).AsFloat()))
+
+	// Values are big endian and fixed width.
+	assert.Equal(t, MemoryCell{0, 0, 1, 2}, intToCell(258))
+	assert.Equal(t, MemoryCell{0xff, 0xff, 0xff, 0xfe}, intToCell(-2))
+	assert.Equal(t, MemoryCell{0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, floatToCell(1.5))
+
+	// Reading a cell doesn't allocate.
+	i, f, b := intToCell(7), floatToCell(7.5), boolToCell(true)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		_ = i.AsInt()
+		_ = f.AsFloat()
+		_ = b.AsBool()
+		_ = i.IsNull()
+	}))
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		_ = compareCells(i, IntType, f, FloatType)
+		_ = compareCells(MemoryCell("a"), TextType, MemoryCell("b"), TextType)
+	}))
+}
+
+// benchmarkRows is the number of rows the scan benchmarks read.
+const benchmarkRows = 1000000
+
+// benchmarkBackend returns a backend with a table t of benchmarkRows rows
+// in the given layout.
+func benchmarkBackend(b *testing.B, using string) *MemoryBackend {
+	mb := NewMemoryBackend()
+	_, err := execute(mb, "CREATE TABLE t (id INT, grp INT, price FLOAT, name TEXT) USING "+using)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rows, versions := [][]MemoryCell{}, []rowVersion{}
+	for i := 0; i < benchmarkRows; i++ {
+		versions = append(versions, mb.stamp(0))
+		rows = append(rows, []MemoryCell{
+			intToCell(int32(i)),
+			intToCell(int32(i % 100)),
+			floatToCell(float64(i%1000) + 0.5),
+			MemoryCell(fmt.Sprintf("name%d", i%10)),
+		})
+	}
+	mb.tables["t"].setRows(rows, versions)
+	return mb
+}
+
+// benchmarkQuery reads every row of query over each layout of the table.
+func benchmarkQuery(b *testing.B, query string) {
+	ast, err := Parse(query)
+	if err != nil {
+		b.Fatal(err)
+	}
+	slct := ast.Statements[0].SelectStatement
+
+	for _, using := range []string{"heap", "columnar"} {
+		b.Run(using, func(b *testing.B) {
+			mb := benchmarkBackend(b, using)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				rows, err := mb.Query(slct)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for rows.Next() {
+				}
+				if err := rows.Err(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	benchmarkQuery(b, "SELECT id, grp, price, name FROM t")
+}
+
+func BenchmarkFilter(b *testing.B) {
+	benchmarkQuery(b, "SELECT id FROM t WHERE grp = 7 AND price < 500.0")
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	benchmarkQuery(b, "SELECT grp, count(*), sum(grp), max(price) FROM t GROUP BY grp")
+}
@@ -0,0 +1,406 @@
+package gogn
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrSerializationFailure = errors.New("Could not serialize access due to concurrent update")
+
+// txid numbers the transactions of a database in the order they start,
+// from 1.
+type txid uint64
+
+// rowVersion identifies a row across the copies of its table, which keep
+// their rows in the order of their ids, and records the transaction that
+// last wrote it. Rows loaded rather than written by a
+// transaction have xmin 0.
+type rowVersion struct {
+	id   uint64
+	xmin txid
+}
+
+// database is the state the sessions of a MemoryBackend share. Committed
+// states of the database are versions, which never change once published:
+// each transaction reads the version current when it started, and its
+// COMMIT publishes a new one. Committing fails with ErrSerializationFailure
+// when another transaction committed changes since to a row or table the
+// transaction changed too, and otherwise merges the changes of both.
+//
+// Readers take mu only to start and end, so they never wait for writers,
+// and neither do writers until they commit.
+type database struct {
+	mu sync.Mutex
+	// versions holds the versions running transactions read, the oldest
+	// first, and then every version committed since. The last one is
+	// current.
+	versions []*version
+	lastTxid txid
+	// running counts the transactions in progress.
+	running int
+	// rowIDs numbers the rows written, for rowVersion.
+	rowIDs uint64
+	// commits counts the commits, and serializables holds the serializable
+	// transactions running and those committed while one still runs.
+	commits       uint64
+	serializables []*serialTx
+}
+
+type version struct {
+	tables    map[string]*table
+	sequences map[string]*sequence
+	// changed holds the ids of the rows the commit updated or deleted by
+	// the name of their table, with an entry for every table it changed,
+	// and altered the tables whose definitions it changed. They're only
+	// kept when other transactions were running, which check them when
+	// they commit.
+	changed map[string]map[uint64]bool
+	altered map[string]bool
+	// readers counts the transactions reading the version.
+	readers int
+}
+
+func (db *database) current() *version {
+	return db.versions[len(db.versions)-1]
+}
+
+// Session returns a new session of the database of mb, for another
+// goroutine to run statements in. Functions are shared by every session,
+// so register them before sharing the database.
+func (mb *MemoryBackend) Session() *MemoryBackend {
+	mb.db.mu.Lock()
+	defer mb.db.mu.Unlock()
+
+	v := mb.db.current()
+	return &MemoryBackend{
+		tables:    v.tables,
+		functions: mb.functions,
+		sequences: v.sequences,
+		db:        mb.db,
+		currvals:  map[string]int64{},
+	}
+}
+
+// stamp returns the version of a row written by the transaction of mb:
+// the row with id, or a new row when id is 0.
+func (mb *MemoryBackend) stamp(id uint64) rowVersion {
+	v := rowVersion{id: id}
+	switch {
+	case id != 0:
+	case mb.db != nil:
+		v.id = atomic.AddUint64(&mb.db.rowIDs, 1)
+	default:
+		mb.rowIDs++
+		v.id = mb.rowIDs
+	}
+	if mb.tx != nil {
+		v.xmin = mb.tx.id
+	}
+	return v
+}
+
+// start gives tx an id and the current version to read.
+func (db *database) start(tx *transaction) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lastTxid++
+	tx.id = db.lastTxid
+	tx.snapshot = db.current()
+	tx.snapshot.readers++
+	tx.start = db.commits
+	db.running++
+}
+
+// finish ends tx, publishing the tables and sequences of its workspace w
+// when commit is set, and returns the version current after it.
+func (db *database) finish(tx *transaction, w *MemoryBackend, commit bool) (*version, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var err error
+	if commit {
+		err = db.commit(tx, w)
+	}
+	if tx.serial != nil && (!commit || err != nil) {
+		db.forget(tx.serial)
+	}
+	tx.snapshot.readers--
+	db.running--
+	db.collect()
+	return db.current(), err
+}
+
+// collect drops the versions before the oldest one a transaction still
+// reads. No transaction can see their tables, or rows only they held,
+// any longer.
+func (db *database) collect() {
+	for len(db.versions) > 1 && db.versions[0].readers == 0 {
+		db.versions[0] = nil
+		db.versions = db.versions[1:]
+	}
+	db.collectSerializable()
+}
+
+// since returns the versions committed after v.
+func (db *database) since(v *version) []*version {
+	for i := len(db.versions) - 1; i >= 0; i-- {
+		if db.versions[i] == v {
+			return db.versions[i+1:]
+		}
+	}
+	return nil
+}
+
+// tableChange is a table a transaction changed: mine is the table as the
+// transaction left it and base the table it started from, or nil when the
+// transaction created it.
+type tableChange struct {
+	base, mine *table
+	altered    bool
+	written    map[uint64]bool
+}
+
+// changes returns the tables the transaction changed in tables, by name.
+func (tx *transaction) changes(tables map[string]*table) []*tableChange {
+	changes := []*tableChange{}
+	for name, t := range tables {
+		if tx.begin.tables[name] == t {
+			continue
+		}
+
+		base := tx.bases[t]
+		changes = append(changes, &tableChange{base: base, mine: t, altered: base == nil || tx.altered[t]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].mine.name < changes[j].mine.name
+	})
+	return changes
+}
+
+// renamed reports whether the table is new under its name.
+func (c *tableChange) renamed() bool {
+	return c.base == nil || c.base.name != c.mine.name
+}
+
+// writes returns the ids of the rows of base the transaction id updated or
+// deleted, along with those it inserted.
+func (c *tableChange) writes(id txid) map[uint64]bool {
+	if c.written != nil || c.base == nil {
+		return c.written
+	}
+
+	c.written = map[uint64]bool{}
+	kept := map[uint64]bool{}
+	c.mine.eachRow(func(v rowVersion, row []MemoryCell) error {
+		kept[v.id] = true
+		if v.xmin == id {
+			c.written[v.id] = true
+		}
+		return nil
+	})
+	c.base.eachRow(func(v rowVersion, row []MemoryCell) error {
+		if !kept[v.id] {
+			c.written[v.id] = true
+		}
+		return nil
+	})
+	return c.written
+}
+
+// commit publishes the state w left as a new version. When other
+// transactions committed since tx started, the changes of tx are merged
+// into the current version instead, unless they overlap.
+func (db *database) commit(tx *transaction, w *MemoryBackend) error {
+	changes := tx.changes(w.tables)
+	created := map[string]*sequence{}
+	for name, s := range w.sequences {
+		if tx.begin.sequences[name] != s {
+			created[name] = s
+		}
+	}
+	v := &version{tables: w.tables, sequences: w.sequences}
+	if len(changes) == 0 && len(created) == 0 {
+		v = nil
+	} else if concurrent := db.since(tx.snapshot); len(concurrent) > 0 {
+		var err error
+		if v.tables, err = db.merge(tx, changes, concurrent); err != nil {
+			return err
+		}
+
+		latest := db.current()
+		v.sequences = make(map[string]*sequence, len(latest.sequences))
+		for name, s := range latest.sequences {
+			v.sequences[name] = s
+		}
+		for name, s := range created {
+			if latest.sequences[name] != tx.snapshot.sequences[name] {
+				return ErrSerializationFailure
+			}
+			v.sequences[name] = s
+		}
+	}
+
+	if tx.serial != nil {
+		if err := db.checkSerializable(tx.serial, rowWrites(changes, tx.id)); err != nil {
+			return err
+		}
+	}
+	db.commits++
+	if tx.serial != nil {
+		tx.serial.commit = db.commits
+	}
+	if v == nil {
+		return nil
+	}
+
+	if db.running > 1 {
+		v.changed, v.altered = map[string]map[uint64]bool{}, map[string]bool{}
+		for _, c := range changes {
+			if c.base != nil {
+				v.changed[c.base.name] = c.writes(tx.id)
+				v.altered[c.base.name] = c.altered
+			}
+			if c.renamed() {
+				v.changed[c.mine.name] = map[uint64]bool{}
+				v.altered[c.mine.name] = true
+			}
+		}
+	}
+	db.versions = append(db.versions, v)
+	return nil
+}
+
+// merge returns the tables of the current version with changes applied,
+// failing when one of them overlaps a change committed in concurrent.
+func (db *database) merge(tx *transaction, changes []*tableChange, concurrent []*version) (map[string]*table, error) {
+	snapshot, latest := tx.snapshot, db.current()
+	theirs, altered := map[string]bool{}, map[string]bool{}
+	for _, v := range concurrent {
+		for name := range v.changed {
+			theirs[name] = true
+		}
+		for name, ok := range v.altered {
+			altered[name] = altered[name] || ok
+		}
+	}
+
+	tables := make(map[string]*table, len(latest.tables))
+	for name, t := range latest.tables {
+		tables[name] = t
+	}
+	for _, c := range changes {
+		if c.base != nil && c.renamed() {
+			delete(tables, c.base.name)
+		}
+	}
+
+	mine := map[string]bool{}
+	for _, c := range changes {
+		name := c.mine.name
+		mine[name] = true
+		if c.renamed() && latest.tables[name] != snapshot.tables[name] {
+			return nil, ErrSerializationFailure
+		}
+		if c.base == nil {
+			tables[name] = c.mine
+			continue
+		}
+
+		lt := latest.tables[c.base.name]
+		if lt == c.base {
+			tables[name] = c.mine
+			continue
+		}
+		if lt == nil || c.renamed() || c.altered || altered[c.base.name] {
+			return nil, ErrSerializationFailure
+		}
+		for _, v := range concurrent {
+			for id := range v.changed[c.base.name] {
+				if c.writes(tx.id)[id] {
+					return nil, ErrSerializationFailure
+				}
+			}
+		}
+
+		t, err := mergeRows(c.base, c.mine, lt, tx.id)
+		if err != nil {
+			return nil, err
+		}
+		tables[name] = t
+	}
+
+	// Each side checked its foreign keys against the tables it read, so
+	// references between tables changed by different sides check again.
+	for name, t := range tables {
+		for _, fk := range t.foreignKeys {
+			if !(mine[name] && theirs[fk.parent]) && !(theirs[name] && mine[fk.parent]) {
+				continue
+			}
+
+			parent := tables[fk.parent]
+			ui, _ := parent.uniqueOn(fk.parentColumns)
+			ix := parent.uniqueIndex(parent.uniques[ui])
+			for _, row := range t.allRows() {
+				if key, ok := keyCells(row, fk.columns); ok {
+					if _, found := ix.find(key); !found {
+						return nil, ErrSerializationFailure
+					}
+				}
+			}
+		}
+	}
+	return tables, nil
+}
+
+// mergeRows returns latest, a version of base another transaction
+// committed, with the rows transaction id inserted, updated and deleted in
+// mine, its own version of base.
+func mergeRows(base, mine, latest *table, id txid) (*table, error) {
+	inBase := map[uint64]bool{}
+	base.eachRow(func(v rowVersion, row []MemoryCell) error {
+		inBase[v.id] = true
+		return nil
+	})
+
+	kept, updated := map[uint64]bool{}, map[uint64][]MemoryCell{}
+	insertedRows, insertedVersions := [][]MemoryCell{}, []rowVersion{}
+	mine.eachRow(func(v rowVersion, row []MemoryCell) error {
+		kept[v.id] = true
+		switch {
+		case !inBase[v.id]:
+			insertedRows = append(insertedRows, row)
+			insertedVersions = append(insertedVersions, v)
+		case v.xmin == id:
+			updated[v.id] = row
+		}
+		return nil
+	})
+
+	// The rows of latest and those inserted are each in the order of
+	// their ids, which the merged rows keep.
+	rows, versions := [][]MemoryCell{}, []rowVersion{}
+	latest.eachRow(func(v rowVersion, row []MemoryCell) error {
+		if inBase[v.id] && !kept[v.id] {
+			return nil
+		}
+		for len(insertedVersions) > 0 && insertedVersions[0].id < v.id {
+			rows = append(rows, insertedRows[0])
+			versions = append(versions, insertedVersions[0])
+			insertedRows, insertedVersions = insertedRows[1:], insertedVersions[1:]
+		}
+		if mineRow, ok := updated[v.id]; ok {
+			row, v = mineRow, rowVersion{id: v.id, xmin: id}
+		}
+		rows = append(rows, row)
+		versions = append(versions, v)
+		return nil
+	})
+
+	t := latest.clone()
+	t.stats = latest.stats
+	t.setRows(append(rows, insertedRows...), append(versions, insertedVersions...))
+	return t, t.rebuild()
+}
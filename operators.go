@@ -0,0 +1,679 @@
+package gogn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// planNode is an operator of a physical plan, which produces rows of its
+// schema from the rows of its children.
+type planNode interface {
+	schema() *table
+	children() []planNode
+	estimate() planEstimate
+	// describe returns the name of the operator and the lines of detail
+	// EXPLAIN shows under it.
+	describe() (string, []string)
+	// open starts producing the rows of the node.
+	open(mb *MemoryBackend) (rowIterator, error)
+	// actual is what running the node did, or nil when it isn't being
+	// analyzed.
+	actual() *planActual
+	analyze()
+}
+
+// planActual is what an operator did while EXPLAIN ANALYZE ran its plan.
+type planActual struct {
+	rows  int
+	loops int
+	time  time.Duration
+}
+
+type planBase struct {
+	out *table
+	est planEstimate
+	act *planActual
+}
+
+func (b *planBase) schema() *table {
+	return b.out
+}
+
+func (b *planBase) estimate() planEstimate {
+	return b.est
+}
+
+func (b *planBase) actual() *planActual {
+	return b.act
+}
+
+// analyze starts recording what running the node does.
+func (b *planBase) analyze() {
+	b.act = &planActual{}
+}
+
+// rowIterator returns the next row of an operator each time it's called,
+// pulling the rows it needs from the operators below it. ok is false once
+// the rows run out.
+type rowIterator func() (row []MemoryCell, ok bool, err error)
+
+// iterateRows returns the rows one after another.
+func iterateRows(rows [][]MemoryCell) rowIterator {
+	i := 0
+	return func() ([]MemoryCell, bool, error) {
+		if i == len(rows) {
+			return nil, false, nil
+		}
+		i++
+		return rows[i-1], true, nil
+	}
+}
+
+// collect reads the rest of the rows of it.
+func collect(it rowIterator) ([][]MemoryCell, error) {
+	rows := [][]MemoryCell{}
+	for {
+		row, ok, err := it()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// filterRows keeps the rows of it matching condition, which is over the
+// columns of t.
+func (mb *MemoryBackend) filterRows(t *table, it rowIterator, condition *expression) rowIterator {
+	if condition == nil {
+		return it
+	}
+
+	return func() ([]MemoryCell, bool, error) {
+		for {
+			row, ok, err := it()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+
+			matched, err := mb.matches(t, row, condition)
+			if err != nil {
+				return nil, false, err
+			}
+			if matched {
+				return row, true, nil
+			}
+		}
+	}
+}
+
+// run opens node. When it's being analyzed, it records the rows the node
+// produced and the time it took, including the time of its children.
+func run(mb *MemoryBackend, node planNode) (rowIterator, error) {
+	act := node.actual()
+	if act == nil {
+		return node.open(mb)
+	}
+
+	start := time.Now()
+	it, err := node.open(mb)
+	act.time += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	act.loops++
+
+	return func() ([]MemoryCell, bool, error) {
+		start := time.Now()
+		row, ok, err := it()
+		act.time += time.Since(start)
+		if ok {
+			act.rows++
+		}
+		return row, ok, err
+	}, nil
+}
+
+// seqScan reads every row of a table, keeping the ones matching filter.
+type seqScan struct {
+	planBase
+	table  *table
+	filter *expression
+}
+
+func (s *seqScan) children() []planNode {
+	return nil
+}
+
+// scanName names the operator reading t, along with the alias the query
+// gives it.
+func scanName(operator string, t *table, schema *table) string {
+	name := operator + " on " + t.name
+	if schema.name != t.name {
+		name += " " + schema.name
+	}
+	return name
+}
+
+func (s *seqScan) describe() (string, []string) {
+	details := []string{}
+	if s.filter != nil {
+		details = append(details, "Filter: "+s.filter.String())
+	}
+
+	if s.table.name == "" {
+		return "Result", details
+	}
+	return scanName("Seq Scan", s.table, s.out), details
+}
+
+func (s *seqScan) open(mb *MemoryBackend) (rowIterator, error) {
+	return mb.filterRows(s.out, s.table.scanRows(), s.filter), nil
+}
+
+// indexScan looks up the rows of a table through one of its indexes,
+// keeping the ones matching filter, which is the part of the condition the
+// index doesn't answer.
+type indexScan struct {
+	planBase
+	table  *table
+	access *indexAccess
+	filter *expression
+}
+
+func (s *indexScan) children() []planNode {
+	return nil
+}
+
+// literalString formats cell as a SQL literal.
+func literalString(cell MemoryCell, typ ColumnType) string {
+	if typ == TextType {
+		return tokenString(token{kind: stringKind, value: cell.AsText()})
+	}
+	return cellToText(cell, typ)
+}
+
+func (s *indexScan) describe() (string, []string) {
+	conditions := []string{}
+	for _, p := range s.access.predicates() {
+		value := "NULL"
+		if p.value != nil {
+			value = literalString(p.value, p.typ)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s %s %s)", s.out.columns[p.column], p.op, value))
+	}
+
+	details := []string{"Index Cond: " + strings.Join(conditions, " AND ")}
+	if s.filter != nil {
+		details = append(details, "Filter: "+s.filter.String())
+	}
+	return scanName("Index Scan using "+s.access.index.name, s.table, s.out), details
+}
+
+func (s *indexScan) open(mb *MemoryBackend) (rowIterator, error) {
+	ids := s.access.ids()
+	i := 0
+	lookup := func() ([]MemoryCell, bool, error) {
+		if i == len(ids) {
+			return nil, false, nil
+		}
+		i++
+		return s.table.lookup(ids[i-1]), true, nil
+	}
+	return mb.filterRows(s.out, lookup, s.filter), nil
+}
+
+// filterNode keeps the rows of its input matching condition.
+type filterNode struct {
+	planBase
+	input     planNode
+	condition *expression
+}
+
+func (f *filterNode) children() []planNode {
+	return []planNode{f.input}
+}
+
+func (f *filterNode) describe() (string, []string) {
+	return "Filter", []string{"Filter: " + f.condition.String()}
+}
+
+func (f *filterNode) open(mb *MemoryBackend) (rowIterator, error) {
+	input, err := run(mb, f.input)
+	if err != nil {
+		return nil, err
+	}
+	return mb.filterRows(f.out, input, f.condition), nil
+}
+
+// joinRow is a row of a join: left followed by right, or by NULLs for the
+// width of the right side when right is nil.
+func joinRow(left, right []MemoryCell, rightWidth int) []MemoryCell {
+	row := append([]MemoryCell{}, left...)
+	if right == nil {
+		return append(row, make([]MemoryCell, rightWidth)...)
+	}
+	return append(row, right...)
+}
+
+func joinName(method string, kind joinKind) string {
+	if kind == leftJoin {
+		return method + " Left Join"
+	}
+	return method
+}
+
+// nestedLoopJoin compares every row of left with every row of right, which
+// it reads into memory first.
+type nestedLoopJoin struct {
+	planBase
+	left, right planNode
+	kind        joinKind
+	on          *expression
+}
+
+func (j *nestedLoopJoin) children() []planNode {
+	return []planNode{j.left, j.right}
+}
+
+func (j *nestedLoopJoin) describe() (string, []string) {
+	details := []string{}
+	if j.on != nil {
+		details = append(details, "Join Filter: "+j.on.String())
+	}
+	return joinName("Nested Loop", j.kind), details
+}
+
+func (j *nestedLoopJoin) open(mb *MemoryBackend) (rowIterator, error) {
+	left, err := run(mb, j.left)
+	if err != nil {
+		return nil, err
+	}
+	it, err := run(mb, j.right)
+	if err != nil {
+		return nil, err
+	}
+	right, err := collect(it)
+	if err != nil {
+		return nil, err
+	}
+
+	// The left row being joined, how far along right it is and whether it
+	// has matched yet.
+	var l []MemoryCell
+	started, i, matched := false, 0, false
+	width := len(j.right.schema().columns)
+	return func() ([]MemoryCell, bool, error) {
+		for {
+			if !started {
+				row, ok, err := left()
+				if err != nil || !ok {
+					return nil, false, err
+				}
+				l, started, i, matched = row, true, 0, false
+			}
+
+			for i < len(right) {
+				row := joinRow(l, right[i], width)
+				i++
+				ok, err := mb.matches(j.out, row, j.on)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					matched = true
+					return row, true, nil
+				}
+			}
+
+			started = false
+			if !matched && j.kind == leftJoin {
+				return joinRow(l, nil, width), true, nil
+			}
+		}
+	}, nil
+}
+
+// hashJoin puts the rows of one side in a hash table by the values of their
+// keys, which the rows of the other side then look up their matches in.
+// Rows with a NULL key never match. A LEFT JOIN always builds the table from
+// the right side.
+type hashJoin struct {
+	planBase
+	left, right         planNode
+	kind                joinKind
+	leftKeys, rightKeys []*expression
+	// types are the types both sides' keys are compared as.
+	types []ColumnType
+	// rest is the part of the ON condition that isn't keys.
+	rest      *expression
+	buildLeft bool
+}
+
+func (j *hashJoin) children() []planNode {
+	return []planNode{j.left, j.right}
+}
+
+func (j *hashJoin) describe() (string, []string) {
+	keys := []string{}
+	for i := range j.leftKeys {
+		keys = append(keys, "("+j.leftKeys[i].String()+" = "+j.rightKeys[i].String()+")")
+	}
+
+	details := []string{"Hash Cond: " + strings.Join(keys, " AND ")}
+	if j.rest != nil {
+		details = append(details, "Join Filter: "+j.rest.String())
+	}
+	if j.buildLeft {
+		details = append(details, "Build Side: left")
+	}
+	return joinName("Hash Join", j.kind), details
+}
+
+// hashKey encodes the keys of row, returning false when one is NULL.
+func (j *hashJoin) hashKey(mb *MemoryBackend, t *table, row []MemoryCell, keys []*expression) (string, bool, error) {
+	values := []MemoryCell{}
+	for i, exp := range keys {
+		cell, typ, err := mb.evaluateCell(t, row, exp)
+		if err != nil || cell == nil {
+			return "", false, err
+		}
+		values = append(values, coerceCell(cell, typ, j.types[i]))
+	}
+	return encodeKey(values), true, nil
+}
+
+func (j *hashJoin) open(mb *MemoryBackend) (rowIterator, error) {
+	left, err := run(mb, j.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := run(mb, j.right)
+	if err != nil {
+		return nil, err
+	}
+
+	build, buildSchema, buildKeys := right, j.right.schema(), j.rightKeys
+	probe, probeSchema, probeKeys := left, j.left.schema(), j.leftKeys
+	if j.buildLeft {
+		build, buildSchema, buildKeys = left, j.left.schema(), j.leftKeys
+		probe, probeSchema, probeKeys = right, j.right.schema(), j.rightKeys
+	}
+
+	buildRows, err := collect(build)
+	if err != nil {
+		return nil, err
+	}
+	hashed := map[string][][]MemoryCell{}
+	for _, row := range buildRows {
+		key, ok, err := j.hashKey(mb, buildSchema, row, buildKeys)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hashed[key] = append(hashed[key], row)
+		}
+	}
+
+	// pending holds the joined rows of the last probed row that haven't
+	// been returned yet.
+	pending := [][]MemoryCell{}
+	width := len(j.right.schema().columns)
+	return func() ([]MemoryCell, bool, error) {
+		for len(pending) == 0 {
+			p, ok, err := probe()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+
+			key, ok, err := j.hashKey(mb, probeSchema, p, probeKeys)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if ok {
+				for _, b := range hashed[key] {
+					row := joinRow(p, b, width)
+					if j.buildLeft {
+						row = joinRow(b, p, width)
+					}
+
+					ok, err := mb.matches(j.out, row, j.rest)
+					if err != nil {
+						return nil, false, err
+					}
+					if ok {
+						pending = append(pending, row)
+					}
+				}
+			}
+
+			if len(pending) == 0 && j.kind == leftJoin {
+				pending = append(pending, joinRow(p, nil, width))
+			}
+		}
+
+		row := pending[0]
+		pending = pending[1:]
+		return row, true, nil
+	}, nil
+}
+
+// aggregateNode groups the rows of its input and computes the aggregates
+// per group.
+type aggregateNode struct {
+	planBase
+	input      planNode
+	groupBy    []*expression
+	aggregates []*groupedAggregate
+}
+
+func (a *aggregateNode) children() []planNode {
+	return []planNode{a.input}
+}
+
+func (a *aggregateNode) describe() (string, []string) {
+	if len(a.groupBy) == 0 {
+		return "Aggregate", nil
+	}
+	return "HashAggregate", []string{"Group Key: " + joinExpressions(a.groupBy)}
+}
+
+func (a *aggregateNode) open(mb *MemoryBackend) (rowIterator, error) {
+	it, err := run(mb, a.input)
+	if err != nil {
+		return nil, err
+	}
+	input, err := collect(it)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := mb.groupRows(a.input.schema(), input, a.groupBy, a.out, a.aggregates)
+	if err != nil {
+		return nil, err
+	}
+	return iterateRows(rows), nil
+}
+
+// sortNode orders the rows of its input. NULLs come last in ascending order
+// and first in descending order, and rows with equal keys keep their order.
+type sortNode struct {
+	planBase
+	input   planNode
+	orderBy []*orderItem
+}
+
+func (s *sortNode) children() []planNode {
+	return []planNode{s.input}
+}
+
+func (s *sortNode) describe() (string, []string) {
+	keys := []string{}
+	for _, item := range s.orderBy {
+		key := item.exp.String()
+		if item.desc {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+	}
+	return "Sort", []string{"Sort Key: " + strings.Join(keys, ", ")}
+}
+
+func (s *sortNode) open(mb *MemoryBackend) (rowIterator, error) {
+	it, err := run(mb, s.input)
+	if err != nil {
+		return nil, err
+	}
+	input, err := collect(it)
+	if err != nil {
+		return nil, err
+	}
+
+	type sortRow struct {
+		row  []MemoryCell
+		keys []MemoryCell
+	}
+	rows := []sortRow{}
+	types := make([]ColumnType, len(s.orderBy))
+	for _, row := range input {
+		keys := []MemoryCell{}
+		for i, item := range s.orderBy {
+			cell, typ, err := mb.evaluateCell(s.out, row, item.exp)
+			if err != nil {
+				return nil, err
+			}
+			if cell != nil {
+				types[i] = typ
+			}
+			keys = append(keys, cell)
+		}
+		rows = append(rows, sortRow{row: row, keys: keys})
+	}
+
+	sort.SliceStable(rows, func(x, y int) bool {
+		for i, item := range s.orderBy {
+			a, b := rows[x].keys[i], rows[y].keys[i]
+			var c int
+			switch {
+			case a == nil && b == nil:
+				continue
+			case a == nil:
+				c = 1
+			case b == nil:
+				c = -1
+			default:
+				c = compareCells(a, types[i], b, types[i])
+			}
+
+			if item.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	sorted := [][]MemoryCell{}
+	for _, r := range rows {
+		sorted = append(sorted, r.row)
+	}
+	return iterateRows(sorted), nil
+}
+
+// projectNode evaluates the select items over the rows of its input.
+type projectNode struct {
+	planBase
+	input planNode
+	items []*expression
+}
+
+func (p *projectNode) children() []planNode {
+	return []planNode{p.input}
+}
+
+func (p *projectNode) describe() (string, []string) {
+	return "Project", nil
+}
+
+func (p *projectNode) open(mb *MemoryBackend) (rowIterator, error) {
+	input, err := run(mb, p.input)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() ([]MemoryCell, bool, error) {
+		in, ok, err := input()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+
+		row := make([]MemoryCell, 0, len(p.items))
+		for _, exp := range p.items {
+			cell, _, err := mb.evaluateCell(p.input.schema(), in, exp)
+			if err != nil {
+				return nil, false, err
+			}
+			row = append(row, cell)
+		}
+		return row, true, nil
+	}, nil
+}
+
+// limitNode skips the first offset rows of its input and passes on at most
+// limit of the rest, or all of them when limit is -1. It stops reading its
+// input once it has passed on limit rows.
+type limitNode struct {
+	planBase
+	input         planNode
+	limit, offset int
+}
+
+func (l *limitNode) children() []planNode {
+	return []planNode{l.input}
+}
+
+func (l *limitNode) describe() (string, []string) {
+	details := []string{}
+	if l.limit >= 0 {
+		details = append(details, fmt.Sprintf("Limit: %d", l.limit))
+	}
+	if l.offset > 0 {
+		details = append(details, fmt.Sprintf("Offset: %d", l.offset))
+	}
+	return "Limit", details
+}
+
+func (l *limitNode) open(mb *MemoryBackend) (rowIterator, error) {
+	input, err := run(mb, l.input)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped, passed := 0, 0
+	return func() ([]MemoryCell, bool, error) {
+		if l.limit >= 0 && passed == l.limit {
+			return nil, false, nil
+		}
+
+		for skipped < l.offset {
+			_, ok, err := input()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			skipped++
+		}
+
+		row, ok, err := input()
+		if ok {
+			passed++
+		}
+		return row, ok, err
+	}, nil
+}
@@ -0,0 +1,491 @@
+package gogn
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// pageSize is the size of every page of a database file.
+const pageSize = 4096
+
+// pageID numbers the pages of a database file. Page 0 is the file header,
+// so it never names another page and marks the end of a chain of them.
+type pageID uint64
+
+// Page kinds, stored in the first byte of each page other than the header.
+const (
+	freePage byte = iota + 1
+	leafPage
+	internalPage
+	overflowPage
+)
+
+var (
+	ErrCorruptDatabase = errors.New("Database file is corrupt")
+	ErrDatabaseClosed  = errors.New("Database is closed")
+)
+
+// databaseMagic starts the header of every database file.
+var databaseMagic = [8]byte{'g', 'o', 'g', 'n', 'd', 'b', 0, 1}
+
+// fileHeader is the content of page 0: where the catalog is and how the
+// rest of the pages are used.
+type fileHeader struct {
+	// pageCount is the number of pages in the file, counting the header.
+	pageCount uint64
+	// freeHead starts the chain of pages no longer in use.
+	freeHead pageID
+	// catalog starts the overflow chain holding the encoded catalog of
+	// catalogLength bytes.
+	catalog       pageID
+	catalogLength uint64
+}
+
+func (h *fileHeader) encode(page []byte) {
+	copy(page, databaseMagic[:])
+	binary.BigEndian.PutUint32(page[8:], pageSize)
+	binary.BigEndian.PutUint64(page[12:], h.pageCount)
+	binary.BigEndian.PutUint64(page[20:], uint64(h.freeHead))
+	binary.BigEndian.PutUint64(page[28:], uint64(h.catalog))
+	binary.BigEndian.PutUint64(page[36:], h.catalogLength)
+}
+
+func (h *fileHeader) decode(page []byte) error {
+	if string(page[:8]) != string(databaseMagic[:]) || binary.BigEndian.Uint32(page[8:]) != pageSize {
+		return fmt.Errorf("%w: bad header", ErrCorruptDatabase)
+	}
+	h.pageCount = binary.BigEndian.Uint64(page[12:])
+	h.freeHead = pageID(binary.BigEndian.Uint64(page[20:]))
+	h.catalog = pageID(binary.BigEndian.Uint64(page[28:]))
+	h.catalogLength = binary.BigEndian.Uint64(page[36:])
+	return nil
+}
+
+// frame is a page held in the buffer pool.
+type frame struct {
+	id   pageID
+	data []byte
+	// dirty is set while the page differs from the database file, and
+	// changed while it differs from the write-ahead log.
+	dirty   bool
+	changed bool
+	pins    int
+	// element is the frame's place in the pool's recently used list.
+	element *list.Element
+}
+
+// bufferPool caches the pages of a database file in at most capacity
+// frames, evicting the least recently used clean page that isn't pinned to
+// make room. Changed pages go to the write-ahead log when commit is called,
+// and stay in the pool until a checkpoint writes them to the file, so the
+// file only changes at checkpoints.
+type bufferPool struct {
+	file     *os.File
+	wal      *writeAheadLog
+	header   fileHeader
+	capacity int
+	frames   map[pageID]*frame
+	// recent orders the frames from most to least recently used.
+	recent *list.List
+	// logged is the header as of the last commit.
+	logged fileHeader
+	// checkpointFrames is the length the log grows to before commit
+	// checkpoints.
+	checkpointFrames int
+}
+
+// defaultPoolCapacity is the number of pages a buffer pool keeps by
+// default, and defaultCheckpointFrames the number of frames logged between
+// checkpoints.
+const (
+	defaultPoolCapacity     = 1024
+	defaultCheckpointFrames = 1024
+)
+
+// openBufferPool opens the database file at path, creating it when it
+// doesn't exist, and recovers the statements in its write-ahead log.
+func openBufferPool(path string, capacity int) (*bufferPool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &bufferPool{
+		file:             file,
+		capacity:         capacity,
+		frames:           map[pageID]*frame{},
+		recent:           list.New(),
+		checkpointFrames: defaultCheckpointFrames,
+	}
+	if err := pool.open(path); err != nil {
+		pool.close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+func (p *bufferPool) open(path string) error {
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		p.header.pageCount = 1
+		if err := p.writeHeader(); err != nil {
+			return err
+		}
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		page := make([]byte, pageSize)
+		if _, err := p.file.ReadAt(page, 0); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("%w: truncated header", ErrCorruptDatabase)
+			}
+			return err
+		}
+		if err := p.header.decode(page); err != nil {
+			return err
+		}
+	}
+
+	if p.wal, err = openWriteAheadLog(path + "-wal"); err != nil {
+		return err
+	}
+	return p.recover()
+}
+
+// recover writes the pages of the statements in the log to the file and
+// empties the log.
+func (p *bufferPool) recover() error {
+	pages, header, err := p.wal.replay()
+	if err != nil {
+		return err
+	}
+
+	if header != nil {
+		for id, data := range pages {
+			if _, err := p.file.WriteAt(data, int64(id)*pageSize); err != nil {
+				return err
+			}
+		}
+		p.header = *header
+		if err := p.writeHeader(); err != nil {
+			return err
+		}
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+	}
+	p.logged = p.header
+	return p.wal.reset()
+}
+
+// fetch returns the frame of page id, reading it from the file if it isn't
+// in the pool. The frame stays pinned in the pool until it's released.
+func (p *bufferPool) fetch(id pageID) (*frame, error) {
+	if id == 0 || uint64(id) >= p.header.pageCount {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrCorruptDatabase, id)
+	}
+
+	if f, ok := p.frames[id]; ok {
+		f.pins++
+		p.recent.MoveToFront(f.element)
+		return f, nil
+	}
+
+	f, err := p.newFrame(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.file.ReadAt(f.data, int64(id)*pageSize); err != nil {
+		p.drop(f)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: page %d missing", ErrCorruptDatabase, id)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// newFrame adds a pinned, zeroed frame for page id to the pool.
+func (p *bufferPool) newFrame(id pageID) (*frame, error) {
+	if len(p.frames) >= p.capacity {
+		p.evict()
+	}
+
+	f := &frame{id: id, data: make([]byte, pageSize), pins: 1}
+	f.element = p.recent.PushFront(f)
+	p.frames[id] = f
+	return f, nil
+}
+
+// evict drops the least recently used clean frame that isn't pinned, if
+// there is one. Otherwise the pool grows past its capacity until a
+// checkpoint.
+func (p *bufferPool) evict() {
+	for e := p.recent.Back(); e != nil; e = e.Prev() {
+		f := e.Value.(*frame)
+		if f.pins == 0 && !f.dirty {
+			p.drop(f)
+			return
+		}
+	}
+}
+
+func (p *bufferPool) drop(f *frame) {
+	p.recent.Remove(f.element)
+	delete(p.frames, f.id)
+}
+
+// release unpins f, marking it dirty when it was changed.
+func (p *bufferPool) release(f *frame, dirty bool) {
+	f.pins--
+	f.dirty = f.dirty || dirty
+	f.changed = f.changed || dirty
+}
+
+// allocate returns a pinned, zeroed frame for a page not in use, reusing a
+// freed page when there is one.
+func (p *bufferPool) allocate() (*frame, error) {
+	if id := p.header.freeHead; id != 0 {
+		f, err := p.fetch(id)
+		if err != nil {
+			return nil, err
+		}
+		if f.data[0] != freePage {
+			p.release(f, false)
+			return nil, fmt.Errorf("%w: page %d on the free list is in use", ErrCorruptDatabase, id)
+		}
+
+		p.header.freeHead = pageID(binary.BigEndian.Uint64(f.data[1:]))
+		for i := range f.data {
+			f.data[i] = 0
+		}
+		f.dirty, f.changed = true, true
+		return f, nil
+	}
+
+	id := pageID(p.header.pageCount)
+	p.header.pageCount++
+	f, err := p.newFrame(id)
+	if err != nil {
+		return nil, err
+	}
+	f.dirty, f.changed = true, true
+	return f, nil
+}
+
+// free puts page id on the free list.
+func (p *bufferPool) free(id pageID) error {
+	f, err := p.fetch(id)
+	if err != nil {
+		return err
+	}
+
+	for i := range f.data {
+		f.data[i] = 0
+	}
+	f.data[0] = freePage
+	binary.BigEndian.PutUint64(f.data[1:], uint64(p.header.freeHead))
+	p.header.freeHead = id
+	p.release(f, true)
+	return nil
+}
+
+// commit logs the pages changed since the last commit along with the
+// header, making the changes durable. It checkpoints once the log grows
+// long or the dirty pages fill the pool.
+func (p *bufferPool) commit() error {
+	changed, dirty := []*frame{}, 0
+	for _, f := range p.frames {
+		if f.changed {
+			changed = append(changed, f)
+		}
+		if f.dirty {
+			dirty++
+		}
+	}
+
+	if len(changed) == 0 && p.header == p.logged {
+		return nil
+	}
+
+	if err := p.wal.commit(changed, &p.header); err != nil {
+		return err
+	}
+	for _, f := range changed {
+		f.changed = false
+	}
+	p.logged = p.header
+
+	if p.wal.frames >= p.checkpointFrames || dirty >= p.capacity {
+		return p.checkpoint()
+	}
+	return nil
+}
+
+// poolSavepoint is the state of the pages changed since the last commit
+// at some point, for rollback to go back to.
+type poolSavepoint struct {
+	pages  map[pageID][]byte
+	header fileHeader
+}
+
+// savepoint copies the pages changed since the last commit.
+func (p *bufferPool) savepoint() *poolSavepoint {
+	s := &poolSavepoint{pages: map[pageID][]byte{}, header: p.header}
+	for _, f := range p.frames {
+		if f.changed {
+			s.pages[f.id] = append([]byte{}, f.data...)
+		}
+	}
+	return s
+}
+
+// rollback brings back the pages and header as of s. Pages changed since
+// without a copy in s are dropped, to be read back from the file, so every
+// dirty page must have been changed since the last commit, as they are
+// after a checkpoint.
+func (p *bufferPool) rollback(s *poolSavepoint) {
+	for _, f := range p.frames {
+		if !f.changed {
+			continue
+		}
+		if data, ok := s.pages[f.id]; ok {
+			copy(f.data, data)
+		} else {
+			p.drop(f)
+		}
+	}
+	p.header = s.header
+}
+
+// checkpoint writes the header and every dirty page to the file, waits for
+// the file to reach the disk and then empties the log. Every change must
+// have been committed first.
+func (p *bufferPool) checkpoint() error {
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+
+	for _, f := range p.frames {
+		if !f.dirty {
+			continue
+		}
+		if _, err := p.file.WriteAt(f.data, int64(f.id)*pageSize); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+
+	for _, f := range p.frames {
+		f.dirty = false
+	}
+	return p.wal.reset()
+}
+
+func (p *bufferPool) writeHeader() error {
+	header := make([]byte, pageSize)
+	p.header.encode(header)
+	_, err := p.file.WriteAt(header, 0)
+	return err
+}
+
+func (p *bufferPool) close() error {
+	err := p.file.Close()
+	if p.wal != nil {
+		if walErr := p.wal.close(); err == nil {
+			err = walErr
+		}
+	}
+	return err
+}
+
+// Overflow pages hold data too large for the page referring to it, in a
+// chain of pages each holding the kind, the next page and the length of
+// its part of the data.
+const overflowHeader = 1 + 8 + 2
+
+// writeOverflow stores data in a new chain of overflow pages and returns
+// its first page.
+func (p *bufferPool) writeOverflow(data []byte) (pageID, error) {
+	var first pageID
+	var previous *frame
+	for len(data) > 0 || first == 0 {
+		f, err := p.allocate()
+		if err != nil {
+			if previous != nil {
+				p.release(previous, true)
+			}
+			return 0, err
+		}
+
+		n := copy(f.data[overflowHeader:], data)
+		data = data[n:]
+		f.data[0] = overflowPage
+		binary.BigEndian.PutUint16(f.data[9:], uint16(n))
+
+		if previous == nil {
+			first = f.id
+		} else {
+			binary.BigEndian.PutUint64(previous.data[1:], uint64(f.id))
+			p.release(previous, true)
+		}
+		previous = f
+	}
+	p.release(previous, true)
+	return first, nil
+}
+
+// readOverflow reads the data stored in the chain of overflow pages
+// starting at id.
+func (p *bufferPool) readOverflow(id pageID, length int) ([]byte, error) {
+	data := make([]byte, 0, length)
+	for id != 0 {
+		f, err := p.fetch(id)
+		if err != nil {
+			return nil, err
+		}
+		if f.data[0] != overflowPage {
+			p.release(f, false)
+			return nil, fmt.Errorf("%w: page %d isn't an overflow page", ErrCorruptDatabase, id)
+		}
+
+		n := int(binary.BigEndian.Uint16(f.data[9:]))
+		data = append(data, f.data[overflowHeader:overflowHeader+n]...)
+		id = pageID(binary.BigEndian.Uint64(f.data[1:]))
+		p.release(f, false)
+	}
+
+	if len(data) != length {
+		return nil, fmt.Errorf("%w: overflow chain holds %d bytes, not %d", ErrCorruptDatabase, len(data), length)
+	}
+	return data, nil
+}
+
+// freeOverflow frees the chain of overflow pages starting at id.
+func (p *bufferPool) freeOverflow(id pageID) error {
+	for id != 0 {
+		f, err := p.fetch(id)
+		if err != nil {
+			return err
+		}
+		next := pageID(binary.BigEndian.Uint64(f.data[1:]))
+		p.release(f, false)
+
+		if err := p.free(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
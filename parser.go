@@ -5,7 +5,12 @@ import (
 	"fmt"
 )
 
+// tokenFromKeyword returns the token k lexes as, which is an identifier
+// for unreserved keywords.
 func tokenFromKeyword(k keyword) token {
+	if unreservedKeywords[k] {
+		return token{kind: identifierKind, value: string(k)}
+	}
 	tok := token{kind: keywordKind, value: string(k)}
 	return tok
 }
@@ -67,6 +72,21 @@ func Parse(source string) (*Ast, error) {
 	return &a, nil
 }
 
+// parseExpressionText parses source as a single expression, such as one
+// formatted by expression.String.
+func parseExpressionText(source string) (*expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, cursor, ok := parseExpression(tokens, 0, 0)
+	if !ok || cursor != uint(len(tokens)) {
+		return nil, errors.New("Failed to parse, expected expression")
+	}
+	return exp, nil
+}
+
 func parseStatement(tokens []*token, initialCursor uint, delimiter token) (*Statement, uint, bool) {
 	cursor := initialCursor
 
@@ -83,12 +103,92 @@ func parseStatement(tokens []*token, initialCursor uint, delimiter token) (*Stat
 		return &Statement{Kind: InsertKind, InsertStatement: inst}, newCursor, true
 	}
 
+	// Look for a UPDATE Statement
+	upd, newCursor, ok := parseUpdateStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: UpdateKind, UpdateStatement: upd}, newCursor, true
+	}
+
+	// Look for a DELETE Statement
+	del, newCursor, ok := parseDeleteStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: DeleteKind, DeleteStatement: del}, newCursor, true
+	}
+
 	// Look for a CREATE Statement
 	crtTbl, newCursor, ok := parseCreateTableStatement(tokens, cursor, semicolonToken)
 	if ok {
 		return &Statement{Kind: CreateTableKind, CreateTableStatement: crtTbl}, newCursor, true
 	}
 
+	// Look for a CREATE SEQUENCE Statement
+	crtSeq, newCursor, ok := parseCreateSequenceStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: CreateSequenceKind, CreateSequenceStatement: crtSeq}, newCursor, true
+	}
+
+	// Look for a CREATE INDEX Statement
+	crtIdx, newCursor, ok := parseCreateIndexStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: CreateIndexKind, CreateIndexStatement: crtIdx}, newCursor, true
+	}
+
+	// Look for a DROP INDEX Statement
+	drpIdx, newCursor, ok := parseDropIndexStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: DropIndexKind, DropIndexStatement: drpIdx}, newCursor, true
+	}
+
+	// Look for an EXPLAIN Statement
+	expl, newCursor, ok := parseExplainStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: ExplainKind, ExplainStatement: expl}, newCursor, true
+	}
+
+	// Look for an ANALYZE Statement
+	anl, newCursor, ok := parseAnalyzeStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: AnalyzeKind, AnalyzeStatement: anl}, newCursor, true
+	}
+
+	// Look for a BEGIN, COMMIT, ROLLBACK, SAVEPOINT, RELEASE or SET
+	// TRANSACTION Statement
+	begin, newCursor, ok := parseBeginStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: BeginKind, BeginStatement: begin}, newCursor, true
+	}
+
+	commit, newCursor, ok := parseCommitStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: CommitKind, CommitStatement: commit}, newCursor, true
+	}
+
+	rollback, newCursor, ok := parseRollbackStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: RollbackKind, RollbackStatement: rollback}, newCursor, true
+	}
+
+	svpt, newCursor, ok := parseSavepointStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: SavepointKind, SavepointStatement: svpt}, newCursor, true
+	}
+
+	rel, newCursor, ok := parseReleaseSavepointStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: ReleaseSavepointKind, ReleaseSavepointStatement: rel}, newCursor, true
+	}
+
+	set, newCursor, ok := parseSetTransactionStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: SetTransactionKind, SetTransactionStatement: set}, newCursor, true
+	}
+
+	// Look for a ALTER TABLE Statement
+	alt, newCursor, ok := parseAlterTableStatement(tokens, cursor, semicolonToken)
+	if ok {
+		return &Statement{Kind: AlterTableKind, AlterTableStatement: alt}, newCursor, true
+	}
+
 	return nil, initialCursor, false
 }
 
@@ -102,12 +202,20 @@ func parseSelectStatement(tokens []*token, initialCursor uint, delimiter token)
 
 	slct := SelectStatement{}
 
-	exps, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromKeyword(fromKeyword), delimiter})
+	// A SELECT inside an INSERT may be followed by the INSERT's ON
+	// CONFLICT and RETURNING.
+	end := []token{delimiter, tokenFromKeyword(onKeyword), tokenFromKeyword(returningKeyword)}
+
+	// Each clause ends where a later one starts.
+	tail := append([]token{tokenFromKeyword(orderKeyword), tokenFromKeyword(limitKeyword), tokenFromKeyword(offsetKeyword)}, end...)
+
+	items, aliases, newCursor, ok := parseSelectItems(tokens, cursor, append([]token{tokenFromKeyword(fromKeyword), tokenFromKeyword(whereKeyword), tokenFromKeyword(groupKeyword)}, tail...))
 	if !ok {
 		return nil, initialCursor, false
 	}
 
-	slct.item = *exps
+	slct.item = items
+	slct.aliases = aliases
 	cursor = newCursor
 
 	if expectToken(tokens, cursor, tokenFromKeyword(fromKeyword)) {
@@ -119,245 +227,2058 @@ func parseSelectStatement(tokens []*token, initialCursor uint, delimiter token)
 		}
 		slct.from = *from
 		cursor = newCursor
-	}
-
-	return &slct, cursor, true
-
-}
-
-func parseToken(tokens []*token, initialCursor uint, kind tokenKind) (*token, uint, bool) {
-	cursor := initialCursor
-
-	if cursor >= uint(len(tokens)) {
-		return nil, initialCursor, false
-	}
-
-	current := tokens[cursor]
-	if current.kind == kind {
-		cursor++
-		return current, cursor, true
-	}
-
-	return nil, initialCursor, false
-}
-
-func parseExpressions(tokens []*token, initialCursor uint, delimiters []token) (*[]*expression, uint, bool) {
-	cursor := initialCursor
-	exps := []*expression{}
 
-outer:
-	for {
-		if cursor >= uint(len(tokens)) {
+		slct.alias, cursor, ok = parseTableAlias(tokens, cursor)
+		if !ok {
 			return nil, initialCursor, false
 		}
 
-		// Look for the delimiter
-		current := tokens[cursor]
-		for _, delimiter := range delimiters {
-			if delimiter.equals(current) {
-				break outer
+		for {
+			join, newCursor, ok := parseJoinClause(tokens, cursor)
+			if !ok {
+				break
 			}
-		}
-
-		// Look for a comma
-		if len(exps) > 0 {
-			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
-				helpMessage(tokens, cursor, "Expected comma")
+			if join == nil {
 				return nil, initialCursor, false
 			}
-			cursor++
+			slct.joins = append(slct.joins, join)
+			cursor = newCursor
 		}
+	}
 
-		// Look for an expression
-		exp, newCursor, ok := parseExpression(tokens, cursor, tokenFromSymbol(commaSymbol))
+	// Look for WHERE
+	if expectToken(tokens, cursor, tokenFromKeyword(whereKeyword)) {
+		cursor++
+		where, newCursor, ok := parseExpression(tokens, cursor, 0)
 		if !ok {
-			helpMessage(tokens, cursor, "Expected expression")
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
 		}
+		slct.where = where
 		cursor = newCursor
-
-		exps = append(exps, exp)
 	}
 
-	return &exps, cursor, true
-}
+	// Look for GROUP BY
+	if expectToken(tokens, cursor, tokenFromKeyword(groupKeyword)) {
+		cursor++
+		if !expectToken(tokens, cursor, tokenFromKeyword(byKeyword)) {
+			helpMessage(tokens, cursor, "Expected BY")
+			return nil, initialCursor, false
+		}
+		cursor++
 
-func parseExpression(tokens []*token, initialCursor uint, _ token) (*expression, uint, bool) {
-	cursor := initialCursor
-	kinds := []tokenKind{identifierKind, numericKind, stringKind}
-	for _, kind := range kinds {
-		t, newCursor, ok := parseToken(tokens, cursor, kind)
-		if ok {
-			return &expression{literal: t, kind: literalKind}, newCursor, true
+		groupBy, newCursor, ok := parseExpressions(tokens, cursor, tail)
+		if !ok || len(*groupBy) == 0 {
+			helpMessage(tokens, cursor, "Expected GROUP BY expressions")
+			return nil, initialCursor, false
 		}
+		slct.groupBy = *groupBy
+		cursor = newCursor
 	}
-	return nil, initialCursor, false
-}
 
-func parseInsertStatement(tokens []*token, initialCursor uint, delimiter token) (*InsertStatement, uint, bool) {
-	cursor := initialCursor
+	// Look for ORDER BY
+	if expectToken(tokens, cursor, tokenFromKeyword(orderKeyword)) {
+		cursor++
+		if !expectToken(tokens, cursor, tokenFromKeyword(byKeyword)) {
+			helpMessage(tokens, cursor, "Expected BY")
+			return nil, initialCursor, false
+		}
+		cursor++
 
-	// Look for INSERT
-	if !expectToken(tokens, cursor, tokenFromKeyword(insertKeyword)) {
-		return nil, initialCursor, false
+		orderBy, newCursor, ok := parseOrderItems(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		slct.orderBy = orderBy
+		cursor = newCursor
 	}
 
-	cursor++
+	// Look for LIMIT and OFFSET, in either order
+	for {
+		var target **expression
+		switch {
+		case slct.limit == nil && expectToken(tokens, cursor, tokenFromKeyword(limitKeyword)):
+			target = &slct.limit
+		case slct.offset == nil && expectToken(tokens, cursor, tokenFromKeyword(offsetKeyword)):
+			target = &slct.offset
+		}
+		if target == nil {
+			break
+		}
+		cursor++
 
-	// Look for INTO
-	if !expectToken(tokens, cursor, tokenFromKeyword(intoKeyword)) {
-		helpMessage(tokens, cursor, "Expected INTO")
-		return nil, initialCursor, false
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected LIMIT or OFFSET expression")
+			return nil, initialCursor, false
+		}
+		*target = exp
+		cursor = newCursor
 	}
 
-	cursor++
-
-	// Look for table name
-	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
-	if !ok {
-		helpMessage(tokens, cursor, "Expected table name")
-		return nil, initialCursor, false
-	}
+	return &slct, cursor, true
 
-	cursor = newCursor
+}
 
-	// Look for VALUES
-	if !expectToken(tokens, cursor, tokenFromKeyword(valuesKeyword)) {
-		helpMessage(tokens, cursor, "Expected VALUES")
-		return nil, initialCursor, false
+// parseTableAlias parses the optional [AS] alias after a table name.
+func parseTableAlias(tokens []*token, initialCursor uint) (token, uint, bool) {
+	cursor := initialCursor
+	as := expectToken(tokens, cursor, tokenFromKeyword(asKeyword))
+	if as {
+		cursor++
 	}
 
-	cursor++
-
-	// Look for left paren
-	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
-		helpMessage(tokens, cursor, "Expected left paren")
-		return nil, initialCursor, false
+	// Without AS, an unreserved keyword like LIMIT starts the next clause
+	// rather than naming the table.
+	alias, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if ok && !as && unreservedKeywords[keyword(alias.value)] {
+		ok = false
 	}
-
-	cursor++
-
-	// Look for expression list
-	values, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromSymbol(rightParenSymbol)})
 	if !ok {
-		return nil, initialCursor, false
-	}
-
-	cursor = newCursor
-
-	// Look for right paren
-	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
-		helpMessage(tokens, cursor, "Expected right paren")
-		return nil, initialCursor, false
+		if as {
+			helpMessage(tokens, cursor, "Expected alias")
+			return token{}, initialCursor, false
+		}
+		return token{}, initialCursor, true
 	}
-
-	cursor++
-
-	return &InsertStatement{Table: *table, Values: values}, cursor, true
-
+	return *alias, newCursor, true
 }
 
-func parseCreateTableStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateTableStatement, uint, bool) {
+// parseJoinClause parses [INNER] JOIN or LEFT [OUTER] JOIN followed by a
+// table, an optional alias and the ON condition. It returns false when there
+// is no join, and a nil clause along with true when there is a malformed one.
+func parseJoinClause(tokens []*token, initialCursor uint) (*joinClause, uint, bool) {
 	cursor := initialCursor
+	join := joinClause{kind: innerJoin}
 
-	// Look for CREATE
-	if !expectToken(tokens, cursor, tokenFromKeyword(createKeyword)) {
-		return nil, initialCursor, false
+	switch {
+	case expectToken(tokens, cursor, tokenFromKeyword(innerKeyword)):
+		cursor++
+	case expectToken(tokens, cursor, tokenFromKeyword(leftKeyword)):
+		join.kind = leftJoin
+		cursor++
+		if expectToken(tokens, cursor, tokenFromKeyword(outerKeyword)) {
+			cursor++
+		}
 	}
 
-	cursor++
-
-	// Look for TABLE
-	if !expectToken(tokens, cursor, tokenFromKeyword(tableKeyword)) {
-		return nil, initialCursor, false
+	if !expectToken(tokens, cursor, tokenFromKeyword(joinKeyword)) {
+		if cursor == initialCursor {
+			return nil, initialCursor, false
+		}
+		helpMessage(tokens, cursor, "Expected JOIN")
+		return nil, initialCursor, true
 	}
-
 	cursor++
 
-	// Look for table name
-	tableName, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
 	if !ok {
 		helpMessage(tokens, cursor, "Expected table name")
-		return nil, initialCursor, false
+		return nil, initialCursor, true
 	}
-
+	join.table = *table
 	cursor = newCursor
 
-	// Look for left paren
-	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
-		helpMessage(tokens, cursor, "Expected left paren")
-		return nil, initialCursor, false
+	join.alias, cursor, ok = parseTableAlias(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, true
 	}
 
+	if !expectToken(tokens, cursor, tokenFromKeyword(onKeyword)) {
+		helpMessage(tokens, cursor, "Expected ON")
+		return nil, initialCursor, true
+	}
 	cursor++
 
-	cols, newCursor, ok := parseColumnDefinitions(tokens, cursor, tokenFromSymbol(rightParenSymbol))
+	on, newCursor, ok := parseExpression(tokens, cursor, 0)
 	if !ok {
-		return nil, initialCursor, false
-	}
-
-	cursor = newCursor
-
-	// Look for right paren
-	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
-		helpMessage(tokens, cursor, "Expected right paren")
-		return nil, initialCursor, false
+		helpMessage(tokens, cursor, "Expected join condition")
+		return nil, initialCursor, true
 	}
+	join.on = on
 
-	cursor++
-
-	return &CreateTableStatement{name: *tableName, cols: cols}, cursor, true
+	return &join, newCursor, true
 }
 
-func parseColumnDefinitions(tokens []*token, initialCursor uint, delimiter token) (*[]*columnDefinition, uint, bool) {
+// parseOrderItems parses the comma separated expressions of ORDER BY, each
+// optionally followed by ASC or DESC.
+func parseOrderItems(tokens []*token, initialCursor uint) ([]*orderItem, uint, bool) {
 	cursor := initialCursor
-
-	cds := []*columnDefinition{}
+	items := []*orderItem{}
 
 	for {
-		if cursor >= uint(len(tokens)) {
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected ORDER BY expression")
 			return nil, initialCursor, false
 		}
+		cursor = newCursor
 
-		// Look for a delimiter
+		item := orderItem{exp: exp}
+		if expectToken(tokens, cursor, tokenFromKeyword(descKeyword)) {
+			item.desc = true
+			cursor++
+		} else if expectToken(tokens, cursor, tokenFromKeyword(ascKeyword)) {
+			cursor++
+		}
+		items = append(items, &item)
+
+		if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
+			return items, cursor, true
+		}
+		cursor++
+	}
+}
+
+// parseSelectItems parses the comma separated items of a SELECT, each with
+// an optional AS alias. The aliases are nil when no item has one.
+func parseSelectItems(tokens []*token, initialCursor uint, delimiters []token) ([]*expression, []token, uint, bool) {
+	cursor := initialCursor
+	items := []*expression{}
+	aliases := []token{}
+	hasAlias := false
+
+outer:
+	for cursor < uint(len(tokens)) {
+		// Look for the delimiter. Before the first item, an unreserved
+		// keyword like LIMIT is a column instead.
 		current := tokens[cursor]
-		if delimiter.equals(current) {
-			break
+		for _, delimiter := range delimiters {
+			if delimiter.equals(current) && (len(items) > 0 || current.kind != identifierKind) {
+				break outer
+			}
 		}
 
 		// Look for a comma
-		if len(cds) > 0 {
+		if len(items) > 0 {
 			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
 				helpMessage(tokens, cursor, "Expected comma")
-				return nil, initialCursor, false
+				return nil, nil, initialCursor, false
 			}
 			cursor++
 		}
 
-		// Look for a column name
-		id, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		// Look for an expression
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
 		if !ok {
-			helpMessage(tokens, cursor, "Expected column name")
-			return nil, initialCursor, false
+			helpMessage(tokens, cursor, "Expected expression")
+			return nil, nil, initialCursor, false
 		}
-
 		cursor = newCursor
 
-		// Look for a column type
-		ty, newCursor, ok := parseToken(tokens, cursor, keywordKind)
-		if !ok {
-			helpMessage(tokens, initialCursor, "Expected column type")
-			return nil, initialCursor, false
+		// Look for AS alias
+		alias := token{}
+		if expectToken(tokens, cursor, tokenFromKeyword(asKeyword)) {
+			cursor++
+			name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected alias")
+				return nil, nil, initialCursor, false
+			}
+			alias = *name
+			cursor = newCursor
+			hasAlias = true
 		}
 
+		items = append(items, exp)
+		aliases = append(aliases, alias)
+	}
+
+	if !hasAlias {
+		aliases = nil
+	}
+	return items, aliases, cursor, true
+}
+
+func parseToken(tokens []*token, initialCursor uint, kind tokenKind) (*token, uint, bool) {
+	cursor := initialCursor
+
+	if cursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	current := tokens[cursor]
+	if current.kind == kind {
+		cursor++
+		return current, cursor, true
+	}
+
+	return nil, initialCursor, false
+}
+
+func parseExpressions(tokens []*token, initialCursor uint, delimiters []token) (*[]*expression, uint, bool) {
+	cursor := initialCursor
+	exps := []*expression{}
+
+outer:
+	for cursor < uint(len(tokens)) {
+		// Look for the delimiter. Before the first expression, an
+		// unreserved keyword like LIMIT is a column instead.
+		current := tokens[cursor]
+		for _, delimiter := range delimiters {
+			if delimiter.equals(current) && (len(exps) > 0 || current.kind != identifierKind) {
+				break outer
+			}
+		}
+
+		// Look for a comma
+		if len(exps) > 0 {
+			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
+				helpMessage(tokens, cursor, "Expected comma")
+				return nil, initialCursor, false
+			}
+			cursor++
+		}
+
+		// Look for an expression
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected expression")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exps = append(exps, exp)
+	}
+
+	return &exps, cursor, true
+}
+
+// bindingPower is how tightly an infix operator holds its operands. Zero
+// means the token is not an infix operator.
+func (t *token) bindingPower() uint {
+	switch t.kind {
+	case keywordKind:
+		switch keyword(t.value) {
+		case orKeyword:
+			return 1
+		case andKeyword:
+			return 2
+		case isKeyword:
+			return 4
+		case inKeyword, betweenKeyword, likeKeyword, ilikeKeyword:
+			return 6
+		}
+	case symbolKind:
+		switch symbol(t.value) {
+		case equalsSymbol, notEqualsSymbol, bangEqualsSymbol, lessThanSymbol,
+			lessThanOrEqualSymbol, greaterThanSymbol, greaterThanOrEqualSymbol:
+			return 5
+		case concatSymbol:
+			return 7
+		case plusSymbol, minusSymbol:
+			return 8
+		case asteriskSymbol, slashSymbol, percentSymbol:
+			return 9
+		}
+	}
+
+	return 0
+}
+
+const (
+	// notBindingPower is the binding power of prefix NOT, which sits between
+	// AND and IS.
+	notBindingPower = 3
+	// negateBindingPower is the binding power of unary minus, which binds
+	// tighter than every infix operator.
+	negateBindingPower = 10
+)
+
+// parseExpression parses operators by precedence climbing, consuming infix
+// operators for as long as they bind tighter than minBp.
+func parseExpression(tokens []*token, initialCursor uint, minBp uint) (*expression, uint, bool) {
+	cursor := initialCursor
+
+	exp, newCursor, ok := parseOperand(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	for cursor < uint(len(tokens)) {
+		op := tokens[cursor]
+		opCursor := cursor
+
+		// NOT IN, NOT BETWEEN, NOT LIKE and NOT ILIKE
+		negate := false
+		if op.matchesKeyword(notKeyword) && cursor+1 < uint(len(tokens)) {
+			next := tokens[cursor+1]
+			if next.matchesKeyword(inKeyword) || next.matchesKeyword(betweenKeyword) ||
+				next.matchesKeyword(likeKeyword) || next.matchesKeyword(ilikeKeyword) {
+				negate = true
+				op = next
+				opCursor = cursor + 1
+			}
+		}
+
+		bp := op.bindingPower()
+		if bp == 0 || bp <= minBp {
+			break
+		}
+		cursor = opCursor + 1
+
+		switch {
+		case op.matchesKeyword(isKeyword):
+			// IS [NOT] NULL | TRUE | FALSE
+			if expectToken(tokens, cursor, tokenFromKeyword(notKeyword)) {
+				negate = true
+				cursor++
+			}
+
+			var rhs *token
+			for _, k := range []keyword{nullKeyword, trueKeyword, falseKeyword} {
+				if expectToken(tokens, cursor, tokenFromKeyword(k)) {
+					rhs = tokens[cursor]
+				}
+			}
+			if rhs == nil {
+				helpMessage(tokens, cursor, "Expected NULL, TRUE or FALSE")
+				return nil, initialCursor, false
+			}
+			cursor++
+
+			exp = &expression{
+				kind:   binaryKind,
+				binary: &binaryExpression{a: exp, b: &expression{kind: literalKind, literal: rhs}, op: *op},
+			}
+		case op.matchesKeyword(inKeyword):
+			if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+				helpMessage(tokens, cursor, "Expected left paren")
+				return nil, initialCursor, false
+			}
+			cursor++
+
+			list, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromSymbol(rightParenSymbol)})
+			if !ok || len(*list) == 0 {
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+				helpMessage(tokens, cursor, "Expected right paren")
+				return nil, initialCursor, false
+			}
+			cursor++
+
+			exp = &expression{kind: inKind, in: &inExpression{operand: exp, list: *list}}
+		case op.matchesKeyword(betweenKeyword):
+			// The bounds bind tighter than AND so that AND separates them.
+			low, newCursor, ok := parseExpression(tokens, cursor, bp)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected lower bound")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			if !expectToken(tokens, cursor, tokenFromKeyword(andKeyword)) {
+				helpMessage(tokens, cursor, "Expected AND")
+				return nil, initialCursor, false
+			}
+			cursor++
+
+			high, newCursor, ok := parseExpression(tokens, cursor, bp)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected upper bound")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &expression{kind: betweenKind, between: &betweenExpression{operand: exp, low: low, high: high}}
+		default:
+			b, newCursor, ok := parseExpression(tokens, cursor, bp)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected right operand")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &expression{kind: binaryKind, binary: &binaryExpression{a: exp, b: b, op: *op}}
+		}
+
+		if negate {
+			exp = &expression{kind: unaryKind, unary: &unaryExpression{operand: exp, op: tokenFromKeyword(notKeyword)}}
+		}
+	}
+
+	return exp, cursor, true
+}
+
+// parseOperand parses everything that can appear on either side of an infix
+// operator: literals, parenthesized expressions, prefix NOT and the keyword
+// led forms like CASE.
+func parseOperand(tokens []*token, initialCursor uint) (*expression, uint, bool) {
+	cursor := initialCursor
+	if cursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	// Look for a parenthesized expression
+	if expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		cursor++
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+			helpMessage(tokens, cursor, "Expected right paren")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		return exp, cursor, true
+	}
+
+	// Look for NOT
+	if expectToken(tokens, cursor, tokenFromKeyword(notKeyword)) {
+		op := tokens[cursor]
+		cursor++
+		operand, newCursor, ok := parseExpression(tokens, cursor, notBindingPower)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected expression after NOT")
+			return nil, initialCursor, false
+		}
+
+		return &expression{kind: unaryKind, unary: &unaryExpression{operand: operand, op: *op}}, newCursor, true
+	}
+
+	// Look for unary minus
+	if expectToken(tokens, cursor, tokenFromSymbol(minusSymbol)) {
+		op := tokens[cursor]
+		cursor++
+		operand, newCursor, ok := parseExpression(tokens, cursor, negateBindingPower)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected expression after minus")
+			return nil, initialCursor, false
+		}
+
+		// A minus before a number becomes part of it, so that the number
+		// is checked against the range of its type with its sign and the
+		// smallest INT can be written.
+		if operand.kind == literalKind && operand.literal.kind == numericKind && operand.literal.value[0] != '-' {
+			literal := *operand.literal
+			literal.value = "-" + literal.value
+			literal.loc = op.loc
+			return &expression{literal: &literal, kind: literalKind}, newCursor, true
+		}
+
+		return &expression{kind: unaryKind, unary: &unaryExpression{operand: operand, op: *op}}, newCursor, true
+	}
+
+	if expectToken(tokens, cursor, tokenFromKeyword(caseKeyword)) {
+		return parseCaseExpression(tokens, cursor)
+	}
+
+	// Look for a function call
+	if cursor+1 < uint(len(tokens)) && tokens[cursor].kind == identifierKind &&
+		expectToken(tokens, cursor+1, tokenFromSymbol(leftParenSymbol)) {
+		return parseCallExpression(tokens, cursor)
+	}
+
+	if expectToken(tokens, cursor, tokenFromKeyword(coalesceKeyword)) ||
+		expectToken(tokens, cursor, tokenFromKeyword(nullifKeyword)) {
+		return parseConditionalFunction(tokens, cursor)
+	}
+
+	// Look for a qualified column name like excluded.id, which is kept as a
+	// single identifier
+	if cursor+2 < uint(len(tokens)) && tokens[cursor].kind == identifierKind &&
+		expectToken(tokens, cursor+1, tokenFromSymbol(dotSymbol)) && tokens[cursor+2].kind == identifierKind {
+		qualified := *tokens[cursor]
+		qualified.value += "." + tokens[cursor+2].value
+		return &expression{literal: &qualified, kind: literalKind}, cursor + 3, true
+	}
+
+	kinds := []tokenKind{identifierKind, numericKind, stringKind}
+	for _, kind := range kinds {
+		t, newCursor, ok := parseToken(tokens, cursor, kind)
+		if ok {
+			return &expression{literal: t, kind: literalKind}, newCursor, true
+		}
+	}
+
+	for _, k := range []keyword{nullKeyword, trueKeyword, falseKeyword} {
+		if expectToken(tokens, cursor, tokenFromKeyword(k)) {
+			return &expression{literal: tokens[cursor], kind: literalKind}, cursor + 1, true
+		}
+	}
+
+	return nil, initialCursor, false
+}
+
+func parseCaseExpression(tokens []*token, initialCursor uint) (*expression, uint, bool) {
+	cursor := initialCursor
+
+	// Look for CASE
+	if !expectToken(tokens, cursor, tokenFromKeyword(caseKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	c := caseExpression{}
+
+	// A simple CASE has an operand before the first WHEN
+	if !expectToken(tokens, cursor, tokenFromKeyword(whenKeyword)) {
+		operand, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHEN or CASE operand")
+			return nil, initialCursor, false
+		}
+		c.operand = operand
+		cursor = newCursor
+	}
+
+	for expectToken(tokens, cursor, tokenFromKeyword(whenKeyword)) {
+		cursor++
+
+		condition, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHEN condition")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		if !expectToken(tokens, cursor, tokenFromKeyword(thenKeyword)) {
+			helpMessage(tokens, cursor, "Expected THEN")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		result, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected THEN result")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		c.whens = append(c.whens, &whenClause{condition: condition, result: result})
+	}
+
+	if len(c.whens) == 0 {
+		helpMessage(tokens, cursor, "Expected WHEN")
+		return nil, initialCursor, false
+	}
+
+	// Look for ELSE
+	if expectToken(tokens, cursor, tokenFromKeyword(elseKeyword)) {
+		cursor++
+
+		elseResult, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected ELSE result")
+			return nil, initialCursor, false
+		}
+		c.elseResult = elseResult
+		cursor = newCursor
+	}
+
+	// Look for END
+	if !expectToken(tokens, cursor, tokenFromKeyword(endKeyword)) {
+		helpMessage(tokens, cursor, "Expected END")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	return &expression{kind: caseKind, caseExp: &c}, cursor, true
+}
+
+func parseCallExpression(tokens []*token, initialCursor uint) (*expression, uint, bool) {
+	cursor := initialCursor
+
+	// Look for function name
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	var args []*expression
+	star := false
+	if expectToken(tokens, cursor, tokenFromSymbol(asteriskSymbol)) {
+		// count(*) takes no arguments
+		star = true
+		cursor++
+	} else if name.value == "position" && !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		// position(substring IN string) uses IN in place of a comma, so the
+		// substring must stop short of parsing IN as an operator.
+		in := tokenFromKeyword(inKeyword)
+		substring, newCursor, ok := parseExpression(tokens, cursor, in.bindingPower())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected substring")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		if !expectToken(tokens, cursor, tokenFromKeyword(inKeyword)) {
+			helpMessage(tokens, cursor, "Expected IN")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		str, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected string")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		args = []*expression{substring, str}
+	} else {
+		exps, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromSymbol(rightParenSymbol)})
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		args = *exps
+	}
+
+	// Look for right paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	return &expression{kind: callKind, call: &callExpression{name: *name, args: args, star: star}}, cursor, true
+}
+
+// parseConditionalFunction parses COALESCE(a, ...) and NULLIF(a, b).
+func parseConditionalFunction(tokens []*token, initialCursor uint) (*expression, uint, bool) {
+	cursor := initialCursor
+	name := tokens[cursor]
+	cursor++
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	args, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromSymbol(rightParenSymbol)})
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	// Look for right paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if keyword(name.value) == nullifKeyword {
+		if len(*args) != 2 {
+			helpMessage(tokens, cursor, "Expected two arguments to NULLIF")
+			return nil, initialCursor, false
+		}
+		return &expression{kind: nullifKind, nullif: &nullifExpression{a: (*args)[0], b: (*args)[1]}}, cursor, true
+	}
+
+	if len(*args) == 0 {
+		helpMessage(tokens, cursor, "Expected at least one argument to COALESCE")
+		return nil, initialCursor, false
+	}
+	return &expression{kind: coalesceKind, coalesce: &coalesceExpression{args: *args}}, cursor, true
+}
+
+func parseInsertStatement(tokens []*token, initialCursor uint, delimiter token) (*InsertStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for INSERT
+	if !expectToken(tokens, cursor, tokenFromKeyword(insertKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for INTO
+	if !expectToken(tokens, cursor, tokenFromKeyword(intoKeyword)) {
+		helpMessage(tokens, cursor, "Expected INTO")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for table name
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	// Look for an optional column list
+	var columns []token
+	if expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		columns, newCursor, ok = parseIdentifierList(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+	}
+
+	// Look for SELECT
+	if slct, newCursor, ok := parseSelectStatement(tokens, cursor, delimiter); ok {
+		cursor = newCursor
+
+		onConflict, newCursor, ok := parseOnConflict(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		returning, newCursor, ok := parseReturning(tokens, cursor, delimiter)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		return &InsertStatement{Table: *table, Columns: columns, Select: slct, OnConflict: onConflict, Returning: returning}, cursor, true
+	}
+
+	// Look for VALUES
+	if !expectToken(tokens, cursor, tokenFromKeyword(valuesKeyword)) {
+		helpMessage(tokens, cursor, "Expected VALUES or SELECT")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for expression list
+	values, newCursor, ok := parseExpressions(tokens, cursor, []token{tokenFromSymbol(rightParenSymbol)})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	// Look for right paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	onConflict, newCursor, ok := parseOnConflict(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	returning, newCursor, ok := parseReturning(tokens, cursor, delimiter)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	return &InsertStatement{Table: *table, Columns: columns, Values: values, OnConflict: onConflict, Returning: returning}, cursor, true
+
+}
+
+func parseCreateTableStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for CREATE
+	if !expectToken(tokens, cursor, tokenFromKeyword(createKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for TABLE
+	if !expectToken(tokens, cursor, tokenFromKeyword(tableKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	// Look for table name
+	tableName, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	using, newCursor, ok := parseUsing(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	// Look for AS SELECT
+	if expectToken(tokens, cursor, tokenFromKeyword(asKeyword)) {
+		cursor++
+		query, newCursor, ok := parseSelectStatement(tokens, cursor, delimiter)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected SELECT")
+			return nil, initialCursor, false
+		}
+
+		return &CreateTableStatement{name: *tableName, query: query, using: using}, newCursor, true
+	}
+	if using.value != "" {
+		helpMessage(tokens, cursor, "Expected AS")
+		return nil, initialCursor, false
+	}
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	cols, constraints, newCursor, ok := parseColumnDefinitions(tokens, cursor, tokenFromSymbol(rightParenSymbol))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	// Look for right paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	cursor++
+
+	using, cursor, ok = parseUsing(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	return &CreateTableStatement{name: *tableName, cols: cols, constraints: constraints, using: using}, cursor, true
+}
+
+// parseUsing parses the optional USING clause naming the layout of a table.
+func parseUsing(tokens []*token, initialCursor uint) (token, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(usingKeyword)) {
+		return token{}, initialCursor, true
+	}
+	cursor++
+
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table layout")
+		return token{}, initialCursor, false
+	}
+	return *name, newCursor, true
+}
+
+// parseIdentifierList parses a parenthesized, comma separated list of
+// identifiers like the columns of a constraint.
+func parseIdentifierList(tokens []*token, initialCursor uint) ([]token, uint, bool) {
+	cursor := initialCursor
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	ids := []token{}
+	for !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		// Look for a comma
+		if len(ids) > 0 {
+			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
+				helpMessage(tokens, cursor, "Expected comma")
+				return nil, initialCursor, false
+			}
+			cursor++
+		}
+
+		id, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected identifier")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		ids = append(ids, *id)
+	}
+	cursor++
+
+	if len(ids) == 0 {
+		helpMessage(tokens, cursor-1, "Expected identifier")
+		return nil, initialCursor, false
+	}
+
+	return ids, cursor, true
+}
+
+func parseReferentialAction(tokens []*token, initialCursor uint) (referentialAction, uint, bool) {
+	cursor := initialCursor
+
+	switch {
+	case expectToken(tokens, cursor, tokenFromKeyword(cascadeKeyword)):
+		return cascadeAction, cursor + 1, true
+	case expectToken(tokens, cursor, tokenFromKeyword(restrictKeyword)):
+		return restrictAction, cursor + 1, true
+	case expectToken(tokens, cursor, tokenFromKeyword(setKeyword)):
+		cursor++
+		if !expectToken(tokens, cursor, tokenFromKeyword(nullKeyword)) {
+			helpMessage(tokens, cursor, "Expected NULL")
+			return restrictAction, initialCursor, false
+		}
+		return setNullAction, cursor + 1, true
+	}
+
+	helpMessage(tokens, cursor, "Expected CASCADE, RESTRICT or SET NULL")
+	return restrictAction, initialCursor, false
+}
+
+// parseReferences parses REFERENCES parent [(columns)] followed by any
+// ON DELETE and ON UPDATE actions into c.
+func parseReferences(tokens []*token, initialCursor uint, c *tableConstraint) (uint, bool) {
+	cursor := initialCursor
+
+	// Look for REFERENCES
+	if !expectToken(tokens, cursor, tokenFromKeyword(referencesKeyword)) {
+		helpMessage(tokens, cursor, "Expected REFERENCES")
+		return initialCursor, false
+	}
+	cursor++
+
+	// Look for the parent table
+	parent, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return initialCursor, false
+	}
+	c.parent = *parent
+	cursor = newCursor
+
+	// Look for the parent columns
+	if expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		columns, newCursor, ok := parseIdentifierList(tokens, cursor)
+		if !ok {
+			return initialCursor, false
+		}
+		c.parentColumns = columns
+		cursor = newCursor
+	}
+
+	// Look for ON DELETE and ON UPDATE
+	for expectToken(tokens, cursor, tokenFromKeyword(onKeyword)) {
+		cursor++
+
+		isDelete := expectToken(tokens, cursor, tokenFromKeyword(deleteKeyword))
+		if !isDelete && !expectToken(tokens, cursor, tokenFromKeyword(updateKeyword)) {
+			helpMessage(tokens, cursor, "Expected DELETE or UPDATE")
+			return initialCursor, false
+		}
+		cursor++
+
+		action, newCursor, ok := parseReferentialAction(tokens, cursor)
+		if !ok {
+			return initialCursor, false
+		}
+		cursor = newCursor
+
+		if isDelete {
+			c.onDelete = action
+		} else {
+			c.onUpdate = action
+		}
+	}
+
+	return cursor, true
+}
+
+// parseCheck parses CHECK (condition) into c.
+func parseCheck(tokens []*token, initialCursor uint, c *tableConstraint) (uint, bool) {
+	cursor := initialCursor
+
+	// Look for CHECK
+	if !expectToken(tokens, cursor, tokenFromKeyword(checkKeyword)) {
+		return initialCursor, false
+	}
+	cursor++
+
+	// Look for left paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return initialCursor, false
+	}
+	cursor++
+
+	check, newCursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected condition")
+		return initialCursor, false
+	}
+	cursor = newCursor
+
+	// Look for right paren
+	if !expectToken(tokens, cursor, tokenFromSymbol(rightParenSymbol)) {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return initialCursor, false
+	}
+	cursor++
+
+	c.kind = CheckConstraint
+	c.check = check
+	return cursor, true
+}
+
+// parseTableConstraint parses [CONSTRAINT name] followed by
+// PRIMARY KEY (columns), UNIQUE (columns), CHECK (condition) or
+// FOREIGN KEY (columns) REFERENCES parent [(columns)].
+func parseTableConstraint(tokens []*token, initialCursor uint) (*tableConstraint, uint, bool) {
+	cursor := initialCursor
+	c := tableConstraint{}
+
+	// Look for CONSTRAINT name
+	if expectToken(tokens, cursor, tokenFromKeyword(constraintKeyword)) {
+		cursor++
+		name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected constraint name")
+			return nil, initialCursor, false
+		}
+		c.name = *name
+		cursor = newCursor
+	}
+
+	if expectToken(tokens, cursor, tokenFromKeyword(checkKeyword)) {
+		newCursor, ok := parseCheck(tokens, cursor, &c)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		return &c, newCursor, true
+	}
+
+	switch {
+	case expectToken(tokens, cursor, tokenFromKeyword(primaryKeyword)):
+		cursor++
+		if !expectToken(tokens, cursor, tokenFromKeyword(keyKeyword)) {
+			helpMessage(tokens, cursor, "Expected KEY")
+			return nil, initialCursor, false
+		}
+		cursor++
+		c.kind = PrimaryKeyConstraint
+	case expectToken(tokens, cursor, tokenFromKeyword(uniqueKeyword)):
+		cursor++
+		c.kind = UniqueConstraint
+	case expectToken(tokens, cursor, tokenFromKeyword(foreignKeyword)):
+		cursor++
+		if !expectToken(tokens, cursor, tokenFromKeyword(keyKeyword)) {
+			helpMessage(tokens, cursor, "Expected KEY")
+			return nil, initialCursor, false
+		}
+		cursor++
+		c.kind = ForeignKeyConstraint
+	default:
+		helpMessage(tokens, cursor, "Expected PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY")
+		return nil, initialCursor, false
+	}
+
+	columns, newCursor, ok := parseIdentifierList(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	c.columns = columns
+	cursor = newCursor
+
+	if c.kind == ForeignKeyConstraint {
+		newCursor, ok := parseReferences(tokens, cursor, &c)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+	}
+
+	return &c, cursor, true
+}
+
+func isTableConstraintStart(t *token) bool {
+	return t.matchesKeyword(constraintKeyword) || t.matchesKeyword(primaryKeyword) ||
+		t.matchesKeyword(uniqueKeyword) || t.matchesKeyword(foreignKeyword) ||
+		t.matchesKeyword(checkKeyword)
+}
+
+func parseColumnDefinitions(tokens []*token, initialCursor uint, delimiter token) (*[]*columnDefinition, []*tableConstraint, uint, bool) {
+	cursor := initialCursor
+
+	cds := []*columnDefinition{}
+	var constraints []*tableConstraint
+
+	for {
+		if cursor >= uint(len(tokens)) {
+			return nil, nil, initialCursor, false
+		}
+
+		// Look for a delimiter
+		current := tokens[cursor]
+		if delimiter.equals(current) {
+			break
+		}
+
+		// Look for a comma
+		if len(cds) > 0 || len(constraints) > 0 {
+			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
+				helpMessage(tokens, cursor, "Expected comma")
+				return nil, nil, initialCursor, false
+			}
+			cursor++
+		}
+
+		// Look for a table constraint
+		if cursor < uint(len(tokens)) && isTableConstraintStart(tokens[cursor]) {
+			c, newCursor, ok := parseTableConstraint(tokens, cursor)
+			if !ok {
+				return nil, nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			constraints = append(constraints, c)
+			continue
+		}
+
+		colDef, newCursor, ok := parseColumnDefinition(tokens, cursor)
+		if !ok {
+			return nil, nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		cds = append(cds, colDef)
+	}
+
+	return &cds, constraints, cursor, true
+}
+
+// parseDatatype parses a column type: a type keyword, or SERIAL or
+// BIGSERIAL, which are unreserved and so lex as identifiers.
+func parseDatatype(tokens []*token, initialCursor uint) (*token, uint, bool) {
+	if expectToken(tokens, initialCursor, tokenFromKeyword(serialKeyword)) ||
+		expectToken(tokens, initialCursor, tokenFromKeyword(bigserialKeyword)) {
+		return tokens[initialCursor], initialCursor + 1, true
+	}
+	return parseToken(tokens, initialCursor, keywordKind)
+}
+
+// parseColumnDefinition parses a column name and type followed by any
+// column constraints.
+func parseColumnDefinition(tokens []*token, initialCursor uint) (*columnDefinition, uint, bool) {
+	cursor := initialCursor
+
+	// Look for a column name
+	id, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column name")
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	// Look for a column type
+	ty, newCursor, ok := parseDatatype(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column type")
+		return nil, initialCursor, false
+	}
+
+	cursor = newCursor
+
+	colDef := &columnDefinition{
+		name:     *id,
+		datatype: *ty,
+	}
+
+	// Look for column constraints
+constraints:
+	for {
+		switch {
+		case expectToken(tokens, cursor, tokenFromKeyword(primaryKeyword)):
+			cursor++
+			if !expectToken(tokens, cursor, tokenFromKeyword(keyKeyword)) {
+				helpMessage(tokens, cursor, "Expected KEY")
+				return nil, initialCursor, false
+			}
+			cursor++
+			colDef.primaryKey = true
+		case expectToken(tokens, cursor, tokenFromKeyword(uniqueKeyword)):
+			cursor++
+			colDef.unique = true
+		case expectToken(tokens, cursor, tokenFromKeyword(notKeyword)):
+			cursor++
+			if !expectToken(tokens, cursor, tokenFromKeyword(nullKeyword)) {
+				helpMessage(tokens, cursor, "Expected NULL")
+				return nil, initialCursor, false
+			}
+			cursor++
+			colDef.notNull = true
+		case expectToken(tokens, cursor, tokenFromKeyword(nullKeyword)):
+			// Nullable is the default
+			cursor++
+		case expectToken(tokens, cursor, tokenFromKeyword(referencesKeyword)):
+			c := tableConstraint{kind: ForeignKeyConstraint, columns: []token{*id}}
+			newCursor, ok := parseReferences(tokens, cursor, &c)
+			if !ok {
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			colDef.references = &c
+		case expectToken(tokens, cursor, tokenFromKeyword(checkKeyword)):
+			c := tableConstraint{columns: []token{*id}}
+			newCursor, ok := parseCheck(tokens, cursor, &c)
+			if !ok {
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			colDef.checks = append(colDef.checks, &c)
+		case expectToken(tokens, cursor, tokenFromKeyword(defaultKeyword)):
+			cursor++
+			exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected default value")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			colDef.defaultValue = exp
+		default:
+			break constraints
+		}
+	}
+
+	return colDef, cursor, true
+}
+
+// parseAssignments parses the column = value list after SET.
+func parseAssignments(tokens []*token, initialCursor uint) ([]*assignment, uint, bool) {
+	cursor := initialCursor
+	set := []*assignment{}
+
+	for {
+		// Look for a comma
+		if len(set) > 0 {
+			if !expectToken(tokens, cursor, tokenFromSymbol(commaSymbol)) {
+				break
+			}
+			cursor++
+		}
+
+		// Look for column = value
+		column, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		if !expectToken(tokens, cursor, tokenFromSymbol(equalsSymbol)) {
+			helpMessage(tokens, cursor, "Expected =")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		value, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected value")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		set = append(set, &assignment{column: *column, value: value})
+	}
+
+	return set, cursor, true
+}
+
+// parseOnConflict parses an optional ON CONFLICT clause of an INSERT,
+// returning nil without one.
+func parseOnConflict(tokens []*token, initialCursor uint) (*onConflictClause, uint, bool) {
+	cursor := initialCursor
+
+	// Look for ON CONFLICT
+	if !expectToken(tokens, cursor, tokenFromKeyword(onKeyword)) {
+		return nil, initialCursor, true
+	}
+	cursor++
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(conflictKeyword)) {
+		helpMessage(tokens, cursor, "Expected CONFLICT")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	c := onConflictClause{}
+
+	// Look for the conflict target
+	if expectToken(tokens, cursor, tokenFromSymbol(leftParenSymbol)) {
+		columns, newCursor, ok := parseIdentifierList(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		c.columns = columns
+		cursor = newCursor
+	}
+
+	// Look for DO
+	if !expectToken(tokens, cursor, tokenFromKeyword(doKeyword)) {
+		helpMessage(tokens, cursor, "Expected DO")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if expectToken(tokens, cursor, tokenFromKeyword(nothingKeyword)) {
+		return &c, cursor + 1, true
+	}
+
+	// Look for UPDATE SET
+	if !expectToken(tokens, cursor, tokenFromKeyword(updateKeyword)) {
+		helpMessage(tokens, cursor, "Expected NOTHING or UPDATE")
+		return nil, initialCursor, false
+	}
+	if c.columns == nil {
+		helpMessage(tokens, cursor, "ON CONFLICT DO UPDATE needs conflict columns")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(setKeyword)) {
+		helpMessage(tokens, cursor, "Expected SET")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	set, newCursor, ok := parseAssignments(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	c.doUpdate = true
+	c.set = set
+	cursor = newCursor
+
+	// Look for WHERE
+	if expectToken(tokens, cursor, tokenFromKeyword(whereKeyword)) {
+		cursor++
+		where, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		c.where = where
+		cursor = newCursor
+	}
+
+	return &c, cursor, true
+}
+
+func parseUpdateStatement(tokens []*token, initialCursor uint, delimiter token) (*UpdateStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for UPDATE
+	if !expectToken(tokens, cursor, tokenFromKeyword(updateKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for table name
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	// Look for SET
+	if !expectToken(tokens, cursor, tokenFromKeyword(setKeyword)) {
+		helpMessage(tokens, cursor, "Expected SET")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	upd := UpdateStatement{table: *table}
+
+	set, newCursor, ok := parseAssignments(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	upd.set = set
+	cursor = newCursor
+
+	// Look for WHERE
+	if expectToken(tokens, cursor, tokenFromKeyword(whereKeyword)) {
+		cursor++
+		where, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		upd.where = where
+		cursor = newCursor
+	}
+
+	returning, newCursor, ok := parseReturning(tokens, cursor, delimiter)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	upd.returning = returning
+	cursor = newCursor
+
+	return &upd, cursor, true
+}
+
+// parseReturning parses an optional RETURNING clause of a write, returning
+// nil items without one.
+func parseReturning(tokens []*token, initialCursor uint, delimiter token) ([]*expression, uint, bool) {
+	cursor := initialCursor
+
+	// Look for RETURNING
+	if !expectToken(tokens, cursor, tokenFromKeyword(returningKeyword)) {
+		return nil, initialCursor, true
+	}
+	cursor++
+
+	exps, newCursor, ok := parseExpressions(tokens, cursor, []token{delimiter})
+	if !ok {
+		return nil, initialCursor, false
+	}
+	if len(*exps) == 0 {
+		helpMessage(tokens, cursor, "Expected RETURNING items")
+		return nil, initialCursor, false
+	}
+
+	return *exps, newCursor, true
+}
+
+func parseDeleteStatement(tokens []*token, initialCursor uint, delimiter token) (*DeleteStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for DELETE
+	if !expectToken(tokens, cursor, tokenFromKeyword(deleteKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for FROM
+	if !expectToken(tokens, cursor, tokenFromKeyword(fromKeyword)) {
+		helpMessage(tokens, cursor, "Expected FROM")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for table name
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	del := DeleteStatement{table: *table}
+
+	// Look for WHERE
+	if expectToken(tokens, cursor, tokenFromKeyword(whereKeyword)) {
+		cursor++
+		where, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		del.where = where
+		cursor = newCursor
+	}
+
+	returning, newCursor, ok := parseReturning(tokens, cursor, delimiter)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	del.returning = returning
+	cursor = newCursor
+
+	return &del, cursor, true
+}
+
+// parseCreateSequenceStatement parses
+// CREATE SEQUENCE name [INCREMENT [BY] n] [START [WITH] n], with the options
+// in any order.
+func parseCreateSequenceStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateSequenceStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for CREATE SEQUENCE
+	if !expectToken(tokens, cursor, tokenFromKeyword(createKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(sequenceKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for sequence name
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected sequence name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	crt := CreateSequenceStatement{name: *name}
+
+	for {
+		var option **expression
+		var noise keyword
+		switch {
+		case expectToken(tokens, cursor, tokenFromKeyword(incrementKeyword)) && crt.increment == nil:
+			option, noise = &crt.increment, byKeyword
+		case expectToken(tokens, cursor, tokenFromKeyword(startKeyword)) && crt.start == nil:
+			option, noise = &crt.start, withKeyword
+		default:
+			return &crt, cursor, true
+		}
+		cursor++
+
+		if expectToken(tokens, cursor, tokenFromKeyword(noise)) {
+			cursor++
+		}
+
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected sequence option value")
+			return nil, initialCursor, false
+		}
 		cursor = newCursor
+		*option = exp
+	}
+}
+
+func parseAlterTableStatement(tokens []*token, initialCursor uint, delimiter token) (*AlterTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for ALTER TABLE
+	if !expectToken(tokens, cursor, tokenFromKeyword(alterKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(tableKeyword)) {
+		helpMessage(tokens, cursor, "Expected TABLE")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for table name
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	alt := AlterTableStatement{table: *table}
 
-		colDef := &columnDefinition{
-			name:     *id,
-			datatype: *ty,
+	// Look for the action, where COLUMN is optional
+	var action keyword
+	for _, k := range []keyword{addKeyword, dropKeyword, renameKeyword, alterKeyword} {
+		if expectToken(tokens, cursor, tokenFromKeyword(k)) {
+			action = k
 		}
+	}
+	if action == "" {
+		helpMessage(tokens, cursor, "Expected ADD, DROP, RENAME or ALTER")
+		return nil, initialCursor, false
+	}
+	cursor++
 
-		cds = append(cds, colDef)
+	// RENAME TO is the whole action when the new name ends the statement,
+	// since a column may be called to too.
+	if action == renameKeyword && expectToken(tokens, cursor, tokenFromKeyword(toKeyword)) &&
+		statementEnds(tokens, cursor+2, delimiter) {
+		cursor++
+		name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected new table name")
+			return nil, initialCursor, false
+		}
+		alt.action = renameTableAction
+		alt.newName = *name
+		return &alt, newCursor, true
+	}
+
+	if optionalColumnKeyword(tokens, cursor, action, delimiter) {
+		cursor++
+	}
+
+	if action == addKeyword {
+		col, newCursor, ok := parseColumnDefinition(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		alt.action = addColumnAction
+		alt.column = col
+		return &alt, newCursor, true
+	}
+
+	// Look for column name
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+	alt.name = *name
+
+	switch action {
+	case dropKeyword:
+		alt.action = dropColumnAction
+	case renameKeyword:
+		if !expectToken(tokens, cursor, tokenFromKeyword(toKeyword)) {
+			helpMessage(tokens, cursor, "Expected TO")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		newName, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected new column name")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		alt.action = renameColumnAction
+		alt.newName = *newName
+	case alterKeyword:
+		if !expectToken(tokens, cursor, tokenFromKeyword(typeKeyword)) {
+			helpMessage(tokens, cursor, "Expected TYPE")
+			return nil, initialCursor, false
+		}
+		cursor++
+
+		ty, newCursor, ok := parseDatatype(tokens, cursor)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column type")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		alt.action = alterColumnTypeAction
+		alt.datatype = *ty
+	}
+
+	return &alt, cursor, true
+}
+
+// optionalColumnKeyword reports whether the token at cursor is the optional
+// COLUMN of action rather than a column called column, which the rest of
+// the action following it tells apart.
+func optionalColumnKeyword(tokens []*token, cursor uint, action keyword, delimiter token) bool {
+	if !expectToken(tokens, cursor, tokenFromKeyword(columnKeyword)) {
+		return false
+	}
+
+	switch action {
+	case addKeyword:
+		// ADD COLUMN name type
+		return cursor+1 < uint(len(tokens)) && tokens[cursor+1].kind == identifierKind
+	case dropKeyword:
+		// DROP COLUMN name
+		return !statementEnds(tokens, cursor+1, delimiter)
+	case renameKeyword:
+		// RENAME COLUMN name TO new
+		return !statementEnds(tokens, cursor+3, delimiter)
+	}
+	// ALTER COLUMN name TYPE type
+	return expectToken(tokens, cursor+2, tokenFromKeyword(typeKeyword))
+}
+
+// statementEnds reports whether the statement ends at cursor.
+func statementEnds(tokens []*token, cursor uint, delimiter token) bool {
+	return cursor >= uint(len(tokens)) || expectToken(tokens, cursor, delimiter)
+}
+
+func parseCreateIndexStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateIndexStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for CREATE [UNIQUE] INDEX
+	if !expectToken(tokens, cursor, tokenFromKeyword(createKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	crt := CreateIndexStatement{}
+	if expectToken(tokens, cursor, tokenFromKeyword(uniqueKeyword)) {
+		crt.unique = true
+		cursor++
+	}
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(indexKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for index name
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+	crt.name = *name
+
+	// Look for ON table
+	if !expectToken(tokens, cursor, tokenFromKeyword(onKeyword)) {
+		helpMessage(tokens, cursor, "Expected ON")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	table, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+	crt.table = *table
+
+	// Look for the indexed columns
+	columns, newCursor, ok := parseIdentifierList(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	if len(columns) == 0 {
+		helpMessage(tokens, cursor, "Expected indexed columns")
+		return nil, initialCursor, false
+	}
+	crt.columns = columns
+
+	return &crt, newCursor, true
+}
+
+func parseDropIndexStatement(tokens []*token, initialCursor uint, delimiter token) (*DropIndexStatement, uint, bool) {
+	cursor := initialCursor
+
+	// Look for DROP INDEX
+	if !expectToken(tokens, cursor, tokenFromKeyword(dropKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	if !expectToken(tokens, cursor, tokenFromKeyword(indexKeyword)) {
+		helpMessage(tokens, cursor, "Expected INDEX")
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	// Look for index name
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index name")
+		return nil, initialCursor, false
+	}
+
+	return &DropIndexStatement{name: *name}, newCursor, true
+}
+
+func parseExplainStatement(tokens []*token, initialCursor uint, delimiter token) (*ExplainStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(explainKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	analyze := expectToken(tokens, cursor, tokenFromKeyword(analyzeKeyword))
+	if analyze {
+		cursor++
+	}
+
+	slct, newCursor, ok := parseSelectStatement(tokens, cursor, delimiter)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected SELECT statement")
+		return nil, initialCursor, false
+	}
+
+	return &ExplainStatement{statement: slct, analyze: analyze}, newCursor, true
+}
+
+func parseAnalyzeStatement(tokens []*token, initialCursor uint, delimiter token) (*AnalyzeStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(analyzeKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	anl := AnalyzeStatement{}
+	if name, newCursor, ok := parseToken(tokens, cursor, identifierKind); ok {
+		anl.table = *name
+		cursor = newCursor
+	}
+
+	return &anl, cursor, true
+}
+
+// parseTransactionKeyword skips the optional TRANSACTION or WORK after
+// BEGIN, COMMIT and ROLLBACK.
+func parseTransactionKeyword(tokens []*token, cursor uint) uint {
+	if expectToken(tokens, cursor, tokenFromKeyword(transactionKeyword)) || expectToken(tokens, cursor, tokenFromKeyword(workKeyword)) {
+		return cursor + 1
+	}
+	return cursor
+}
+
+func parseBeginStatement(tokens []*token, initialCursor uint, delimiter token) (*BeginStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(beginKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor = parseTransactionKeyword(tokens, cursor+1)
+
+	begin := &BeginStatement{}
+	if expectToken(tokens, cursor, tokenFromKeyword(isolationKeyword)) {
+		isolation, newCursor, ok := parseIsolationLevel(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		begin.isolation, cursor = isolation, newCursor
+	}
+	return begin, cursor, true
+}
+
+// parseIsolationLevel parses ISOLATION LEVEL followed by SERIALIZABLE,
+// REPEATABLE READ, READ COMMITTED or READ UNCOMMITTED.
+func parseIsolationLevel(tokens []*token, initialCursor uint) (isolationLevel, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(isolationKeyword)) {
+		return defaultIsolation, initialCursor, false
+	}
+	cursor++
+	if !expectToken(tokens, cursor, tokenFromKeyword(levelKeyword)) {
+		helpMessage(tokens, cursor, "Expected LEVEL")
+		return defaultIsolation, initialCursor, false
+	}
+	cursor++
+
+	switch {
+	case expectToken(tokens, cursor, tokenFromKeyword(serializableKeyword)):
+		return serializable, cursor + 1, true
+	case expectToken(tokens, cursor, tokenFromKeyword(repeatableKeyword)) &&
+		expectToken(tokens, cursor+1, tokenFromKeyword(readKeyword)):
+		return repeatableRead, cursor + 2, true
+	case expectToken(tokens, cursor, tokenFromKeyword(readKeyword)) &&
+		expectToken(tokens, cursor+1, tokenFromKeyword(committedKeyword)):
+		return readCommitted, cursor + 2, true
+	case expectToken(tokens, cursor, tokenFromKeyword(readKeyword)) &&
+		expectToken(tokens, cursor+1, tokenFromKeyword(uncommittedKeyword)):
+		return readUncommitted, cursor + 2, true
+	}
+	helpMessage(tokens, cursor, "Expected isolation level")
+	return defaultIsolation, initialCursor, false
+}
+
+func parseSetTransactionStatement(tokens []*token, initialCursor uint, delimiter token) (*SetTransactionStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(setKeyword)) ||
+		!expectToken(tokens, cursor+1, tokenFromKeyword(transactionKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	cursor += 2
+	if !expectToken(tokens, cursor, tokenFromKeyword(isolationKeyword)) {
+		helpMessage(tokens, cursor, "Expected ISOLATION LEVEL")
+		return nil, initialCursor, false
+	}
+
+	isolation, cursor, ok := parseIsolationLevel(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	return &SetTransactionStatement{isolation: isolation}, cursor, true
+}
+
+func parseCommitStatement(tokens []*token, initialCursor uint, delimiter token) (*CommitStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(commitKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor = parseTransactionKeyword(tokens, cursor+1)
+
+	return &CommitStatement{}, cursor, true
+}
+
+func parseRollbackStatement(tokens []*token, initialCursor uint, delimiter token) (*RollbackStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(rollbackKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor = parseTransactionKeyword(tokens, cursor+1)
+
+	rollback := RollbackStatement{}
+	if expectToken(tokens, cursor, tokenFromKeyword(toKeyword)) {
+		name, newCursor, ok := parseSavepointName(tokens, cursor+1)
+		if !ok {
+			helpMessage(tokens, cursor+1, "Expected savepoint name")
+			return nil, initialCursor, false
+		}
+		rollback.savepoint = *name
+		cursor = newCursor
+	}
+
+	return &rollback, cursor, true
+}
+
+// parseSavepointName parses the name of a savepoint, after an optional
+// SAVEPOINT.
+func parseSavepointName(tokens []*token, cursor uint) (*token, uint, bool) {
+	if expectToken(tokens, cursor, tokenFromKeyword(savepointKeyword)) {
+		cursor++
+	}
+	return parseToken(tokens, cursor, identifierKind)
+}
+
+func parseSavepointStatement(tokens []*token, initialCursor uint, delimiter token) (*SavepointStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(savepointKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	name, newCursor, ok := parseToken(tokens, cursor, identifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected savepoint name")
+		return nil, initialCursor, false
+	}
+
+	return &SavepointStatement{name: *name}, newCursor, true
+}
+
+func parseReleaseSavepointStatement(tokens []*token, initialCursor uint, delimiter token) (*ReleaseSavepointStatement, uint, bool) {
+	cursor := initialCursor
+	if !expectToken(tokens, cursor, tokenFromKeyword(releaseKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	name, newCursor, ok := parseSavepointName(tokens, cursor+1)
+	if !ok {
+		helpMessage(tokens, cursor+1, "Expected savepoint name")
+		return nil, initialCursor, false
 	}
 
-	return &cds, cursor, true
+	return &ReleaseSavepointStatement{name: *name}, newCursor, true
 }
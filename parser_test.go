@@ -133,3 +133,490 @@ func TestParse(t *testing.T) {
 		assert.Equal(t, test.ast, ast, test.source)
 	}
 }
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		source string
+		check  func(exp *expression) bool
+	}{
+		{
+			// AND binds tighter than OR.
+			source: "a = 1 OR b = 2 AND c = 3",
+			check: func(exp *expression) bool {
+				return exp.kind == binaryKind && exp.binary.op.value == "or" &&
+					exp.binary.b.kind == binaryKind && exp.binary.b.binary.op.value == "and"
+			},
+		},
+		{
+			// The AND inside BETWEEN separates the bounds.
+			source: "a BETWEEN 1 AND 2 AND b",
+			check: func(exp *expression) bool {
+				return exp.kind == binaryKind && exp.binary.op.value == "and" &&
+					exp.binary.a.kind == betweenKind && exp.binary.a.between.high.literal.value == "2"
+			},
+		},
+		{
+			source: "a NOT IN (1, 2)",
+			check: func(exp *expression) bool {
+				return exp.kind == unaryKind && exp.unary.operand.kind == inKind &&
+					len(exp.unary.operand.in.list) == 2
+			},
+		},
+		{
+			source: "NOT a IS NOT NULL",
+			check: func(exp *expression) bool {
+				return exp.kind == unaryKind && exp.unary.operand.kind == unaryKind &&
+					exp.unary.operand.unary.operand.kind == binaryKind
+			},
+		},
+		{
+			source: "CASE a WHEN 1 THEN 'x' WHEN 2 THEN 'y' ELSE 'z' END",
+			check: func(exp *expression) bool {
+				return exp.kind == caseKind && exp.caseExp.operand != nil &&
+					len(exp.caseExp.whens) == 2 && exp.caseExp.elseResult.literal.value == "z"
+			},
+		},
+		{
+			source: "COALESCE(a, NULLIF(b, ''), 'none')",
+			check: func(exp *expression) bool {
+				return exp.kind == coalesceKind && len(exp.coalesce.args) == 3 &&
+					exp.coalesce.args[1].kind == nullifKind
+			},
+		},
+		{
+			// Multiplication binds tighter than addition, which binds tighter
+			// than comparison.
+			source: "a + b * 2 > -c",
+			check: func(exp *expression) bool {
+				return exp.kind == binaryKind && exp.binary.op.value == ">" &&
+					exp.binary.a.binary.op.value == "+" && exp.binary.a.binary.b.binary.op.value == "*" &&
+					exp.binary.b.kind == unaryKind
+			},
+		},
+		{
+			source: "position('b' IN lower(a)) || 'x'",
+			check: func(exp *expression) bool {
+				return exp.kind == binaryKind && exp.binary.op.value == "||" &&
+					exp.binary.a.kind == callKind && len(exp.binary.a.call.args) == 2 &&
+					exp.binary.a.call.args[1].kind == callKind
+			},
+		},
+		{
+			source: "count(*) + count(a)",
+			check: func(exp *expression) bool {
+				return exp.binary.a.call.star && len(exp.binary.a.call.args) == 0 &&
+					!exp.binary.b.call.star && exp.String() == "(count(*) + count(a))"
+			},
+		},
+		{
+			source: "(a ILIKE 'x%') = TRUE",
+			check: func(exp *expression) bool {
+				return exp.kind == binaryKind && exp.binary.op.value == "=" &&
+					exp.binary.a.binary.op.value == "ilike"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		tokens, err := lex(test.source)
+		assert.Nil(t, err, test.source)
+
+		exp, cursor, ok := parseExpression(tokens, 0, 0)
+		assert.True(t, ok, test.source)
+		assert.Equal(t, uint(len(tokens)), cursor, test.source)
+		if ok {
+			assert.True(t, test.check(exp), test.source)
+		}
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	ast, err := Parse("CREATE TABLE t (a INT NOT NULL PRIMARY KEY, b TEXT UNIQUE NULL, c INT, CONSTRAINT t_bc UNIQUE (b, c), PRIMARY KEY (c))")
+	assert.Nil(t, err)
+
+	crt := ast.Statements[0].CreateTableStatement
+	cols := *crt.cols
+	assert.Equal(t, 3, len(cols))
+	assert.True(t, cols[0].notNull && cols[0].primaryKey && !cols[0].unique)
+	assert.True(t, !cols[1].notNull && !cols[1].primaryKey && cols[1].unique)
+	assert.True(t, !cols[2].notNull && !cols[2].primaryKey && !cols[2].unique)
+
+	assert.Equal(t, 2, len(crt.constraints))
+	assert.Equal(t, "t_bc", crt.constraints[0].name.value)
+	assert.Equal(t, UniqueConstraint, crt.constraints[0].kind)
+	assert.Equal(t, 2, len(crt.constraints[0].columns))
+	assert.Equal(t, "", crt.constraints[1].name.value)
+	assert.Equal(t, PrimaryKeyConstraint, crt.constraints[1].kind)
+	assert.Equal(t, "c", crt.constraints[1].columns[0].value)
+
+	ast, err = Parse("UPDATE t SET a = a + 1, b = 'x' WHERE c = 2")
+	assert.Nil(t, err)
+
+	upd := ast.Statements[0].UpdateStatement
+	assert.Equal(t, UpdateKind, ast.Statements[0].Kind)
+	assert.Equal(t, "t", upd.table.value)
+	assert.Equal(t, 2, len(upd.set))
+	assert.Equal(t, "(a + 1)", upd.set[0].value.String())
+	assert.Equal(t, "(c = 2)", upd.where.String())
+}
+
+func TestParseForeignKeys(t *testing.T) {
+	ast, err := Parse(`CREATE TABLE t (
+		a INT REFERENCES p ON DELETE CASCADE,
+		b INT,
+		CONSTRAINT t_b FOREIGN KEY (b) REFERENCES q (x) ON UPDATE SET NULL ON DELETE RESTRICT
+	)`)
+	assert.Nil(t, err)
+
+	crt := ast.Statements[0].CreateTableStatement
+	a := (*crt.cols)[0].references
+	assert.Equal(t, ForeignKeyConstraint, a.kind)
+	assert.Equal(t, "a", a.columns[0].value)
+	assert.Equal(t, "p", a.parent.value)
+	assert.Equal(t, 0, len(a.parentColumns))
+	assert.Equal(t, cascadeAction, a.onDelete)
+	assert.Equal(t, restrictAction, a.onUpdate)
+
+	assert.Equal(t, 1, len(crt.constraints))
+	b := crt.constraints[0]
+	assert.Equal(t, "t_b", b.name.value)
+	assert.Equal(t, ForeignKeyConstraint, b.kind)
+	assert.Equal(t, "q", b.parent.value)
+	assert.Equal(t, "x", b.parentColumns[0].value)
+	assert.Equal(t, restrictAction, b.onDelete)
+	assert.Equal(t, setNullAction, b.onUpdate)
+
+	ast, err = Parse("DELETE FROM t WHERE a > 1; DELETE FROM t")
+	assert.Nil(t, err)
+	assert.Equal(t, DeleteKind, ast.Statements[0].Kind)
+	assert.Equal(t, "t", ast.Statements[0].DeleteStatement.table.value)
+	assert.Equal(t, "(a > 1)", ast.Statements[0].DeleteStatement.where.String())
+	assert.Nil(t, ast.Statements[1].DeleteStatement.where)
+}
+
+func TestParseDefaultsAndChecks(t *testing.T) {
+	ast, err := Parse("CREATE TABLE t (a INT DEFAULT 1 + 2 NOT NULL CHECK (a > 0), b TEXT DEFAULT now(), CONSTRAINT t_ab CHECK (a < length(b)))")
+	assert.Nil(t, err)
+
+	crt := ast.Statements[0].CreateTableStatement
+	a := (*crt.cols)[0]
+	assert.Equal(t, "(1 + 2)", a.defaultValue.String())
+	assert.True(t, a.notNull)
+	assert.Equal(t, 1, len(a.checks))
+	assert.Equal(t, CheckConstraint, a.checks[0].kind)
+	assert.Equal(t, "(a > 0)", a.checks[0].check.String())
+	assert.Equal(t, "now()", (*crt.cols)[1].defaultValue.String())
+
+	assert.Equal(t, 1, len(crt.constraints))
+	assert.Equal(t, "t_ab", crt.constraints[0].name.value)
+	assert.Equal(t, CheckConstraint, crt.constraints[0].kind)
+
+	ast, err = Parse("INSERT INTO t (b, a) VALUES ('x', 1)")
+	assert.Nil(t, err)
+	ins := ast.Statements[0].InsertStatement
+	assert.Equal(t, 2, len(ins.Columns))
+	assert.Equal(t, "b", ins.Columns[0].value)
+	assert.Equal(t, 2, len(*ins.Values))
+}
+
+func TestParseCreateSequence(t *testing.T) {
+	ast, err := Parse("CREATE SEQUENCE s START WITH 5 INCREMENT -1; CREATE SEQUENCE t")
+	assert.Nil(t, err)
+
+	seq := ast.Statements[0].CreateSequenceStatement
+	assert.Equal(t, CreateSequenceKind, ast.Statements[0].Kind)
+	assert.Equal(t, "s", seq.name.value)
+	assert.Equal(t, "5", seq.start.String())
+	assert.Equal(t, "-1", seq.increment.String())
+
+	seq = ast.Statements[1].CreateSequenceStatement
+	assert.Nil(t, seq.start)
+	assert.Nil(t, seq.increment)
+
+	_, err = Parse("CREATE SEQUENCE s START START 1")
+	assert.NotNil(t, err)
+}
+
+func TestParseReturning(t *testing.T) {
+	ast, err := Parse("INSERT INTO t VALUES (1) RETURNING a, b + 1; UPDATE t SET a = 2 WHERE b RETURNING a; DELETE FROM t RETURNING lower(c)")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ast.Statements))
+
+	assert.Equal(t, 2, len(ast.Statements[0].InsertStatement.Returning))
+	assert.Equal(t, "(b + 1)", ast.Statements[0].InsertStatement.Returning[1].String())
+	assert.Equal(t, "b", ast.Statements[1].UpdateStatement.where.String())
+	assert.Equal(t, "a", ast.Statements[1].UpdateStatement.returning[0].String())
+	assert.Equal(t, "lower(c)", ast.Statements[2].DeleteStatement.returning[0].String())
+
+	_, err = Parse("DELETE FROM t RETURNING")
+	assert.NotNil(t, err)
+}
+
+func TestParseOnConflict(t *testing.T) {
+	ast, err := Parse("INSERT INTO t VALUES (1, 2) ON CONFLICT DO NOTHING; INSERT INTO t VALUES (1, 2) ON CONFLICT (a, b) DO UPDATE SET b = excluded.b + t.b WHERE excluded.b > .5 RETURNING a")
+	assert.Nil(t, err)
+
+	c := ast.Statements[0].InsertStatement.OnConflict
+	assert.Nil(t, c.columns)
+	assert.False(t, c.doUpdate)
+
+	ins := ast.Statements[1].InsertStatement
+	c = ins.OnConflict
+	assert.Equal(t, 2, len(c.columns))
+	assert.True(t, c.doUpdate)
+	assert.Equal(t, "(excluded.b + t.b)", c.set[0].value.String())
+	assert.Equal(t, "(excluded.b > .5)", c.where.String())
+	assert.Equal(t, 1, len(ins.Returning))
+
+	_, err = Parse("INSERT INTO t VALUES (1) ON CONFLICT DO UPDATE SET a = 1")
+	assert.NotNil(t, err)
+}
+
+func TestParseInsertSelect(t *testing.T) {
+	ast, err := Parse("INSERT INTO t (a) SELECT b AS a FROM u WHERE c ON CONFLICT DO NOTHING RETURNING a; CREATE TABLE v AS SELECT a, b + 1 AS c FROM t GROUP BY a, b")
+	assert.Nil(t, err)
+
+	ins := ast.Statements[0].InsertStatement
+	assert.Nil(t, ins.Values)
+	assert.Equal(t, "u", ins.Select.from.value)
+	assert.Equal(t, "a", ins.Select.aliases[0].value)
+	assert.Equal(t, "c", ins.Select.where.String())
+	assert.NotNil(t, ins.OnConflict)
+	assert.Equal(t, 1, len(ins.Returning))
+
+	crt := ast.Statements[1].CreateTableStatement
+	assert.Nil(t, crt.cols)
+	assert.Equal(t, "v", crt.name.value)
+	assert.Equal(t, 2, len(crt.query.item))
+	assert.Equal(t, "", crt.query.aliases[0].value)
+	assert.Equal(t, "c", crt.query.aliases[1].value)
+	assert.Equal(t, 2, len(crt.query.groupBy))
+}
+
+func TestParseTableLayout(t *testing.T) {
+	ast, err := Parse("CREATE TABLE t (a INT) USING columnar; CREATE TABLE u USING heap AS SELECT a FROM t; CREATE TABLE v (a INT)")
+	assert.Nil(t, err)
+	assert.Equal(t, "columnar", ast.Statements[0].CreateTableStatement.using.value)
+	assert.Equal(t, "heap", ast.Statements[1].CreateTableStatement.using.value)
+	assert.NotNil(t, ast.Statements[1].CreateTableStatement.query)
+	assert.Equal(t, "", ast.Statements[2].CreateTableStatement.using.value)
+
+	for _, source := range []string{
+		"CREATE TABLE t (a INT) USING",
+		"CREATE TABLE t USING columnar",
+		"CREATE TABLE t USING columnar (a INT)",
+	} {
+		_, err = Parse(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestParseAlterTable(t *testing.T) {
+	ast, err := Parse(`ALTER TABLE t ADD COLUMN c INT DEFAULT 0 NOT NULL;
+	ALTER TABLE t ADD d TEXT;
+	ALTER TABLE t DROP COLUMN c;
+	ALTER TABLE t RENAME d TO e;
+	ALTER TABLE t RENAME TO u;
+	ALTER TABLE u ALTER COLUMN e TYPE FLOAT`)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ast.Statements))
+	assert.Equal(t, AlterTableKind, ast.Statements[0].Kind)
+
+	alt := ast.Statements[0].AlterTableStatement
+	assert.Equal(t, addColumnAction, alt.action)
+	assert.Equal(t, "c", alt.column.name.value)
+	assert.Equal(t, "0", alt.column.defaultValue.String())
+	assert.True(t, alt.column.notNull)
+	assert.Equal(t, "d", ast.Statements[1].AlterTableStatement.column.name.value)
+
+	alt = ast.Statements[2].AlterTableStatement
+	assert.Equal(t, dropColumnAction, alt.action)
+	assert.Equal(t, "c", alt.name.value)
+
+	alt = ast.Statements[3].AlterTableStatement
+	assert.Equal(t, renameColumnAction, alt.action)
+	assert.Equal(t, "d", alt.name.value)
+	assert.Equal(t, "e", alt.newName.value)
+
+	alt = ast.Statements[4].AlterTableStatement
+	assert.Equal(t, renameTableAction, alt.action)
+	assert.Equal(t, "u", alt.newName.value)
+
+	alt = ast.Statements[5].AlterTableStatement
+	assert.Equal(t, alterColumnTypeAction, alt.action)
+	assert.Equal(t, "u", alt.table.value)
+	assert.Equal(t, "float", alt.datatype.value)
+
+	// Columns may be called column, to or type too.
+	ast, err = Parse(`ALTER TABLE t ADD column INT;
+	ALTER TABLE t RENAME column TO to;
+	ALTER TABLE t RENAME COLUMN to TO type;
+	ALTER TABLE t ALTER type TYPE TEXT;
+	ALTER TABLE t DROP column;
+	ALTER TABLE t RENAME TO to`)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ast.Statements))
+	assert.Equal(t, "column", ast.Statements[0].AlterTableStatement.column.name.value)
+	for i, names := range [][2]string{{"column", "to"}, {"to", "type"}} {
+		alt = ast.Statements[i+1].AlterTableStatement
+		assert.Equal(t, renameColumnAction, alt.action)
+		assert.Equal(t, names[0], alt.name.value)
+		assert.Equal(t, names[1], alt.newName.value)
+	}
+	assert.Equal(t, "type", ast.Statements[3].AlterTableStatement.name.value)
+	assert.Equal(t, "column", ast.Statements[4].AlterTableStatement.name.value)
+	assert.Equal(t, renameTableAction, ast.Statements[5].AlterTableStatement.action)
+	assert.Equal(t, "to", ast.Statements[5].AlterTableStatement.newName.value)
+
+	for _, source := range []string{"ALTER TABLE t", "ALTER TABLE t DROP", "ALTER TABLE t RENAME a b", "ALTER TABLE t ALTER a FLOAT"} {
+		_, err = Parse(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestParseSelectClauses(t *testing.T) {
+	ast, err := Parse(`SELECT o.id, c.name FROM orders AS o JOIN customers c ON o.customer = c.id LEFT OUTER JOIN items ON items.order_id = o.id WHERE o.id > 1 ORDER BY 2 DESC, o.id LIMIT 10 OFFSET 5;
+	SELECT a FROM t INNER JOIN u ON a = b LEFT JOIN v ON c OFFSET 1 LIMIT 2;
+	EXPLAIN SELECT a FROM t GROUP BY a ORDER BY a;
+	EXPLAIN ANALYZE SELECT a FROM t;
+	ANALYZE;
+	ANALYZE t`)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ast.Statements))
+
+	slct := ast.Statements[0].SelectStatement
+	assert.Equal(t, "orders", slct.from.value)
+	assert.Equal(t, "o", slct.alias.value)
+	assert.Equal(t, 2, len(slct.joins))
+	assert.Equal(t, innerJoin, slct.joins[0].kind)
+	assert.Equal(t, "customers", slct.joins[0].table.value)
+	assert.Equal(t, "c", slct.joins[0].alias.value)
+	assert.Equal(t, "(o.customer = c.id)", slct.joins[0].on.String())
+	assert.Equal(t, leftJoin, slct.joins[1].kind)
+	assert.Equal(t, "", slct.joins[1].alias.value)
+	assert.Equal(t, "(o.id > 1)", slct.where.String())
+	assert.Equal(t, 2, len(slct.orderBy))
+	assert.Equal(t, "2", slct.orderBy[0].exp.String())
+	assert.True(t, slct.orderBy[0].desc)
+	assert.False(t, slct.orderBy[1].desc)
+	assert.Equal(t, "10", slct.limit.String())
+	assert.Equal(t, "5", slct.offset.String())
+
+	slct = ast.Statements[1].SelectStatement
+	assert.Equal(t, "", slct.alias.value)
+	assert.Equal(t, innerJoin, slct.joins[0].kind)
+	assert.Equal(t, leftJoin, slct.joins[1].kind)
+	assert.Equal(t, "2", slct.limit.String())
+	assert.Equal(t, "1", slct.offset.String())
+
+	assert.Equal(t, ExplainKind, ast.Statements[2].Kind)
+	expl := ast.Statements[2].ExplainStatement
+	assert.Equal(t, 1, len(expl.statement.groupBy))
+	assert.Equal(t, 1, len(expl.statement.orderBy))
+	assert.False(t, expl.analyze)
+	assert.True(t, ast.Statements[3].ExplainStatement.analyze)
+
+	assert.Equal(t, AnalyzeKind, ast.Statements[4].Kind)
+	assert.Equal(t, "", ast.Statements[4].AnalyzeStatement.table.value)
+	assert.Equal(t, "t", ast.Statements[5].AnalyzeStatement.table.value)
+
+	ast, err = Parse("SELECT limit FROM t LIMIT 1; SELECT a FROM t u OFFSET 2")
+	assert.Nil(t, err)
+	assert.Equal(t, "limit", ast.Statements[0].SelectStatement.item[0].String())
+	assert.Equal(t, "", ast.Statements[0].SelectStatement.alias.value)
+	assert.Equal(t, "1", ast.Statements[0].SelectStatement.limit.String())
+	assert.Equal(t, "u", ast.Statements[1].SelectStatement.alias.value)
+	assert.Equal(t, "2", ast.Statements[1].SelectStatement.offset.String())
+
+	for _, source := range []string{
+		"SELECT a FROM t JOIN u",
+		"SELECT a FROM t JOIN u ON",
+		"SELECT a FROM t LEFT u ON a",
+		"SELECT a FROM t AS",
+		"SELECT a FROM t ORDER a",
+		"SELECT a FROM t ORDER BY",
+		"SELECT a FROM t LIMIT",
+		"SELECT a FROM t LIMIT 1 LIMIT 2",
+		"EXPLAIN",
+		"EXPLAIN ANALYZE",
+		"EXPLAIN INSERT INTO t VALUES (1)",
+		"ANALYZE t u",
+	} {
+		_, err = Parse(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestParseTransactionStatements(t *testing.T) {
+	ast, err := Parse("BEGIN; BEGIN TRANSACTION; COMMIT; COMMIT WORK; ROLLBACK; ROLLBACK TRANSACTION")
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ast.Statements))
+
+	kinds := []AstKind{BeginKind, BeginKind, CommitKind, CommitKind, RollbackKind, RollbackKind}
+	for i, kind := range kinds {
+		assert.Equal(t, kind, ast.Statements[i].Kind)
+	}
+	assert.NotNil(t, ast.Statements[1].BeginStatement)
+	assert.NotNil(t, ast.Statements[3].CommitStatement)
+	assert.NotNil(t, ast.Statements[5].RollbackStatement)
+
+	for _, source := range []string{
+		"BEGIN t",
+		"COMMIT TRANSACTION WORK",
+		"ROLLBACK t",
+		"ROLLBACK TO",
+		"ROLLBACK TO SAVEPOINT",
+		"SAVEPOINT",
+		"RELEASE",
+		"RELEASE SAVEPOINT",
+	} {
+		_, err = Parse(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestParseSetTransactionStatements(t *testing.T) {
+	ast, err := Parse(`SET TRANSACTION ISOLATION LEVEL SERIALIZABLE;
+	SET TRANSACTION ISOLATION LEVEL REPEATABLE READ;
+	SET TRANSACTION ISOLATION LEVEL READ COMMITTED;
+	SET TRANSACTION ISOLATION LEVEL READ UNCOMMITTED;
+	BEGIN ISOLATION LEVEL SERIALIZABLE;
+	BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ`)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ast.Statements))
+
+	for i, level := range []isolationLevel{serializable, repeatableRead, readCommitted, readUncommitted} {
+		assert.Equal(t, SetTransactionKind, ast.Statements[i].Kind)
+		assert.Equal(t, level, ast.Statements[i].SetTransactionStatement.isolation)
+	}
+	assert.Equal(t, serializable, ast.Statements[4].BeginStatement.isolation)
+	assert.Equal(t, repeatableRead, ast.Statements[5].BeginStatement.isolation)
+
+	for _, source := range []string{
+		"SET TRANSACTION",
+		"SET TRANSACTION ISOLATION",
+		"SET TRANSACTION ISOLATION LEVEL",
+		"SET TRANSACTION ISOLATION LEVEL READ",
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE",
+		"SET TRANSACTION LEVEL SERIALIZABLE",
+		"BEGIN ISOLATION LEVEL",
+	} {
+		_, err = Parse(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestParseSavepointStatements(t *testing.T) {
+	ast, err := Parse("SAVEPOINT a; RELEASE SAVEPOINT a; RELEASE b; ROLLBACK TO SAVEPOINT a; ROLLBACK WORK TO b")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(ast.Statements))
+
+	assert.Equal(t, SavepointKind, ast.Statements[0].Kind)
+	assert.Equal(t, "a", ast.Statements[0].SavepointStatement.name.value)
+	assert.Equal(t, ReleaseSavepointKind, ast.Statements[1].Kind)
+	assert.Equal(t, "a", ast.Statements[1].ReleaseSavepointStatement.name.value)
+	assert.Equal(t, "b", ast.Statements[2].ReleaseSavepointStatement.name.value)
+	assert.Equal(t, RollbackKind, ast.Statements[3].Kind)
+	assert.Equal(t, "a", ast.Statements[3].RollbackStatement.savepoint.value)
+	assert.Equal(t, "b", ast.Statements[4].RollbackStatement.savepoint.value)
+}
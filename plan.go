@@ -0,0 +1,555 @@
+package gogn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type planKind uint
+
+const (
+	scanPlan planKind = iota
+	joinPlan
+	filterPlan
+	aggregatePlan
+	sortPlan
+	projectPlan
+	limitPlan
+)
+
+// logicalPlan is a node of the tree of relational operators a SELECT is
+// built into, before choosing how each one is carried out. schema holds the
+// columns of the rows the node produces.
+type logicalPlan struct {
+	kind   planKind
+	schema *table
+	input  *logicalPlan
+	// right is the right side of a join, whose left side is input.
+	right *logicalPlan
+
+	// table is the table a scan reads.
+	table *table
+	// condition is the filter of a scan or a filter, or the ON condition
+	// of a join.
+	condition *expression
+	join      joinKind
+
+	groupBy    []*expression
+	aggregates []*groupedAggregate
+	orderBy    []*orderItem
+	items      []*expression
+
+	// limit is -1 without LIMIT.
+	limit, offset int
+}
+
+// logicalScan plans reading the table or system view called name, which
+// the query refers to by alias when it has one.
+func (mb *MemoryBackend) logicalScan(name, alias token) (*logicalPlan, error) {
+	t, ok := mb.tables[name.value]
+	if !ok {
+		t, ok = mb.systemView(name.value)
+	}
+	if !ok {
+		return nil, ErrTableDoesNotExist
+	}
+
+	schema := *t
+	if alias.value != "" {
+		schema.name = alias.value
+	}
+	return &logicalPlan{kind: scanPlan, table: t, schema: &schema}, nil
+}
+
+// joinSchema is the schema of the rows of left followed by the rows of
+// right, with every column qualified with the name of its table.
+func joinSchema(left, right *table) *table {
+	schema := &table{}
+	for _, side := range []*table{left, right} {
+		for i, col := range side.columns {
+			if !strings.Contains(col, ".") {
+				col = side.name + "." + col
+			}
+			schema.columns = append(schema.columns, col)
+			schema.columnTypes = append(schema.columnTypes, side.columnTypes[i])
+		}
+	}
+	return schema
+}
+
+// orderItems resolves the ORDER BY items of slct, which may name a select
+// item by its position or alias instead of repeating it.
+func orderItems(slct *SelectStatement) ([]*orderItem, error) {
+	items := []*orderItem{}
+	for _, item := range slct.orderBy {
+		exp := item.exp
+		if exp.kind == literalKind && exp.literal.kind == numericKind {
+			position, err := strconv.Atoi(exp.literal.value)
+			if err != nil || position < 1 || position > len(slct.item) {
+				return nil, fmt.Errorf("%w: ORDER BY position %s is not in select list", ErrColumnDoesNotExist, exp.literal.value)
+			}
+			exp = slct.item[position-1]
+		} else if exp.kind == literalKind && exp.literal.kind == identifierKind && slct.aliases != nil {
+			for i, alias := range slct.aliases {
+				if alias.value == exp.literal.value {
+					exp = slct.item[i]
+					break
+				}
+			}
+		}
+		items = append(items, &orderItem{exp: exp, desc: item.desc})
+	}
+	return items, nil
+}
+
+// limitValue evaluates a LIMIT or OFFSET expression, which must be a
+// constant, non-negative integer. It returns -1 for a missing or NULL limit.
+func (mb *MemoryBackend) limitValue(exp *expression) (int, error) {
+	if exp == nil {
+		return -1, nil
+	}
+	if !isConstant(exp) {
+		return -1, fmt.Errorf("%w: %s is not a constant", ErrInvalidLimit, exp)
+	}
+
+	cell, typ, err := mb.evaluateCell(&table{}, nil, exp)
+	if err != nil {
+		return -1, err
+	}
+	if cell == nil {
+		return -1, nil
+	}
+	if typ != IntType {
+		return -1, fmt.Errorf("%w: expected integer, got %s", ErrInvalidLimit, typ)
+	}
+	if cell.AsInt() < 0 {
+		return -1, fmt.Errorf("%w: %d is negative", ErrInvalidLimit, cell.AsInt())
+	}
+	return int(cell.AsInt()), nil
+}
+
+// logicalSelect builds the logical plan of slct, type checking it along the
+// way.
+func (mb *MemoryBackend) logicalSelect(slct *SelectStatement) (*logicalPlan, error) {
+	var plan *logicalPlan
+	if slct.from.value == "" {
+		// A SELECT without FROM is evaluated once against an empty row.
+		t := &table{}
+		t.appendRow(nil, rowVersion{})
+		plan = &logicalPlan{kind: scanPlan, table: t, schema: t}
+	} else {
+		var err error
+		plan, err = mb.logicalScan(slct.from, slct.alias)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, join := range slct.joins {
+		right, err := mb.logicalScan(join.table, join.alias)
+		if err != nil {
+			return nil, err
+		}
+
+		schema := joinSchema(plan.schema, right.schema)
+		if err := mb.checkCondition(schema, join.on); err != nil {
+			return nil, err
+		}
+		plan = &logicalPlan{kind: joinPlan, schema: schema, input: plan, right: right, join: join.kind, condition: join.on}
+	}
+
+	if slct.where != nil {
+		if err := mb.checkCondition(plan.schema, slct.where); err != nil {
+			return nil, err
+		}
+		plan = &logicalPlan{kind: filterPlan, schema: plan.schema, input: plan, condition: slct.where}
+	}
+
+	orderBy, err := orderItems(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	items := slct.item
+	isAggregate := len(slct.groupBy) > 0
+	for _, exp := range items {
+		isAggregate = isAggregate || mb.containsAggregate(exp)
+	}
+	for _, item := range orderBy {
+		isAggregate = isAggregate || mb.containsAggregate(item.exp)
+	}
+	if isAggregate {
+		grouped, aggregates, rewritten, err := mb.planGrouping(plan.schema, items, slct.groupBy)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range orderBy {
+			item.exp, err = mb.rewriteGrouped(plan.schema, item.exp, slct.groupBy, grouped, &aggregates)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		plan = &logicalPlan{kind: aggregatePlan, schema: grouped, input: plan, groupBy: slct.groupBy, aggregates: aggregates}
+		items = rewritten
+	}
+
+	if len(orderBy) > 0 {
+		for _, item := range orderBy {
+			if _, err := mb.expressionType(plan.schema, item.exp); err != nil {
+				return nil, err
+			}
+		}
+		plan = &logicalPlan{kind: sortPlan, schema: plan.schema, input: plan, orderBy: orderBy}
+	}
+
+	// Name the columns after the items as written, before grouping
+	// rewrote them.
+	projected := &table{}
+	for i, item := range slct.item {
+		typ, err := mb.expressionType(plan.schema, items[i])
+		if err != nil {
+			return nil, err
+		}
+
+		name := columnName(item)
+		if slct.aliases != nil && slct.aliases[i].value != "" {
+			name = slct.aliases[i].value
+		}
+		projected.columns = append(projected.columns, name)
+		projected.columnTypes = append(projected.columnTypes, typ)
+	}
+	plan = &logicalPlan{kind: projectPlan, schema: projected, input: plan, items: items}
+
+	if slct.limit != nil || slct.offset != nil {
+		limit, err := mb.limitValue(slct.limit)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := mb.limitValue(slct.offset)
+		if err != nil {
+			return nil, err
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		plan = &logicalPlan{kind: limitPlan, schema: plan.schema, input: plan, limit: limit, offset: offset}
+	}
+
+	return plan, nil
+}
+
+// constantLiteral evaluates the constant expression exp into a literal of
+// the same type. It returns false when evaluating it fails, so the error
+// still happens when the query runs, and for values no literal can spell.
+func (mb *MemoryBackend) constantLiteral(exp *expression) (*expression, bool) {
+	cell, typ, err := mb.evaluateCell(&table{}, nil, exp)
+	if err != nil || cell == nil {
+		return nil, false
+	}
+
+	var lit token
+	switch typ {
+	case IntType:
+		lit = token{kind: numericKind, value: strconv.Itoa(int(cell.AsInt()))}
+	case FloatType:
+		f := cell.AsFloat()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, false
+		}
+
+		// Floats need a decimal point to stay floats.
+		value := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(value, ".e") {
+			value += ".0"
+		}
+		lit = token{kind: numericKind, value: value}
+	case TextType:
+		lit = token{kind: stringKind, value: cell.AsText()}
+	case BoolType:
+		lit = tokenFromKeyword(falseKeyword)
+		if cell.AsBool() {
+			lit = tokenFromKeyword(trueKeyword)
+		}
+	default:
+		return nil, false
+	}
+	return &expression{kind: literalKind, literal: &lit}, true
+}
+
+// foldConstants replaces the constant subexpressions of exp with their
+// values.
+func (mb *MemoryBackend) foldConstants(exp *expression) *expression {
+	if exp == nil {
+		return nil
+	}
+
+	if exp.kind != literalKind && isConstant(exp) {
+		if lit, ok := mb.constantLiteral(exp); ok {
+			return lit
+		}
+	}
+
+	folded, _ := exp.mapChildren(func(child *expression) (*expression, error) {
+		return mb.foldConstants(child), nil
+	})
+	return folded
+}
+
+// foldPlan folds the constants of every expression in plan.
+func (mb *MemoryBackend) foldPlan(plan *logicalPlan) {
+	if plan == nil {
+		return
+	}
+
+	fold := func(exps []*expression) []*expression {
+		folded := []*expression{}
+		for _, exp := range exps {
+			folded = append(folded, mb.foldConstants(exp))
+		}
+		return folded
+	}
+
+	plan.condition = mb.foldConstants(plan.condition)
+	plan.groupBy = fold(plan.groupBy)
+	plan.items = fold(plan.items)
+
+	aggregates := []*groupedAggregate{}
+	for _, agg := range plan.aggregates {
+		call := *agg.call
+		call.args = fold(call.args)
+		aggregates = append(aggregates, &groupedAggregate{call: &call, fn: agg.fn, argTypes: agg.argTypes})
+	}
+	plan.aggregates = aggregates
+
+	orderBy := []*orderItem{}
+	for _, item := range plan.orderBy {
+		orderBy = append(orderBy, &orderItem{exp: mb.foldConstants(item.exp), desc: item.desc})
+	}
+	plan.orderBy = orderBy
+
+	mb.foldPlan(plan.input)
+	mb.foldPlan(plan.right)
+}
+
+// conjuncts splits exp into the conditions it ANDs together.
+func conjuncts(exp *expression) []*expression {
+	if exp == nil {
+		return nil
+	}
+	if exp.kind == binaryKind && exp.binary.op.matchesKeyword(andKeyword) {
+		return append(conjuncts(exp.binary.a), conjuncts(exp.binary.b)...)
+	}
+	return []*expression{exp}
+}
+
+// conjoin ANDs exps together, giving nil for none.
+func conjoin(exps []*expression) *expression {
+	var exp *expression
+	for _, e := range exps {
+		if exp == nil {
+			exp = e
+			continue
+		}
+		exp = &expression{kind: binaryKind, binary: &binaryExpression{a: exp, b: e, op: tokenFromKeyword(andKeyword)}}
+	}
+	return exp
+}
+
+type joinSide uint
+
+const (
+	leftSide joinSide = iota
+	rightSide
+	bothSides
+)
+
+// side tells which side of the join plan the columns exp refers to come
+// from. Conditions without columns count as the left side.
+func (plan *logicalPlan) side(exp *expression) joinSide {
+	left, right := false, false
+	for _, col := range plan.schema.referencedColumns(exp) {
+		if col < len(plan.input.schema.columns) {
+			left = true
+		} else {
+			right = true
+		}
+	}
+
+	switch {
+	case left && right:
+		return bothSides
+	case right:
+		return rightSide
+	}
+	return leftSide
+}
+
+// withFilter puts a filter of predicates on top of plan, if there are any.
+func withFilter(plan *logicalPlan, predicates []*expression) *logicalPlan {
+	if len(predicates) == 0 {
+		return plan
+	}
+	return &logicalPlan{kind: filterPlan, schema: plan.schema, input: plan, condition: conjoin(predicates)}
+}
+
+// pushDown moves predicates, which filter the rows of plan, and the filters
+// within plan as far down towards the scans as they go. Predicates are
+// never moved below the side of a LEFT JOIN that gets NULLs for rows
+// without a match, since that would keep the rows they filter out.
+func (mb *MemoryBackend) pushDown(plan *logicalPlan, predicates []*expression) *logicalPlan {
+	switch plan.kind {
+	case filterPlan:
+		return mb.pushDown(plan.input, append(conjuncts(plan.condition), predicates...))
+	case scanPlan:
+		if len(predicates) > 0 {
+			plan.condition = conjoin(append(conjuncts(plan.condition), predicates...))
+		}
+		return plan
+	case joinPlan:
+		left, right, on, above := []*expression{}, []*expression{}, []*expression{}, []*expression{}
+
+		// The ON condition of a LEFT JOIN decides which right rows match,
+		// so only conditions on the right side can move out of it.
+		for _, p := range conjuncts(plan.condition) {
+			switch side := plan.side(p); {
+			case side == rightSide:
+				right = append(right, p)
+			case side == leftSide && plan.join == innerJoin:
+				left = append(left, p)
+			default:
+				on = append(on, p)
+			}
+		}
+
+		for _, p := range predicates {
+			switch side := plan.side(p); {
+			case side == leftSide:
+				left = append(left, p)
+			case plan.join == leftJoin:
+				above = append(above, p)
+			case side == rightSide:
+				right = append(right, p)
+			default:
+				on = append(on, p)
+			}
+		}
+
+		plan.input = mb.pushDown(plan.input, left)
+		plan.right = mb.pushDown(plan.right, right)
+		plan.condition = conjoin(on)
+		return withFilter(plan, above)
+	}
+
+	plan.input = mb.pushDown(plan.input, nil)
+	return withFilter(plan, predicates)
+}
+
+// planSelect plans slct: it builds the logical plan, rewrites it and picks
+// the cheapest way to carry out each operator.
+func (mb *MemoryBackend) planSelect(slct *SelectStatement) (planNode, error) {
+	plan, err := mb.logicalSelect(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	mb.foldPlan(plan)
+	plan = mb.pushDown(plan, nil)
+	return mb.physicalPlan(plan), nil
+}
+
+// Explain returns the plan of a SELECT as one row of text per line. With
+// ANALYZE it also runs the query, adding what each operator actually did to
+// its line.
+func (mb *MemoryBackend) Explain(expl *ExplainStatement) (*Results, error) {
+	var result *Results
+	err := mb.inTransaction(func(w *MemoryBackend) (err error) {
+		result, err = w.explain(expl)
+		return err
+	})
+	return result, err
+}
+
+func (mb *MemoryBackend) explain(expl *ExplainStatement) (*Results, error) {
+	start := time.Now()
+	plan, err := mb.planSelect(expl.statement)
+	if err != nil {
+		return nil, err
+	}
+	planning := time.Since(start)
+
+	var execution time.Duration
+	if expl.analyze {
+		var analyze func(node planNode)
+		analyze = func(node planNode) {
+			node.analyze()
+			for _, child := range node.children() {
+				analyze(child)
+			}
+		}
+		analyze(plan)
+
+		start = time.Now()
+		it, err := run(mb, plan)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := collect(it); err != nil {
+			return nil, err
+		}
+		execution = time.Since(start)
+	}
+
+	lines := explainLines(plan, "", false)
+	if expl.analyze {
+		lines = append(lines, "Planning Time: "+milliseconds(planning), "Execution Time: "+milliseconds(execution))
+	}
+
+	results := &Results{Columns: []struct {
+		Type ColumnType
+		Name string
+	}{{Type: TextType, Name: "QUERY PLAN"}}}
+	for _, line := range lines {
+		results.Rows = append(results.Rows, []Cell{MemoryCell(line)})
+	}
+	return results, nil
+}
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
+
+// explainLines formats node and its children like Postgres does, with each
+// child on an arrow indented below its parent. The rows and time of an
+// analyzed node are averages over the times it ran.
+func explainLines(node planNode, indent string, arrow bool) []string {
+	head, inner := indent, indent+"  "
+	if arrow {
+		head, inner = indent+"->  ", indent+"      "
+	}
+
+	name, details := node.describe()
+	e := node.estimate()
+	line := fmt.Sprintf("%s%s  (cost=%.2f rows=%.0f)", head, name, e.cost, e.rows)
+	if act := node.actual(); act != nil {
+		if act.loops == 0 {
+			line += " (never executed)"
+		} else {
+			loops := time.Duration(act.loops)
+			line += fmt.Sprintf(" (actual time=%s rows=%d loops=%d)", milliseconds(act.time/loops), act.rows/act.loops, act.loops)
+		}
+	}
+
+	lines := []string{line}
+	for _, detail := range details {
+		lines = append(lines, inner+detail)
+	}
+	for _, child := range node.children() {
+		lines = append(lines, explainLines(child, inner, true)...)
+	}
+	return lines
+}
@@ -9,7 +9,17 @@ import (
 )
 
 func main() {
-	mb := NewMemoryBackend()
+	// With a path argument the database is kept in that file, and otherwise
+	// only in memory.
+	var mb Backend = NewMemoryBackend()
+	if len(os.Args) > 1 {
+		db, err := OpenDiskBackend(os.Args[1])
+		if err != nil {
+			panic(err)
+		}
+		mb = db
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Welcome to gogn SQL")
 
@@ -18,6 +28,27 @@ func main() {
 		text, err := reader.ReadString('\n')
 		text = strings.Replace(text, "\n", "", -1)
 
+		// .save and .load write an in-memory database to a snapshot file
+		// and replace it with the one in a snapshot file.
+		if command, path, ok := strings.Cut(text, " "); ok && (command == ".save" || command == ".load") {
+			memory, ok := mb.(*MemoryBackend)
+			if !ok {
+				fmt.Println("Snapshots are only for in-memory databases")
+				continue
+			}
+
+			if command == ".save" {
+				err = saveSnapshot(memory, path)
+			} else if memory, err = loadSnapshot(path); err == nil {
+				mb = memory
+			}
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("ok")
+			continue
+		}
+
 		ast, err := Parse(text)
 		if err != nil {
 			panic(err)
@@ -32,46 +63,198 @@ func main() {
 				}
 				fmt.Println("ok")
 			case InsertKind:
-				err = mb.Insert(stmt.InsertStatement)
+				results, err := mb.Insert(stmt.InsertStatement)
+				if err != nil {
+					panic(err)
+				}
+				if results != nil {
+					printResults(results)
+				}
+				fmt.Println("ok")
+			case UpdateKind:
+				results, err := mb.Update(stmt.UpdateStatement)
+				if err != nil {
+					panic(err)
+				}
+				if results != nil {
+					printResults(results)
+				}
+				fmt.Println("ok")
+			case DeleteKind:
+				results, err := mb.Delete(stmt.DeleteStatement)
+				if err != nil {
+					panic(err)
+				}
+				if results != nil {
+					printResults(results)
+				}
+				fmt.Println("ok")
+			case CreateSequenceKind:
+				err = mb.CreateSequence(stmt.CreateSequenceStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case AlterTableKind:
+				err = mb.AlterTable(stmt.AlterTableStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case CreateIndexKind:
+				err = mb.CreateIndex(stmt.CreateIndexStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case DropIndexKind:
+				err = mb.DropIndex(stmt.DropIndexStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case AnalyzeKind:
+				err = mb.Analyze(stmt.AnalyzeStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case BeginKind:
+				err = mb.Begin(stmt.BeginStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case CommitKind:
+				err = mb.Commit(stmt.CommitStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case RollbackKind:
+				err = mb.Rollback(stmt.RollbackStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case SavepointKind:
+				err = mb.Savepoint(stmt.SavepointStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case ReleaseSavepointKind:
+				err = mb.ReleaseSavepoint(stmt.ReleaseSavepointStatement)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("ok")
+			case SetTransactionKind:
+				err = mb.SetTransaction(stmt.SetTransactionStatement)
 				if err != nil {
 					panic(err)
 				}
 				fmt.Println("ok")
 			case SelectKind:
-				results, err := mb.Select(stmt.SelectStatement)
+				rows, err := mb.Query(stmt.SelectStatement)
 				if err != nil {
 					panic(err)
 				}
 
-				for _, col := range results.Columns {
-					fmt.Printf("| %s ", col.Name)
+				// Print each row as soon as it's produced.
+				printColumns(rows.Columns)
+				for rows.Next() {
+					printRow(rows.Columns, rows.Row())
 				}
-				fmt.Println("|")
-
-				for i := 0; i < 20; i++ {
-					fmt.Printf("=")
+				if err := rows.Err(); err != nil {
+					panic(err)
 				}
-				fmt.Println()
-
-				for _, result := range results.Rows {
-					fmt.Printf("|")
-
-					for i, cell := range result {
-						typ := results.Columns[i].Type
-						s := ""
-						switch typ {
-						case IntType:
-							s = fmt.Sprintf("%d", cell.AsInt())
-						case TextType:
-							s = cell.AsText()
-						}
-
-						fmt.Printf(" %s | ", s)
-					}
-					fmt.Println()
+				fmt.Println("ok")
+			case ExplainKind:
+				results, err := mb.Explain(stmt.ExplainStatement)
+				if err != nil {
+					panic(err)
 				}
+
+				printResults(results)
 				fmt.Println("ok")
 			}
 		}
 	}
 }
+
+func saveSnapshot(mb *MemoryBackend, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	if err := mb.Save(w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func loadSnapshot(path string) (*MemoryBackend, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadMemoryBackend(bufio.NewReader(file))
+}
+
+type columns = []struct {
+	Type ColumnType
+	Name string
+}
+
+func printResults(results *Results) {
+	printColumns(results.Columns)
+	for _, result := range results.Rows {
+		printRow(results.Columns, result)
+	}
+}
+
+func printColumns(cols columns) {
+	for _, col := range cols {
+		fmt.Printf("| %s ", col.Name)
+	}
+	fmt.Println("|")
+
+	for i := 0; i < 20; i++ {
+		fmt.Printf("=")
+	}
+	fmt.Println()
+}
+
+func printRow(cols columns, row []Cell) {
+	fmt.Printf("|")
+
+	for i, cell := range row {
+		typ := cols[i].Type
+		s := ""
+		switch {
+		case cell.IsNull():
+			s = "NULL"
+		case typ == IntType:
+			s = fmt.Sprintf("%d", cell.AsInt())
+		case typ == TextType:
+			s = cell.AsText()
+		case typ == FloatType:
+			s = fmt.Sprintf("%g", cell.AsFloat())
+		case typ == BoolType:
+			s = fmt.Sprintf("%t", cell.AsBool())
+		}
+
+		fmt.Printf(" %s | ", s)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,618 @@
+package gogn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// rowTree is a B+tree in the pages of a buffer pool holding the rows of a
+// table, keyed by their ids. Internal pages hold keys and the
+// pages of the children between them, and leaf pages hold the rows, linked
+// left to right for scanning. Rows too large to share a leaf go in overflow
+// pages.
+type rowTree struct {
+	pool  *bufferPool
+	root  pageID
+	count int
+}
+
+// A leaf page is its kind, the number of rows, the next leaf and then each
+// row's key and record. An internal page is its kind, the number of keys,
+// the first child and then each key with the child starting at it.
+const (
+	nodeHeader   = 1 + 2 + 8
+	leafEntry    = 8
+	internalItem = 8 + 8
+	// maxInlineRow is the size of the largest encoded row kept in a leaf.
+	maxInlineRow = pageSize / 4
+)
+
+// Records of rows kept in a leaf start with inlineRecord and the length of
+// the row, and those of rows in overflow pages with overflowRecord, the
+// length and the first overflow page.
+const (
+	inlineRecord byte = iota
+	overflowRecord
+)
+
+type treeNode struct {
+	id   pageID
+	leaf bool
+	keys []uint64
+	// records holds the record of each row of a leaf, and children the
+	// pages of an internal node, one more than its keys.
+	records  [][]byte
+	children []pageID
+	next     pageID
+}
+
+func (n *treeNode) size() int {
+	if !n.leaf {
+		return nodeHeader + len(n.keys)*internalItem
+	}
+
+	size := nodeHeader
+	for _, r := range n.records {
+		size += leafEntry + len(r)
+	}
+	return size
+}
+
+func (n *treeNode) encode(page []byte) {
+	for i := range page {
+		page[i] = 0
+	}
+
+	binary.BigEndian.PutUint16(page[1:], uint16(len(n.keys)))
+	at := nodeHeader
+	if n.leaf {
+		page[0] = leafPage
+		binary.BigEndian.PutUint64(page[3:], uint64(n.next))
+		for i, key := range n.keys {
+			binary.BigEndian.PutUint64(page[at:], key)
+			at += leafEntry
+			at += copy(page[at:], n.records[i])
+		}
+		return
+	}
+
+	page[0] = internalPage
+	binary.BigEndian.PutUint64(page[3:], uint64(n.children[0]))
+	for i, key := range n.keys {
+		binary.BigEndian.PutUint64(page[at:], key)
+		binary.BigEndian.PutUint64(page[at+8:], uint64(n.children[i+1]))
+		at += internalItem
+	}
+}
+
+// recordLength is the length of the record starting data.
+func recordLength(data []byte) (int, error) {
+	if len(data) < 3 {
+		return 0, ErrCorruptDatabase
+	}
+	switch data[0] {
+	case inlineRecord:
+		n := 3 + int(binary.BigEndian.Uint16(data[1:]))
+		if n > len(data) {
+			return 0, ErrCorruptDatabase
+		}
+		return n, nil
+	case overflowRecord:
+		if len(data) < 1+4+8 {
+			return 0, ErrCorruptDatabase
+		}
+		return 1 + 4 + 8, nil
+	}
+	return 0, ErrCorruptDatabase
+}
+
+func decodeNode(id pageID, page []byte) (*treeNode, error) {
+	n := &treeNode{id: id}
+	count := int(binary.BigEndian.Uint16(page[1:]))
+	at := nodeHeader
+
+	switch page[0] {
+	case leafPage:
+		n.leaf = true
+		n.next = pageID(binary.BigEndian.Uint64(page[3:]))
+		for i := 0; i < count; i++ {
+			if at+leafEntry > len(page) {
+				return nil, fmt.Errorf("%w: leaf page %d overruns", ErrCorruptDatabase, id)
+			}
+			n.keys = append(n.keys, binary.BigEndian.Uint64(page[at:]))
+			at += leafEntry
+
+			length, err := recordLength(page[at:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad record in page %d", err, id)
+			}
+			n.records = append(n.records, append([]byte{}, page[at:at+length]...))
+			at += length
+		}
+	case internalPage:
+		if nodeHeader+count*internalItem > len(page) {
+			return nil, fmt.Errorf("%w: internal page %d overruns", ErrCorruptDatabase, id)
+		}
+		n.children = append(n.children, pageID(binary.BigEndian.Uint64(page[3:])))
+		for i := 0; i < count; i++ {
+			n.keys = append(n.keys, binary.BigEndian.Uint64(page[at:]))
+			n.children = append(n.children, pageID(binary.BigEndian.Uint64(page[at+8:])))
+			at += internalItem
+		}
+	default:
+		return nil, fmt.Errorf("%w: page %d isn't a tree page", ErrCorruptDatabase, id)
+	}
+	return n, nil
+}
+
+func (t *rowTree) read(id pageID) (*treeNode, error) {
+	f, err := t.pool.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.release(f, false)
+	return decodeNode(id, f.data)
+}
+
+// write stores n in its page, which only counts as changed, and so only
+// goes to the write-ahead log, when its bytes differ.
+func (t *rowTree) write(n *treeNode) error {
+	f, err := t.pool.fetch(n.id)
+	if err != nil {
+		return err
+	}
+	page := make([]byte, pageSize)
+	n.encode(page)
+	changed := string(page) != string(f.data)
+	copy(f.data, page)
+	t.pool.release(f, changed)
+	return nil
+}
+
+// newNode allocates a page for a node.
+func (t *rowTree) newNode(leaf bool) (*treeNode, error) {
+	f, err := t.pool.allocate()
+	if err != nil {
+		return nil, err
+	}
+	t.pool.release(f, true)
+	return &treeNode{id: f.id, leaf: leaf}, nil
+}
+
+// newRowTree creates an empty tree in pool.
+func newRowTree(pool *bufferPool) (*rowTree, error) {
+	t := &rowTree{pool: pool}
+	root, err := t.newNode(true)
+	if err != nil {
+		return nil, err
+	}
+	t.root = root.id
+	return t, t.write(root)
+}
+
+// record stores an encoded row as a record, moving it to overflow pages
+// when it's too large for a leaf.
+func (t *rowTree) record(row []byte) ([]byte, error) {
+	if len(row) <= maxInlineRow {
+		r := make([]byte, 3, 3+len(row))
+		r[0] = inlineRecord
+		binary.BigEndian.PutUint16(r[1:], uint16(len(row)))
+		return append(r, row...), nil
+	}
+
+	first, err := t.pool.writeOverflow(row)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]byte, 1+4+8)
+	r[0] = overflowRecord
+	binary.BigEndian.PutUint32(r[1:], uint32(len(row)))
+	binary.BigEndian.PutUint64(r[5:], uint64(first))
+	return r, nil
+}
+
+// value returns the encoded row a record holds.
+func (t *rowTree) value(record []byte) ([]byte, error) {
+	if record[0] == inlineRecord {
+		return record[3:], nil
+	}
+	length := int(binary.BigEndian.Uint32(record[1:]))
+	return t.pool.readOverflow(pageID(binary.BigEndian.Uint64(record[5:])), length)
+}
+
+// childIndex is the child of an internal node whose keys include key.
+func (n *treeNode) childIndex(key uint64) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > key
+	})
+}
+
+// get returns the encoded row with key.
+func (t *rowTree) get(key uint64) ([]byte, error) {
+	n, err := t.read(t.root)
+	if err != nil {
+		return nil, err
+	}
+	for !n.leaf {
+		if n, err = t.read(n.children[n.childIndex(key)]); err != nil {
+			return nil, err
+		}
+	}
+
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] >= key
+	})
+	if i == len(n.keys) || n.keys[i] != key {
+		return nil, fmt.Errorf("%w: row %d missing", ErrCorruptDatabase, key)
+	}
+	return t.value(n.records[i])
+}
+
+// put stores row with key, replacing the row with key if there is one.
+func (t *rowTree) put(key uint64, row []byte) error {
+	record, err := t.record(row)
+	if err != nil {
+		return err
+	}
+
+	added, split, right, err := t.putInto(t.root, key, record)
+	if err != nil {
+		return err
+	}
+	if right != 0 {
+		// The root split, so the tree grows a level.
+		root, err := t.newNode(false)
+		if err != nil {
+			return err
+		}
+		root.keys = []uint64{split}
+		root.children = []pageID{t.root, right}
+		if err := t.write(root); err != nil {
+			return err
+		}
+		t.root = root.id
+	}
+
+	if added {
+		t.count++
+	}
+	return nil
+}
+
+// putInto stores the record in the subtree at id, reporting whether it's
+// a new key. When the node splits it returns the first key of the new
+// right node and its page.
+func (t *rowTree) putInto(id pageID, key uint64, record []byte) (bool, uint64, pageID, error) {
+	n, err := t.read(id)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	if n.leaf {
+		i := sort.Search(len(n.keys), func(i int) bool {
+			return n.keys[i] >= key
+		})
+		if i < len(n.keys) && n.keys[i] == key {
+			if err := t.freeRecord(n.records[i]); err != nil {
+				return false, 0, 0, err
+			}
+			n.records[i] = record
+			split, right, err := t.splitIfFull(n, false)
+			return false, split, right, err
+		}
+
+		n.keys = append(n.keys[:i], append([]uint64{key}, n.keys[i:]...)...)
+		n.records = append(n.records[:i], append([][]byte{record}, n.records[i:]...)...)
+		split, right, err := t.splitIfFull(n, i == len(n.keys)-1)
+		return true, split, right, err
+	}
+
+	i := n.childIndex(key)
+	added, split, right, err := t.putInto(n.children[i], key, record)
+	if err != nil || right == 0 {
+		return added, 0, 0, err
+	}
+	n.keys = append(n.keys[:i], append([]uint64{split}, n.keys[i:]...)...)
+	n.children = append(n.children[:i+1], append([]pageID{right}, n.children[i+1:]...)...)
+	split, right, err = t.splitIfFull(n, i == len(n.keys)-1)
+	return added, split, right, err
+}
+
+// treeStep is an internal node on the way down to a leaf and the child
+// taken from it.
+type treeStep struct {
+	node  *treeNode
+	child int
+}
+
+// delete removes the row with key from the tree. A leaf left empty is
+// freed, unless it's the first, but leaves left partly empty aren't merged.
+func (t *rowTree) delete(key uint64) error {
+	path := []treeStep{}
+	n, err := t.read(t.root)
+	for err == nil && !n.leaf {
+		i := n.childIndex(key)
+		path = append(path, treeStep{n, i})
+		n, err = t.read(n.children[i])
+	}
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] >= key
+	})
+	if i == len(n.keys) || n.keys[i] != key {
+		return fmt.Errorf("%w: row %d missing", ErrCorruptDatabase, key)
+	}
+	if err := t.freeRecord(n.records[i]); err != nil {
+		return err
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.records = append(n.records[:i], n.records[i+1:]...)
+	t.count--
+
+	if len(n.keys) > 0 {
+		return t.write(n)
+	}
+	return t.unlink(n, path)
+}
+
+// unlink frees the empty leaf n at the end of path, taking it out of the
+// chain of leaves and out of its parent, along with the parents it leaves
+// without children. The first leaf stays, since no leaf links to it.
+func (t *rowTree) unlink(n *treeNode, path []treeStep) error {
+	// The previous leaf is the last one under the child before the one
+	// the path took at the lowest node where there is one.
+	var previous *treeNode
+	for level := len(path) - 1; level >= 0 && previous == nil; level-- {
+		if step := path[level]; step.child > 0 {
+			var err error
+			previous, err = t.read(step.node.children[step.child-1])
+			for err == nil && !previous.leaf {
+				previous, err = t.read(previous.children[len(previous.children)-1])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if previous == nil {
+		return t.write(n)
+	}
+
+	previous.next = n.next
+	if err := t.write(previous); err != nil {
+		return err
+	}
+
+	freed := n.id
+	for level := len(path) - 1; level >= 0; level-- {
+		if err := t.pool.free(freed); err != nil {
+			return err
+		}
+
+		parent, i := path[level].node, path[level].child
+		parent.children = append(parent.children[:i], parent.children[i+1:]...)
+		if len(parent.keys) > 0 {
+			k := i - 1
+			if i == 0 {
+				k = 0
+			}
+			parent.keys = append(parent.keys[:k], parent.keys[k+1:]...)
+		}
+		if len(parent.children) > 0 {
+			if err := t.write(parent); err != nil {
+				return err
+			}
+			break
+		}
+		freed = parent.id
+	}
+
+	// A root left with one child gives way to it.
+	for {
+		root, err := t.read(t.root)
+		if err != nil {
+			return err
+		}
+		if root.leaf || len(root.children) > 1 {
+			return nil
+		}
+		if err := t.pool.free(root.id); err != nil {
+			return err
+		}
+		t.root = root.children[0]
+	}
+}
+
+// splitIfFull writes n, first splitting it in two when it no longer fits
+// in a page. When rows are being appended, which is how tables grow, the
+// left node keeps all it can so pages end up full.
+func (t *rowTree) splitIfFull(n *treeNode, appended bool) (uint64, pageID, error) {
+	if n.size() <= pageSize {
+		return 0, 0, t.write(n)
+	}
+
+	right, err := t.newNode(n.leaf)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var split uint64
+	if n.leaf {
+		// Rows vary in size, so leaves split at half their bytes.
+		at := len(n.keys) - 1
+		if !appended {
+			half, size := n.size()/2, nodeHeader+leafEntry+len(n.records[0])
+			for at = 1; at < len(n.keys)-1; at++ {
+				if size += leafEntry + len(n.records[at]); size > half {
+					break
+				}
+			}
+		}
+		split = n.keys[at]
+		right.keys = append(right.keys, n.keys[at:]...)
+		right.records = append(right.records, n.records[at:]...)
+		n.keys, n.records = n.keys[:at], n.records[:at]
+		right.next, n.next = n.next, right.id
+	} else {
+		at := len(n.keys) / 2
+		if appended {
+			at = len(n.keys) - 1
+		}
+		split = n.keys[at]
+		right.keys = append(right.keys, n.keys[at+1:]...)
+		right.children = append(right.children, n.children[at+1:]...)
+		n.keys, n.children = n.keys[:at], n.children[:at+1]
+	}
+
+	if err := t.write(n); err != nil {
+		return 0, 0, err
+	}
+	return split, right.id, t.write(right)
+}
+
+// leftmost returns the first leaf of the tree.
+func (t *rowTree) leftmost() (*treeNode, error) {
+	n, err := t.read(t.root)
+	for err == nil && !n.leaf {
+		n, err = t.read(n.children[0])
+	}
+	return n, err
+}
+
+// scan returns the keys and encoded rows in key order, one each time it's
+// called.
+func (t *rowTree) scan() func() (uint64, []byte, bool, error) {
+	var n *treeNode
+	i := 0
+	return func() (uint64, []byte, bool, error) {
+		if n == nil {
+			var err error
+			if n, err = t.leftmost(); err != nil {
+				return 0, nil, false, err
+			}
+		}
+
+		for i == len(n.keys) {
+			if n.next == 0 {
+				return 0, nil, false, nil
+			}
+			next, err := t.read(n.next)
+			if err != nil {
+				return 0, nil, false, err
+			}
+			n, i = next, 0
+		}
+
+		i++
+		row, err := t.value(n.records[i-1])
+		return n.keys[i-1], row, err == nil, err
+	}
+}
+
+// last returns the largest key of the tree, or 0 when it's empty.
+func (t *rowTree) last() (uint64, error) {
+	n, err := t.read(t.root)
+	for err == nil && !n.leaf {
+		n, err = t.read(n.children[len(n.children)-1])
+	}
+	if err != nil || len(n.keys) == 0 {
+		return 0, err
+	}
+	return n.keys[len(n.keys)-1], nil
+}
+
+// destroy frees every page of the tree.
+func (t *rowTree) destroy() error {
+	return t.freeNode(t.root)
+}
+
+func (t *rowTree) freeNode(id pageID) error {
+	n, err := t.read(id)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range n.children {
+		if err := t.freeNode(child); err != nil {
+			return err
+		}
+	}
+	for _, r := range n.records {
+		if err := t.freeRecord(r); err != nil {
+			return err
+		}
+	}
+	return t.pool.free(id)
+}
+
+// freeRecord frees the overflow pages of a record, if it has any.
+func (t *rowTree) freeRecord(record []byte) error {
+	if record[0] != overflowRecord {
+		return nil
+	}
+	return t.pool.freeOverflow(pageID(binary.BigEndian.Uint64(record[5:])))
+}
+
+// replace makes the rows the content of the tree, each with the key at
+// the same position of keys, which are in order.
+func (t *rowTree) replace(keys []uint64, rows [][]byte) error {
+	if err := t.destroy(); err != nil {
+		return err
+	}
+
+	fresh, err := newRowTree(t.pool)
+	if err != nil {
+		return err
+	}
+	*t = *fresh
+	for i, row := range rows {
+		if err := t.put(keys[i], row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeRow encodes the cells of a row, each as its length plus one
+// followed by its bytes, with a length of 0 for NULL.
+func encodeRow(row []MemoryCell) []byte {
+	data := binary.AppendUvarint(nil, uint64(len(row)))
+	for _, cell := range row {
+		if cell == nil {
+			data = append(data, 0)
+			continue
+		}
+		data = binary.AppendUvarint(data, uint64(len(cell))+1)
+		data = append(data, cell...)
+	}
+	return data
+}
+
+func decodeRow(data []byte) ([]MemoryCell, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: bad row", ErrCorruptDatabase)
+	}
+	data = data[n:]
+
+	row := make([]MemoryCell, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n)+1 {
+			return nil, fmt.Errorf("%w: bad row", ErrCorruptDatabase)
+		}
+		data = data[n:]
+
+		if length == 0 {
+			row = append(row, nil)
+			continue
+		}
+		row = append(row, MemoryCell(data[:length-1:length-1]))
+		data = data[length-1:]
+	}
+	return row, nil
+}
@@ -0,0 +1,198 @@
+package gogn
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// sequence is a counter created by CREATE SEQUENCE or a SERIAL column.
+// Like in Postgres, value is the last value handed out when called is set,
+// and the next value to hand out otherwise. Sequences are shared by every
+// version of the database, so mu guards the fields after increment.
+type sequence struct {
+	mu        sync.Mutex
+	name      string
+	increment int64
+	value     int64
+	called    bool
+}
+
+// next advances s and returns its new value. Values are ints, so the
+// sequence runs out at the edges of the int range.
+func (s *sequence) next() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.called {
+		value := s.value + s.increment
+		if value > math.MaxInt32 || value < math.MinInt32 {
+			return 0, fmt.Errorf("%w: sequence %s reached its limit", ErrIntegerOutOfRange, s.name)
+		}
+		s.value = value
+	}
+
+	s.called = true
+	return s.value, nil
+}
+
+// newSequence checks the options of a sequence. start defaults to 1, or -1
+// for descending sequences.
+func newSequence(name string, increment int64, start *int64) (*sequence, error) {
+	if increment == 0 {
+		return nil, fmt.Errorf("%w: INCREMENT must not be zero", ErrInvalidSequence)
+	}
+
+	s := &sequence{name: name, increment: increment, value: 1}
+	if increment < 0 {
+		s.value = -1
+	}
+	if start != nil {
+		s.value = *start
+	}
+	return s, nil
+}
+
+// sequenceOption evaluates the value of a CREATE SEQUENCE option.
+func (mb *MemoryBackend) sequenceOption(exp *expression, name string) (*int64, error) {
+	if exp == nil {
+		return nil, nil
+	}
+
+	cell, typ, err := mb.evaluateCell(&table{}, nil, exp)
+	if err != nil {
+		return nil, err
+	}
+	if typ != IntType || cell == nil {
+		return nil, fmt.Errorf("%w: %s must be an int", ErrInvalidSequence, name)
+	}
+
+	value := int64(cell.AsInt())
+	return &value, nil
+}
+
+// CreateSequence adds the sequence to MemoryBackend based on the information
+// in CreateSequenceStatement
+func (mb *MemoryBackend) CreateSequence(crt *CreateSequenceStatement) error {
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.createSequence(crt)
+	})
+}
+
+func (mb *MemoryBackend) createSequence(crt *CreateSequenceStatement) error {
+	if _, ok := mb.sequences[crt.name.value]; ok {
+		return fmt.Errorf("%w: %s", ErrSequenceExists, crt.name.value)
+	}
+
+	increment, err := mb.sequenceOption(crt.increment, "INCREMENT")
+	if err != nil {
+		return err
+	}
+	start, err := mb.sequenceOption(crt.start, "START")
+	if err != nil {
+		return err
+	}
+
+	if increment == nil {
+		one := int64(1)
+		increment = &one
+	}
+
+	s, err := newSequence(crt.name.value, *increment, start)
+	if err != nil {
+		return err
+	}
+
+	mb.sequences[s.name] = s
+	return nil
+}
+
+// nextvalCall builds nextval('name'), the default of SERIAL columns.
+func nextvalCall(name string) *expression {
+	return &expression{
+		kind: callKind,
+		call: &callExpression{
+			name: token{value: "nextval", kind: identifierKind},
+			args: []*expression{{
+				kind:    literalKind,
+				literal: &token{value: name, kind: stringKind},
+			}},
+		},
+	}
+}
+
+func (mb *MemoryBackend) sequence(name string) (*sequence, error) {
+	s, ok := mb.sequences[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSequenceDoesNotExist, name)
+	}
+	return s, nil
+}
+
+func init() {
+	builtinFunctions["nextval"] = []*function{{
+		argTypes:   []ColumnType{TextType},
+		returnType: IntType,
+		callBackend: func(mb *MemoryBackend, args []MemoryCell) (MemoryCell, error) {
+			s, err := mb.sequence(args[0].AsText())
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := s.next()
+			if err != nil {
+				return nil, err
+			}
+			mb.currvals[s.name] = value
+			return intToCell(int32(value)), nil
+		},
+	}}
+
+	builtinFunctions["currval"] = []*function{{
+		argTypes:   []ColumnType{TextType},
+		returnType: IntType,
+		callBackend: func(mb *MemoryBackend, args []MemoryCell) (MemoryCell, error) {
+			s, err := mb.sequence(args[0].AsText())
+			if err != nil {
+				return nil, err
+			}
+
+			value, ok := mb.currvals[s.name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrSequenceNotStarted, s.name)
+			}
+			return intToCell(int32(value)), nil
+		},
+	}}
+
+	// setval(name, value[, called]) makes the next nextval return the
+	// value after value, or value itself when called is false. Like
+	// nextval, it sets currval only when called is true.
+	setval := func(mb *MemoryBackend, args []MemoryCell) (MemoryCell, error) {
+		s, err := mb.sequence(args[0].AsText())
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.value = int64(args[1].AsInt())
+		s.called = len(args) < 3 || args[2].AsBool()
+		if s.called {
+			mb.currvals[s.name] = s.value
+		}
+		return args[1], nil
+	}
+	builtinFunctions["setval"] = []*function{
+		{
+			argTypes:    []ColumnType{TextType, IntType},
+			returnType:  IntType,
+			callBackend: setval,
+		},
+		{
+			argTypes:    []ColumnType{TextType, IntType, BoolType},
+			returnType:  IntType,
+			callBackend: setval,
+		},
+	}
+}
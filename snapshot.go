@@ -0,0 +1,231 @@
+package gogn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var (
+	ErrCorruptSnapshot     = errors.New("Snapshot is corrupt")
+	ErrUnsupportedSnapshot = errors.New("Snapshot version is not supported")
+)
+
+// A snapshot starts with snapshotMagic and snapshotVersion, followed by
+// blocks of a kind, a length, a checksum of the kind and contents, and
+// then the contents. The first block holds the catalog of the tables and
+// sequences, the next ones the rows of each table in chunks, and an empty
+// last block marks the end so that a truncated snapshot isn't mistaken for
+// a whole one.
+var snapshotMagic = [8]byte{'g', 'o', 'g', 'n', 's', 'n', 'a', 'p'}
+
+const snapshotVersion = 1
+
+// Block kinds, stored in the first byte of each block.
+const (
+	catalogBlock byte = iota + 1
+	rowsBlock
+	endBlock
+)
+
+const (
+	blockHeaderSize = 1 + 4 + 4
+	// snapshotChunk is the number of rows a rows block holds at most.
+	snapshotChunk = 1024
+)
+
+func blockChecksum(kind byte, contents []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE([]byte{kind}), crc32.IEEETable, contents)
+}
+
+func writeBlock(w io.Writer, kind byte, contents []byte) error {
+	header := make([]byte, blockHeaderSize)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(contents)))
+	binary.BigEndian.PutUint32(header[5:], blockChecksum(kind, contents))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(contents)
+	return err
+}
+
+// readBlock reads the next block, failing when it was cut short or its
+// checksum doesn't match.
+func readBlock(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("%w: truncated", ErrCorruptSnapshot)
+		}
+		return 0, nil, err
+	}
+
+	// The contents are copied as they arrive rather than allocated up
+	// front, so a corrupt length can't allocate more than there is.
+	var contents bytes.Buffer
+	length := int64(binary.BigEndian.Uint32(header[1:]))
+	if n, err := io.CopyN(&contents, r, length); n < length {
+		if err == io.EOF {
+			return 0, nil, fmt.Errorf("%w: truncated", ErrCorruptSnapshot)
+		}
+		return 0, nil, err
+	}
+
+	if blockChecksum(header[0], contents.Bytes()) != binary.BigEndian.Uint32(header[5:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return header[0], contents.Bytes(), nil
+}
+
+// Save writes a snapshot of every table, with its schema and rows, and of
+// every sequence to w. Functions aren't included, so they need registering
+// again on the backend the snapshot is loaded into. The snapshot holds the
+// database as the session sees it.
+func (mb *MemoryBackend) Save(w io.Writer) error {
+	return mb.inTransaction(func(tx *MemoryBackend) error {
+		return tx.writeSnapshot(w)
+	})
+}
+
+func (mb *MemoryBackend) writeSnapshot(w io.Writer) error {
+	header := make([]byte, len(snapshotMagic)+4)
+	copy(header, snapshotMagic[:])
+	binary.BigEndian.PutUint32(header[len(snapshotMagic):], snapshotVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	names := mb.tableNames()
+	e := &catalogEncoder{}
+	e.uint(uint64(len(names)))
+	for _, name := range names {
+		e.table(mb.tables[name])
+	}
+	e.sequences(mb)
+	if err := writeBlock(w, catalogBlock, e.data); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		next := mb.tables[name].scanRows()
+		for done := false; !done; {
+			// Each block names its table and holds a count of rows,
+			// each an encoded row prefixed with its length.
+			e := &catalogEncoder{}
+			e.string(name)
+			rows := []byte{}
+			count := 0
+			for ; count < snapshotChunk; count++ {
+				row, ok, err := next()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					done = true
+					break
+				}
+
+				encoded := encodeRow(row)
+				rows = binary.AppendUvarint(rows, uint64(len(encoded)))
+				rows = append(rows, encoded...)
+			}
+			if count == 0 {
+				break
+			}
+
+			e.uint(uint64(count))
+			if err := writeBlock(w, rowsBlock, append(e.data, rows...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeBlock(w, endBlock, nil)
+}
+
+// LoadMemoryBackend reads a snapshot written by Save into a new
+// MemoryBackend.
+func LoadMemoryBackend(r io.Reader) (*MemoryBackend, error) {
+	header := make([]byte, len(snapshotMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: truncated header", ErrCorruptSnapshot)
+		}
+		return nil, err
+	}
+	if string(header[:len(snapshotMagic)]) != string(snapshotMagic[:]) {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrCorruptSnapshot)
+	}
+	if version := binary.BigEndian.Uint32(header[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSnapshot, version)
+	}
+
+	kind, contents, err := readBlock(r)
+	if err != nil {
+		return nil, err
+	}
+	if kind != catalogBlock {
+		return nil, fmt.Errorf("%w: missing catalog", ErrCorruptSnapshot)
+	}
+
+	mb := NewMemoryBackend()
+	c := &catalogDecoder{data: contents, corrupt: ErrCorruptSnapshot}
+	columnar := map[string]bool{}
+	for i := c.count(); i > 0 && c.err == nil; i-- {
+		t, isColumnar := c.table()
+		mb.tables[t.name] = t
+		columnar[t.name] = isColumnar
+	}
+	c.sequences(mb)
+	c.checkForeignKeys(mb)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	for {
+		kind, contents, err := readBlock(r)
+		if err != nil {
+			return nil, err
+		}
+		if kind == endBlock {
+			break
+		}
+		if kind != rowsBlock {
+			return nil, fmt.Errorf("%w: unknown block", ErrCorruptSnapshot)
+		}
+
+		c := &catalogDecoder{data: contents, corrupt: ErrCorruptSnapshot}
+		name := c.string()
+		t, ok := mb.tables[name]
+		if c.err == nil && !ok {
+			return nil, fmt.Errorf("%w: rows of unknown table %s", ErrCorruptSnapshot, name)
+		}
+
+		for i := c.count(); i > 0 && c.err == nil; i-- {
+			n := c.count()
+			if c.err != nil {
+				break
+			}
+			row, err := decodeRow(c.data[:n])
+			if err != nil || len(row) != len(t.columns) {
+				return nil, fmt.Errorf("%w: bad row in %s", ErrCorruptSnapshot, name)
+			}
+			c.data = c.data[n:]
+			t.appendRow(row, mb.stamp(0))
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	for _, name := range mb.tableNames() {
+		if err := mb.tables[name].restore(columnar[name]); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorruptSnapshot, err)
+		}
+	}
+	return mb, nil
+}
@@ -0,0 +1,215 @@
+package gogn
+
+// Serializable transactions use serializable snapshot isolation: they read
+// snapshots like any other, but the database also tracks what they read
+// and wrote, to find each rw-antidependency between two of them running at
+// the same time, where one reads rows the other writes without seeing the
+// write. Snapshot isolation only goes wrong through a transaction with such
+// a dependency on each side, so a serializable transaction that would
+// commit with both fails with ErrSerializationFailure instead, like in
+// Postgres. This can abort transactions that would have been fine, and
+// only protects serializable transactions from each other.
+
+// serialTx is what the database tracks of a serializable transaction.
+type serialTx struct {
+	// start is the number of commits before the transaction started, and
+	// commit its own number once it committed, or 0 before.
+	start, commit uint64
+	reads         []predicateRead
+	// writes holds the rows the transaction changed by the name of their
+	// table, once it commits.
+	writes map[string]*tableWrites
+	// in is set when a concurrent transaction reads what this one writes,
+	// and out when this one reads what a concurrent one writes. doomed is
+	// set when another transaction found both.
+	in, out, doomed bool
+}
+
+// predicateRead is a read of the rows of a table matching condition, which
+// is over the columns of schema.
+type predicateRead struct {
+	table     string
+	schema    *table
+	condition *expression
+}
+
+// tableWrites holds the rows a transaction changed in a table, before and
+// after, or all when it changed the table itself.
+type tableWrites struct {
+	all  bool
+	rows [][]MemoryCell
+}
+
+// isolate sets the isolation level of the transaction of mb.
+func (mb *MemoryBackend) isolate(level isolationLevel) {
+	tx := mb.tx
+	if level == defaultIsolation || level == tx.isolation {
+		return
+	}
+	tx.isolation = level
+	if mb.db == nil {
+		return
+	}
+
+	mb.db.mu.Lock()
+	defer mb.db.mu.Unlock()
+	if level == serializable {
+		tx.serial = &serialTx{start: tx.start}
+		mb.db.serializables = append(mb.db.serializables, tx.serial)
+	} else if tx.serial != nil {
+		mb.db.forget(tx.serial)
+		tx.serial = nil
+	}
+}
+
+// read records that a serializable transaction read the rows of t
+// matching condition.
+func (mb *MemoryBackend) read(t *table, schema *table, condition *expression) {
+	if mb.tx == nil || mb.tx.serial == nil || mb.tables[t.name] != t {
+		return
+	}
+
+	// The schema is copied, since the transaction may go on to change its
+	// table while other transactions check the read.
+	r := predicateRead{
+		table: t.name,
+		schema: &table{
+			name:        schema.name,
+			columns:     append([]string{}, schema.columns...),
+			columnTypes: append([]ColumnType{}, schema.columnTypes...),
+		},
+		condition: condition,
+	}
+
+	mb.db.mu.Lock()
+	defer mb.db.mu.Unlock()
+	mb.tx.serial.reads = append(mb.tx.serial.reads, r)
+}
+
+// matches reports whether a read of s might have returned a row of writes.
+// Conditions calling functions aren't evaluated, so they match every row.
+func (s *serialTx) matches(writes map[string]*tableWrites) bool {
+	evaluator := newMemoryBackend()
+	for _, r := range s.reads {
+		w, ok := writes[r.table]
+		if !ok {
+			continue
+		}
+		if w.all || r.condition == nil || callsFunctions(r.condition) {
+			return true
+		}
+
+		for _, row := range w.rows {
+			if ok, err := evaluator.matches(r.schema, row, r.condition); ok || err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func callsFunctions(exp *expression) bool {
+	if exp.kind == callKind {
+		return true
+	}
+	for _, child := range exp.children() {
+		if callsFunctions(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// rowWrites returns the rows changes made, as they were and as they are.
+func rowWrites(changes []*tableChange, id txid) map[string]*tableWrites {
+	writes := map[string]*tableWrites{}
+	for _, c := range changes {
+		if c.altered || c.renamed() {
+			writes[c.mine.name] = &tableWrites{all: true}
+			if c.base != nil {
+				writes[c.base.name] = &tableWrites{all: true}
+			}
+			continue
+		}
+
+		w := &tableWrites{}
+		written := c.writes(id)
+		c.base.eachRow(func(v rowVersion, row []MemoryCell) error {
+			if written[v.id] {
+				w.rows = append(w.rows, row)
+			}
+			return nil
+		})
+		c.mine.eachRow(func(v rowVersion, row []MemoryCell) error {
+			if v.xmin == id {
+				w.rows = append(w.rows, row)
+			}
+			return nil
+		})
+		writes[c.mine.name] = w
+	}
+	return writes
+}
+
+// checkSerializable finds the rw-antidependencies between t, which is
+// about to commit writes, and the transactions concurrent with it, failing
+// when t, or a committed transaction, would have one on each side.
+func (db *database) checkSerializable(t *serialTx, writes map[string]*tableWrites) error {
+	t.writes = writes
+	for _, s := range db.serializables {
+		if s == t || s.commit != 0 && s.commit <= t.start {
+			continue
+		}
+
+		if s.matches(t.writes) {
+			s.out, t.in = true, true
+		}
+		if s.commit != 0 && t.matches(s.writes) {
+			t.out, s.in = true, true
+		}
+
+		if s.in && s.out {
+			if s.commit != 0 {
+				return ErrSerializationFailure
+			}
+			s.doomed = true
+		}
+	}
+
+	if t.doomed || t.in && t.out {
+		return ErrSerializationFailure
+	}
+	return nil
+}
+
+// forget stops tracking s.
+func (db *database) forget(s *serialTx) {
+	for i, other := range db.serializables {
+		if other == s {
+			db.serializables = append(db.serializables[:i], db.serializables[i+1:]...)
+			return
+		}
+	}
+}
+
+// collectSerializable forgets the committed transactions every running
+// one started after.
+func (db *database) collectSerializable() {
+	oldest := db.commits + 1
+	for _, s := range db.serializables {
+		if s.commit == 0 && s.start < oldest {
+			oldest = s.start
+		}
+	}
+
+	kept := db.serializables[:0]
+	for _, s := range db.serializables {
+		if s.commit == 0 || s.commit > oldest {
+			kept = append(kept, s)
+		}
+	}
+	for i := len(kept); i < len(db.serializables); i++ {
+		db.serializables[i] = nil
+	}
+	db.serializables = kept
+}
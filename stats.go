@@ -0,0 +1,346 @@
+package gogn
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statisticsTarget is the most values ANALYZE keeps in the list of most
+// common values of a column, and the number of buckets of its histogram.
+const statisticsTarget = 10
+
+// columnStats describes the values of a column when ANALYZE last ran.
+type columnStats struct {
+	typ          ColumnType
+	nullFraction float64
+	distinct     int
+	// min and max are nil when every value is NULL.
+	min, max MemoryCell
+	// mostCommon holds the values appearing more than once, most common
+	// first, and mostCommonFreqs the fraction of rows holding each.
+	mostCommon      []MemoryCell
+	mostCommonFreqs []float64
+	// histogram holds bounds splitting the other non-NULL values into
+	// buckets of equal size, from the smallest value to the largest.
+	histogram []MemoryCell
+}
+
+type tableStats struct {
+	rows    int
+	columns []*columnStats
+}
+
+// analyzeColumn collects the statistics of the values of a column.
+func analyzeColumn(values []MemoryCell, typ ColumnType) *columnStats {
+	s := &columnStats{typ: typ}
+	if len(values) == 0 {
+		return s
+	}
+
+	sorted := []MemoryCell{}
+	for _, v := range values {
+		if v != nil {
+			sorted = append(sorted, v)
+		}
+	}
+	s.nullFraction = float64(len(values)-len(sorted)) / float64(len(values))
+	if len(sorted) == 0 {
+		return s
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareCells(sorted[i], typ, sorted[j], typ) < 0
+	})
+	s.min, s.max = sorted[0], sorted[len(sorted)-1]
+
+	// Count each run of equal values.
+	type run struct {
+		value MemoryCell
+		count int
+	}
+	runs := []run{}
+	for _, v := range sorted {
+		if len(runs) > 0 && compareCells(runs[len(runs)-1].value, typ, v, typ) == 0 {
+			runs[len(runs)-1].count++
+			continue
+		}
+		runs = append(runs, run{value: v, count: 1})
+	}
+	s.distinct = len(runs)
+
+	common := append([]run{}, runs...)
+	sort.SliceStable(common, func(i, j int) bool {
+		return common[i].count > common[j].count
+	})
+	isCommon := map[string]bool{}
+	for _, r := range common {
+		if r.count < 2 || len(s.mostCommon) == statisticsTarget {
+			break
+		}
+		s.mostCommon = append(s.mostCommon, r.value)
+		s.mostCommonFreqs = append(s.mostCommonFreqs, float64(r.count)/float64(len(values)))
+		isCommon[string(r.value)] = true
+	}
+
+	rest := []MemoryCell{}
+	for _, v := range sorted {
+		if !isCommon[string(v)] {
+			rest = append(rest, v)
+		}
+	}
+	if len(rest) < 2 {
+		return s
+	}
+
+	buckets := statisticsTarget
+	if len(rest)-1 < buckets {
+		buckets = len(rest) - 1
+	}
+	for i := 0; i <= buckets; i++ {
+		s.histogram = append(s.histogram, rest[i*(len(rest)-1)/buckets])
+	}
+	return s
+}
+
+// analyze collects the statistics of t.
+func (t *table) analyze() *tableStats {
+	rows := t.allRows()
+	stats := &tableStats{rows: len(rows)}
+	for i, typ := range t.columnTypes {
+		values := []MemoryCell{}
+		for _, row := range rows {
+			values = append(values, row[i])
+		}
+		stats.columns = append(stats.columns, analyzeColumn(values, typ))
+	}
+	return stats
+}
+
+// Analyze collects the statistics the planner estimates with, for the
+// table named in AnalyzeStatement or for every table.
+func (mb *MemoryBackend) Analyze(anl *AnalyzeStatement) error {
+	return mb.inTransaction(func(w *MemoryBackend) error {
+		return w.analyze(anl)
+	})
+}
+
+func (mb *MemoryBackend) analyze(anl *AnalyzeStatement) error {
+	if anl.table.value != "" {
+		t, ok := mb.tables[anl.table.value]
+		if !ok {
+			return ErrTableDoesNotExist
+		}
+		mb.writable(t).stats = t.analyze()
+		return nil
+	}
+
+	for _, t := range mb.tables {
+		mb.writable(t).stats = t.analyze()
+	}
+	return nil
+}
+
+// commonFraction is the fraction of rows holding one of the most common
+// values.
+func (s *columnStats) commonFraction() float64 {
+	sum := 0.0
+	for _, f := range s.mostCommonFreqs {
+		sum += f
+	}
+	return sum
+}
+
+// equalFraction estimates the fraction of rows whose value equals v.
+func (s *columnStats) equalFraction(v MemoryCell, typ ColumnType) float64 {
+	if v == nil {
+		return 0
+	}
+
+	for i, common := range s.mostCommon {
+		if compareCells(common, s.typ, v, typ) == 0 {
+			return s.mostCommonFreqs[i]
+		}
+	}
+
+	// The other values share the remaining rows evenly.
+	others := s.distinct - len(s.mostCommon)
+	if others <= 0 {
+		return 0
+	}
+	return math.Max(1-s.nullFraction-s.commonFraction(), 0) / float64(others)
+}
+
+// position places v between a and b, from 0 at a to 1 at b, for numbers.
+// Other values are placed halfway.
+func position(v MemoryCell, typ ColumnType, a, b MemoryCell, boundType ColumnType) float64 {
+	if (typ != IntType && typ != FloatType) || (boundType != IntType && boundType != FloatType) {
+		return 0.5
+	}
+
+	x := coerceCell(v, typ, FloatType).AsFloat()
+	lo := coerceCell(a, boundType, FloatType).AsFloat()
+	hi := coerceCell(b, boundType, FloatType).AsFloat()
+	if hi <= lo {
+		return 0.5
+	}
+	return math.Min(math.Max((x-lo)/(hi-lo), 0), 1)
+}
+
+// lessFraction estimates the fraction of rows whose value is less than v,
+// or not more than v when inclusive.
+func (s *columnStats) lessFraction(v MemoryCell, typ ColumnType, inclusive bool) float64 {
+	if v == nil || s.min == nil {
+		return 0
+	}
+
+	less := func(c MemoryCell) bool {
+		cmp := compareCells(c, s.typ, v, typ)
+		return cmp < 0 || (inclusive && cmp == 0)
+	}
+
+	fraction := 0.0
+	for i, common := range s.mostCommon {
+		if less(common) {
+			fraction += s.mostCommonFreqs[i]
+		}
+	}
+
+	rest := math.Max(1-s.nullFraction-s.commonFraction(), 0)
+	bounds := s.histogram
+	if len(bounds) < 2 {
+		bounds = []MemoryCell{s.min, s.max}
+	}
+
+	switch {
+	case !less(bounds[0]):
+		return fraction
+	case less(bounds[len(bounds)-1]):
+		return fraction + rest
+	}
+
+	// Find the bucket v falls in and how far along it.
+	i := sort.Search(len(bounds), func(i int) bool {
+		return !less(bounds[i])
+	}) - 1
+	within := position(v, typ, bounds[i], bounds[i+1], s.typ)
+	return fraction + rest*(float64(i)+within)/float64(len(bounds)-1)
+}
+
+// predicateSelectivity estimates the fraction of rows whose value in a
+// column with the statistics s compares to value as op does.
+func predicateSelectivity(s *columnStats, distinct float64, op symbol, value MemoryCell, typ ColumnType) float64 {
+	if s == nil {
+		switch op {
+		case equalsSymbol:
+			return 1 / math.Max(distinct, 1)
+		case notEqualsSymbol, bangEqualsSymbol:
+			return 1 - 1/math.Max(distinct, 1)
+		}
+		return rangeSelectivity
+	}
+
+	if value == nil {
+		return 0
+	}
+
+	notNull := 1 - s.nullFraction
+	switch op {
+	case equalsSymbol:
+		return s.equalFraction(value, typ)
+	case notEqualsSymbol, bangEqualsSymbol:
+		return math.Max(notNull-s.equalFraction(value, typ), 0)
+	case lessThanSymbol:
+		return s.lessFraction(value, typ, false)
+	case lessThanOrEqualSymbol:
+		return s.lessFraction(value, typ, true)
+	case greaterThanSymbol:
+		return math.Max(notNull-s.lessFraction(value, typ, true), 0)
+	case greaterThanOrEqualSymbol:
+		return math.Max(notNull-s.lessFraction(value, typ, false), 0)
+	}
+	return defaultSelectivity
+}
+
+// statsView is the pg_stats system view, with a row of statistics for each
+// column of the tables ANALYZE has run on.
+func (mb *MemoryBackend) statsView() *table {
+	view := &table{name: "pg_stats"}
+	for _, col := range []struct {
+		name string
+		typ  ColumnType
+	}{
+		{"tablename", TextType},
+		{"attname", TextType},
+		{"row_count", IntType},
+		{"null_frac", FloatType},
+		{"n_distinct", IntType},
+		{"min_value", TextType},
+		{"max_value", TextType},
+		{"most_common_vals", TextType},
+		{"most_common_freqs", TextType},
+		{"histogram_bounds", TextType},
+	} {
+		view.columns = append(view.columns, col.name)
+		view.columnTypes = append(view.columnTypes, col.typ)
+	}
+
+	names := []string{}
+	for name, t := range mb.tables {
+		if t.stats != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	text := func(cell MemoryCell, typ ColumnType) MemoryCell {
+		if cell == nil {
+			return nil
+		}
+		return MemoryCell(cellToText(cell, typ))
+	}
+	list := func(items []string) MemoryCell {
+		if len(items) == 0 {
+			return nil
+		}
+		return MemoryCell("{" + strings.Join(items, ",") + "}")
+	}
+
+	for _, name := range names {
+		t := mb.tables[name]
+		for i, s := range t.stats.columns {
+			values, freqs, bounds := []string{}, []string{}, []string{}
+			for j, v := range s.mostCommon {
+				values = append(values, cellToText(v, s.typ))
+				freqs = append(freqs, strconv.FormatFloat(s.mostCommonFreqs[j], 'g', 4, 64))
+			}
+			for _, v := range s.histogram {
+				bounds = append(bounds, cellToText(v, s.typ))
+			}
+
+			view.appendRow([]MemoryCell{
+				MemoryCell(name),
+				MemoryCell(t.columns[i]),
+				intToCell(int32(t.stats.rows)),
+				floatToCell(s.nullFraction),
+				intToCell(int32(s.distinct)),
+				text(s.min, s.typ),
+				text(s.max, s.typ),
+				list(values),
+				list(freqs),
+				list(bounds),
+			}, rowVersion{id: uint64(view.rowCount())})
+		}
+	}
+	return view
+}
+
+// systemView returns the current contents of the system view called name.
+func (mb *MemoryBackend) systemView(name string) (*table, bool) {
+	switch name {
+	case "pg_stats":
+		return mb.statsView(), true
+	}
+	return nil, false
+}
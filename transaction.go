@@ -0,0 +1,299 @@
+package gogn
+
+import (
+	"fmt"
+)
+
+// transaction is the state of a transaction. Its statements change copies
+// of the tables they write to, made the first time each table changes, so
+// that the tables as of BEGIN and of each savepoint stay as they were for
+// ROLLBACK to bring back, and so that other transactions keep reading the
+// tables as they were until COMMIT publishes the copies.
+//
+// Sequences advance outside of transactions, like in Postgres, so ROLLBACK
+// only takes back the ones created since BEGIN or the savepoint.
+type transaction struct {
+	// id numbers the transaction and snapshot is the version of the
+	// database it reads, in a session of a database.
+	id       txid
+	snapshot *version
+	// start is the number of commits before the transaction started.
+	start uint64
+	// begin is the state as of BEGIN, and savepoints the state as of each
+	// savepoint since, the latest last.
+	begin      *savepoint
+	savepoints []*savepoint
+	// shared holds the tables of begin and the savepoints, which
+	// statements mustn't change.
+	shared map[*table]bool
+	// bases maps the copy of each table of begin to that table, and
+	// altered holds the copies whose definitions changed.
+	bases   map[*table]*table
+	altered map[*table]bool
+	// isolation is the level SET TRANSACTION chose, which it can only do
+	// before the transaction is queried. serial tracks the reads and
+	// writes of a serializable transaction.
+	isolation isolationLevel
+	queried   bool
+	serial    *serialTx
+}
+
+type savepoint struct {
+	name      string
+	tables    map[string]*table
+	sequences map[string]*sequence
+}
+
+// save returns the current state of mb, which statements no longer change
+// in place from then on.
+func (mb *MemoryBackend) save(name string) *savepoint {
+	s := &savepoint{name: name, tables: mb.tables, sequences: mb.sequences}
+	mb.restore(s)
+	for _, t := range s.tables {
+		mb.tx.shared[t] = true
+	}
+	return s
+}
+
+// restore brings back the state of s, leaving s as it is.
+func (mb *MemoryBackend) restore(s *savepoint) {
+	mb.tables = make(map[string]*table, len(s.tables))
+	for name, t := range s.tables {
+		mb.tables[name] = t
+	}
+	mb.sequences = make(map[string]*sequence, len(s.sequences))
+	for name, seq := range s.sequences {
+		mb.sequences[name] = seq
+	}
+}
+
+// findSavepoint returns the position of the latest savepoint called name.
+func (tx *transaction) findSavepoint(name string) (int, error) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", ErrSavepointDoesNotExist, name)
+}
+
+// begin starts a transaction and returns the backend its statements run
+// on: a workspace of its own reading the current version of the database,
+// or mb itself for the backend of a DiskBackend.
+func (mb *MemoryBackend) begin() *MemoryBackend {
+	tx := &transaction{
+		shared:  map[*table]bool{},
+		bases:   map[*table]*table{},
+		altered: map[*table]bool{},
+	}
+	w := mb
+	if mb.db != nil {
+		mb.db.start(tx)
+		w = &MemoryBackend{
+			tables:    tx.snapshot.tables,
+			functions: mb.functions,
+			sequences: tx.snapshot.sequences,
+			db:        mb.db,
+			currvals:  mb.currvals,
+		}
+	}
+
+	w.tx = tx
+	tx.begin = w.save("")
+	return w
+}
+
+// end commits the transaction of w, or rolls it back. A commit that would
+// overwrite changes committed since the transaction started fails with
+// ErrSerializationFailure and rolls back instead.
+func (mb *MemoryBackend) end(w *MemoryBackend, commit bool) error {
+	tx := w.tx
+	w.tx = nil
+	if !commit {
+		w.tables, w.sequences = tx.begin.tables, tx.begin.sequences
+	}
+	if mb.db == nil {
+		return nil
+	}
+
+	v, err := mb.db.finish(tx, w, commit)
+	mb.tables, mb.sequences = v.tables, v.sequences
+	return err
+}
+
+// inTransaction runs a statement: in the transaction BEGIN started, or
+// else in one of its own, committed when the statement succeeds.
+func (mb *MemoryBackend) inTransaction(f func(w *MemoryBackend) error) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.work != nil {
+		mb.work.tx.queried = true
+		return f(mb.work)
+	}
+	if mb.db == nil {
+		return f(mb)
+	}
+
+	w := mb.begin()
+	if err := f(w); err != nil {
+		mb.end(w, false)
+		return err
+	}
+	return mb.end(w, true)
+}
+
+func (mb *MemoryBackend) Begin(begin *BeginStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.work != nil {
+		return ErrTransactionInProgress
+	}
+	mb.work = mb.begin()
+	mb.work.isolate(begin.isolation)
+	return nil
+}
+
+// SetTransaction sets the isolation level of the transaction. READ
+// UNCOMMITTED, READ COMMITTED and REPEATABLE READ all read the snapshot the
+// transaction started with, which is stricter than the first two ask for.
+// SERIALIZABLE also aborts transactions whose outcome couldn't come from
+// running them one at a time.
+func (mb *MemoryBackend) SetTransaction(set *SetTransactionStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.work == nil {
+		return ErrNoTransaction
+	}
+	if mb.work.tx.queried {
+		return ErrTransactionStarted
+	}
+	mb.work.isolate(set.isolation)
+	return nil
+}
+
+func (mb *MemoryBackend) Commit(*CommitStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.work == nil {
+		return ErrNoTransaction
+	}
+	w := mb.work
+	mb.work = nil
+	return mb.end(w, true)
+}
+
+// Rollback ends the transaction, undoing its changes, or with a savepoint
+// undoes the changes since it and forgets the savepoints after it.
+func (mb *MemoryBackend) Rollback(rollback *RollbackStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	w := mb.work
+	if w == nil {
+		return ErrNoTransaction
+	}
+
+	if rollback.savepoint.value == "" {
+		mb.work = nil
+		return mb.end(w, false)
+	}
+
+	i, err := w.tx.findSavepoint(rollback.savepoint.value)
+	if err != nil {
+		return err
+	}
+	w.tx.savepoints = w.tx.savepoints[:i+1]
+	w.restore(w.tx.savepoints[i])
+	return nil
+}
+
+// Savepoint marks the current state of the transaction to roll back to.
+// A savepoint can reuse the name of an earlier one, which it hides until
+// it's released.
+func (mb *MemoryBackend) Savepoint(svpt *SavepointStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	w := mb.work
+	if w == nil {
+		return ErrNoTransaction
+	}
+
+	w.tx.savepoints = append(w.tx.savepoints, w.save(svpt.name.value))
+	return nil
+}
+
+// ReleaseSavepoint forgets a savepoint and every one after it, keeping the
+// changes since.
+func (mb *MemoryBackend) ReleaseSavepoint(rel *ReleaseSavepointStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	w := mb.work
+	if w == nil {
+		return ErrNoTransaction
+	}
+
+	i, err := w.tx.findSavepoint(rel.name.value)
+	if err != nil {
+		return err
+	}
+	w.tx.savepoints = w.tx.savepoints[:i]
+	return nil
+}
+
+// writable returns t for a statement to change. Inside a transaction, the
+// first change to a table replaces it with a copy of its own.
+func (mb *MemoryBackend) writable(t *table) *table {
+	if mb.tx == nil || !mb.tx.shared[t] {
+		return t
+	}
+
+	c := t.copy()
+	if base, ok := mb.tx.bases[t]; ok {
+		mb.tx.bases[c] = base
+	} else if mb.tx.begin.tables[t.name] == t {
+		mb.tx.bases[c] = t
+	}
+	mb.tx.altered[c] = mb.tx.altered[t]
+	mb.tables[c.name] = c
+	return c
+}
+
+// alter returns t for a statement to change the definition of.
+func (mb *MemoryBackend) alter(t *table) *table {
+	t = mb.writable(t)
+	if mb.tx != nil {
+		mb.tx.altered[t] = true
+	}
+	return t
+}
+
+// copy returns a copy of t, indexes included, that can be changed
+// without affecting t. The rows are shared until the copy changes them.
+func (t *table) copy() *table {
+	c := t.clone()
+	c.stats = t.stats
+
+	if t.heap != nil {
+		c.heap = t.heap.clone()
+	}
+	if t.columnar != nil {
+		// Appending to the columns of the copy mustn't write over those
+		// t might append.
+		c.versions = t.versions[:len(t.versions):len(t.versions)]
+		c.columnar = &columnStore{}
+		for _, v := range t.columnar.vectors {
+			c.columnar.vectors = append(c.columnar.vectors, v.slice(0, v.len()))
+		}
+	}
+
+	for _, ix := range c.indexes {
+		ix.tree = ix.tree.clone()
+	}
+	return c
+}
@@ -0,0 +1,181 @@
+package gogn
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// writeAheadLog holds the pages each statement changed, appended and synced
+// before any of them are written to the database file. The file only
+// changes at a checkpoint, which writes every page logged since the last
+// one and then truncates the log. After a crash, replaying the log brings
+// the file up to the last statement whose log reached the disk whole.
+//
+// The log starts with walMagic and the page size, followed by frames of a
+// kind, a page and a checksum, then a page of data. A statement's changed
+// pages are logged as page frames followed by a commit frame holding the
+// file header, and the frames of a statement without its commit frame are
+// ignored. Each checksum covers its frame and the checksum before it, so a
+// torn or stale frame breaks the chain and ends the log.
+type writeAheadLog struct {
+	file *os.File
+	// size is the length of the log up to the last commit frame, where the
+	// next frame goes.
+	size int64
+	// checksum is the checksum of the last commit frame.
+	checksum uint32
+	// frames counts the frames logged since the last checkpoint.
+	frames int
+}
+
+const (
+	walHeaderSize   = 8 + 4
+	frameHeaderSize = 1 + 8 + 4
+	frameSize       = frameHeaderSize + pageSize
+)
+
+// Frame kinds, stored in the first byte of each frame.
+const (
+	pageFrame byte = iota + 1
+	commitFrame
+)
+
+var walMagic = [8]byte{'g', 'o', 'g', 'n', 'w', 'a', 'l', 1}
+
+// openWriteAheadLog opens the log at path, creating it when it doesn't
+// exist. Call replay before logging anything else.
+func openWriteAheadLog(path string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &writeAheadLog{file: file}, nil
+}
+
+// frameChecksum chains the checksum of a frame onto the one before it.
+func frameChecksum(previous uint32, frame []byte) uint32 {
+	var seed [4]byte
+	binary.BigEndian.PutUint32(seed[:], previous)
+	sum := crc32.ChecksumIEEE(seed[:])
+	sum = crc32.Update(sum, crc32.IEEETable, frame[:9])
+	return crc32.Update(sum, crc32.IEEETable, frame[frameHeaderSize:])
+}
+
+// replay reads the statements in the log, returning the last version of
+// every page they changed and the file header of the last one, or nil when
+// no statement is complete. The log is then positioned after that
+// statement, so a torn one at its end is overwritten.
+func (w *writeAheadLog) replay() (map[pageID][]byte, *fileHeader, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := w.file.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if string(header[:8]) != string(walMagic[:]) || binary.BigEndian.Uint32(header[8:]) != pageSize {
+		// The log never got past its header, so there's nothing to replay.
+		return nil, nil, nil
+	}
+
+	pages, committed := map[pageID][]byte{}, map[pageID][]byte{}
+	var last *fileHeader
+	w.size, w.checksum, w.frames = walHeaderSize, 0, 0
+
+	checksum, frames := uint32(0), 0
+	frame := make([]byte, frameSize)
+	for offset := int64(walHeaderSize); ; offset += frameSize {
+		if _, err := w.file.ReadAt(frame, offset); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		checksum = frameChecksum(checksum, frame)
+		if checksum != binary.BigEndian.Uint32(frame[9:]) {
+			break
+		}
+		frames++
+
+		data := append([]byte{}, frame[frameHeaderSize:]...)
+		switch frame[0] {
+		case pageFrame:
+			pages[pageID(binary.BigEndian.Uint64(frame[1:]))] = data
+			continue
+		case commitFrame:
+		default:
+			return nil, nil, ErrCorruptDatabase
+		}
+
+		last = &fileHeader{}
+		if err := last.decode(data); err != nil {
+			return nil, nil, err
+		}
+		for id, page := range pages {
+			committed[id] = page
+		}
+		pages = map[pageID][]byte{}
+		w.size, w.checksum, w.frames = offset+frameSize, checksum, frames
+	}
+	return committed, last, nil
+}
+
+// commit logs a statement's changed pages and the file header after it,
+// waiting for them to reach the disk.
+func (w *writeAheadLog) commit(pages []*frame, header *fileHeader) error {
+	if w.size < walHeaderSize {
+		// The log is empty and needs its header.
+		if err := w.reset(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, (len(pages)+1)*frameSize)
+	checksum := w.checksum
+	for i := 0; i <= len(pages); i++ {
+		frame := buf[i*frameSize : (i+1)*frameSize]
+		if i < len(pages) {
+			frame[0] = pageFrame
+			binary.BigEndian.PutUint64(frame[1:], uint64(pages[i].id))
+			copy(frame[frameHeaderSize:], pages[i].data)
+		} else {
+			frame[0] = commitFrame
+			header.encode(frame[frameHeaderSize:])
+		}
+		checksum = frameChecksum(checksum, frame)
+		binary.BigEndian.PutUint32(frame[9:], checksum)
+	}
+
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	w.checksum = checksum
+	w.frames += len(pages) + 1
+	return nil
+}
+
+// reset empties the log once its pages are in the database file.
+func (w *writeAheadLog) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+
+	header := make([]byte, walHeaderSize)
+	copy(header, walMagic[:])
+	binary.BigEndian.PutUint32(header[8:], pageSize)
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size, w.checksum, w.frames = walHeaderSize, 0, 0
+	return nil
+}
+
+func (w *writeAheadLog) close() error {
+	return w.file.Close()
+}